@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/stevequadros/uploader/providers/auditor"
+	"github.com/stevequadros/uploader/providers/ratelimit"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+)
+
+var auditUsage = `
+uploader audit lists a prefix on every chosen provider and reports the objects missing from
+some of them or stored with a different size or checksum. The most recently modified copy is
+taken as correct, -repair copies it to the providers that disagree.
+
+Usage:
+  uploader audit -config FILE -bucket BUCKET [-prefix PREFIX] [-provider NAME]... [-output text|json] [-repair]
+`
+
+func runAudit(args []string) int {
+	fs := newFlagSet("audit", auditUsage)
+	flags := commonFlags{}
+	var bucket, prefix, output string
+	var repair bool
+	flags.register(fs, "only audit these providers, defaults to every configured provider")
+	fs.StringVar(&bucket, "bucket", "", "[REQUIRED] Bucket to audit")
+	fs.StringVar(&prefix, "prefix", "", "Only audit keys under this prefix")
+	fs.StringVar(&output, "output", "text", "Report format, text or json")
+	fs.BoolVar(&repair, "repair", false, "Copy the correct version to every provider that disagrees")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	if flags.configPath == "" || bucket == "" {
+		fs.Usage()
+		return exitUsage
+	}
+	if output != "text" && output != "json" {
+		logError("Error processing flags", fmt.Errorf("unknown output %q, use text or json", output))
+		return exitUsage
+	}
+	if output == "json" {
+		logOut = os.Stderr
+	}
+
+	ctx := context.Background()
+	uploaders, err := flags.uploaders(ctx)
+	if err != nil {
+		return exitFailure
+	}
+	if len(uploaders) < 2 {
+		logError("Error processing flags", errors.New("auditing needs at least two providers"))
+		return exitUsage
+	}
+
+	logInProcess("Auditing")
+	report := auditor.Run(ctx, uploaders, bucket, prefix)
+	if repair && len(report.Issues) > 0 {
+		logInProcess("Repairing")
+		auditor.Repair(ctx, uploaders, ratelimit.New(float64(flags.config.Bandwidth)), bucket, report.Issues)
+	}
+
+	if output == "json" {
+		b, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			logError("Error writing report", err)
+			return exitFailure
+		}
+		fmt.Println(string(b))
+	} else {
+		printAuditReport(os.Stdout, report, repair)
+	}
+	return auditExitCode(report, repair)
+}
+
+func printAuditReport(out io.Writer, report auditor.Report, repaired bool) {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "PROVIDER\tOBJECTS\tSIZE\tERROR")
+	for _, p := range report.Providers {
+		fmt.Fprintf(w, "%s\t%d\t%s\t%s\n", p.Provider, p.Objects, formatBytes(p.Bytes), p.Error)
+	}
+	_ = w.Flush()
+
+	if len(report.Issues) > 0 {
+		fmt.Fprintln(out)
+		w = tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+		header := "KIND\tKEY\tSOURCE\tSTALE"
+		if repaired {
+			header += "\tREPAIR"
+		}
+		fmt.Fprintln(w, header)
+		for _, i := range report.Issues {
+			stale := make([]string, len(i.Stale))
+			for j, p := range i.Stale {
+				stale[j] = string(p)
+			}
+			row := fmt.Sprintf("%s\t%s\t%s\t%s", i.Kind, i.Key, i.Source, strings.Join(stale, ","))
+			if repaired {
+				row += "\t" + repairStatus(i)
+			}
+			fmt.Fprintln(w, row)
+		}
+		_ = w.Flush()
+	}
+	for _, key := range report.Unverified {
+		fmt.Fprintf(out, "\tunverified %s: same size everywhere but no checksum to compare\n", key)
+	}
+
+	fmt.Fprintf(out, "\n%d keys audited, %d out of sync, %d unverified\n", report.Keys, len(report.Issues), len(report.Unverified))
+}
+
+func repairStatus(i auditor.Issue) string {
+	if i.Fixed() {
+		return "✓"
+	}
+	var errs []string
+	for p, err := range i.RepairErrors {
+		errs = append(errs, fmt.Sprintf("%s: %s", p, err))
+	}
+	return "✗ " + strings.Join(errs, "; ")
+}
+
+// auditExitCode fails on any disagreement left, after a repair only on those it couldn't fix
+func auditExitCode(report auditor.Report, repaired bool) int {
+	for _, p := range report.Providers {
+		if p.Error != "" {
+			return exitFailure
+		}
+	}
+	if !repaired {
+		if len(report.Issues) > 0 {
+			return exitFailure
+		}
+		return exitOK
+	}
+	var failed int
+	for _, i := range report.Issues {
+		if !i.Fixed() {
+			failed++
+		}
+	}
+	return exitCode(failed, len(report.Issues))
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/stevequadros/uploader/config"
+	xproviders "github.com/stevequadros/uploader/providers"
+	pinit "github.com/stevequadros/uploader/providers/initializer"
+	"github.com/stevequadros/uploader/providers/logging"
+	"strings"
+)
+
+const (
+	exitOK = iota
+	exitFailure
+	exitUsage
+	// exitPartial means the command succeeded on some providers and failed on others
+	exitPartial
+)
+
+type command struct {
+	name    string
+	summary string
+	run     func(args []string) int
+}
+
+var commands = []command{
+	{"upload", "upload a file to every chosen provider", runUpload},
+	{"download", "download an object from the fastest provider", runDownload},
+	{"ls", "list objects under a prefix", runLs},
+	{"rm", "delete an object from every chosen provider", runRm},
+	{"stat", "compare an object's size, checksums and metadata across providers", runStat},
+	{"cp", "copy an object from one provider to others", runCp},
+	{"sync", "upload new and changed files in a directory, optionally deleting removed ones", runSync},
+	{"audit", "compare a prefix across providers and optionally repair the differences", runAudit},
+	{"repair", "retry uploads that failed on a provider", runRepair},
+	{"gc", "abort abandoned multipart uploads, blocks and sessions", runGc},
+	{"config", "create, check, show, encrypt, decrypt or edit a config file", runConfig},
+	{"doctor", "check credentials and permissions for every provider", runDoctor},
+}
+
+func commandList() string {
+	b := strings.Builder{}
+	for _, c := range commands {
+		b.WriteString(fmt.Sprintf("  %-10s %s\n", c.name, c.summary))
+	}
+	return b.String()
+}
+
+// commonFlags are shared by every command that talks to providers
+type commonFlags struct {
+	configPath string
+	providers  providerFlag
+	// config is the config uploaders loaded, restricted to the chosen providers
+	config config.Config
+	logs   logFlags
+}
+
+func (f *commonFlags) register(fs *flag.FlagSet, providerUsage string) {
+	fs.StringVar(&f.configPath, "config", "", "[REQUIRED] Path to config.json")
+	fs.Var(&f.providers, "provider", providerUsage)
+	f.logs.register(fs)
+}
+
+// uploaders loads the config and initializes only the chosen providers
+func (f *commonFlags) uploaders(ctx context.Context) ([]xproviders.Uploader, error) {
+	uploaders, cfg, err := loadUploaders(ctx, f.configPath, f.providers, &f.logs)
+	f.config = cfg
+	return uploaders, err
+}
+
+// logger is the logger of the -log flags, nil when logs are off
+func (f *commonFlags) logger() *logging.Logger {
+	log, _ := f.logs.logger()
+	return log
+}
+
+// loadUploaders loads the config and initializes the chosen providers, logging as logs say when
+// given
+func loadUploaders(ctx context.Context, configPath string, providers providerFlag, logs *logFlags) ([]xproviders.Uploader, config.Config, error) {
+	log, err := logs.logger()
+	if err != nil {
+		logError("Error processing flags", err)
+		return nil, config.Config{}, err
+	}
+	logInProcess("Validating config")
+	cfg, err := config.New(configPath)
+	if err != nil {
+		logError("Config error", err)
+		return nil, cfg, err
+	}
+	logs.addSecrets(cfg.Secrets())
+	if cfg, err = selectProviders(cfg, providers); err != nil {
+		logError("Error processing flags", err)
+		return nil, cfg, err
+	}
+	logSuccess("Config validated")
+
+	logInProcess("Initializing Providers")
+	uploaders, err := pinit.Init(ctx, cfg, log)
+	if err != nil {
+		logError("Error initializing providers", err)
+		return nil, cfg, err
+	}
+	var names []xproviders.Provider
+	for _, u := range uploaders {
+		names = append(names, u.GetName())
+	}
+	logSuccess(fmt.Sprintf("Providers Initialized: %v", names))
+	return uploaders, cfg, nil
+}
+
+// newFlagSet builds a FlagSet that prints usage followed by the flag defaults
+func newFlagSet(name, usage string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Println(usage)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// parseArgs parses flags given before, after or between positional arguments, which the flag
+// package stops at, and returns the positional arguments. Everything after -- is positional
+func parseArgs(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		rest := fs.Args()
+		if parsed := len(args) - len(rest); parsed > 0 && args[parsed-1] == "--" {
+			return append(positional, rest...), nil
+		}
+		args = rest
+		if len(args) == 0 {
+			return positional, nil
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+// exitCode maps how many providers failed out of total to the process exit code
+func exitCode(failed, total int) int {
+	switch {
+	case failed == 0:
+		return exitOK
+	case failed < total:
+		return exitPartial
+	default:
+		return exitFailure
+	}
+}
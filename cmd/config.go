@@ -0,0 +1,270 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/stevequadros/uploader/config"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+type stringsFlag []string
+
+func (s *stringsFlag) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringsFlag) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
+
+var configUsage = `
+uploader config manages config files.
+
+Usage:
+  uploader config init [-provider NAME]... [-non-interactive] [-o FILE] [-force] [provider flags]
+  uploader config validate FILE
+  uploader config show FILE
+  uploader config encrypt [-age RECIPIENT]... [-passphrase] [-keyfile PATH] [-w] FILE
+  uploader config decrypt [-w] FILE
+  uploader config edit FILE
+
+Encrypted configs keep keys readable and encrypt every value, they are decrypted
+transparently when loaded with -config. Decryption keys are read from
+` + config.AgeKeyFileEnv + `, ` + config.AgeKeyEnv + `, ` + config.PassphraseEnv + ` or ` + config.KeyFileEnv + `.
+`
+
+// runConfig dispatches the config subcommands and returns the exit code
+func runConfig(args []string) int {
+	if len(args) == 0 {
+		fmt.Println(configUsage)
+		return exitUsage
+	}
+
+	var err error
+	switch args[0] {
+	case "init":
+		err = configInit(args[1:])
+	case "validate":
+		err = configValidate(args[1:])
+	case "show":
+		err = configShow(args[1:])
+	case "encrypt":
+		err = configEncrypt(args[1:])
+	case "decrypt":
+		err = configDecrypt(args[1:])
+	case "edit":
+		err = configEdit(args[1:])
+	default:
+		fmt.Println(configUsage)
+		return exitUsage
+	}
+	if err != nil {
+		logError("config "+args[0]+" failed", err)
+		return exitFailure
+	}
+	return exitOK
+}
+
+// configValidate loads the config like an upload would, then checks each provider's credentials
+func configValidate(args []string) error {
+	fs := flag.NewFlagSet("config validate", flag.ContinueOnError)
+	path, err := parseFileArg(fs, args)
+	if err != nil {
+		return err
+	}
+
+	logInProcess("Validating config")
+	cfg, err := config.New(path)
+	if err != nil {
+		return err
+	}
+	logSuccess("Config valid")
+
+	logInProcess("Checking credentials")
+	var failed int
+	for _, check := range cfg.CheckCredentials() {
+		if check.Err != nil {
+			failed++
+			logError(check.Provider, check.Err)
+		} else {
+			logSuccess(check.Provider)
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d provider(s) have unusable credentials", failed)
+	}
+	return nil
+}
+
+// configShow prints the effective config, decrypted and with references resolved, minus secrets
+func configShow(args []string) error {
+	fs := flag.NewFlagSet("config show", flag.ContinueOnError)
+	path, err := parseFileArg(fs, args)
+	if err != nil {
+		return err
+	}
+
+	cfg, err := config.New(path)
+	if err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(cfg.Redacted(), "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(b))
+	return nil
+}
+
+func configEncrypt(args []string) error {
+	fs := flag.NewFlagSet("config encrypt", flag.ContinueOnError)
+	var recipients stringsFlag
+	var usePassphrase, inPlace bool
+	var keyFile string
+	fs.Var(&recipients, "age", "age recipient (age1...) to encrypt for, may be repeated")
+	fs.BoolVar(&usePassphrase, "passphrase", false, "encrypt with the passphrase in "+config.PassphraseEnv)
+	fs.StringVar(&keyFile, "keyfile", "", "encrypt with a key derived from the contents of this file")
+	fs.BoolVar(&inPlace, "w", false, "write result to the file instead of stdout")
+	path, err := parseFileArg(fs, args)
+	if err != nil {
+		return err
+	}
+
+	opts := config.EncryptOptions{AgeRecipients: recipients, KeyFile: keyFile}
+	if usePassphrase {
+		if opts.Passphrase = os.Getenv(config.PassphraseEnv); opts.Passphrase == "" {
+			return fmt.Errorf("-passphrase set but %s is empty", config.PassphraseEnv)
+		}
+	}
+
+	plain, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	encrypted, err := config.Encrypt(plain, opts)
+	if err != nil {
+		return err
+	}
+	return writeOutput(path, encrypted, inPlace)
+}
+
+func configDecrypt(args []string) error {
+	fs := flag.NewFlagSet("config decrypt", flag.ContinueOnError)
+	var inPlace bool
+	fs.BoolVar(&inPlace, "w", false, "write result to the file instead of stdout")
+	path, err := parseFileArg(fs, args)
+	if err != nil {
+		return err
+	}
+
+	encrypted, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	keys, err := config.KeysFromEnv()
+	if err != nil {
+		return err
+	}
+	plain, err := config.Decrypt(encrypted, keys)
+	if err != nil {
+		return err
+	}
+	return writeOutput(path, plain, inPlace)
+}
+
+// configEdit opens the decrypted config in $EDITOR and encrypts the result again
+// with the same keys, the plaintext only ever lives in a 0600 temp file
+func configEdit(args []string) error {
+	fs := flag.NewFlagSet("config edit", flag.ContinueOnError)
+	path, err := parseFileArg(fs, args)
+	if err != nil {
+		return err
+	}
+
+	encrypted, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	keys, err := config.KeysFromEnv()
+	if err != nil {
+		return err
+	}
+	plain, err := config.Decrypt(encrypted, keys)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp("", "uploader-config-*.json")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err = tmp.Write(plain); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+
+	editor := os.Getenv("EDITOR")
+	if editor == "" {
+		editor = "vi"
+	}
+	cmd := exec.Command("sh", "-c", editor+` "$1"`, "sh", tmp.Name())
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err = cmd.Run(); err != nil {
+		return fmt.Errorf("editor exited with error: %w", err)
+	}
+
+	edited, err := os.ReadFile(tmp.Name())
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(edited, plain) {
+		logSuccess("No changes")
+		return nil
+	}
+	if !json.Valid(edited) {
+		return errors.New("edited config is not valid json, changes discarded")
+	}
+
+	reencrypted, err := config.Reencrypt(encrypted, edited, keys)
+	if err != nil {
+		return err
+	}
+	if err = writeOutput(path, reencrypted, true); err != nil {
+		return err
+	}
+	logSuccess(fmt.Sprintf("Saved %q", path))
+	return nil
+}
+
+func parseFileArg(fs *flag.FlagSet, args []string) (string, error) {
+	if err := fs.Parse(args); err != nil {
+		return "", err
+	}
+	if fs.NArg() != 1 {
+		return "", errors.New("expected exactly one config file argument")
+	}
+	return fs.Arg(0), nil
+}
+
+// writeOutput prints data, or replaces path with it keeping the file's permissions
+func writeOutput(path string, data []byte, inPlace bool) error {
+	if !inPlace {
+		_, err := os.Stdout.Write(data)
+		return err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, info.Mode().Perm())
+}
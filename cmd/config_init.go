@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/stevequadros/uploader/config"
+	xproviders "github.com/stevequadros/uploader/providers"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+type initOptions struct {
+	providers        providerFlag
+	awsCredentials   string
+	awsProfile       string
+	azureAccountName string
+	azureAccountKey  string
+	gcpCredentials   string
+	gcpScopes        stringsFlag
+}
+
+// configInit generates a config for the chosen providers, prompting for anything not
+// given as a flag unless stdin is not a terminal or -non-interactive is set
+func configInit(args []string) error {
+	fs := flag.NewFlagSet("config init", flag.ContinueOnError)
+	var opts initOptions
+	var out string
+	var nonInteractive, force bool
+	fs.Var(&opts.providers, "provider", "provider to configure, may be repeated. Valid Options: aws, gcp, azure")
+	fs.BoolVar(&nonInteractive, "non-interactive", false, "never prompt, use flags and defaults only")
+	fs.StringVar(&out, "o", "", "file to write the config to, defaults to stdout")
+	fs.BoolVar(&force, "force", false, "overwrite the output file if it exists")
+	fs.StringVar(&opts.awsCredentials, "aws-credentials", "", "path to the aws shared credentials file")
+	fs.StringVar(&opts.awsProfile, "aws-profile", "", "aws profile to use")
+	fs.StringVar(&opts.azureAccountName, "azure-account-name", "", "azure storage account name")
+	fs.StringVar(&opts.azureAccountKey, "azure-account-key", "", "azure storage account key or a secret reference such as env:NAME")
+	fs.StringVar(&opts.gcpCredentials, "gcp-credentials", "", "path to the gcp credentials json")
+	fs.Var(&opts.gcpScopes, "gcp-scope", "gcp oauth scope, may be repeated")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if out != "" && !force {
+		if _, err := os.Stat(out); err == nil {
+			return fmt.Errorf("%q already exists, use -force to overwrite", out)
+		}
+	}
+
+	p := &prompter{
+		in:          bufio.NewReader(os.Stdin),
+		out:         os.Stderr,
+		interactive: !nonInteractive && isTerminal(os.Stdin),
+	}
+	cfg, err := buildConfig(opts, p)
+	if err != nil {
+		return err
+	}
+
+	b, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+	if out == "" {
+		_, err = os.Stdout.Write(b)
+		return err
+	}
+	// the config may hold keys, keep it private
+	if err = os.WriteFile(out, b, 0600); err != nil {
+		return err
+	}
+	logSuccess(fmt.Sprintf("Wrote %q, check it with: uploader config validate %s", out, out))
+	return nil
+}
+
+func buildConfig(opts initOptions, p *prompter) (config.Config, error) {
+	var cfg config.Config
+
+	if len(opts.providers) == 0 {
+		if !p.interactive {
+			return cfg, errors.New("at least one -provider is required")
+		}
+		answer, err := p.ask("Providers (comma separated)", "", "aws,azure,gcp")
+		if err != nil {
+			return cfg, err
+		}
+		for _, name := range strings.Split(answer, ",") {
+			opts.providers = append(opts.providers, xproviders.Provider(strings.TrimSpace(name)))
+		}
+	}
+	if err := validateProviders(opts.providers); err != nil {
+		return cfg, err
+	}
+
+	home, _ := os.UserHomeDir()
+	for _, provider := range opts.providers {
+		switch provider {
+		case xproviders.AWS:
+			filename, err := p.ask("AWS credentials file", opts.awsCredentials, filepath.Join(home, ".aws", "credentials"))
+			if err != nil {
+				return cfg, err
+			}
+			profile, err := p.ask("AWS profile", opts.awsProfile, "default")
+			if err != nil {
+				return cfg, err
+			}
+			cfg.AWS = config.NewAWS(filename, profile)
+		case xproviders.Azure:
+			name, err := p.ask("Azure account name", opts.azureAccountName, "")
+			if err != nil {
+				return cfg, err
+			}
+			key, err := p.ask("Azure account key (or env:NAME, file:PATH, vault:PATH#FIELD)", opts.azureAccountKey, "env:AZURE_STORAGE_KEY")
+			if err != nil {
+				return cfg, err
+			}
+			cfg.Azure = config.NewAzure(name, key)
+		case xproviders.GCP:
+			filename, err := p.ask("GCP credentials json", opts.gcpCredentials, os.Getenv("GOOGLE_APPLICATION_CREDENTIALS"))
+			if err != nil {
+				return cfg, err
+			}
+			cfg.GCP = config.NewGCP(filename)
+			if len(opts.gcpScopes) > 0 {
+				cfg.GCP.Credentials.Scopes = opts.gcpScopes
+			}
+		}
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return cfg, fmt.Errorf("incomplete config: %w", err)
+	}
+	return cfg, nil
+}
+
+type prompter struct {
+	in          *bufio.Reader
+	out         io.Writer
+	interactive bool
+}
+
+// ask returns value if it was already given, otherwise prompts for it. An empty answer,
+// or not being interactive, selects def
+func (p *prompter) ask(label, value, def string) (string, error) {
+	if value != "" {
+		return value, nil
+	}
+	if !p.interactive {
+		return def, nil
+	}
+	if def != "" {
+		fmt.Fprintf(p.out, "%s [%s]: ", label, def)
+	} else {
+		fmt.Fprintf(p.out, "%s: ", label)
+	}
+	// running out of input, e.g. stdin is /dev/null, answers with the default
+	line, err := p.in.ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return "", err
+	}
+	if line = strings.TrimSpace(line); line == "" {
+		return def, nil
+	}
+	return line, nil
+}
+
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
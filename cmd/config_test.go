@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bufio"
+	"github.com/stevequadros/uploader/config"
+	xproviders "github.com/stevequadros/uploader/providers"
+	"github.com/stretchr/testify/require"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestBuildConfig(t *testing.T) {
+	tests := []struct {
+		name        string
+		opts        initOptions
+		input       string
+		interactive bool
+		check       func(t *testing.T, cfg config.Config)
+		wantErr     bool
+	}{
+		{
+			name: "non-interactive uses flags and defaults",
+			opts: initOptions{
+				providers:        providerFlag{xproviders.AWS, xproviders.Azure},
+				awsCredentials:   "/creds",
+				azureAccountName: "account",
+			},
+			check: func(t *testing.T, cfg config.Config) {
+				require.Equal(t, config.NewAWS("/creds", "default"), cfg.AWS)
+				require.Equal(t, config.NewAzure("account", "env:AZURE_STORAGE_KEY"), cfg.Azure)
+				require.Nil(t, cfg.GCP)
+			},
+		},
+		{
+			name:    "non-interactive requires providers",
+			opts:    initOptions{},
+			wantErr: true,
+		},
+		{
+			name:    "non-interactive fails on missing required values",
+			opts:    initOptions{providers: providerFlag{xproviders.Azure}},
+			wantErr: true,
+		},
+		{
+			name:    "invalid provider",
+			opts:    initOptions{providers: providerFlag{"foo"}},
+			wantErr: true,
+		},
+		{
+			name:        "interactive prompts for providers and values",
+			opts:        initOptions{gcpScopes: stringsFlag{"scope"}},
+			input:       "gcp\n/gcp.json\n",
+			interactive: true,
+			check: func(t *testing.T, cfg config.Config) {
+				require.Nil(t, cfg.AWS)
+				require.Equal(t, "/gcp.json", cfg.GCP.Credentials.Filename)
+				require.Equal(t, []string{"scope"}, cfg.GCP.Credentials.Scopes)
+			},
+		},
+		{
+			name:        "interactive empty answers take defaults",
+			opts:        initOptions{providers: providerFlag{xproviders.AWS}},
+			input:       "/aws\n\n",
+			interactive: true,
+			check: func(t *testing.T, cfg config.Config) {
+				require.Equal(t, config.NewAWS("/aws", "default"), cfg.AWS)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &prompter{
+				in:          bufio.NewReader(strings.NewReader(tt.input)),
+				out:         io.Discard,
+				interactive: tt.interactive,
+			}
+			cfg, err := buildConfig(tt.opts, p)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			tt.check(t, cfg)
+		})
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	xproviders "github.com/stevequadros/uploader/providers"
+	"github.com/stevequadros/uploader/providers/coordinator"
+	"github.com/stevequadros/uploader/providers/ratelimit"
+	"os"
+	"time"
+)
+
+var cpUsage = `
+uploader cp copies an object from one provider to one or more others, for example to
+backfill a provider that missed an upload. The object is staged in a temp file on the way.
+
+Usage:
+  uploader cp -config FILE -from NAME -to NAME... -bucket BUCKET -key KEY [-dest-bucket BUCKET] [-dest-key KEY]
+`
+
+func runCp(args []string) int {
+	fs := newFlagSet("cp", cpUsage)
+	var configPath, from, bucket, key, destBucket, destKey string
+	var grace time.Duration
+	to := providerFlag{}
+	fs.StringVar(&configPath, "config", "", "[REQUIRED] Path to config.json")
+	fs.StringVar(&from, "from", "", "[REQUIRED] Provider to copy from")
+	fs.Var(&to, "to", "[REQUIRED 1+] Providers to copy to, each preceded with it's own flag")
+	fs.StringVar(&bucket, "bucket", "", "[REQUIRED] Source bucket")
+	fs.StringVar(&key, "key", "", "[REQUIRED] Source key")
+	fs.StringVar(&destBucket, "dest-bucket", "", "Destination bucket, defaults to -bucket. Will Create bucket if it doesn't exist.")
+	fs.StringVar(&destKey, "dest-key", "", "Destination key, defaults to -key")
+	fs.DurationVar(&grace, "grace", defaultGrace, "How long copies in flight may finish after an interrupt before they are aborted")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	if configPath == "" || from == "" || bucket == "" || key == "" {
+		fs.Usage()
+		return exitUsage
+	}
+	if err := validateCopy(xproviders.Provider(from), to); err != nil {
+		logError("Error processing flags", err)
+		return exitUsage
+	}
+	if destBucket == "" {
+		destBucket = bucket
+	}
+	if destKey == "" {
+		destKey = key
+	}
+
+	ctx, stop := interruptContext(grace)
+	defer stop()
+	uploaders, cfg, err := loadUploaders(ctx, configPath, append(providerFlag{xproviders.Provider(from)}, to...), nil)
+	if err != nil {
+		return exitFailure
+	}
+	var source xproviders.Uploader
+	var destinations []xproviders.Uploader
+	for _, u := range uploaders {
+		if u.GetName() == xproviders.Provider(from) {
+			source = u
+		} else {
+			destinations = append(destinations, u)
+		}
+	}
+
+	logInProcess(fmt.Sprintf("Downloading from %q", from))
+	staged, _, err := xproviders.Stage(ctx, source, bucket, key)
+	if err != nil {
+		logError(fmt.Sprintf("Error downloading from %q", from), err)
+		return exitFailure
+	}
+	defer os.Remove(staged.Name())
+	defer staged.Close()
+	logSuccess("Downloaded")
+
+	logInProcess("Copying")
+	coord, _ := coordinator.NewCoordinator(destinations,
+		coordinator.WithGracePeriod(grace),
+		coordinator.WithBandwidthLimit(ratelimit.New(float64(cfg.Bandwidth))),
+	)
+	res, err := coord.Do(ctx, destBucket, destKey, staged)
+	if err != nil {
+		logError("Error copying", err)
+	}
+	for _, p := range res.Done {
+		logSuccess(fmt.Sprintf("Copied to %q", p))
+	}
+	for _, e := range res.Failed {
+		logError(fmt.Sprintf("Error copying to %q: ", e.Provider), e.Error)
+	}
+	if ctx.Err() != nil {
+		fmt.Fprintf(logOut, "\nInterrupted, copied to %d / %d providers\n", len(res.Done), len(destinations))
+		return exitInterrupted
+	}
+	return exitCode(len(res.Failed), len(destinations))
+}
+
+func validateCopy(from xproviders.Provider, to providerFlag) error {
+	if err := validateProviders(append(providerFlag{from}, to...)); err != nil {
+		return err
+	}
+	if len(to) == 0 {
+		return errors.New("-to needs at least one provider")
+	}
+	for _, p := range to {
+		if p == from {
+			return fmt.Errorf("%q is both the source and a destination", p)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/stevequadros/uploader/config"
+	xproviders "github.com/stevequadros/uploader/providers"
+	"github.com/stevequadros/uploader/providers/doctor"
+	"os"
+	"strings"
+	"text/tabwriter"
+)
+
+var doctorUsage = `
+uploader doctor checks every configured provider before you upload: credentials resolve,
+the bucket exists or can be created, and a probe object can be written, read and deleted.
+
+Usage:
+  uploader doctor -config FILE -bucket BUCKET [-provider NAME]...
+`
+
+func runDoctor(args []string) int {
+	fs := flag.NewFlagSet("doctor", flag.ContinueOnError)
+	providers := providerFlag{}
+	var configPath, bucket string
+	fs.Var(&providers, "provider", "only check these providers, defaults to every configured provider")
+	fs.StringVar(&configPath, "config", "", "[REQUIRED] Path to config.json")
+	fs.StringVar(&bucket, "bucket", "", "[REQUIRED] Bucket to check. Will Create bucket if it doesn't exist.")
+	fs.Usage = func() {
+		fmt.Println(doctorUsage)
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	if configPath == "" || bucket == "" {
+		fs.Usage()
+		return exitUsage
+	}
+
+	logInProcess("Validating config")
+	// secrets are resolved per provider by the credentials check
+	cfg, err := config.NewUnresolved(configPath)
+	if err != nil {
+		logError("Config error", err)
+		return exitFailure
+	}
+	if cfg, err = selectProviders(cfg, providers); err != nil {
+		logError("Error processing flags", err)
+		return exitUsage
+	}
+	logSuccess("Config validated")
+
+	logInProcess("Checking providers")
+	report := doctor.Run(context.Background(), cfg, bucket)
+	printDoctorReport(report)
+	if !report.OK() {
+		return exitFailure
+	}
+	return exitOK
+}
+
+func printDoctorReport(report doctor.Report) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	header := []string{"PROVIDER"}
+	for _, s := range doctor.Steps {
+		header = append(header, strings.ToUpper(string(s)))
+	}
+	fmt.Fprintln(w, strings.Join(header, "\t"))
+	for _, p := range report.Providers {
+		row := []string{string(p.Provider)}
+		for _, s := range doctor.Steps {
+			res := p.Step(s)
+			switch {
+			case res.OK:
+				row = append(row, "✓")
+			case res.Skipped:
+				row = append(row, "-")
+			default:
+				row = append(row, "✗")
+			}
+		}
+		fmt.Fprintln(w, strings.Join(row, "\t"))
+	}
+	_ = w.Flush()
+
+	for _, p := range report.Providers {
+		for _, s := range p.Steps {
+			if s.OK || s.Skipped {
+				continue
+			}
+			fmt.Println()
+			logError(fmt.Sprintf("%s %s failed", p.Provider, s.Step), errors.New(s.Error))
+			fmt.Println("\t\t hint:", s.Hint)
+		}
+	}
+}
+
+// selectProviders narrows cfg down to the chosen providers, none chosen keeps every configured one
+func selectProviders(cfg config.Config, providers providerFlag) (config.Config, error) {
+	if len(providers) == 0 {
+		return cfg, nil
+	}
+	if err := validateProviders(providers); err != nil {
+		return cfg, err
+	}
+
+	selected := config.Config{Bandwidth: cfg.Bandwidth, Tracing: cfg.Tracing}
+	var missing []string
+	for _, p := range providers {
+		switch p {
+		case xproviders.AWS:
+			selected.AWS = cfg.AWS
+			if cfg.AWS == nil {
+				missing = append(missing, string(p))
+			}
+		case xproviders.Azure:
+			selected.Azure = cfg.Azure
+			if cfg.Azure == nil {
+				missing = append(missing, string(p))
+			}
+		case xproviders.GCP:
+			selected.GCP = cfg.GCP
+			if cfg.GCP == nil {
+				missing = append(missing, string(p))
+			}
+		}
+	}
+	if len(missing) > 0 {
+		return selected, fmt.Errorf("providers not configured: %s", strings.Join(missing, ", "))
+	}
+	return selected, nil
+}
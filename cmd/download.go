@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/stevequadros/uploader/providers/coordinator"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+var downloadUsage = `
+uploader download reads an object from every chosen provider at once and keeps whichever
+answers first. Output goes to the key's base name unless -o is given, "-o -" writes to stdout.
+
+Usage:
+  uploader download -config FILE -bucket BUCKET -key KEY [-o FILE] [-provider NAME]...
+`
+
+func runDownload(args []string) int {
+	fs := newFlagSet("download", downloadUsage)
+	flags := commonFlags{}
+	var bucket, key, output string
+	flags.register(fs, "only download from these providers, defaults to every configured provider")
+	fs.StringVar(&bucket, "bucket", "", "[REQUIRED] Bucket to read from")
+	fs.StringVar(&key, "key", "", "[REQUIRED] key to download")
+	fs.StringVar(&output, "o", "", "File to write, - for stdout. Defaults to the key's base name")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	if flags.configPath == "" || bucket == "" || key == "" {
+		fs.Usage()
+		return exitUsage
+	}
+	if output == "" {
+		output = path.Base(key)
+	}
+	if output == "-" {
+		logOut = os.Stderr
+	}
+
+	ctx := context.Background()
+	uploaders, err := flags.uploaders(ctx)
+	if err != nil {
+		return exitFailure
+	}
+
+	logInProcess("Downloading")
+	coord, _ := coordinator.NewCoordinator(uploaders, coordinator.WithLogger(flags.logger()))
+	r, p, err := coord.Download(ctx, bucket, key)
+	if err != nil {
+		logError("Error downloading", err)
+		return exitFailure
+	}
+	defer r.Close()
+
+	n, err := writeDownload(output, r)
+	if err != nil {
+		logError(fmt.Sprintf("Error downloading from %q", p), err)
+		return exitFailure
+	}
+	logSuccess(fmt.Sprintf("Downloaded %d bytes from %q to %s", n, p, output))
+	return exitOK
+}
+
+// writeDownload copies r to output through a temp file in the same directory, so a failed
+// download never leaves a truncated file behind
+func writeDownload(output string, r io.Reader) (int64, error) {
+	if output == "-" {
+		return io.Copy(os.Stdout, r)
+	}
+	if info, err := os.Stat(output); err == nil && info.IsDir() {
+		return 0, errors.New(output + " is a directory")
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(output), "."+filepath.Base(output)+".*.part")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name())
+
+	n, err := io.Copy(tmp, r)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return n, err
+	}
+	return n, os.Rename(tmp.Name(), output)
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/stevequadros/uploader/providers/checkpoint"
+	"github.com/stevequadros/uploader/providers/gc"
+	"text/tabwriter"
+	"time"
+)
+
+var gcUsage = `
+uploader gc aborts the uploads of a bucket that were started more than -older-than ago and never
+completed, which keep storing (and billing) their parts: S3 multipart uploads, blobs that only
+have uncommitted Azure blocks, and the GCS sessions and other uploads tracked by local checkpoints.
+GCS can't list its sessions, the untracked ones expire after a week. -dry-run lists them without
+aborting anything.
+
+Usage:
+  uploader gc -config FILE -bucket BUCKET [-older-than 24h] [-provider NAME]... [-dry-run]
+`
+
+func runGc(args []string) int {
+	fs := newFlagSet("gc", gcUsage)
+	flags := commonFlags{}
+	var bucket, checkpointDir string
+	var olderThan time.Duration
+	var dryRun bool
+	flags.register(fs, "only collect on these providers, defaults to every configured provider")
+	fs.StringVar(&bucket, "bucket", "", "[REQUIRED] Bucket to collect")
+	fs.DurationVar(&olderThan, "older-than", 24*time.Hour, "Only abort uploads started, or last resumed, longer ago than this")
+	fs.BoolVar(&dryRun, "dry-run", false, "List the abandoned uploads without aborting them")
+	fs.StringVar(&checkpointDir, "checkpoints", defaultCheckpointDir(), "Directory of the checkpoints kept by resumable uploads, empty to ignore them")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	if flags.configPath == "" || bucket == "" {
+		fs.Usage()
+		return exitUsage
+	}
+	if olderThan <= 0 {
+		logError("Error processing flags", errors.New("-older-than must be positive, uploads in progress would be aborted"))
+		return exitUsage
+	}
+
+	ctx, stop := interruptContext(0)
+	defer stop()
+	uploaders, err := flags.uploaders(ctx)
+	if err != nil {
+		return exitFailure
+	}
+	var store *checkpoint.Store
+	if checkpointDir != "" {
+		if store, err = checkpoint.Open(checkpointDir); err != nil {
+			logError("Error opening checkpoints, only listing providers", err)
+		}
+	}
+
+	if dryRun {
+		logInProcess("Looking for abandoned uploads (dry run)")
+	} else {
+		logInProcess("Aborting abandoned uploads")
+	}
+	report := gc.Run(ctx, uploaders, store, bucket, time.Now().Add(-olderThan), dryRun)
+	printGcReport(report)
+
+	var failed int
+	for _, p := range report.Providers {
+		if p.Err != nil {
+			failed++
+		}
+	}
+	if ctx.Err() != nil {
+		return exitInterrupted
+	}
+	if report.Failed() > 0 && failed == 0 {
+		return exitPartial
+	}
+	return exitCode(failed, len(report.Providers))
+}
+
+func printGcReport(report gc.Report) {
+	for _, p := range report.Providers {
+		if p.Err != nil {
+			logError(fmt.Sprintf("Error listing uploads on %q", p.Provider), p.Err)
+		}
+		if !p.Supported {
+			fmt.Fprintf(logOut, "\t%s can't list unfinished uploads, only those with a local checkpoint are collected\n", p.Provider)
+		}
+	}
+	if len(report.Items) == 0 {
+		logSuccess(fmt.Sprintf("No abandoned uploads in %s", report.Bucket))
+		return
+	}
+
+	w := tabwriter.NewWriter(logOut, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "PROVIDER\tKEY\tSTARTED\tPARTS\tSIZE\tSTATUS")
+	for _, it := range report.Items {
+		status := "aborted"
+		switch {
+		case report.DryRun:
+			status = "would abort"
+		case it.Err != nil:
+			status = "failed: " + it.Err.Error()
+		}
+		started := "unknown"
+		if !it.Started.IsZero() {
+			started = it.Started.Local().Format("2006-01-02 15:04")
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\t%s\n", it.Provider, it.Key, started, it.Parts, formatBytes(it.Size), status)
+	}
+	_ = w.Flush()
+
+	if report.DryRun {
+		fmt.Fprintf(logOut, "\n%d abandoned uploads, %s would be reclaimed. dry run, nothing was aborted\n", len(report.Items), formatBytes(report.Bytes()))
+		return
+	}
+	fmt.Fprintf(logOut, "\nAborted %d / %d abandoned uploads, %s reclaimed\n", len(report.Items)-report.Failed(), len(report.Items), formatBytes(report.Bytes()))
+}
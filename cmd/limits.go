@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/stevequadros/uploader/config"
+	xproviders "github.com/stevequadros/uploader/providers"
+	"github.com/stevequadros/uploader/providers/ratelimit"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// watchLimits returns the bucket limiting the bandwidth of every upload together, to limitRate
+// when given and to the config's bandwidth otherwise. Until ctx is done, SIGHUP reloads the
+// config and applies its limits to the bucket, unless limitRate was given, and to every provider
+func watchLimits(ctx context.Context, flags commonFlags, uploaders []xproviders.Uploader, limitRate config.Rate) *ratelimit.Bucket {
+	global := ratelimit.New(float64(flags.config.Bandwidth))
+	if limitRate > 0 {
+		global.SetRate(float64(limitRate))
+	}
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
+	go func() {
+		defer signal.Stop(reload)
+		for {
+			select {
+			case <-reload:
+			case <-ctx.Done():
+				return
+			}
+			cfg, err := config.New(flags.configPath)
+			if err != nil {
+				logError("Error reloading limits, keeping the current ones", err)
+				continue
+			}
+			flags.logs.addSecrets(cfg.Secrets())
+			if limitRate == 0 {
+				global.SetRate(float64(cfg.Bandwidth))
+			}
+			for _, u := range uploaders {
+				xproviders.LimitersOf(u).SetLimits(providerLimits(cfg, u.GetName()))
+			}
+			fmt.Fprintf(logOut, "\nLimits reloaded from %s: %s\n", flags.configPath, limitsNote(global, uploaders))
+		}
+	}()
+	return global
+}
+
+// providerLimits are the limits the config sets for p, nil for none
+func providerLimits(cfg config.Config, p xproviders.Provider) *config.Limits {
+	switch {
+	case p == xproviders.AWS && cfg.AWS != nil:
+		return cfg.AWS.Limits
+	case p == xproviders.Azure && cfg.Azure != nil:
+		return cfg.Azure.Limits
+	case p == xproviders.GCP && cfg.GCP != nil:
+		return cfg.GCP.Limits
+	}
+	return nil
+}
+
+// limitsNote describes the bandwidth and request limits in effect
+func limitsNote(global *ratelimit.Bucket, uploaders []xproviders.Uploader) string {
+	note := "total " + rateNote(global.Rate())
+	for _, u := range uploaders {
+		l := xproviders.LimitersOf(u)
+		note += fmt.Sprintf(", %s %s", u.GetName(), rateNote(l.Bandwidth.Rate()))
+		if r := l.Requests.Rate(); r > 0 {
+			note += fmt.Sprintf(" and %g requests/s", r)
+		}
+	}
+	return note
+}
+
+func rateNote(rate float64) string {
+	if rate <= 0 {
+		return "unlimited"
+	}
+	return config.Rate(rate).String()
+}
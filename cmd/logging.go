@@ -0,0 +1,60 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/stevequadros/uploader/providers/logging"
+	"os"
+)
+
+// logFlags configure the structured logs written to stderr, none unless -log-level is given
+type logFlags struct {
+	level  string
+	format string
+	wire   bool
+
+	redactor *logging.Redactor
+	log      *logging.Logger
+}
+
+func (f *logFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&f.level, "log-level", "", "Write structured logs to stderr from this level: debug, info, warn or error")
+	fs.StringVar(&f.format, "log-format", "text", "Format of the structured logs, text or json")
+	fs.BoolVar(&f.wire, "log-wire", false, "With -log-level debug, also log every HTTP request sent to the providers")
+}
+
+// logger builds the logger the flags configure, nil when logs are off. Secrets are redacted
+// from everything it writes, the config's included once they are added with addSecrets
+func (f *logFlags) logger() (*logging.Logger, error) {
+	if f == nil || f.level == "" {
+		return nil, nil
+	}
+	if f.log != nil {
+		return f.log, nil
+	}
+	level, err := logging.ParseLevel(f.level)
+	if err != nil {
+		return nil, err
+	}
+	f.redactor = logging.NewRedactor()
+	opts := logging.HandlerOptions{Level: level, Redactor: f.redactor}
+	switch f.format {
+	case "text":
+		f.log = logging.New(logging.NewTextHandler(os.Stderr, opts))
+	case "json":
+		f.log = logging.New(logging.NewJSONHandler(os.Stderr, opts))
+	default:
+		return nil, fmt.Errorf("unknown log format %q, use text or json", f.format)
+	}
+	if f.wire {
+		f.log = f.log.WithWire()
+	}
+	return f.log, nil
+}
+
+// addSecrets has the logs redact secrets wherever they appear
+func (f *logFlags) addSecrets(secrets []string) {
+	if f != nil && f.redactor != nil {
+		f.redactor.Add(secrets...)
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	xproviders "github.com/stevequadros/uploader/providers"
+	"os"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+var lsUsage = `
+uploader ls lists objects under a prefix for every chosen provider. One page is listed per
+provider unless -all is given, continue with -page-token against a single provider.
+
+Usage:
+  uploader ls -config FILE -bucket BUCKET [-prefix PREFIX] [-limit N] [-all] [-page-token TOKEN] [-provider NAME]...
+`
+
+type listing struct {
+	provider xproviders.Provider
+	page     xproviders.ListPage
+	err      error
+}
+
+func runLs(args []string) int {
+	fs := newFlagSet("ls", lsUsage)
+	flags := commonFlags{}
+	var bucket, prefix, pageToken string
+	var limit int
+	var all bool
+	flags.register(fs, "only list these providers, defaults to every configured provider")
+	fs.StringVar(&bucket, "bucket", "", "[REQUIRED] Bucket to list")
+	fs.StringVar(&prefix, "prefix", "", "Only list keys starting with prefix")
+	fs.IntVar(&limit, "limit", 0, "Page size, defaults to the provider's own")
+	fs.BoolVar(&all, "all", false, "List every page instead of only the first")
+	fs.StringVar(&pageToken, "page-token", "", "Continue a previous listing, needs exactly one -provider")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	if flags.configPath == "" || bucket == "" {
+		fs.Usage()
+		return exitUsage
+	}
+	if pageToken != "" && len(flags.providers) != 1 {
+		logError("Error processing flags", errors.New("-page-token belongs to one provider, choose it with -provider"))
+		return exitUsage
+	}
+
+	ctx := context.Background()
+	uploaders, err := flags.uploaders(ctx)
+	if err != nil {
+		return exitFailure
+	}
+
+	opts := xproviders.ListOptions{Prefix: prefix, PageToken: pageToken, MaxKeys: limit}
+	listings := make([]listing, len(uploaders))
+	wg := sync.WaitGroup{}
+	for i, u := range uploaders {
+		wg.Add(1)
+		go func(i int, u xproviders.Uploader) {
+			defer wg.Done()
+			listings[i] = list(ctx, u, bucket, opts, all)
+		}(i, u)
+	}
+	wg.Wait()
+
+	printListings(listings)
+	var failed int
+	for _, l := range listings {
+		if l.err != nil {
+			failed++
+			logError(fmt.Sprintf("Error listing %q", l.provider), l.err)
+		}
+	}
+	return exitCode(failed, len(listings))
+}
+
+func list(ctx context.Context, u xproviders.Uploader, bucket string, opts xproviders.ListOptions, all bool) listing {
+	res := listing{provider: u.GetName()}
+	l, ok := u.(xproviders.Lister)
+	if !ok {
+		res.err = errors.New("listing not supported")
+		return res
+	}
+	if all {
+		res.page.Objects, res.err = xproviders.ListAll(ctx, l, bucket, opts)
+		return res
+	}
+	res.page, res.err = l.List(ctx, bucket, opts)
+	return res
+}
+
+func printListings(listings []listing) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "PROVIDER\tKEY\tSIZE\tMODIFIED")
+	for _, l := range listings {
+		for _, o := range l.page.Objects {
+			fmt.Fprintf(w, "%s\t%s\t%d\t%s\n", l.provider, o.Key, o.Size, o.LastModified.Format(time.RFC3339))
+		}
+	}
+	_ = w.Flush()
+
+	for _, l := range listings {
+		if l.page.NextPageToken != "" {
+			fmt.Printf("\nmore objects in %s, continue with: -provider %s -page-token %s\n", l.provider, l.provider, l.page.NextPageToken)
+		}
+	}
+}
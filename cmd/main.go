@@ -1,14 +1,11 @@
 package main
 
 import (
-	"context"
 	"errors"
-	"flag"
 	"fmt"
-	"github.com/stevequadros/uploader/config"
+	"github.com/stevequadros/uploader/keys"
 	xproviders "github.com/stevequadros/uploader/providers"
-	"github.com/stevequadros/uploader/providers/coordinator"
-	pinit "github.com/stevequadros/uploader/providers/initializer"
+	"io"
 	"os"
 	"strings"
 )
@@ -28,86 +25,76 @@ func (i *providerFlag) Set(value string) error {
 	return nil
 }
 
+func (i providerFlag) has(p xproviders.Provider) bool {
+	for _, name := range i {
+		if name == p {
+			return true
+		}
+	}
+	return false
+}
+
 var usage = `
-uploader uploads a file to any of the provider providers [aws, gcp, azure] to the given bucket, key.
+uploader uploads, inspects and manages files across the providers [aws, gcp, azure].
+
+Usage:
+  uploader <command> [flags]
+
+Commands:
+` + commandList() + `
+Run "uploader <command> -h" for the flags of a command. Commands share -config and -provider.
+Commands that write or delete require at least one -provider, the others default to every
+configured provider.
+
+Exit codes: 0 success, 1 failure, 2 invalid usage, 3 failed on some providers only, 130
+interrupted by SIGINT or SIGTERM. Flags may follow arguments, -- ends them for arguments that
+start with -.
 
-see example_config.json to get started on your config file. 
+see example_config.json to get started on your config file, or run "uploader config init".
 
 Example Usage:
-./uploader --provider aws --provider azure --provider gcp --file test.txt --config ~/.filescom/config.json -bucket filescometestagain -key test.txt
+./uploader upload --provider aws --provider azure --provider gcp --file test.txt --config ~/.filescom/config.json -bucket filescometestagain -key test.txt
 `
 
 func main() {
-	providers := providerFlag{}
-	var filename, configPath, bucket, key string
-	flag.Var(&providers, "provider", "[REQUIRED 1+] Providers targeted. Valid Options: aws, gcp, azure. Each one must be preceded with it's own flag, ex: -provider aws -provider azure -provider gcp")
-	flag.StringVar(&filename, "file", "", "[REQUIRED] The file to upload")
-	flag.StringVar(&configPath, "config", "", "[REQUIRED] Path to config.json")
-	flag.StringVar(&bucket, "bucket", "", "[REQUIRED] Target bucket for file. Will Create bucket if it doesn't exist.")
-	flag.StringVar(&key, "key", "", "[REQUIRED] key for file")
-	flag.Parse()
-
-	if flag.NFlag() == 0 {
-		flag.Usage = func() {
-			fmt.Println(usage)
-			flag.PrintDefaults()
-		}
-		flag.Usage()
-		os.Exit(1)
-	}
+	os.Exit(run(os.Args[1:]))
+}
 
-	if err := validateFlags(providers, filename, configPath, bucket, key); err != nil {
-		logErrorAndExit("Error processing flags", err)
+func run(args []string) int {
+	if len(args) == 0 {
+		fmt.Println(usage)
+		return exitUsage
 	}
-
-	logInProcess("Validating config")
-	cfg, err := config.New(configPath)
-	if err != nil {
-		logErrorAndExit("Config error", err)
+	// flags without a command are the original single upload invocation
+	if strings.HasPrefix(args[0], "-") && args[0] != "-h" && args[0] != "--help" {
+		return runUpload(args)
 	}
-	logSuccess("Config validated")
-
-	logInProcess("Checking File to upload")
-	file := validateUploadFile(filename)
-	defer func() {
-		if err = file.Close(); err != nil {
-			logErrorAndExit("error closing upload file", err)
+	for _, c := range commands {
+		if c.name == args[0] {
+			return c.run(args[1:])
 		}
-	}()
-	logSuccess("Upload file valid")
-
-	logInProcess("Initializing Providers")
-	ctx := context.Background()
-	var uploaders []xproviders.Uploader
-	uploaders, err = pinit.Init(ctx, cfg)
-	logSuccess(fmt.Sprintf("Providers Initialized: %v", providers))
-
-	logInProcess("Beginning Uploads")
-	var coord coordinator.Coordinator
-	coord, err = coordinator.NewCoordinator(uploaders)
-	res, err := coord.Do(ctx, bucket, key, file)
-	if err != nil {
-		logError("Error uploading", err)
 	}
-	for _, p := range res.Done {
-		logSuccess(fmt.Sprintf("Successfully Uploaded to %q", p))
+	if args[0] != "help" && args[0] != "-h" && args[0] != "--help" {
+		fmt.Printf("unknown command %q\n", args[0])
 	}
-
-	for _, e := range res.Failed {
-		logError(fmt.Sprintf("Error Uploading file to %q: ", e.Provider), e.Error)
-	}
-	fmt.Printf("\nUploaded %q to %d / %d providers\n", file.Name(), len(res.Done), len(providers))
-	os.Exit(0)
+	fmt.Println(usage)
+	return exitUsage
 }
 
-func validateFlags(providers providerFlag, filename, configPath, bucket, key string) error {
+func validateFlags(providers providerFlag, paths []string, configPath, bucket, key string) error {
 	var validationErrors []error
 	if err := validateProviders(providers); err != nil {
 		validationErrors = append(validationErrors, err)
 	}
 
-	if filename == "" {
-		validationErrors = append(validationErrors, errors.New("filename flag to upload cannot be empty"))
+	if len(paths) == 0 {
+		validationErrors = append(validationErrors, errors.New("file flag to upload cannot be empty"))
+	}
+	for _, p := range paths {
+		if p == "" {
+			validationErrors = append(validationErrors, errors.New("file flag to upload cannot be empty"))
+			break
+		}
 	}
 
 	if configPath == "" {
@@ -118,8 +105,15 @@ func validateFlags(providers providerFlag, filename, configPath, bucket, key str
 		validationErrors = append(validationErrors, errors.New("bucket flag cannot be empty"))
 	}
 
-	if key == "" {
-		validationErrors = append(validationErrors, errors.New("key flag cannot be empty"))
+	if key != "" && len(paths) > 1 && !keys.IsTemplate(key) {
+		validationErrors = append(validationErrors, errors.New("key flag names a single file, use prefix or a key template for several"))
+	}
+
+	for _, p := range paths {
+		if p == stdinPath && (len(paths) > 1 || key == "") {
+			validationErrors = append(validationErrors, errors.New("file - streams stdin on its own and needs the key flag"))
+			break
+		}
 	}
 
 	if len(validationErrors) > 0 {
@@ -133,14 +127,6 @@ func validateFlags(providers providerFlag, filename, configPath, bucket, key str
 	}
 }
 
-func validateUploadFile(path string) *os.File {
-	file, err := os.Open(path)
-	if err != nil {
-		handleErrAndExit("could not open file to upload ", err)
-	}
-	return file
-}
-
 func validateProviders(providers []xproviders.Provider) error {
 	if len(providers) == 0 {
 		return errors.New("providers cannot be empty")
@@ -158,27 +144,18 @@ func validateProviders(providers []xproviders.Provider) error {
 	}
 }
 
-func handleErrAndExit(prepend string, err error) {
-	if err != nil {
-		logError(prepend, err)
-		os.Exit(1)
-	}
-}
+// logOut is where progress lines go, commands writing data to stdout switch it to stderr
+var logOut io.Writer = os.Stdout
 
 func logInProcess(s string) {
-	fmt.Println(s + "...")
+	fmt.Fprintln(logOut, s+"...")
 }
 
 func logError(prepend string, e error) {
-	fmt.Println("\t✗ " + prepend)
-	fmt.Println("\t\t", e)
-}
-
-func logErrorAndExit(prepend string, e error) {
-	logError(prepend, e)
-	os.Exit(1)
+	fmt.Fprintln(logOut, "\t✗ "+prepend)
+	fmt.Fprintln(logOut, "\t\t", e)
 }
 
 func logSuccess(s string) {
-	fmt.Println("\t✓ " + s)
+	fmt.Fprintln(logOut, "\t✓ "+s)
 }
@@ -1,10 +1,19 @@
 package main
 
 import (
+	"errors"
 	"fmt"
+	"github.com/stevequadros/uploader/config"
 	xproviders "github.com/stevequadros/uploader/providers"
+	"github.com/stevequadros/uploader/providers/auditor"
+	"github.com/stevequadros/uploader/providers/coordinator"
 	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"testing/iotest"
+	"time"
 )
 
 func TestValidProviders(t *testing.T) {
@@ -32,31 +41,279 @@ func TestValidProviders(t *testing.T) {
 func Test_validateFlags(t *testing.T) {
 	type args struct {
 		providers  providerFlag
-		filename   string
+		paths      []string
 		configPath string
 		bucket     string
 		key        string
 	}
 
-	filename, configPath, bucket, key := "test", "test", "test", "test"
+	paths, configPath, bucket, key := []string{"test"}, "test", "test", "test"
 
 	tests := []struct {
 		name    string
 		args    args
 		wantErr bool
 	}{
-		{"valid flags no errors", args{providerFlag{"aws"}, filename, configPath, bucket, key}, false},
-		{"filename blank errors", args{providerFlag{"aws"}, "", configPath, bucket, key}, true},
-		{"configpath blank errors", args{providerFlag{"aws"}, filename, "", bucket, key}, true},
-		{"bucket blank errors", args{providerFlag{"aws"}, filename, configPath, "", key}, true},
-		{"key blank errors", args{providerFlag{"aws"}, filename, configPath, bucket, ""}, true},
+		{"valid flags no errors", args{providerFlag{"aws"}, paths, configPath, bucket, key}, false},
+		{"filename blank errors", args{providerFlag{"aws"}, []string{""}, configPath, bucket, key}, true},
+		{"no files errors", args{providerFlag{"aws"}, nil, configPath, bucket, key}, true},
+		{"configpath blank errors", args{providerFlag{"aws"}, paths, "", bucket, key}, true},
+		{"bucket blank errors", args{providerFlag{"aws"}, paths, configPath, "", key}, true},
+		{"key blank defaults to the file name", args{providerFlag{"aws"}, paths, configPath, bucket, ""}, false},
+		{"key with several files errors", args{providerFlag{"aws"}, []string{"a", "b"}, configPath, bucket, key}, true},
+		{"key template with several files is valid", args{providerFlag{"aws"}, []string{"a", "b"}, configPath, bucket, "{{.Basename}}"}, false},
+		{"stdin with key is valid", args{providerFlag{"aws"}, []string{"-"}, configPath, bucket, key}, false},
+		{"stdin without key errors", args{providerFlag{"aws"}, []string{"-"}, configPath, bucket, ""}, true},
+		{"stdin with other files errors", args{providerFlag{"aws"}, []string{"-", "a"}, configPath, bucket, ""}, true},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if err := validateFlags(tt.args.providers, tt.args.filename, tt.args.configPath, tt.args.bucket, tt.args.key); (err != nil) != tt.wantErr {
+			if err := validateFlags(tt.args.providers, tt.args.paths, tt.args.configPath, tt.args.bucket, tt.args.key); (err != nil) != tt.wantErr {
 				t.Errorf("validateFlags() error = %v, wantErr %v", err, tt.wantErr)
 			}
 		})
 	}
 }
+
+func Test_selectProviders(t *testing.T) {
+	cfg := config.Config{AWS: config.NewAWS("file", "profile"), GCP: config.NewGCP("file")}
+
+	tests := []struct {
+		name      string
+		providers providerFlag
+		want      config.Config
+		wantErr   bool
+	}{
+		{"none selected keeps all", nil, cfg, false},
+		{"selects subset", providerFlag{xproviders.GCP}, config.Config{GCP: cfg.GCP}, false},
+		{"unconfigured provider errors", providerFlag{xproviders.Azure}, config.Config{}, true},
+		{"invalid provider errors", providerFlag{"foo"}, cfg, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := selectProviders(cfg, tt.providers)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_exitCode(t *testing.T) {
+	require.Equal(t, exitOK, exitCode(0, 3))
+	require.Equal(t, exitPartial, exitCode(1, 3))
+	require.Equal(t, exitFailure, exitCode(3, 3))
+}
+
+func Test_batchExitCode(t *testing.T) {
+	failed := []coordinator.DoError{{Provider: xproviders.Azure, Error: errors.New("denied")}}
+	one := coordinator.FileResult{Done: []xproviders.Provider{xproviders.AWS, xproviders.GCP}, Failed: failed}
+	require.Equal(t, exitPartial, batchExitCode(coordinator.BatchResult{Files: []coordinator.FileResult{one}}), "one file failing on one of three providers")
+	require.Equal(t, exitFailure, batchExitCode(coordinator.BatchResult{Files: []coordinator.FileResult{{Failed: failed}}}))
+	require.Equal(t, exitOK, batchExitCode(coordinator.BatchResult{Files: []coordinator.FileResult{{Done: []xproviders.Provider{xproviders.AWS}}}}))
+}
+
+func Test_validateCopy(t *testing.T) {
+	tests := []struct {
+		name    string
+		from    xproviders.Provider
+		to      providerFlag
+		wantErr bool
+	}{
+		{"valid copy", xproviders.AWS, providerFlag{xproviders.GCP, xproviders.Azure}, false},
+		{"no destinations errors", xproviders.AWS, nil, true},
+		{"source as destination errors", xproviders.AWS, providerFlag{xproviders.AWS}, true},
+		{"invalid provider errors", "foo", providerFlag{xproviders.AWS}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateCopy(tt.from, tt.to)
+			if tt.wantErr {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+}
+
+func Test_writeDownload(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "out.txt")
+	n, err := writeDownload(out, strings.NewReader("hello"))
+	require.NoError(t, err)
+	require.Equal(t, int64(5), n)
+	b, err := os.ReadFile(out)
+	require.NoError(t, err)
+	require.Equal(t, "hello", string(b))
+
+	_, err = writeDownload(out, iotest.ErrReader(errors.New("boom")))
+	require.Error(t, err)
+	b, err = os.ReadFile(out)
+	require.NoError(t, err)
+	require.Equal(t, "hello", string(b), "a failed download leaves the existing file alone")
+	entries, err := os.ReadDir(filepath.Dir(out))
+	require.NoError(t, err)
+	require.Len(t, entries, 1, "temp files are cleaned up")
+}
+
+func Test_formatBytes(t *testing.T) {
+	require.Equal(t, "512 B", formatBytes(512))
+	require.Equal(t, "1.5 KiB", formatBytes(1536))
+	require.Equal(t, "2.0 GiB", formatBytes(2<<30))
+}
+
+func Test_progressBar(t *testing.T) {
+	p := coordinator.ProviderProgress{
+		Provider:   xproviders.AWS,
+		Sent:       512 << 10,
+		Total:      2 << 20,
+		Files:      4,
+		Done:       1,
+		Active:     2,
+		Retries:    1,
+		Throughput: 256 << 10,
+		ETA:        6 * time.Second,
+	}
+	require.Equal(t, "aws    [======>                       ]  25%  512.0 KiB / 2.0 MiB at 256.0 KiB/s, ETA 6s  1 / 4 files, 2 uploading, 1 retries", progressBar(p))
+
+	p = coordinator.ProviderProgress{Provider: xproviders.GCP, Sent: 100, Files: 1, Active: 1}
+	require.Equal(t, "gcp    [                              ]       100 B at 0 B/s  0 / 1 files, 1 uploading", progressBar(p))
+}
+
+func Test_progressMode(t *testing.T) {
+	mode, err := progressMode("none")
+	require.NoError(t, err)
+	require.Equal(t, "none", mode)
+	out := logOut
+	defer func() { logOut = out }()
+	logOut = &strings.Builder{}
+	mode, err = progressMode("auto")
+	require.NoError(t, err)
+	require.Equal(t, "log", mode)
+	_, err = progressMode("bars")
+	require.Error(t, err)
+}
+
+func Test_batchReport(t *testing.T) {
+	began := time.Now().Add(-time.Minute)
+	jobs := []coordinator.BatchFile{
+		{Path: "a.txt", Key: "a.txt", Keys: coordinator.ProviderKeys{xproviders.GCP: "gcp/a.txt"}},
+		{Path: "b.txt", Key: "b.txt"},
+	}
+	res := coordinator.BatchResult{Files: []coordinator.FileResult{
+		{
+			Path:      "a.txt",
+			Key:       "a.txt",
+			Size:      10,
+			Done:      []xproviders.Provider{xproviders.AWS, xproviders.GCP},
+			Checksums: xproviders.Checksums{SHA256: "abc"},
+			Versions:  map[xproviders.Provider]string{xproviders.AWS: "v1"},
+		},
+		{
+			Path:     "b.txt",
+			Key:      "b.txt",
+			Size:     20,
+			Failed:   []coordinator.DoError{{Provider: xproviders.AWS, Error: fmt.Errorf("%w: nothing read for 1m", coordinator.ErrTimeout)}},
+			Deferred: []xproviders.Provider{xproviders.GCP},
+		},
+	}}
+	rec := newUploadRecorder()
+	for _, ev := range []coordinator.Event{
+		{Type: coordinator.EventStarted, Provider: xproviders.AWS, Path: "a.txt", Key: "a.txt", Time: began},
+		{Type: coordinator.EventRetry, Provider: xproviders.AWS, Path: "a.txt", Key: "a.txt", Time: began.Add(time.Second)},
+		{Type: coordinator.EventDone, Provider: xproviders.AWS, Path: "a.txt", Key: "a.txt", Time: began.Add(2 * time.Second)},
+	} {
+		rec.Handle(ev)
+	}
+
+	report := batchReport("bucket", jobs, res, rec, began)
+	require.Equal(t, 2, report.Files)
+	require.Equal(t, 1, report.Failed)
+	require.Len(t, report.Uploads, 4)
+	aws, gcp := report.Uploads[0], report.Uploads[1]
+	require.Equal(t, "done", aws.Status)
+	require.Equal(t, 2, aws.Attempts)
+	require.Equal(t, 2.0, aws.Duration)
+	require.Equal(t, "v1", aws.VersionID)
+	require.Equal(t, "abc", aws.Checksums.SHA256)
+	require.Equal(t, "gcp/a.txt", gcp.Key)
+	require.Empty(t, gcp.VersionID)
+	require.Equal(t, "deferred", report.Uploads[2].Status)
+	failed := report.Uploads[3]
+	require.Equal(t, "failed", failed.Status)
+	require.Equal(t, "timeout", failed.ErrorClass)
+	require.Nil(t, failed.Checksums)
+
+	d := destinationReport{}
+	d.fail(fmt.Errorf("verifying: %w", xproviders.NewError(xproviders.Azure, "upload", 403, "AuthenticationFailed", "req-1", errors.New("denied"))))
+	require.Equal(t, destinationReport{
+		Error:      "verifying: azure upload failed: denied (status 403, request ID req-1)",
+		ErrorClass: "auth",
+		StatusCode: 403,
+		RequestID:  "req-1",
+	}, d)
+
+	junit := junitReport(report)
+	require.Equal(t, 4, junit.Tests)
+	require.Equal(t, 1, junit.Failures)
+	require.Equal(t, 1, junit.Skipped)
+	require.Len(t, junit.Suites, 2)
+	require.Equal(t, "aws", junit.Suites[0].Name)
+	require.Equal(t, "2.000", junit.Suites[0].Time)
+	require.Equal(t, "b.txt to bucket/b.txt", junit.Suites[0].Cases[1].Name)
+	require.Equal(t, "timeout", junit.Suites[0].Cases[1].Failure.Type)
+}
+
+func Test_verifiedNote(t *testing.T) {
+	verified := map[xproviders.Provider]xproviders.Checksums{
+		xproviders.AWS: {},
+		xproviders.GCP: {MD5: "a", CRC32C: "b"},
+	}
+	require.Equal(t, "", verifiedNote(nil, xproviders.AWS))
+	require.Equal(t, ", size verified", verifiedNote(verified, xproviders.AWS))
+	require.Equal(t, ", md5 and crc32c verified", verifiedNote(verified, xproviders.GCP))
+}
+
+func Test_auditExitCode(t *testing.T) {
+	ok := auditor.ProviderSummary{Provider: xproviders.AWS}
+	fixed := auditor.Issue{Stale: []xproviders.Provider{xproviders.GCP}, Repaired: []xproviders.Provider{xproviders.GCP}}
+	broken := auditor.Issue{Stale: []xproviders.Provider{xproviders.GCP}}
+
+	require.Equal(t, exitOK, auditExitCode(auditor.Report{Providers: []auditor.ProviderSummary{ok}}, false))
+	require.Equal(t, exitFailure, auditExitCode(auditor.Report{Issues: []auditor.Issue{fixed}}, false), "issues found without repairing")
+	require.Equal(t, exitOK, auditExitCode(auditor.Report{Issues: []auditor.Issue{fixed}}, true))
+	require.Equal(t, exitPartial, auditExitCode(auditor.Report{Issues: []auditor.Issue{fixed, broken}}, true))
+	require.Equal(t, exitFailure, auditExitCode(auditor.Report{Providers: []auditor.ProviderSummary{{Error: "denied"}}}, true))
+}
+
+func Test_parseArgs(t *testing.T) {
+	fs := newFlagSet("test", "")
+	var bucket string
+	var del bool
+	fs.StringVar(&bucket, "bucket", "", "")
+	fs.BoolVar(&del, "delete", false, "")
+
+	positional, err := parseArgs(fs, []string{"dir", "--bucket", "b", "other", "-delete"})
+	require.NoError(t, err)
+	require.Equal(t, []string{"dir", "other"}, positional)
+	require.Equal(t, "b", bucket)
+	require.True(t, del)
+
+	// -- ends the flags, even between positional arguments
+	del = false
+	positional, err = parseArgs(fs, []string{"dir", "--", "-delete", "--bucket"})
+	require.NoError(t, err)
+	require.Equal(t, []string{"dir", "-delete", "--bucket"}, positional)
+	require.False(t, del)
+
+	positional, err = parseArgs(fs, []string{"-bucket", "c", "--", "-x"})
+	require.NoError(t, err)
+	require.Equal(t, []string{"-x"}, positional)
+	require.Equal(t, "c", bucket)
+}
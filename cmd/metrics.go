@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"github.com/stevequadros/uploader/providers/coordinator"
+	"github.com/stevequadros/uploader/providers/metrics"
+	"net"
+	"net/http"
+	"time"
+)
+
+// metricsFlags export the metrics of uploads, served while they run or written once they end
+type metricsFlags struct {
+	listen   string
+	textfile string
+
+	registry *metrics.Registry
+	server   *http.Server
+}
+
+func (f *metricsFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&f.listen, "metrics-listen", "", "Serve Prometheus metrics on /metrics at this address while uploading, ex: :9090")
+	fs.StringVar(&f.textfile, "metrics-textfile", "", "Write Prometheus metrics to this file once uploads end, ex: for node_exporter's textfile collector")
+}
+
+// start serves the metrics when -metrics-listen is given and returns the option counting the
+// coordinator's uploads in them, none when metrics are off
+func (f *metricsFlags) start() ([]coordinator.Option, error) {
+	if f.listen == "" && f.textfile == "" {
+		return nil, nil
+	}
+	f.registry = metrics.NewRegistry()
+	m := coordinator.NewMetrics(f.registry)
+	if f.listen != "" {
+		ln, err := net.Listen("tcp", f.listen)
+		if err != nil {
+			return nil, err
+		}
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", f.registry.Handler())
+		f.server = &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+		go func() { _ = f.server.Serve(ln) }()
+		logSuccess("Serving metrics on http://" + ln.Addr().String() + "/metrics")
+	}
+	return []coordinator.Option{coordinator.WithMetrics(m)}, nil
+}
+
+// stop writes -metrics-textfile and stops serving the metrics
+func (f *metricsFlags) stop() {
+	if f.registry == nil {
+		return
+	}
+	if f.textfile != "" {
+		if err := f.registry.WriteTextfile(f.textfile); err != nil {
+			logError("Error writing metrics", err)
+		}
+	}
+	if f.server != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		_ = f.server.Shutdown(ctx)
+	}
+}
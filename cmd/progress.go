@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"github.com/stevequadros/uploader/providers/coordinator"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// barInterval is how often progress bars are redrawn, logInterval how often progress is logged
+	barInterval = 200 * time.Millisecond
+	logInterval = 10 * time.Second
+	barWidth    = 30
+)
+
+// progressMode picks how upload progress is shown for -progress: bars on a terminal, log lines
+// otherwise, or none
+func progressMode(mode string) (string, error) {
+	switch mode {
+	case "auto":
+		if f, ok := logOut.(*os.File); ok && isTerminal(f) {
+			return "bar", nil
+		}
+		return "log", nil
+	case "bar", "log", "none":
+		return mode, nil
+	}
+	return "", fmt.Errorf("-progress must be auto, bar, log or none, not %q", mode)
+}
+
+// showProgress renders t to logOut as mode says until the returned func is called, which draws
+// the bars a last time
+func showProgress(mode string, t *coordinator.Tracker) func() {
+	if mode == "none" {
+		return func() {}
+	}
+	interval := logInterval
+	if mode == "bar" {
+		interval = barInterval
+	}
+	done := make(chan struct{})
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		var drawn int
+		for {
+			select {
+			case <-ticker.C:
+			case <-done:
+				if mode == "bar" {
+					drawBars(logOut, t.Progress(), drawn)
+				}
+				return
+			}
+			if mode == "bar" {
+				drawn = drawBars(logOut, t.Progress(), drawn)
+			} else {
+				logProgress(logOut, t.Progress())
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		wg.Wait()
+	}
+}
+
+// drawBars draws a bar for every provider over the drawn lines drawn before, and returns how
+// many it drew
+func drawBars(w io.Writer, progress []coordinator.ProviderProgress, drawn int) int {
+	var b strings.Builder
+	if drawn > 0 {
+		fmt.Fprintf(&b, "\x1b[%dA", drawn)
+	}
+	for _, p := range progress {
+		fmt.Fprintf(&b, "\x1b[2K%s\n", progressBar(p))
+	}
+	fmt.Fprint(w, b.String())
+	return len(progress)
+}
+
+// progressBar is one provider's line of progress bars
+func progressBar(p coordinator.ProviderProgress) string {
+	bar := strings.Repeat(" ", barWidth)
+	percent := "    "
+	if p.Total > 0 {
+		filled := int(p.Sent * barWidth / p.Total)
+		if filled > barWidth {
+			filled = barWidth
+		}
+		bar = strings.Repeat("=", filled) + strings.Repeat(" ", barWidth-filled)
+		if filled > 0 && filled < barWidth {
+			bar = bar[:filled-1] + ">" + bar[filled:]
+		}
+		percent = fmt.Sprintf("%3d%%", p.Sent*100/p.Total)
+	}
+	return fmt.Sprintf("%-6s [%s] %s  %s  %s", p.Provider, bar, percent, progressNote(p), filesNote(p))
+}
+
+// logProgress writes a line of progress for every provider
+func logProgress(w io.Writer, progress []coordinator.ProviderProgress) {
+	for _, p := range progress {
+		fmt.Fprintf(w, "\t%s: %s, %s\n", p.Provider, progressNote(p), filesNote(p))
+	}
+}
+
+// progressNote is the bytes sent, throughput and ETA of p
+func progressNote(p coordinator.ProviderProgress) string {
+	note := formatBytes(p.Sent)
+	if p.Total > 0 {
+		note += " / " + formatBytes(p.Total)
+	}
+	note += fmt.Sprintf(" at %s/s", formatBytes(int64(p.Throughput)))
+	if p.ETA > 0 {
+		note += fmt.Sprintf(", ETA %s", p.ETA.Round(time.Second))
+	}
+	return note
+}
+
+// filesNote counts p's uploads by state, leaving out those none are in
+func filesNote(p coordinator.ProviderProgress) string {
+	note := fmt.Sprintf("%d / %d files", p.Done+p.Skipped, p.Files)
+	for _, c := range []struct {
+		n    int
+		name string
+	}{{p.Active, "uploading"}, {p.Failed, "failed"}, {p.Deferred, "deferred"}, {p.Retries, "retries"}} {
+		if c.n > 0 {
+			note += fmt.Sprintf(", %d %s", c.n, c.name)
+		}
+	}
+	return note
+}
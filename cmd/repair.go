@@ -0,0 +1,189 @@
+package main
+
+import (
+	"fmt"
+	"github.com/stevequadros/uploader/providers/ratelimit"
+	"github.com/stevequadros/uploader/providers/repair"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+	"text/tabwriter"
+)
+
+var repairUsage = `
+uploader repair retries the uploads queued after failing on a provider. Each object is uploaded
+again from its local file when the file still holds what was uploaded, otherwise it is copied
+from a provider that has it. Repaired uploads leave the queue, the others stay for next time.
+Uploads that failed on credentials or on the object itself, or failed every repair so far, are
+parked: they stay queued but are only retried with -parked, once the cause is fixed.
+
+Usage:
+  uploader repair -config FILE [-repair-queue PATH] [-provider NAME]... [-list] [-parked] [-deferred]
+`
+
+func runRepair(args []string) int {
+	fs := newFlagSet("repair", repairUsage)
+	var configPath, queuePath string
+	var list, parked, deferred bool
+	only := providerFlag{}
+	fs.StringVar(&configPath, "config", "", "[REQUIRED] Path to config.json")
+	fs.Var(&only, "provider", "only repair uploads to these providers, defaults to every queued provider")
+	fs.StringVar(&queuePath, "repair-queue", defaultQueuePath(), "Journal the failed uploads were queued in")
+	fs.BoolVar(&list, "list", false, "Show the queued uploads without retrying them")
+	fs.BoolVar(&parked, "parked", false, "Also retry the parked uploads")
+	fs.BoolVar(&deferred, "deferred", false, "Only retry the uploads deferred past a soft deadline, as upload does in the background")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	if queuePath == "" || (configPath == "" && !list) {
+		fs.Usage()
+		return exitUsage
+	}
+	if len(only) > 0 {
+		if err := validateProviders(only); err != nil {
+			logError("Error processing flags", err)
+			return exitUsage
+		}
+	}
+
+	if deferred {
+		// started by upload in the background, it carries on once the terminal is closed
+		signal.Ignore(syscall.SIGHUP)
+	}
+
+	q, err := repair.Open(queuePath)
+	if err != nil {
+		logError("Error reading the repair queue", err)
+		return exitFailure
+	}
+	var entries []repair.Entry
+	for _, e := range q.Entries() {
+		if (len(only) == 0 || only.has(e.Provider)) && (!deferred || e.Deferred()) && (!e.Parked || parked || list) {
+			entries = append(entries, e)
+		}
+	}
+	if list {
+		printQueue(entries)
+		return exitOK
+	}
+	if len(entries) == 0 {
+		logSuccess("Nothing to repair")
+		return exitOK
+	}
+
+	// every configured provider, the ones that have a copy may be needed as sources
+	// an interrupt cancels at once, what was uploaded so far is aborted on each provider
+	ctx, stop := interruptContext(0)
+	defer stop()
+	uploaders, cfg, err := loadUploaders(ctx, configPath, nil, nil)
+	if err != nil {
+		return exitFailure
+	}
+
+	logInProcess(fmt.Sprintf("Repairing %d uploads", len(entries)))
+	var failed int
+	for _, r := range repair.Run(ctx, q, uploaders, ratelimit.New(float64(cfg.Bandwidth)), entries) {
+		e := r.Entry
+		if r.Err != nil {
+			failed++
+			logError(fmt.Sprintf("Error repairing %s/%s on %q: ", e.Bucket, e.Key, e.Provider), r.Err)
+			if e.Parked {
+				fmt.Fprintln(logOut, "\tparked, retry it with -parked once the cause is fixed")
+			}
+			continue
+		}
+		logSuccess(fmt.Sprintf("Repaired %s/%s on %q from %s", e.Bucket, e.Key, e.Provider, r.From))
+	}
+	// -deferred runs alongside uploads that may be queueing failures, compacting would drop them
+	if !deferred {
+		if err = q.Compact(); err != nil {
+			logError("Error compacting the repair queue", err)
+		}
+	}
+	fmt.Fprintf(logOut, "\nRepaired %d / %d uploads, %d left in %s\n", len(entries)-failed, len(entries), q.Len(), q.Path())
+	if ctx.Err() != nil {
+		return exitInterrupted
+	}
+	return exitCode(failed, len(entries))
+}
+
+func printQueue(entries []repair.Entry) {
+	if len(entries) == 0 {
+		fmt.Fprintln(logOut, "The repair queue is empty")
+		return
+	}
+	w := tabwriter.NewWriter(logOut, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "PROVIDER\tBUCKET\tKEY\tATTEMPTS\tQUEUED\tERROR")
+	for _, e := range entries {
+		attempts := fmt.Sprint(e.Attempts)
+		if e.Parked {
+			attempts += ", parked"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", e.Provider, e.Bucket, e.Key, attempts, e.Created.Local().Format("2006-01-02 15:04"), e.Error)
+	}
+	_ = w.Flush()
+}
+
+// defaultQueuePath is the repair queue used when -repair-queue isn't given, empty if there is
+// no config directory to keep it in
+func defaultQueuePath() string {
+	path, err := repair.DefaultPath()
+	if err != nil {
+		return ""
+	}
+	return path
+}
+
+// queueFailures records failed uploads for uploader repair and reports whether they were queued,
+// a queue that can't be written is reported without failing the upload
+func queueFailures(queuePath string, entries []repair.Entry) bool {
+	if queuePath == "" || len(entries) == 0 {
+		return false
+	}
+	q, err := repair.Open(queuePath)
+	if err == nil {
+		err = q.Add(entries...)
+	}
+	if err != nil {
+		logError("Error queueing failed uploads for repair", err)
+		return false
+	}
+	fmt.Fprintf(logOut, "%d failed or deferred uploads queued, retry them with: uploader repair -config FILE\n", len(entries))
+	var parked int
+	for _, e := range entries {
+		if e.Parked {
+			parked++
+		}
+	}
+	if parked > 0 {
+		fmt.Fprintf(logOut, "%d of them failed on credentials or on the object itself and are parked, fix the cause then retry them with -parked\n", parked)
+	}
+	return true
+}
+
+// repairInBackground starts uploader repair -deferred on the queue in a process of its own that
+// upload doesn't wait for, logging to the queue's path followed by .log
+func repairInBackground(configPath, queuePath string) {
+	exe, err := os.Executable()
+	if err != nil {
+		logError("Error starting the repair of deferred uploads, run uploader repair instead", err)
+		return
+	}
+	logPath := queuePath + ".log"
+	out, err := os.OpenFile(logPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		logError("Error starting the repair of deferred uploads, run uploader repair instead", err)
+		return
+	}
+	defer out.Close()
+	cmd := exec.Command(exe, "repair", "-config", configPath, "-repair-queue", queuePath, "-deferred")
+	cmd.Stdout, cmd.Stderr = out, out
+	if err = cmd.Start(); err != nil {
+		logError("Error starting the repair of deferred uploads, run uploader repair instead", err)
+		return
+	}
+	// not waited for, the repair goes on once upload exits
+	_ = cmd.Process.Release()
+	fmt.Fprintf(logOut, "Repairing the deferred uploads in the background, see %s\n", logPath)
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	xproviders "github.com/stevequadros/uploader/providers"
+	"github.com/stevequadros/uploader/providers/coordinator"
+	"os"
+	"sync"
+	"time"
+)
+
+// uploadReport is what -output json prints and -junit is written from
+type uploadReport struct {
+	Bucket      string              `json:"bucket"`
+	Started     time.Time           `json:"started"`
+	Duration    float64             `json:"durationSeconds"`
+	Files       int                 `json:"files"`
+	Failed      int                 `json:"failed"`
+	Interrupted bool                `json:"interrupted,omitempty"`
+	Uploads     []destinationReport `json:"uploads"`
+}
+
+// destinationReport is the upload of one file, or stdin, to one provider
+type destinationReport struct {
+	// Path is - for stdin
+	Path     string              `json:"path"`
+	Provider xproviders.Provider `json:"provider"`
+	Bucket   string              `json:"bucket"`
+	Key      string              `json:"key"`
+	// Status is done, skipped, failed, deferred or not-started
+	Status   string  `json:"status"`
+	Bytes    int64   `json:"bytes"`
+	Duration float64 `json:"durationSeconds"`
+	// Attempts counts the first try and every retry that sent the content again
+	Attempts   int                   `json:"attempts"`
+	Resumed    int64                 `json:"resumedBytes,omitempty"`
+	Checksums  *xproviders.Checksums `json:"checksums,omitempty"`
+	VersionID  string                `json:"versionId,omitempty"`
+	Error      string                `json:"error,omitempty"`
+	ErrorClass string                `json:"errorClass,omitempty"`
+	// StatusCode and RequestID are the provider's answer to the failed request, when it gave one
+	StatusCode int    `json:"statusCode,omitempty"`
+	RequestID  string `json:"requestId,omitempty"`
+}
+
+// fail sets the report of a failed upload from its error
+func (d *destinationReport) fail(err error) {
+	d.Error, d.ErrorClass = err.Error(), coordinator.ErrorClass(err)
+	var perr *xproviders.Error
+	if errors.As(err, &perr) {
+		d.StatusCode, d.RequestID = perr.StatusCode, perr.RequestID
+	}
+}
+
+// uploadRecorder times every upload and counts its attempts from the coordinator's events
+type uploadRecorder struct {
+	mu      sync.Mutex
+	uploads map[recordedID]*recordedUpload
+}
+
+type recordedID struct {
+	provider  xproviders.Provider
+	path, key string
+}
+
+type recordedUpload struct {
+	started, ended time.Time
+	attempts       int
+}
+
+func newUploadRecorder() *uploadRecorder {
+	return &uploadRecorder{uploads: map[recordedID]*recordedUpload{}}
+}
+
+func (r *uploadRecorder) Handle(ev coordinator.Event) {
+	if ev.Type == coordinator.EventProgress {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	id := recordedID{ev.Provider, ev.Path, ev.Key}
+	u, ok := r.uploads[id]
+	if !ok {
+		u = &recordedUpload{}
+		r.uploads[id] = u
+	}
+	switch ev.Type {
+	case coordinator.EventStarted:
+		u.started, u.attempts = ev.Time, 1
+	case coordinator.EventRetry:
+		u.attempts++
+	default:
+		u.ended = ev.Time
+	}
+}
+
+// upload returns how long the upload of path to key on p ran and how many attempts it took
+func (r *uploadRecorder) upload(p xproviders.Provider, path, key string) (time.Duration, int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	u, ok := r.uploads[recordedID{p, path, key}]
+	if !ok || u.started.IsZero() || u.ended.IsZero() {
+		return 0, 0
+	}
+	return u.ended.Sub(u.started), u.attempts
+}
+
+// batchReport reports every upload of a DoBatch
+func batchReport(bucket string, jobs []coordinator.BatchFile, res coordinator.BatchResult, rec *uploadRecorder, began time.Time) uploadReport {
+	report := uploadReport{
+		Bucket:   bucket,
+		Started:  began,
+		Duration: time.Since(began).Seconds(),
+		Files:    len(res.Files),
+		Failed:   res.Failed(),
+		Uploads:  []destinationReport{},
+	}
+	for i, f := range res.Files {
+		add := func(p xproviders.Provider, status string, err error) {
+			d := destinationReport{
+				Path:     f.Path,
+				Provider: p,
+				Bucket:   bucket,
+				Key:      jobs[i].KeyFor(p),
+				Status:   status,
+				Bytes:    f.Size,
+				Resumed:  f.Resumed[p],
+			}
+			duration, attempts := rec.upload(p, d.Path, d.Key)
+			d.Duration, d.Attempts = duration.Seconds(), attempts
+			if status == "done" && !f.Checksums.Empty() {
+				sums := f.Checksums
+				d.Checksums, d.VersionID = &sums, f.Versions[p]
+			}
+			if err != nil {
+				d.fail(err)
+			}
+			report.Uploads = append(report.Uploads, d)
+		}
+		for _, p := range f.Done {
+			add(p, "done", nil)
+		}
+		for _, p := range f.Skipped {
+			add(p, "skipped", nil)
+		}
+		for _, p := range f.Deferred {
+			add(p, "deferred", nil)
+		}
+		for _, e := range f.Failed {
+			status := "failed"
+			if errors.Is(e.Error, coordinator.ErrNotStarted) {
+				status = "not-started"
+			}
+			add(e.Provider, status, e.Error)
+		}
+	}
+	return report
+}
+
+// streamReport reports every upload of stdin
+func streamReport(bucket string, targets coordinator.ProviderKeys, res coordinator.DoResult, rec *uploadRecorder, began time.Time) uploadReport {
+	report := uploadReport{
+		Bucket:   bucket,
+		Started:  began,
+		Duration: time.Since(began).Seconds(),
+		Files:    1,
+		Uploads:  []destinationReport{},
+	}
+	if len(res.Failed) > 0 {
+		report.Failed = 1
+	}
+	add := func(p xproviders.Provider, status string, err error) {
+		d := destinationReport{Path: "-", Provider: p, Bucket: bucket, Key: targets[p], Status: status, Bytes: res.Size}
+		duration, attempts := rec.upload(p, "", d.Key)
+		d.Duration, d.Attempts = duration.Seconds(), attempts
+		if status == "done" && !res.Checksums.Empty() {
+			sums := res.Checksums
+			d.Checksums, d.VersionID = &sums, res.Versions[p]
+		}
+		if err != nil {
+			d.fail(err)
+		}
+		report.Uploads = append(report.Uploads, d)
+	}
+	for _, p := range res.Done {
+		add(p, "done", nil)
+	}
+	for _, e := range res.Failed {
+		add(e.Provider, "failed", e.Error)
+	}
+	return report
+}
+
+// writeReports prints report as JSON to stdout for -output json and writes it as JUnit XML to
+// junitPath when given
+func writeReports(output, junitPath string, report uploadReport) {
+	if output == "json" {
+		b, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			logError("Error writing report", err)
+		} else {
+			fmt.Println(string(b))
+		}
+	}
+	if junitPath == "" {
+		return
+	}
+	b, err := xml.MarshalIndent(junitReport(report), "", "  ")
+	if err == nil {
+		err = os.WriteFile(junitPath, append([]byte(xml.Header), append(b, '\n')...), 0644)
+	}
+	if err != nil {
+		logError("Error writing the JUnit report", err)
+	}
+}
+
+type junitSuites struct {
+	XMLName  xml.Name     `xml:"testsuites"`
+	Name     string       `xml:"name,attr"`
+	Tests    int          `xml:"tests,attr"`
+	Failures int          `xml:"failures,attr"`
+	Skipped  int          `xml:"skipped,attr"`
+	Time     string       `xml:"time,attr"`
+	Suites   []junitSuite `xml:"testsuite"`
+}
+
+type junitSuite struct {
+	Name     string      `xml:"name,attr"`
+	Tests    int         `xml:"tests,attr"`
+	Failures int         `xml:"failures,attr"`
+	Skipped  int         `xml:"skipped,attr"`
+	Time     string      `xml:"time,attr"`
+	Cases    []junitCase `xml:"testcase"`
+}
+
+type junitCase struct {
+	Name      string        `xml:"name,attr"`
+	Classname string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	Skipped   *junitSkipped `xml:"skipped,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Text    string `xml:",chardata"`
+}
+
+type junitSkipped struct {
+	Message string `xml:"message,attr"`
+}
+
+// junitReport makes a test suite of every provider and a test case of every upload to it.
+// Deferred uploads and those never started are skipped, objects already stored pass
+func junitReport(report uploadReport) junitSuites {
+	suites := junitSuites{Name: "uploader upload", Time: junitTime(report.Duration)}
+	index := map[xproviders.Provider]int{}
+	times := map[xproviders.Provider]float64{}
+	for _, d := range report.Uploads {
+		i, ok := index[d.Provider]
+		if !ok {
+			i = len(suites.Suites)
+			index[d.Provider] = i
+			suites.Suites = append(suites.Suites, junitSuite{Name: string(d.Provider)})
+		}
+		s := &suites.Suites[i]
+		c := junitCase{
+			Name:      fmt.Sprintf("%s to %s/%s", d.Path, d.Bucket, d.Key),
+			Classname: "uploader." + string(d.Provider),
+			Time:      junitTime(d.Duration),
+		}
+		switch d.Status {
+		case "failed":
+			c.Failure = &junitFailure{Message: d.Error, Type: d.ErrorClass, Text: d.Error}
+			s.Failures++
+		case "deferred":
+			c.Skipped = &junitSkipped{Message: "deferred past the soft deadline, queued for repair"}
+			s.Skipped++
+		case "not-started":
+			c.Skipped = &junitSkipped{Message: "not started, the upload was interrupted"}
+			s.Skipped++
+		}
+		s.Tests++
+		times[d.Provider] += d.Duration
+		s.Cases = append(s.Cases, c)
+	}
+	for i := range suites.Suites {
+		s := &suites.Suites[i]
+		s.Time = junitTime(times[xproviders.Provider(s.Name)])
+		suites.Tests += s.Tests
+		suites.Failures += s.Failures
+		suites.Skipped += s.Skipped
+	}
+	return suites
+}
+
+func junitTime(seconds float64) string {
+	return fmt.Sprintf("%.3f", seconds)
+}
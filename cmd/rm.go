@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	xproviders "github.com/stevequadros/uploader/providers"
+	"sync"
+)
+
+var rmUsage = `
+uploader rm deletes an object from every chosen provider concurrently.
+
+Usage:
+  uploader rm -config FILE -provider NAME... -bucket BUCKET -key KEY
+`
+
+func runRm(args []string) int {
+	fs := newFlagSet("rm", rmUsage)
+	flags := commonFlags{}
+	var bucket, key string
+	flags.register(fs, "[REQUIRED 1+] Providers to delete from. Valid Options: aws, gcp, azure")
+	fs.StringVar(&bucket, "bucket", "", "[REQUIRED] Bucket holding the object")
+	fs.StringVar(&key, "key", "", "[REQUIRED] key to delete")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	if flags.configPath == "" || bucket == "" || key == "" {
+		fs.Usage()
+		return exitUsage
+	}
+	// deleting from every configured provider by accident is too easy without this
+	if err := validateProviders(flags.providers); err != nil {
+		logError("Error processing flags", err)
+		return exitUsage
+	}
+
+	ctx := context.Background()
+	uploaders, err := flags.uploaders(ctx)
+	if err != nil {
+		return exitFailure
+	}
+
+	logInProcess("Deleting")
+	errs := make([]error, len(uploaders))
+	wg := sync.WaitGroup{}
+	for i, u := range uploaders {
+		wg.Add(1)
+		go func(i int, u xproviders.Uploader) {
+			defer wg.Done()
+			d, ok := u.(xproviders.Deleter)
+			if !ok {
+				errs[i] = errors.New("delete not supported")
+				return
+			}
+			errs[i] = d.Delete(ctx, bucket, key)
+		}(i, u)
+	}
+	wg.Wait()
+
+	var failed int
+	for i, u := range uploaders {
+		if errs[i] != nil {
+			failed++
+			logError(fmt.Sprintf("Error deleting from %q", u.GetName()), errs[i])
+			continue
+		}
+		logSuccess(fmt.Sprintf("Deleted from %q", u.GetName()))
+	}
+	return exitCode(failed, len(uploaders))
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// exitInterrupted is the exit code after SIGINT or SIGTERM, as a shell reports a killed command
+const exitInterrupted = 130
+
+// defaultGrace is how long uploads in flight may finish after an interruption
+const defaultGrace = 30 * time.Second
+
+// interruptContext is cancelled by the first SIGINT or SIGTERM, after which the default handling
+// is restored so a second one quits at once without waiting for the grace period or cleanup
+func interruptContext(grace time.Duration) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		select {
+		case sig := <-signals:
+			signal.Stop(signals)
+			if grace > 0 {
+				fmt.Fprintf(logOut, "\n%s received, letting uploads in flight finish for up to %s, aborting the rest. Interrupt again to quit now\n", sig, grace)
+			} else {
+				fmt.Fprintf(logOut, "\n%s received, aborting uploads. Interrupt again to quit now\n", sig)
+			}
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, func() {
+		signal.Stop(signals)
+		cancel()
+	}
+}
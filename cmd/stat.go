@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	xproviders "github.com/stevequadros/uploader/providers"
+	"os"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+var statUsage = `
+uploader stat shows an object's size, checksums and metadata side by side for every chosen
+provider, so copies that drifted apart stand out.
+
+Usage:
+  uploader stat -config FILE -bucket BUCKET -key KEY [-provider NAME]...
+`
+
+type statResult struct {
+	provider xproviders.Provider
+	info     xproviders.ObjectInfo
+	err      error
+}
+
+func runStat(args []string) int {
+	fs := newFlagSet("stat", statUsage)
+	flags := commonFlags{}
+	var bucket, key string
+	flags.register(fs, "only stat these providers, defaults to every configured provider")
+	fs.StringVar(&bucket, "bucket", "", "[REQUIRED] Bucket holding the object")
+	fs.StringVar(&key, "key", "", "[REQUIRED] key to describe")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	if flags.configPath == "" || bucket == "" || key == "" {
+		fs.Usage()
+		return exitUsage
+	}
+
+	ctx := context.Background()
+	uploaders, err := flags.uploaders(ctx)
+	if err != nil {
+		return exitFailure
+	}
+
+	results := make([]statResult, len(uploaders))
+	wg := sync.WaitGroup{}
+	for i, u := range uploaders {
+		wg.Add(1)
+		go func(i int, u xproviders.Uploader) {
+			defer wg.Done()
+			results[i].provider = u.GetName()
+			s, ok := u.(xproviders.Stater)
+			if !ok {
+				results[i].err = errors.New("stat not supported")
+				return
+			}
+			results[i].info, results[i].err = s.Stat(ctx, bucket, key)
+		}(i, u)
+	}
+	wg.Wait()
+
+	printStat(results)
+	var failed int
+	for _, r := range results {
+		if r.err != nil {
+			failed++
+			logError(fmt.Sprintf("Error reading %q", r.provider), r.err)
+		}
+	}
+	return exitCode(failed, len(results))
+}
+
+// statRows are the compared fields, in display order
+var statRows = []struct {
+	name  string
+	value func(xproviders.ObjectInfo) string
+}{
+	{"size", func(o xproviders.ObjectInfo) string { return fmt.Sprint(o.Size) }},
+	{"etag", func(o xproviders.ObjectInfo) string { return o.ETag }},
+	{"md5", func(o xproviders.ObjectInfo) string { return o.Checksums.MD5 }},
+	{"crc32c", func(o xproviders.ObjectInfo) string { return o.Checksums.CRC32C }},
+	{"sha256", func(o xproviders.ObjectInfo) string { return o.Checksums.SHA256 }},
+	{"last modified", func(o xproviders.ObjectInfo) string { return o.LastModified.Format(time.RFC3339) }},
+	{"content type", func(o xproviders.ObjectInfo) string { return o.ContentType }},
+	{"version", func(o xproviders.ObjectInfo) string { return o.VersionID }},
+}
+
+func printStat(results []statResult) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	header := []string{""}
+	for _, r := range results {
+		header = append(header, strings.ToUpper(string(r.provider)))
+	}
+	fmt.Fprintln(w, strings.Join(header, "\t"))
+	for _, row := range statRows {
+		line := []string{row.name}
+		for _, r := range results {
+			v := "-"
+			switch {
+			case errors.Is(r.err, xproviders.ErrNotFound):
+				v = "not found"
+			case r.err != nil:
+				v = "error"
+			case row.value(r.info) != "":
+				v = row.value(r.info)
+			}
+			line = append(line, v)
+		}
+		fmt.Fprintln(w, strings.Join(line, "\t"))
+	}
+	_ = w.Flush()
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"github.com/stevequadros/uploader/files"
+	"github.com/stevequadros/uploader/providers/ratelimit"
+	"github.com/stevequadros/uploader/providers/syncer"
+	"text/tabwriter"
+)
+
+var syncUsage = `
+uploader sync makes the objects under -prefix on every chosen provider match a local directory.
+Each provider is compared on its own, only new and changed files are uploaded to it and, with
+-delete, objects with no local file are removed. Files match by size and checksum, or by size
+and modification time with -compare mtime.
+
+Usage:
+  uploader sync DIR -config FILE -provider NAME... -bucket BUCKET [-prefix PREFIX] [-delete] [-dry-run]
+`
+
+func runSync(args []string) int {
+	fs := newFlagSet("sync", syncUsage)
+	flags := commonFlags{}
+	var include, exclude stringsFlag
+	var bucket, prefix, compare string
+	var del, dryRun, followSymlinks bool
+	var workers int
+	flags.register(fs, "[REQUIRED 1+] Providers to sync. Valid Options: aws, gcp, azure")
+	fs.StringVar(&bucket, "bucket", "", "[REQUIRED] Bucket to sync to. Will Create bucket if it doesn't exist.")
+	fs.StringVar(&prefix, "prefix", "", "Key prefix the directory is synced under, as a directory: a trailing slash is added when missing")
+	fs.StringVar(&compare, "compare", string(syncer.CompareChecksum), "How unchanged files are detected: checksum or mtime")
+	fs.BoolVar(&del, "delete", false, "Delete objects under the prefix that no longer exist locally")
+	fs.BoolVar(&dryRun, "dry-run", false, "Show what would change without changing anything")
+	fs.Var(&include, "include", "Only sync files matching this pattern, may be repeated")
+	fs.Var(&exclude, "exclude", "Skip files matching this pattern, may be repeated. Excluded objects are never deleted")
+	fs.BoolVar(&followSymlinks, "follow-symlinks", false, "Follow symlinks instead of skipping them")
+	fs.IntVar(&workers, "workers", syncer.DefaultWorkers, "Uploads and deletes to run at once across all providers")
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return exitUsage
+	}
+	if len(positional) != 1 || flags.configPath == "" || bucket == "" {
+		fs.Usage()
+		return exitUsage
+	}
+	if err = validateProviders(flags.providers); err != nil {
+		logError("Error processing flags", err)
+		return exitUsage
+	}
+
+	// an interrupt cancels at once, what was uploaded so far is aborted on each provider
+	ctx, stop := interruptContext(0)
+	defer stop()
+	uploaders, err := flags.uploaders(ctx)
+	if err != nil {
+		return exitFailure
+	}
+
+	if dryRun {
+		logInProcess("Planning sync (dry run)")
+	} else {
+		logInProcess("Syncing")
+	}
+	res, err := syncer.Run(ctx, uploaders, bucket, positional[0], syncer.Options{
+		Files: files.Options{
+			Prefix:         prefix,
+			Include:        include,
+			Exclude:        exclude,
+			FollowSymlinks: followSymlinks,
+		},
+		Compare:   syncer.Compare(compare),
+		Delete:    del,
+		DryRun:    dryRun,
+		Workers:   workers,
+		Bandwidth: ratelimit.New(float64(flags.config.Bandwidth)),
+	})
+	if err != nil {
+		logError("Error syncing", err)
+		return exitFailure
+	}
+	printSyncReport(res, dryRun)
+	if ctx.Err() != nil {
+		return exitInterrupted
+	}
+
+	var failed int
+	for _, p := range res.Providers {
+		if p.Failed() > 0 {
+			failed++
+		}
+	}
+	return exitCode(failed, len(res.Providers))
+}
+
+var syncSymbols = map[syncer.Action]string{
+	syncer.ActionUpload: "+",
+	syncer.ActionDelete: "-",
+}
+
+func printSyncReport(res syncer.Result, dryRun bool) {
+	for _, s := range res.Skipped {
+		fmt.Fprintf(logOut, "\tskipping %s: %s\n", s.Path, s.Reason)
+	}
+	for _, p := range res.Providers {
+		if p.Err != nil {
+			logError(fmt.Sprintf("Error listing %q, nothing was synced to it", p.Provider), p.Err)
+			continue
+		}
+		for _, c := range p.Changes {
+			if c.Action == syncer.ActionUnchanged {
+				continue
+			}
+			if c.Err != nil {
+				logError(fmt.Sprintf("Error syncing %s to %q", c.Key, p.Provider), c.Err)
+				continue
+			}
+			fmt.Fprintf(logOut, "\t%s %s %s (%s)\n", syncSymbols[c.Action], p.Provider, c.Key, c.Reason)
+		}
+	}
+
+	fmt.Fprintln(logOut)
+	w := tabwriter.NewWriter(logOut, 0, 0, 2, ' ', 0)
+	if dryRun {
+		fmt.Fprintln(w, "PROVIDER\tTO UPLOAD\tTO DELETE\tUNCHANGED\tFAILED")
+	} else {
+		fmt.Fprintln(w, "PROVIDER\tUPLOADED\tDELETED\tUNCHANGED\tFAILED")
+	}
+	for _, p := range res.Providers {
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%d\n", p.Provider, p.Count(syncer.ActionUpload), p.Count(syncer.ActionDelete), p.Count(syncer.ActionUnchanged), p.Failed())
+	}
+	_ = w.Flush()
+	if dryRun {
+		fmt.Fprintln(logOut, "\ndry run, nothing was changed")
+	}
+}
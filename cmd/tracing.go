@@ -0,0 +1,34 @@
+package main
+
+import (
+	"context"
+	"github.com/stevequadros/uploader/config"
+	"github.com/stevequadros/uploader/providers/coordinator"
+	"github.com/stevequadros/uploader/providers/tracing"
+	"time"
+)
+
+// tracingFlushTimeout bounds sending the last spans on exit, a collector that is down must not
+// hold the command up
+const tracingFlushTimeout = 5 * time.Second
+
+// startTracing traces uploads to the collector of the config's tracing section, nothing is
+// traced without one. stop sends the spans still buffered and must be called before exiting
+func startTracing(ctx context.Context, cfg config.Config) (opts []coordinator.Option, stop func()) {
+	if cfg.Tracing == nil {
+		return nil, func() {}
+	}
+	tp, err := tracing.NewProvider(ctx, *cfg.Tracing)
+	if err != nil {
+		logError("Error setting up tracing, uploads won't be traced", err)
+		return nil, func() {}
+	}
+	return []coordinator.Option{coordinator.WithTracerProvider(tp)}, func() {
+		// ctx may be cancelled by an interrupt, the spans of the interrupted uploads still go out
+		sctx, cancel := context.WithTimeout(context.Background(), tracingFlushTimeout)
+		defer cancel()
+		if err := tp.Shutdown(sctx); err != nil {
+			logError("Error sending traces", err)
+		}
+	}
+}
@@ -0,0 +1,562 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/stevequadros/uploader/config"
+	"github.com/stevequadros/uploader/files"
+	"github.com/stevequadros/uploader/keys"
+	xproviders "github.com/stevequadros/uploader/providers"
+	"github.com/stevequadros/uploader/providers/checkpoint"
+	"github.com/stevequadros/uploader/providers/coordinator"
+	"github.com/stevequadros/uploader/providers/repair"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// stdinPath as the file to upload streams stdin
+const stdinPath = "-"
+
+var uploadUsage = `
+uploader upload sends files to every chosen provider concurrently, creating the bucket if needed.
+Files, glob patterns and directories can be given with -file or as arguments, directories are
+walked recursively.
+
+  -key                names a single file, or is a template rendered for every file and provider,
+                      ex: builds/{{.Date "2006/01/02"}}/{{.Hostname}}/{{.SHA256 | short}}-{{.Basename}}
+  -prefix             names every other file, followed by its name or path relative to the directory
+  -file -             streams stdin to -key on every provider at once, -stream-buffer MiB per provider
+  -content-addressed  names every file by the SHA-256 of its content and skips providers holding it
+  -verify             compares the checksums of every upload with what each provider stored
+  -repair-queue       queues uploads that failed on a provider for "uploader repair" to retry
+  -checkpoints        keeps the progress of files of -resume-threshold MiB, running again resumes them
+  -grace              lets uploads in flight finish after SIGINT or SIGTERM, the rest are aborted
+  -soft-deadline      queues the slower providers once -min-success providers hold a file, a background repair finishes them
+  -breaker-*          fail a provider's uploads at once after repeated failures, probing it again
+  -limit-rate         caps the bandwidth of all uploads together, SIGHUP reloads the config's limits
+  -progress           shows bars on a terminal and log lines every 10s otherwise
+  -output, -junit     report every upload as json on stdout or JUnit XML for CI
+  -metrics-*          serve Prometheus metrics while uploading or write them for node_exporter
+
+With a tracing section in the config every upload is traced to its OpenTelemetry collector.
+
+Usage:
+  uploader upload -config FILE -provider NAME... -bucket BUCKET -file FILE -key KEY
+  pg_dump db | uploader upload -config FILE -provider NAME... -bucket BUCKET -file - -key KEY
+  uploader upload -config FILE -provider NAME... -bucket BUCKET [-prefix PREFIX] [-include PATTERN]... [-exclude PATTERN]... PATH...
+  uploader upload -config FILE -provider NAME... -bucket BUCKET -content-addressed [-shard N] [-prefix PREFIX] PATH...
+  uploader upload -config FILE -provider NAME... -bucket BUCKET [-output json] [-junit FILE] PATH...
+  uploader upload -config FILE -provider NAME... -bucket BUCKET [-metrics-listen ADDR] [-metrics-textfile FILE] PATH...
+`
+
+// uploadOptions are the flags of upload, shared by the file and stdin paths
+type uploadOptions struct {
+	bucket, key, prefix string
+	// tmpl is key parsed, when it is a template
+	tmpl             *keys.Template
+	include, exclude stringsFlag
+	followSymlinks   bool
+	contentAddressed bool
+	shards           int
+	verify           bool
+	queuePath        string
+	checkpointDir    string
+	resumeThreshold  int
+	grace            time.Duration
+	softDeadline     time.Duration
+	minSuccess       int
+	breaker          coordinator.BreakerSettings
+	limitRate        config.Rate
+	output           string
+	junitPath        string
+	progress         string
+	workers          int
+	streamBuffer     int
+	metrics          metricsFlags
+	// metricsOpts count the uploads in the metrics once they are served
+	metricsOpts []coordinator.Option
+}
+
+func runUpload(args []string) int {
+	fs := newFlagSet("upload", uploadUsage)
+	flags := commonFlags{}
+	var paths stringsFlag
+	o := uploadOptions{}
+	flags.register(fs, "[REQUIRED 1+] Providers targeted. Valid Options: aws, gcp, azure. Each one must be preceded with it's own flag, ex: -provider aws -provider azure -provider gcp")
+	fs.Var(&paths, "file", "[REQUIRED 1+] File, glob pattern or directory to upload, may be repeated or given as arguments")
+	fs.StringVar(&o.bucket, "bucket", "", "[REQUIRED] Target bucket for file. Will Create bucket if it doesn't exist.")
+	fs.StringVar(&o.key, "key", "", "key for a single file, defaults to -prefix plus the file name. A template with {{ }} is rendered for every file and provider")
+	fs.StringVar(&o.prefix, "prefix", "", "Prepended to every key, include the trailing slash for a directory")
+	fs.Var(&o.include, "include", "Only upload files matching this pattern, may be repeated")
+	fs.Var(&o.exclude, "exclude", "Skip files matching this pattern, may be repeated")
+	fs.BoolVar(&o.followSymlinks, "follow-symlinks", false, "Follow symlinks found in directories instead of skipping them")
+	fs.BoolVar(&o.contentAddressed, "content-addressed", false, "Key every file by the SHA-256 of its content under -prefix, providers already holding it are skipped")
+	fs.IntVar(&o.shards, "shard", 0, "With -content-addressed, spread keys over N levels of two character directories, ex: 2 gives ab/cd/abcd...")
+	fs.BoolVar(&o.verify, "verify", true, "Checksum uploads and fail those a provider stored differently")
+	fs.StringVar(&o.queuePath, "repair-queue", defaultQueuePath(), "Journal failed uploads are queued in for uploader repair, empty to not queue them")
+	fs.StringVar(&o.checkpointDir, "checkpoints", defaultCheckpointDir(), "Directory the progress of large uploads is kept in so they can resume, empty to not resume")
+	fs.IntVar(&o.resumeThreshold, "resume-threshold", coordinator.DefaultResumeThreshold>>20, "MiB from which files are uploaded in parts that can resume")
+	fs.DurationVar(&o.grace, "grace", defaultGrace, "How long uploads in flight may finish after an interrupt before they are aborted")
+	fs.DurationVar(&o.softDeadline, "soft-deadline", 0, "Stop waiting on slow providers once a file has been uploading this long and -min-success providers have it, they are queued and repaired in the background")
+	fs.IntVar(&o.minSuccess, "min-success", 1, "Providers that must hold a file before -soft-deadline defers the others")
+	fs.IntVar(&o.breaker.Failures, "breaker-failures", 5, "Consecutive failures after which a provider's uploads fail at once, 0 to never trip on them")
+	fs.Float64Var(&o.breaker.ErrorRate, "breaker-error-rate", 0, "Fraction of a provider's latest uploads failing after which its uploads fail at once, ex: 0.5")
+	fs.DurationVar(&o.breaker.Cooldown, "breaker-cooldown", coordinator.DefaultBreakerCooldown, "How long a tripped provider fails uploads at once before one is tried again")
+	fs.Var(&o.limitRate, "limit-rate", "Bandwidth of all uploads together, ex: 20MB/s or 512KiB/s, defaults to the config's bandwidth")
+	fs.StringVar(&o.output, "output", "text", "Report format, text or json. json prints a report of every upload to stdout and logs to stderr")
+	fs.StringVar(&o.junitPath, "junit", "", "Also write the report as JUnit XML to this file, for CI")
+	o.metrics.register(fs)
+	fs.StringVar(&o.progress, "progress", "auto", "How to show progress: bar, log lines every 10s, none, or auto for bars on a terminal and log lines otherwise")
+	fs.IntVar(&o.workers, "workers", coordinator.DefaultWorkers, "Uploads to run at once across all files and providers")
+	fs.IntVar(&o.streamBuffer, "stream-buffer", coordinator.DefaultStreamChunks*coordinator.DefaultStreamChunkSize>>20, "MiB of stdin queued per provider before the slowest one holds back reading")
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return exitUsage
+	}
+	if fs.NFlag() == 0 {
+		fs.Usage()
+		return exitUsage
+	}
+	paths = append(paths, positional...)
+
+	if err := validateFlags(flags.providers, paths, flags.configPath, o.bucket, o.key); err != nil {
+		logError("Error processing flags", err)
+		return exitUsage
+	}
+
+	if o.breaker.ErrorRate < 0 || o.breaker.ErrorRate > 1 {
+		logError("Error processing flags", errors.New("-breaker-error-rate must be between 0 and 1"))
+		return exitUsage
+	}
+	if o.softDeadline > 0 && o.queuePath == "" {
+		logError("Error processing flags", errors.New("-soft-deadline needs -repair-queue, deferred uploads would never complete"))
+		return exitUsage
+	}
+	if o.output != "text" && o.output != "json" {
+		logError("Error processing flags", fmt.Errorf("unknown output %q, use text or json", o.output))
+		return exitUsage
+	}
+	if o.output == "json" {
+		logOut = os.Stderr
+	}
+	if o.progress, err = progressMode(o.progress); err != nil {
+		logError("Error processing flags", err)
+		return exitUsage
+	}
+
+	if o.contentAddressed {
+		if o.key != "" {
+			logError("Error processing flags", errors.New("-key can't be used with -content-addressed, the content names the key"))
+			return exitUsage
+		}
+		o.key = keys.ContentAddressed(o.prefix, o.shards)
+	} else if o.shards != 0 {
+		logError("Error processing flags", errors.New("-shard needs -content-addressed"))
+		return exitUsage
+	}
+
+	if keys.IsTemplate(o.key) {
+		if o.tmpl, err = keys.Parse(o.key); err != nil {
+			logError("Error processing flags", err)
+			return exitUsage
+		}
+	}
+
+	if o.metricsOpts, err = o.metrics.start(); err != nil {
+		logError("Error serving metrics", err)
+		return exitFailure
+	}
+	defer o.metrics.stop()
+
+	if paths[0] == stdinPath {
+		return uploadStdin(flags, o)
+	}
+	return uploadFiles(flags, o, paths)
+}
+
+// uploadFiles uploads the files, globs and directories of paths
+func uploadFiles(flags commonFlags, o uploadOptions, paths []string) int {
+	logInProcess("Checking Files to upload")
+	batch, skipped, err := files.Expand(paths, files.Options{
+		Prefix:         o.prefix,
+		Include:        o.include,
+		Exclude:        o.exclude,
+		FollowSymlinks: o.followSymlinks,
+	})
+	if err != nil {
+		logError("could not read files to upload ", err)
+		return exitFailure
+	}
+	for _, s := range skipped {
+		fmt.Fprintf(logOut, "\tskipping %s: %s\n", s.Path, s.Reason)
+	}
+	if len(batch) == 0 {
+		logError("Nothing to upload", errors.New("no files left after filtering"))
+		return exitFailure
+	}
+	if o.key != "" && o.tmpl == nil {
+		if len(batch) > 1 {
+			logError("Error processing flags", fmt.Errorf("-key names a single file but %d files were found, use -prefix", len(batch)))
+			return exitUsage
+		}
+		batch[0].Key = o.key
+	}
+	logSuccess(fmt.Sprintf("%d files to upload", len(batch)))
+
+	ctx, stop := interruptContext(o.grace)
+	defer stop()
+	uploaders, err := flags.uploaders(ctx)
+	if err != nil {
+		return exitFailure
+	}
+	tracingOpts, stopTracing := startTracing(ctx, flags.config)
+	defer stopTracing()
+
+	jobs, err := batchKeys(batch, uploaders, o.bucket, o.tmpl, time.Now())
+	if err != nil {
+		logError("Error building keys", err)
+		return exitFailure
+	}
+
+	logInProcess("Beginning Uploads")
+	opts := []coordinator.Option{
+		coordinator.WithGracePeriod(o.grace),
+		coordinator.WithBandwidthLimit(watchLimits(ctx, flags, uploaders, o.limitRate)),
+		coordinator.WithLogger(flags.logger()),
+	}
+	opts = append(opts, o.metricsOpts...)
+	opts = append(opts, tracingOpts...)
+	if o.contentAddressed {
+		opts = append(opts, coordinator.WithSkipExisting())
+	}
+	if o.verify {
+		opts = append(opts, coordinator.WithVerify())
+	}
+	if o.softDeadline > 0 {
+		opts = append(opts, coordinator.WithSoftDeadline(o.softDeadline, o.minSuccess))
+	}
+	opts = append(opts, coordinator.WithCircuitBreaker(o.breaker))
+	store := openCheckpoints(ctx, o.checkpointDir, uploaders)
+	if store != nil {
+		opts = append(opts, coordinator.WithCheckpoints(store, int64(o.resumeThreshold)<<20))
+	}
+	tracker := coordinator.NewTracker(uploaders)
+	var total int64
+	for _, f := range batch {
+		total += f.Size
+	}
+	tracker.Expect(len(jobs), total)
+	rec := newUploadRecorder()
+	opts = append(opts, coordinator.WithProgress(func(ev coordinator.Event) {
+		tracker.Handle(ev)
+		rec.Handle(ev)
+	}))
+	coord, _ := coordinator.NewCoordinator(uploaders, opts...)
+	began := time.Now()
+	stopProgress := showProgress(o.progress, tracker)
+	res := coord.DoBatch(ctx, o.bucket, jobs, o.workers)
+	stopProgress()
+	printUploadReport(res, uploaders)
+	report := batchReport(o.bucket, jobs, res, rec, began)
+	report.Interrupted = ctx.Err() != nil
+	writeReports(o.output, o.junitPath, report)
+	if ctx.Err() != nil {
+		// running the same upload again is what continues it, not a repair
+		printInterrupted(res, store, began)
+		return exitInterrupted
+	}
+	if queueFailures(o.queuePath, repair.FromBatch(o.bucket, jobs, res)) && res.Deferred() > 0 {
+		repairInBackground(flags.configPath, o.queuePath)
+	}
+	return batchExitCode(res)
+}
+
+// batchExitCode is exitCode over every upload of the batch, a file failing on one provider out of
+// three is partial
+func batchExitCode(res coordinator.BatchResult) int {
+	var failed, total int
+	for _, f := range res.Files {
+		failed += len(f.Failed)
+		total += len(f.Done) + len(f.Skipped) + len(f.Failed) + len(f.Deferred)
+	}
+	return exitCode(failed, total)
+}
+
+// printInterrupted sums up a batch cut short by a signal
+func printInterrupted(res coordinator.BatchResult, store *checkpoint.Store, began time.Time) {
+	notStarted := res.NotStarted()
+	fmt.Fprintf(logOut, "\nInterrupted: %d / %d files reached every provider, %d were aborted or failed and %d never started\n",
+		len(res.Files)-res.Failed(), len(res.Files), res.Failed()-notStarted, notStarted)
+	if store == nil {
+		return
+	}
+	cps, err := store.List()
+	if err != nil {
+		return
+	}
+	var kept int
+	for _, cp := range cps {
+		if cp.Updated.After(began) {
+			kept++
+		}
+	}
+	if kept > 0 {
+		fmt.Fprintf(logOut, "%d large uploads kept their progress in %s, run the same upload again to resume them\n", kept, store.Dir())
+	}
+}
+
+// uploadStdin streams stdin to -key on every provider
+func uploadStdin(flags commonFlags, o uploadOptions) int {
+	if o.tmpl != nil && o.tmpl.NeedsContent() {
+		logError("Error processing flags", errors.New("the key template uses .SHA256, which isn't known before stdin is uploaded"))
+		return exitUsage
+	}
+
+	ctx, stop := interruptContext(o.grace)
+	defer stop()
+	uploaders, err := flags.uploaders(ctx)
+	if err != nil {
+		return exitFailure
+	}
+	tracingOpts, stopTracing := startTracing(ctx, flags.config)
+	defer stopTracing()
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		logError("Error building keys", err)
+		return exitFailure
+	}
+	targets := coordinator.ProviderKeys{}
+	data := keys.StreamData(hostname, time.Now())
+	for _, u := range uploaders {
+		if targets[u.GetName()], err = renderKey(o.key, o.tmpl, data, u.GetName(), o.bucket); err != nil {
+			logError("Error building keys", err)
+			return exitFailure
+		}
+	}
+
+	logInProcess("Streaming stdin")
+	opts := []coordinator.Option{
+		coordinator.WithStreamBuffer(coordinator.DefaultStreamChunkSize, o.streamBuffer),
+		coordinator.WithGracePeriod(o.grace),
+		coordinator.WithBandwidthLimit(watchLimits(ctx, flags, uploaders, o.limitRate)),
+		coordinator.WithLogger(flags.logger()),
+	}
+	opts = append(opts, o.metricsOpts...)
+	opts = append(opts, tracingOpts...)
+	if o.verify {
+		opts = append(opts, coordinator.WithVerify())
+	}
+	tracker := coordinator.NewTracker(uploaders)
+	rec := newUploadRecorder()
+	opts = append(opts, coordinator.WithProgress(func(ev coordinator.Event) {
+		tracker.Handle(ev)
+		rec.Handle(ev)
+	}))
+	coord, _ := coordinator.NewCoordinator(uploaders, opts...)
+	began := time.Now()
+	stopProgress := showProgress(o.progress, tracker)
+	res, err := coord.DoStreamTo(ctx, o.bucket, targets, os.Stdin)
+	stopProgress()
+	if err != nil {
+		logError("Error uploading", err)
+	}
+	for _, p := range res.Done {
+		logSuccess(fmt.Sprintf("Successfully Uploaded to %q%s", p, verifiedNote(res.Verified, p)))
+	}
+	for _, e := range res.Failed {
+		logError(fmt.Sprintf("Error Uploading stdin to %q: ", e.Provider), e.Error)
+	}
+	fmt.Fprintf(logOut, "\nUploaded %s from stdin to %d / %d providers\n", formatBytes(res.Size), len(res.Done), len(uploaders))
+	report := streamReport(o.bucket, targets, res, rec, began)
+	report.Interrupted = ctx.Err() != nil
+	writeReports(o.output, o.junitPath, report)
+	if ctx.Err() != nil {
+		fmt.Fprintln(logOut, "Interrupted, the providers that didn't finish aborted what they had stored")
+		return exitInterrupted
+	}
+	// stdin can't be read again, the providers that have it are copied from
+	if len(res.Done) > 0 && queueFailures(o.queuePath, repair.FromResult(o.bucket, "", targets, res)) && len(res.Deferred) > 0 {
+		repairInBackground(flags.configPath, o.queuePath)
+	}
+	return exitCode(len(res.Failed), len(uploaders))
+}
+
+// batchKeys pairs every file with its key on each provider, rendering tmpl when there is one.
+// Every key is checked against its provider's naming rules before anything is uploaded
+func batchKeys(batch []files.File, uploaders []xproviders.Uploader, bucket string, tmpl *keys.Template, now time.Time) ([]coordinator.BatchFile, error) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return nil, err
+	}
+
+	jobs := make([]coordinator.BatchFile, len(batch))
+	for i, f := range batch {
+		jobs[i] = coordinator.BatchFile{Path: f.Path, Key: f.Key, Keys: coordinator.ProviderKeys{}}
+		data := keys.FileData(f, hostname, now)
+		if tmpl != nil && tmpl.NeedsContent() {
+			// already read for the key, stored with the object so later runs can compare it
+			if jobs[i].SHA256, err = data.SHA256(); err != nil {
+				return nil, err
+			}
+		}
+		for _, u := range uploaders {
+			key, err := renderKey(f.Key, tmpl, data, u.GetName(), bucket)
+			if err != nil {
+				return nil, err
+			}
+			jobs[i].Keys[u.GetName()] = key
+		}
+		if len(uploaders) > 0 {
+			jobs[i].Key = jobs[i].Keys[uploaders[0].GetName()]
+		}
+	}
+	return jobs, nil
+}
+
+// renderKey renders tmpl for provider p, or validates key as is when there is no template
+func renderKey(key string, tmpl *keys.Template, data keys.Data, p xproviders.Provider, bucket string) (string, error) {
+	if tmpl == nil {
+		return key, xproviders.ValidateKey(p, key)
+	}
+	data.Provider, data.Bucket = string(p), bucket
+	return tmpl.Render(data)
+}
+
+func printUploadReport(res coordinator.BatchResult, uploaders []xproviders.Uploader) {
+	for _, f := range res.Files {
+		if f.OK() {
+			for _, p := range f.Done {
+				logSuccess(fmt.Sprintf("Uploaded %s to %q on %s%s%s", f.Path, f.Key, p, resumedNote(f.Resumed, p), verifiedNote(f.Verified, p)))
+			}
+			if len(f.Skipped) > 0 {
+				logSuccess(fmt.Sprintf("%s already stored as %q on %v", f.Path, f.Key, f.Skipped))
+			}
+			if len(f.Deferred) > 0 {
+				fmt.Fprintf(logOut, "\t%s deferred on %v past the soft deadline\n", f.Path, f.Deferred)
+			}
+			continue
+		}
+		for _, e := range f.Failed {
+			if errors.Is(e.Error, coordinator.ErrNotStarted) {
+				continue
+			}
+			logError(fmt.Sprintf("Error Uploading %s to %q: ", f.Path, e.Provider), e.Error)
+		}
+	}
+
+	fmt.Fprintln(logOut)
+	done, skipped, failed := res.ProviderCounts()
+	deferred := map[xproviders.Provider]int{}
+	for _, f := range res.Files {
+		for _, p := range f.Deferred {
+			deferred[p]++
+		}
+	}
+	w := tabwriter.NewWriter(logOut, 0, 0, 2, ' ', 0)
+	if res.Deferred() > 0 {
+		fmt.Fprintln(w, "PROVIDER\tUPLOADED\tSKIPPED\tFAILED\tDEFERRED")
+	} else {
+		fmt.Fprintln(w, "PROVIDER\tUPLOADED\tSKIPPED\tFAILED")
+	}
+	for _, u := range uploaders {
+		p := u.GetName()
+		if res.Deferred() > 0 {
+			fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%d\n", p, done[p], skipped[p], failed[p], deferred[p])
+		} else {
+			fmt.Fprintf(w, "%s\t%d\t%d\t%d\n", p, done[p], skipped[p], failed[p])
+		}
+	}
+	_ = w.Flush()
+	fmt.Fprintf(logOut, "\nUploaded %d / %d files (%s) to every provider\n", len(res.Files)-res.Failed(), len(res.Files), formatBytes(res.Bytes()))
+	for _, b := range res.Breakers {
+		if b.Trips > 0 {
+			fmt.Fprintf(logOut, "%s circuit breaker %s, tripped %d times, %d uploads failed at once. last error: %v\n", b.Provider, b.State, b.Trips, b.Rejected, b.LastError)
+		}
+	}
+	if n := res.Deferred(); n > 0 {
+		fmt.Fprintf(logOut, "%d uploads deferred past the soft deadline\n", n)
+	}
+	if n := res.SkippedBytes(); n > 0 {
+		fmt.Fprintf(logOut, "Skipped %s already stored\n", formatBytes(n))
+	}
+}
+
+// resumedNote says how much of the file p already had from an interrupted run
+func resumedNote(resumed map[xproviders.Provider]int64, p xproviders.Provider) string {
+	if resumed[p] == 0 {
+		return ""
+	}
+	return fmt.Sprintf(", resumed after %s", formatBytes(resumed[p]))
+}
+
+// openCheckpoints opens the checkpoint store and cleans up checkpoints too old to resume along
+// with their orphaned parts. Uploads don't resume when it can't be opened
+func openCheckpoints(ctx context.Context, dir string, uploaders []xproviders.Uploader) *checkpoint.Store {
+	if dir == "" {
+		return nil
+	}
+	store, err := checkpoint.Open(dir)
+	if err != nil {
+		logError("Error opening checkpoints, uploads won't resume", err)
+		return nil
+	}
+	cleaned, err := store.Clean(ctx, uploaders, checkpoint.DefaultMaxAge)
+	if err != nil {
+		logError("Error cleaning stale checkpoints", err)
+	}
+	for _, c := range cleaned {
+		cp := c.Checkpoint
+		if c.Err != nil {
+			logError(fmt.Sprintf("Error discarding the parts of an abandoned upload of %s/%s on %q: ", cp.Bucket, cp.Key, cp.Provider), c.Err)
+			continue
+		}
+		fmt.Fprintf(logOut, "\tdiscarded an abandoned upload of %s/%s on %s (%s)\n", cp.Bucket, cp.Key, cp.Provider, formatBytes(cp.Uploaded()))
+	}
+	return store
+}
+
+// defaultCheckpointDir is the checkpoint directory used when -checkpoints isn't given, empty if
+// there is no config directory to keep it in
+func defaultCheckpointDir() string {
+	dir, err := checkpoint.DefaultDir()
+	if err != nil {
+		return ""
+	}
+	return dir
+}
+
+// verifiedNote says which checksums p confirmed, nothing when verification was off
+func verifiedNote(verified map[xproviders.Provider]xproviders.Checksums, p xproviders.Provider) string {
+	if verified == nil {
+		return ""
+	}
+	sums := verified[p]
+	var names []string
+	for _, c := range []struct{ name, sum string }{{"md5", sums.MD5}, {"crc32c", sums.CRC32C}, {"sha256", sums.SHA256}} {
+		if c.sum != "" {
+			names = append(names, c.name)
+		}
+	}
+	if len(names) == 0 {
+		return ", size verified"
+	}
+	return ", " + strings.Join(names, " and ") + " verified"
+}
+
+// formatBytes prints n with a binary unit, ex: 1.5 MiB
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
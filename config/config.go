@@ -8,39 +8,82 @@ import (
 )
 
 type Config struct {
-	AWS   *AWS
-	Azure *Azure
-	GCP   *GCP
+	AWS   *AWS   `json:"aws,omitempty"`
+	Azure *Azure `json:"azure,omitempty"`
+	GCP   *GCP   `json:"gcp,omitempty"`
+	// Bandwidth caps the bytes read for uploads to every provider together
+	Bandwidth Rate `json:"bandwidth,omitempty"`
+	// Tracing exports traces of uploads, none are recorded without it
+	Tracing *Tracing `json:"tracing,omitempty"`
 }
 
 /*
 Config takes list of providers and path to config file
+decrypts config file if it was encrypted with Encrypt
 validates config file
 validates each provider config
+resolves secret references in credential fields
 */
 
 func New(path string) (config Config, err error) {
+	return load(path, NewSecretResolver())
+}
+
+// NewUnresolved loads a config like New but leaves secret references in place, so they
+// can be resolved, and fail, one provider at a time with ResolveSecrets
+func NewUnresolved(path string) (config Config, err error) {
+	return load(path, nil)
+}
+
+func load(path string, resolver *SecretResolver) (config Config, err error) {
 	var configFile *os.File
 	configFile, err = os.Open(path)
+	if err != nil {
+		return config, err
+	}
 	defer func() {
-		err = configFile.Close()
+		if closeErr := configFile.Close(); err == nil {
+			err = closeErr
+		}
 	}()
-	config, err = NewFromJSON(configFile)
+	config, err = newFromJSON(configFile, resolver)
 	return config, err
 }
 
 func NewFromJSON(reader io.Reader) (config Config, err error) {
+	return newFromJSON(reader, NewSecretResolver())
+}
+
+// newFromJSON skips secret resolution when resolver is nil
+func newFromJSON(reader io.Reader, resolver *SecretResolver) (config Config, err error) {
 	var configData []byte
 	configData, err = io.ReadAll(reader)
 	if err != nil {
 		return config, err
 	}
 
+	if IsEncrypted(configData) {
+		var keys DecryptionKeys
+		if keys, err = KeysFromEnv(); err != nil {
+			return config, err
+		}
+		if configData, err = Decrypt(configData, keys); err != nil {
+			return config, err
+		}
+	}
+
 	err = json.Unmarshal(configData, &config)
 	if err != nil {
 		return config, err
 	}
 
+	if resolver != nil {
+		err = config.ResolveSecrets(resolver)
+		if err != nil {
+			return config, err
+		}
+	}
+
 	err = config.Validate()
 	if err != nil {
 		return config, err
@@ -64,18 +107,25 @@ func (c *Config) Validate() error {
 			return err
 		}
 	}
+	if c.Tracing != nil {
+		if err := c.Tracing.Validate(); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
 type AWS struct {
-	Credentials *AWSCredentials
+	Credentials *AWSCredentials `json:"credentials"`
+	Timeouts    *Timeouts       `json:"timeouts,omitempty"`
+	Limits      *Limits         `json:"limits,omitempty"`
 }
 
 type AWSCredentials struct {
 	// location of aws credentials file
-	Filename string
+	Filename string `json:"filename"`
 	// profile to use
-	Profile string
+	Profile string `json:"profile"`
 }
 
 func NewAWS(filename, profile string) *AWS {
@@ -101,12 +151,14 @@ func (p *AWS) Validate() error {
 }
 
 type Azure struct {
-	Credentials *AzureCredentials
+	Credentials *AzureCredentials `json:"credentials"`
+	Timeouts    *Timeouts         `json:"timeouts,omitempty"`
+	Limits      *Limits           `json:"limits,omitempty"`
 }
 
 type AzureCredentials struct {
-	AccountName string
-	AccountKey  string
+	AccountName string `json:"accountName"`
+	AccountKey  string `json:"accountKey"`
 }
 
 func NewAzure(accountName, accountKey string) *Azure {
@@ -130,17 +182,19 @@ func (p *Azure) Validate() error {
 }
 
 type GCP struct {
-	Credentials *GCPCredentials
+	Credentials *GCPCredentials `json:"credentials"`
+	Timeouts    *Timeouts       `json:"timeouts,omitempty"`
+	Limits      *Limits         `json:"limits,omitempty"`
 }
 
 type GCPCredentials struct {
 	// path to json GCP credentials
-	Filename string
-	Scopes   []string
+	Filename string   `json:"filename"`
+	Scopes   []string `json:"scopes"`
 }
 
 func NewGCP(filename string) *GCP {
-	return &GCP{&GCPCredentials{
+	return &GCP{Credentials: &GCPCredentials{
 		Filename: filename,
 		// defaulting to this for now, ideally should accept scopes as argument in more fleshed out version
 		Scopes: []string{"https://www.googleapis.com/auth/devstorage.full_control"},
@@ -72,7 +72,7 @@ func TestNewFromJSON(t *testing.T) {
 		},
 		"[aws] config invalid without profile": {
 			`{"aws": {"credentials": {"filename": "test"}}}`,
-			Config{AWS: &AWS{&AWSCredentials{Filename: "test"}}},
+			Config{AWS: &AWS{Credentials: &AWSCredentials{Filename: "test"}}},
 			true,
 		},
 		"[gcp] config invalid without filename": {
@@ -82,7 +82,7 @@ func TestNewFromJSON(t *testing.T) {
 		},
 		"[gcp] config invalid without scopes": {
 			`{"gcp": {"credentials": {"filename": "test"}}}`,
-			Config{GCP: &GCP{&GCPCredentials{Filename: "test"}}},
+			Config{GCP: &GCP{Credentials: &GCPCredentials{Filename: "test"}}},
 			true,
 		},
 		"[azure] config invalid without accountname": {
@@ -92,7 +92,7 @@ func TestNewFromJSON(t *testing.T) {
 		},
 		"[azure] config invalid without account key": {
 			`{"azure": {"credentials": {"accountName": "test"}}}`,
-			Config{Azure: &Azure{&AzureCredentials{AccountName: "test"}}},
+			Config{Azure: &Azure{Credentials: &AzureCredentials{AccountName: "test"}}},
 			true,
 		},
 	}
@@ -0,0 +1,182 @@
+package config
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+const redacted = "REDACTED"
+
+var azureAccountNameRe = regexp.MustCompile(`^[a-z0-9]{3,24}$`)
+
+// CredentialCheck is the outcome of checking one provider's credentials locally
+type CredentialCheck struct {
+	Provider string
+	Err      error
+}
+
+// CheckCredentials goes beyond Validate and checks that the configured credentials are usable
+// without contacting any provider: files exist and parse, profiles exist, keys are well formed.
+// It expects secret references to be resolved already
+func (c *Config) CheckCredentials() []CredentialCheck {
+	var checks []CredentialCheck
+	if c.AWS != nil {
+		checks = append(checks, CredentialCheck{"aws", c.AWS.CheckCredentials()})
+	}
+	if c.Azure != nil {
+		checks = append(checks, CredentialCheck{"azure", c.Azure.CheckCredentials()})
+	}
+	if c.GCP != nil {
+		checks = append(checks, CredentialCheck{"gcp", c.GCP.CheckCredentials()})
+	}
+	return checks
+}
+
+// CheckCredentials verifies the shared credentials file holds a complete section for the profile
+func (p *AWS) CheckCredentials() error {
+	if err := p.Validate(); err != nil {
+		return err
+	}
+	f, err := os.Open(p.Credentials.Filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var inProfile, found, hasKeyID, hasSecret bool
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section := strings.TrimSpace(strings.TrimPrefix(line[1:len(line)-1], "profile "))
+			inProfile = section == p.Credentials.Profile
+			found = found || inProfile
+			continue
+		}
+		if !inProfile {
+			continue
+		}
+		key := strings.TrimSpace(strings.SplitN(line, "=", 2)[0])
+		hasKeyID = hasKeyID || key == "aws_access_key_id"
+		hasSecret = hasSecret || key == "aws_secret_access_key"
+	}
+	if err = scanner.Err(); err != nil {
+		return err
+	}
+
+	switch {
+	case !found:
+		return fmt.Errorf("aws profile %q not found in %q", p.Credentials.Profile, p.Credentials.Filename)
+	case !hasKeyID || !hasSecret:
+		return fmt.Errorf("aws profile %q is missing aws_access_key_id or aws_secret_access_key", p.Credentials.Profile)
+	}
+	return nil
+}
+
+// CheckCredentials verifies the account name and key are well formed
+func (p *Azure) CheckCredentials() error {
+	if err := p.Validate(); err != nil {
+		return err
+	}
+	if !azureAccountNameRe.MatchString(p.Credentials.AccountName) {
+		return fmt.Errorf("azure account name %q must be 3-24 lowercase letters or digits", p.Credentials.AccountName)
+	}
+	if _, err := base64.StdEncoding.DecodeString(p.Credentials.AccountKey); err != nil {
+		return errors.New("azure account key is not valid base64")
+	}
+	return nil
+}
+
+// CheckCredentials verifies the credentials file is a google credentials json
+func (p *GCP) CheckCredentials() error {
+	if err := p.Validate(); err != nil {
+		return err
+	}
+	b, err := os.ReadFile(p.Credentials.Filename)
+	if err != nil {
+		return err
+	}
+	var creds struct {
+		Type        string `json:"type"`
+		ClientEmail string `json:"client_email"`
+		PrivateKey  string `json:"private_key"`
+	}
+	if err = json.Unmarshal(b, &creds); err != nil {
+		return fmt.Errorf("gcp credentials %q are not valid json", p.Credentials.Filename)
+	}
+	switch creds.Type {
+	case "service_account":
+		if creds.ClientEmail == "" || creds.PrivateKey == "" {
+			return fmt.Errorf("gcp service account %q is missing client_email or private_key", p.Credentials.Filename)
+		}
+	case "authorized_user", "external_account":
+	default:
+		return fmt.Errorf("gcp credentials %q have unknown type %q", p.Credentials.Filename, creds.Type)
+	}
+	return nil
+}
+
+// Secrets returns the secret values of the config, those Redacted replaces, for logs to remove
+func (c Config) Secrets() []string {
+	var secrets []string
+	if c.Azure != nil && c.Azure.Credentials != nil && c.Azure.Credentials.AccountKey != "" {
+		secrets = append(secrets, c.Azure.Credentials.AccountKey)
+	}
+	if c.Tracing != nil {
+		for _, v := range c.Tracing.Headers {
+			if v != "" {
+				secrets = append(secrets, v)
+			}
+		}
+	}
+	return secrets
+}
+
+// Redacted returns a copy of the config that is safe to print, secret values are replaced
+func (c Config) Redacted() Config {
+	if c.AWS != nil {
+		aws := *c.AWS
+		if aws.Credentials != nil {
+			creds := *aws.Credentials
+			aws.Credentials = &creds
+		}
+		c.AWS = &aws
+	}
+	if c.Azure != nil {
+		azure := *c.Azure
+		if azure.Credentials != nil {
+			creds := *azure.Credentials
+			if creds.AccountKey != "" {
+				creds.AccountKey = redacted
+			}
+			azure.Credentials = &creds
+		}
+		c.Azure = &azure
+	}
+	if c.GCP != nil {
+		gcp := *c.GCP
+		if gcp.Credentials != nil {
+			creds := *gcp.Credentials
+			creds.Scopes = append([]string(nil), creds.Scopes...)
+			gcp.Credentials = &creds
+		}
+		c.GCP = &gcp
+	}
+	if c.Tracing != nil {
+		tracing := *c.Tracing
+		if len(tracing.Headers) > 0 {
+			tracing.Headers = map[string]string{}
+			for k := range c.Tracing.Headers {
+				tracing.Headers[k] = redacted
+			}
+		}
+		c.Tracing = &tracing
+	}
+	return c
+}
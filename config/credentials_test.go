@@ -0,0 +1,61 @@
+package config
+
+import (
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeFile(t *testing.T, name, contents string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(path, []byte(contents), 0600))
+	return path
+}
+
+func TestCheckCredentials(t *testing.T) {
+	awsCreds := writeFile(t, "credentials", "[default]\naws_access_key_id = id\naws_secret_access_key = secret\n\n[partial]\naws_access_key_id = id\n")
+	gcpCreds := writeFile(t, "gcp.json", `{"type": "service_account", "client_email": "a@b.c", "private_key": "key"}`)
+	gcpBad := writeFile(t, "bad.json", `{"type": "service_account"}`)
+
+	tc := map[string]struct {
+		check func() error
+		err   bool
+	}{
+		"[aws] valid profile":              {NewAWS(awsCreds, "default").CheckCredentials, false},
+		"[aws] missing profile":            {NewAWS(awsCreds, "nope").CheckCredentials, true},
+		"[aws] incomplete profile":         {NewAWS(awsCreds, "partial").CheckCredentials, true},
+		"[aws] missing file":               {NewAWS(awsCreds+"nope", "default").CheckCredentials, true},
+		"[azure] valid":                    {NewAzure("account1", "a2V5").CheckCredentials, false},
+		"[azure] invalid account name":     {NewAzure("Account_1", "a2V5").CheckCredentials, true},
+		"[azure] key not base64":           {NewAzure("account1", "not base64!").CheckCredentials, true},
+		"[gcp] valid service account":      {NewGCP(gcpCreds).CheckCredentials, false},
+		"[gcp] incomplete service account": {NewGCP(gcpBad).CheckCredentials, true},
+		"[gcp] missing file":               {NewGCP(gcpCreds + "nope").CheckCredentials, true},
+	}
+
+	for name, tt := range tc {
+		t.Run(name, func(t *testing.T) {
+			if tt.err {
+				require.Error(t, tt.check())
+			} else {
+				require.NoError(t, tt.check())
+			}
+		})
+	}
+}
+
+func TestRedacted(t *testing.T) {
+	cfg := Config{Azure: NewAzure("account", "secret"), GCP: NewGCP("file")}
+	r := cfg.Redacted()
+	require.Equal(t, "REDACTED", r.Azure.Credentials.AccountKey)
+	require.Equal(t, "account", r.Azure.Credentials.AccountName)
+	// the original is untouched
+	require.Equal(t, "secret", cfg.Azure.Credentials.AccountKey)
+}
+
+func TestSecrets(t *testing.T) {
+	require.Empty(t, Config{GCP: NewGCP("file")}.Secrets())
+	require.Equal(t, []string{"secret"}, Config{Azure: NewAzure("account", "secret")}.Secrets())
+}
@@ -0,0 +1,610 @@
+package config
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"filippo.io/age"
+	"filippo.io/age/armor"
+	"fmt"
+	"golang.org/x/crypto/scrypt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+Encrypted configs keep their structure readable: every string value is replaced by
+ENC[AES256_GCM,data:...,iv:...,tag:...,type:str] while keys stay in plaintext, so diffs
+of a committed config still show which field changed.
+
+Values are encrypted with a random data key, bound to their json path so they cannot be
+moved between fields. A MAC over every path and plaintext value, encrypted with the data key,
+catches values swapped for plaintext and fields added or removed. The data key itself is stored under the top level "encryption" key,
+wrapped once per key source: age recipients, a passphrase (scrypt) and/or a keyfile.
+Any one of them is enough to decrypt.
+*/
+
+// env vars consulted by KeysFromEnv
+const (
+	AgeKeyEnv     = "UPLOADER_AGE_KEY"
+	AgeKeyFileEnv = "UPLOADER_AGE_KEY_FILE"
+	PassphraseEnv = "UPLOADER_CONFIG_PASSPHRASE"
+	KeyFileEnv    = "UPLOADER_CONFIG_KEYFILE"
+)
+
+const (
+	encryptionMetaKey = "encryption"
+	encryptionVersion = 1
+	dataKeySize       = 32
+
+	scryptN = 1 << 15
+	scryptR = 8
+	scryptP = 1
+	// a config file sets its own scrypt parameters, these bound the memory and time a crafted
+	// one can make decrypting take: 128*N*r bytes and p passes over them
+	scryptMaxN      = 1 << 20
+	scryptMaxMemory = 1 << 30
+	scryptMaxP      = 16
+)
+
+var (
+	ErrNotEncrypted = errors.New("config is not encrypted")
+	ErrNoKey        = errors.New("no key available to decrypt config")
+
+	encValueRe = regexp.MustCompile(`^ENC\[AES256_GCM,data:([^,]*),iv:([^,]+),tag:([^,]+),type:str\]$`)
+)
+
+// EncryptOptions selects the key sources a config is encrypted for, at least one is required
+type EncryptOptions struct {
+	AgeRecipients []string
+	Passphrase    string
+	KeyFile       string
+}
+
+// DecryptionKeys holds whatever key material is available for decrypting a config
+type DecryptionKeys struct {
+	AgeIdentities []age.Identity
+	Passphrase    string
+	KeyFile       string
+}
+
+type encryptionMeta struct {
+	Version      int    `json:"version"`
+	LastModified string `json:"lastModified"`
+	// MAC is the encrypted HMAC of the document's paths and plaintext values
+	MAC        string           `json:"mac"`
+	Age        *ageKeyGroup     `json:"age,omitempty"`
+	Passphrase *passphraseGroup `json:"passphrase,omitempty"`
+	KeyFile    *keyFileGroup    `json:"keyfile,omitempty"`
+}
+
+type ageKeyGroup struct {
+	Recipients []string `json:"recipients"`
+	Enc        string   `json:"enc"`
+}
+
+type passphraseGroup struct {
+	Salt string `json:"salt"`
+	N    int    `json:"n"`
+	R    int    `json:"r"`
+	P    int    `json:"p"`
+	Enc  string `json:"enc"`
+}
+
+type keyFileGroup struct {
+	Enc string `json:"enc"`
+}
+
+// KeysFromEnv collects decryption keys from UPLOADER_AGE_KEY, UPLOADER_AGE_KEY_FILE,
+// UPLOADER_CONFIG_PASSPHRASE and UPLOADER_CONFIG_KEYFILE
+func KeysFromEnv() (DecryptionKeys, error) {
+	var keys DecryptionKeys
+	if k := os.Getenv(AgeKeyEnv); k != "" {
+		ids, err := age.ParseIdentities(strings.NewReader(k))
+		if err != nil {
+			return keys, fmt.Errorf("parsing %s: %w", AgeKeyEnv, err)
+		}
+		keys.AgeIdentities = append(keys.AgeIdentities, ids...)
+	}
+	if path := os.Getenv(AgeKeyFileEnv); path != "" {
+		f, err := os.Open(path)
+		if err != nil {
+			return keys, err
+		}
+		ids, err := age.ParseIdentities(f)
+		f.Close()
+		if err != nil {
+			return keys, fmt.Errorf("parsing age identities in %q: %w", path, err)
+		}
+		keys.AgeIdentities = append(keys.AgeIdentities, ids...)
+	}
+	keys.Passphrase = os.Getenv(PassphraseEnv)
+	keys.KeyFile = os.Getenv(KeyFileEnv)
+	return keys, nil
+}
+
+// IsEncrypted reports whether data is a config produced by Encrypt
+func IsEncrypted(data []byte) bool {
+	var top map[string]json.RawMessage
+	if err := json.Unmarshal(data, &top); err != nil {
+		return false
+	}
+	_, ok := top[encryptionMetaKey]
+	return ok
+}
+
+// Encrypt encrypts every string value of the plaintext json config with a new data key
+func Encrypt(plain []byte, opts EncryptOptions) ([]byte, error) {
+	doc, err := parseDocument(plain)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := doc[encryptionMetaKey]; ok {
+		return nil, errors.New("config is already encrypted")
+	}
+
+	dataKey := make([]byte, dataKeySize)
+	if _, err = rand.Read(dataKey); err != nil {
+		return nil, err
+	}
+	meta, err := wrapDataKey(dataKey, opts)
+	if err != nil {
+		return nil, err
+	}
+	return sealDocument(doc, meta, dataKey)
+}
+
+// Decrypt returns the plaintext json of an encrypted config
+func Decrypt(data []byte, keys DecryptionKeys) ([]byte, error) {
+	doc, _, _, err := openDocument(data, keys)
+	if err != nil {
+		return nil, err
+	}
+	return marshalDocument(doc)
+}
+
+// Reencrypt encrypts plain with the data key and key sources of the already encrypted
+// config, so key sources the caller cannot unlock themselves are kept
+func Reencrypt(encrypted, plain []byte, keys DecryptionKeys) ([]byte, error) {
+	_, meta, dataKey, err := openDocument(encrypted, keys)
+	if err != nil {
+		return nil, err
+	}
+	doc, err := parseDocument(plain)
+	if err != nil {
+		return nil, err
+	}
+	delete(doc, encryptionMetaKey)
+	return sealDocument(doc, meta, dataKey)
+}
+
+func openDocument(data []byte, keys DecryptionKeys) (doc map[string]interface{}, meta encryptionMeta, dataKey []byte, err error) {
+	doc, err = parseDocument(data)
+	if err != nil {
+		return nil, meta, nil, err
+	}
+	rawMeta, ok := doc[encryptionMetaKey]
+	if !ok {
+		return nil, meta, nil, ErrNotEncrypted
+	}
+	delete(doc, encryptionMetaKey)
+
+	metaJSON, err := json.Marshal(rawMeta)
+	if err != nil {
+		return nil, meta, nil, err
+	}
+	if err = json.Unmarshal(metaJSON, &meta); err != nil {
+		return nil, meta, nil, fmt.Errorf("malformed encryption metadata: %w", err)
+	}
+	if meta.Version != encryptionVersion {
+		return nil, meta, nil, fmt.Errorf("unsupported encryption version %d", meta.Version)
+	}
+
+	dataKey, err = unwrapDataKey(meta, keys)
+	if err != nil {
+		return nil, meta, nil, err
+	}
+
+	decrypted, err := walkStrings(doc, "", func(path, v string) (string, error) {
+		return decryptValue(dataKey, path, v)
+	})
+	if err != nil {
+		return nil, meta, nil, err
+	}
+	if meta.MAC == "" {
+		return nil, meta, nil, errors.New("config has no MAC, it was tampered with")
+	}
+	mac, err := decryptValue(dataKey, macPath(meta), meta.MAC)
+	if err != nil {
+		return nil, meta, nil, err
+	}
+	want, err := documentMAC(dataKey, decrypted)
+	if err != nil {
+		return nil, meta, nil, err
+	}
+	if !hmac.Equal([]byte(mac), []byte(want)) {
+		return nil, meta, nil, errors.New("config MAC mismatch, values or fields were changed outside of the tool")
+	}
+	return decrypted.(map[string]interface{}), meta, dataKey, nil
+}
+
+func sealDocument(doc map[string]interface{}, meta encryptionMeta, dataKey []byte) ([]byte, error) {
+	encrypted, err := walkStrings(doc, "", func(path, v string) (string, error) {
+		return encryptValue(dataKey, path, v)
+	})
+	if err != nil {
+		return nil, err
+	}
+	out := encrypted.(map[string]interface{})
+	meta.LastModified = time.Now().UTC().Format(time.RFC3339)
+	mac, err := documentMAC(dataKey, doc)
+	if err != nil {
+		return nil, err
+	}
+	if meta.MAC, err = encryptValue(dataKey, macPath(meta), mac); err != nil {
+		return nil, err
+	}
+	out[encryptionMetaKey] = meta
+	return marshalDocument(out)
+}
+
+func parseDocument(data []byte) (map[string]interface{}, error) {
+	var doc map[string]interface{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&doc); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+func marshalDocument(doc map[string]interface{}) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	enc := json.NewEncoder(buf)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// documentMAC is the hex HMAC-SHA256, keyed with the data key, of every path and plaintext value
+// of doc, empty objects and arrays included so removing them is caught too
+func documentMAC(dataKey []byte, doc interface{}) (string, error) {
+	h := hmac.New(sha256.New, dataKey)
+	var write func(v interface{}, path string) error
+	write = func(v interface{}, path string) error {
+		switch t := v.(type) {
+		case map[string]interface{}:
+			keys := make([]string, 0, len(t))
+			for k := range t {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			fmt.Fprintf(h, "%s{%d\x00", path, len(t))
+			for _, k := range keys {
+				if err := write(t[k], path+k+":"); err != nil {
+					return err
+				}
+			}
+		case []interface{}:
+			fmt.Fprintf(h, "%s[%d\x00", path, len(t))
+			for i, item := range t {
+				if err := write(item, path+strconv.Itoa(i)+":"); err != nil {
+					return err
+				}
+			}
+		default:
+			b, err := json.Marshal(t)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(h, "%s=%s\x00", path, b)
+		}
+		return nil
+	}
+	if err := write(doc, ""); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// macPath is the additional authenticated data of the MAC, binding it to the last modification
+func macPath(meta encryptionMeta) string {
+	return encryptionMetaKey + ":mac:" + meta.LastModified
+}
+
+// walkStrings returns a copy of v with fn applied to every string, path is the
+// colon separated location of the value and is used as additional authenticated data
+func walkStrings(v interface{}, path string, fn func(path, v string) (string, error)) (interface{}, error) {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(t))
+		for k := range t {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		out := make(map[string]interface{}, len(t))
+		for _, k := range keys {
+			child, err := walkStrings(t[k], path+k+":", fn)
+			if err != nil {
+				return nil, err
+			}
+			out[k] = child
+		}
+		return out, nil
+	case []interface{}:
+		out := make([]interface{}, len(t))
+		for i, item := range t {
+			child, err := walkStrings(item, path+strconv.Itoa(i)+":", fn)
+			if err != nil {
+				return nil, err
+			}
+			out[i] = child
+		}
+		return out, nil
+	case string:
+		return fn(path, t)
+	default:
+		return v, nil
+	}
+}
+
+func encryptValue(key []byte, path, v string) (string, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+	iv := make([]byte, gcm.NonceSize())
+	if _, err = rand.Read(iv); err != nil {
+		return "", err
+	}
+	sealed := gcm.Seal(nil, iv, []byte(v), []byte(path))
+	data, tag := sealed[:len(sealed)-gcm.Overhead()], sealed[len(sealed)-gcm.Overhead():]
+	return fmt.Sprintf("ENC[AES256_GCM,data:%s,iv:%s,tag:%s,type:str]",
+		base64.StdEncoding.EncodeToString(data),
+		base64.StdEncoding.EncodeToString(iv),
+		base64.StdEncoding.EncodeToString(tag),
+	), nil
+}
+
+func decryptValue(key []byte, path, v string) (string, error) {
+	m := encValueRe.FindStringSubmatch(v)
+	if m == nil {
+		// every value is encrypted, one in plaintext was put there by hand
+		return "", fmt.Errorf("value at %q is not encrypted, edit encrypted configs with config edit", strings.TrimSuffix(path, ":"))
+	}
+	var parts [3][]byte
+	for i := range parts {
+		b, err := base64.StdEncoding.DecodeString(m[i+1])
+		if err != nil {
+			return "", fmt.Errorf("malformed encrypted value at %q", strings.TrimSuffix(path, ":"))
+		}
+		parts[i] = b
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+	if len(parts[1]) != gcm.NonceSize() {
+		return "", fmt.Errorf("malformed encrypted value at %q", strings.TrimSuffix(path, ":"))
+	}
+	plain, err := gcm.Open(nil, parts[1], append(parts[0], parts[2]...), []byte(path))
+	if err != nil {
+		return "", fmt.Errorf("decrypting value at %q failed, wrong key or tampered config", strings.TrimSuffix(path, ":"))
+	}
+	return string(plain), nil
+}
+
+func wrapDataKey(dataKey []byte, opts EncryptOptions) (encryptionMeta, error) {
+	meta := encryptionMeta{Version: encryptionVersion}
+
+	if len(opts.AgeRecipients) > 0 {
+		var recipients []age.Recipient
+		for _, r := range opts.AgeRecipients {
+			parsed, err := age.ParseX25519Recipient(r)
+			if err != nil {
+				return meta, fmt.Errorf("invalid age recipient %q: %w", r, err)
+			}
+			recipients = append(recipients, parsed)
+		}
+		buf := &bytes.Buffer{}
+		armored := armor.NewWriter(buf)
+		w, err := age.Encrypt(armored, recipients...)
+		if err != nil {
+			return meta, err
+		}
+		if _, err = w.Write(dataKey); err != nil {
+			return meta, err
+		}
+		if err = w.Close(); err != nil {
+			return meta, err
+		}
+		if err = armored.Close(); err != nil {
+			return meta, err
+		}
+		meta.Age = &ageKeyGroup{Recipients: opts.AgeRecipients, Enc: buf.String()}
+	}
+
+	if opts.Passphrase != "" {
+		salt := make([]byte, 16)
+		if _, err := rand.Read(salt); err != nil {
+			return meta, err
+		}
+		kek, err := scrypt.Key([]byte(opts.Passphrase), salt, scryptN, scryptR, scryptP, dataKeySize)
+		if err != nil {
+			return meta, err
+		}
+		enc, err := sealKey(kek, dataKey)
+		if err != nil {
+			return meta, err
+		}
+		meta.Passphrase = &passphraseGroup{
+			Salt: base64.StdEncoding.EncodeToString(salt),
+			N:    scryptN,
+			R:    scryptR,
+			P:    scryptP,
+			Enc:  enc,
+		}
+	}
+
+	if opts.KeyFile != "" {
+		kek, err := keyFileKey(opts.KeyFile)
+		if err != nil {
+			return meta, err
+		}
+		enc, err := sealKey(kek, dataKey)
+		if err != nil {
+			return meta, err
+		}
+		meta.KeyFile = &keyFileGroup{Enc: enc}
+	}
+
+	if meta.Age == nil && meta.Passphrase == nil && meta.KeyFile == nil {
+		return meta, errors.New("at least one age recipient, passphrase or keyfile is required")
+	}
+	return meta, nil
+}
+
+// unwrapDataKey tries every key source the caller has material for
+func unwrapDataKey(meta encryptionMeta, keys DecryptionKeys) ([]byte, error) {
+	var errs []string
+
+	if meta.Age != nil && len(keys.AgeIdentities) > 0 {
+		r, err := age.Decrypt(armor.NewReader(strings.NewReader(meta.Age.Enc)), keys.AgeIdentities...)
+		if err == nil {
+			var dataKey []byte
+			if dataKey, err = io.ReadAll(r); err == nil {
+				return dataKey, nil
+			}
+		}
+		errs = append(errs, "age: "+err.Error())
+	}
+
+	if meta.Passphrase != nil && keys.Passphrase != "" {
+		dataKey, err := unwrapPassphrase(*meta.Passphrase, keys.Passphrase)
+		if err == nil {
+			return dataKey, nil
+		}
+		errs = append(errs, "passphrase: "+err.Error())
+	}
+
+	if meta.KeyFile != nil && keys.KeyFile != "" {
+		dataKey, err := unwrapKeyFile(*meta.KeyFile, keys.KeyFile)
+		if err == nil {
+			return dataKey, nil
+		}
+		errs = append(errs, "keyfile: "+err.Error())
+	}
+
+	if len(errs) == 0 {
+		return nil, fmt.Errorf("%w, set %s, %s, %s or %s", ErrNoKey, AgeKeyFileEnv, AgeKeyEnv, PassphraseEnv, KeyFileEnv)
+	}
+	return nil, fmt.Errorf("%w: %s", ErrNoKey, strings.Join(errs, "; "))
+}
+
+// unwrapPassphrase opens the data key p wrapped with a key derived from passphrase
+func unwrapPassphrase(p passphraseGroup, passphrase string) ([]byte, error) {
+	salt, err := base64.StdEncoding.DecodeString(p.Salt)
+	if err != nil {
+		return nil, errors.New("malformed salt")
+	}
+	if err = checkScryptParams(p.N, p.R, p.P); err != nil {
+		return nil, err
+	}
+	kek, err := scrypt.Key([]byte(passphrase), salt, p.N, p.R, p.P, dataKeySize)
+	if err != nil {
+		return nil, err
+	}
+	dataKey, err := openKey(kek, p.Enc)
+	if err != nil {
+		return nil, errors.New("incorrect passphrase")
+	}
+	return dataKey, nil
+}
+
+// unwrapKeyFile opens the data key k wrapped with the key in the file at path
+func unwrapKeyFile(k keyFileGroup, path string) ([]byte, error) {
+	kek, err := keyFileKey(path)
+	if err != nil {
+		return nil, err
+	}
+	dataKey, err := openKey(kek, k.Enc)
+	if err != nil {
+		return nil, errors.New("incorrect keyfile")
+	}
+	return dataKey, nil
+}
+
+// checkScryptParams rejects parameters outside what Encrypt would choose by a wide margin
+func checkScryptParams(n, r, p int) error {
+	if n < 2 || n > scryptMaxN || n&(n-1) != 0 {
+		return fmt.Errorf("scrypt N %d must be a power of two up to %d", n, scryptMaxN)
+	}
+	if r < 1 || r > scryptMaxMemory/(128*n) || p < 1 || p > scryptMaxP {
+		return fmt.Errorf("scrypt r %d and p %d out of bounds for N %d", r, p, n)
+	}
+	return nil
+}
+
+// keyFileKey derives a key from the contents of a keyfile of any length
+func keyFileKey(path string) ([]byte, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	b = bytes.TrimSpace(b)
+	if len(b) == 0 {
+		return nil, fmt.Errorf("keyfile %q is empty", path)
+	}
+	sum := sha256.Sum256(b)
+	return sum[:], nil
+}
+
+func sealKey(kek, dataKey []byte) (string, error) {
+	gcm, err := newGCM(kek)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(gcm.Seal(nonce, nonce, dataKey, nil)), nil
+}
+
+func openKey(kek []byte, enc string) ([]byte, error) {
+	b, err := base64.StdEncoding.DecodeString(enc)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := newGCM(kek)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) < gcm.NonceSize() {
+		return nil, errors.New("wrapped key too short")
+	}
+	return gcm.Open(nil, b[:gcm.NonceSize()], b[gcm.NonceSize():], nil)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
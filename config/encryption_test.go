@@ -0,0 +1,213 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"filippo.io/age"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestEncryptDecrypt(t *testing.T) {
+	identity, err := age.GenerateX25519Identity()
+	require.NoError(t, err)
+	keyFile := filepath.Join(t.TempDir(), "key")
+	require.NoError(t, os.WriteFile(keyFile, []byte("some random key material\n"), 0600))
+
+	opts := EncryptOptions{
+		AgeRecipients: []string{identity.Recipient().String()},
+		Passphrase:    "correct horse",
+		KeyFile:       keyFile,
+	}
+	encrypted, err := Encrypt([]byte(validConfig), opts)
+	require.NoError(t, err)
+	require.True(t, IsEncrypted(encrypted))
+	require.False(t, IsEncrypted([]byte(validConfig)))
+
+	// keys stay readable, values do not
+	require.Contains(t, string(encrypted), `"accountKey"`)
+	require.NotContains(t, string(encrypted), "azurekey")
+
+	tc := map[string]struct {
+		keys DecryptionKeys
+		err  bool
+	}{
+		"age identity":       {DecryptionKeys{AgeIdentities: []age.Identity{identity}}, false},
+		"passphrase":         {DecryptionKeys{Passphrase: "correct horse"}, false},
+		"keyfile":            {DecryptionKeys{KeyFile: keyFile}, false},
+		"wrong passphrase":   {DecryptionKeys{Passphrase: "battery staple"}, true},
+		"no keys":            {DecryptionKeys{}, true},
+		"one good key wins":  {DecryptionKeys{Passphrase: "battery staple", KeyFile: keyFile}, false},
+		"missing keyfile":    {DecryptionKeys{KeyFile: filepath.Join(t.TempDir(), "nope")}, true},
+		"unrelated identity": {DecryptionKeys{AgeIdentities: []age.Identity{mustIdentity(t)}}, true},
+	}
+
+	for name, tt := range tc {
+		t.Run(name, func(t *testing.T) {
+			plain, err := Decrypt(encrypted, tt.keys)
+			if tt.err {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			cfg, err := NewFromJSON(bytes.NewReader(plain))
+			require.NoError(t, err)
+			require.Equal(t, "azurekey", cfg.Azure.Credentials.AccountKey)
+			require.Equal(t, []string{"scope1", "scope2"}, cfg.GCP.Credentials.Scopes)
+		})
+	}
+}
+
+func mustIdentity(t *testing.T) *age.X25519Identity {
+	t.Helper()
+	id, err := age.GenerateX25519Identity()
+	require.NoError(t, err)
+	return id
+}
+
+func TestDecryptRejectsMovedValues(t *testing.T) {
+	keyFile := filepath.Join(t.TempDir(), "key")
+	require.NoError(t, os.WriteFile(keyFile, []byte("key"), 0600))
+	encrypted, err := Encrypt([]byte(validConfig), EncryptOptions{KeyFile: keyFile})
+	require.NoError(t, err)
+
+	var doc map[string]interface{}
+	require.NoError(t, json.Unmarshal(encrypted, &doc))
+	azure := doc["azure"].(map[string]interface{})["credentials"].(map[string]interface{})
+	azure["accountName"], azure["accountKey"] = azure["accountKey"], azure["accountName"]
+	tampered, err := json.Marshal(doc)
+	require.NoError(t, err)
+
+	_, err = Decrypt(tampered, DecryptionKeys{KeyFile: keyFile})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "tampered")
+}
+
+func TestDecryptRejectsEditedDocument(t *testing.T) {
+	keyFile := filepath.Join(t.TempDir(), "key")
+	require.NoError(t, os.WriteFile(keyFile, []byte("key"), 0600))
+	encrypted, err := Encrypt([]byte(validConfig), EncryptOptions{KeyFile: keyFile})
+	require.NoError(t, err)
+
+	for name, tt := range map[string]struct {
+		edit func(doc map[string]interface{})
+		err  string
+	}{
+		"value swapped for plaintext": {func(doc map[string]interface{}) {
+			doc["azure"].(map[string]interface{})["credentials"].(map[string]interface{})["accountKey"] = "attackerkey"
+		}, "not encrypted"},
+		"encrypted field removed": {func(doc map[string]interface{}) {
+			delete(doc["azure"].(map[string]interface{})["credentials"].(map[string]interface{}), "accountKey")
+		}, "MAC mismatch"},
+		"MAC removed": {func(doc map[string]interface{}) {
+			delete(doc[encryptionMetaKey].(map[string]interface{}), "mac")
+		}, "no MAC"},
+	} {
+		tt := tt
+		t.Run(name, func(t *testing.T) {
+			var doc map[string]interface{}
+			require.NoError(t, json.Unmarshal(encrypted, &doc))
+			tt.edit(doc)
+			tampered, err := json.Marshal(doc)
+			require.NoError(t, err)
+
+			_, err = Decrypt(tampered, DecryptionKeys{KeyFile: keyFile})
+			require.Error(t, err)
+			require.Contains(t, err.Error(), tt.err)
+		})
+	}
+}
+
+func TestReencryptKeepsKeySources(t *testing.T) {
+	identity := mustIdentity(t)
+	encrypted, err := Encrypt([]byte(validConfig), EncryptOptions{
+		AgeRecipients: []string{identity.Recipient().String()},
+		Passphrase:    "pass",
+	})
+	require.NoError(t, err)
+
+	edited := strings.Replace(validConfig, "azurekey", "newkey", 1)
+	reencrypted, err := Reencrypt(encrypted, []byte(edited), DecryptionKeys{Passphrase: "pass"})
+	require.NoError(t, err)
+
+	// the age recipient can still decrypt although the edit was made with the passphrase
+	plain, err := Decrypt(reencrypted, DecryptionKeys{AgeIdentities: []age.Identity{identity}})
+	require.NoError(t, err)
+	require.Contains(t, string(plain), "newkey")
+}
+
+func TestNewFromJSONDecryptsWithEnvKeys(t *testing.T) {
+	encrypted, err := Encrypt([]byte(validConfig), EncryptOptions{Passphrase: "pass"})
+	require.NoError(t, err)
+
+	_, err = NewFromJSON(bytes.NewReader(encrypted))
+	require.ErrorIs(t, err, ErrNoKey)
+
+	t.Setenv(PassphraseEnv, "pass")
+	cfg, err := NewFromJSON(bytes.NewReader(encrypted))
+	require.NoError(t, err)
+	require.Equal(t, "testprofile", cfg.AWS.Credentials.Profile)
+}
+
+func TestDecryptBoundsScryptParams(t *testing.T) {
+	encrypted, err := Encrypt([]byte(validConfig), EncryptOptions{Passphrase: "pass"})
+	require.NoError(t, err)
+
+	for name, set := range map[string]func(p map[string]interface{}){
+		"huge N":        func(p map[string]interface{}) { p["n"] = 1 << 30 },
+		"N not a power": func(p map[string]interface{}) { p["n"] = 1000 },
+		"huge r":        func(p map[string]interface{}) { p["r"] = 1 << 20 },
+		"huge p":        func(p map[string]interface{}) { p["p"] = 1 << 20 },
+		"r overflowing": func(p map[string]interface{}) { p["r"] = 1 << 60 },
+		"negative p":    func(p map[string]interface{}) { p["p"] = -1 },
+	} {
+		set := set
+		t.Run(name, func(t *testing.T) {
+			var doc map[string]interface{}
+			require.NoError(t, json.Unmarshal(encrypted, &doc))
+			set(doc[encryptionMetaKey].(map[string]interface{})["passphrase"].(map[string]interface{}))
+			crafted, err := json.Marshal(doc)
+			require.NoError(t, err)
+
+			_, err = Decrypt(crafted, DecryptionKeys{Passphrase: "pass"})
+			require.Error(t, err)
+			require.Contains(t, err.Error(), "scrypt")
+		})
+	}
+}
+
+func TestDecryptFallsBackPastMalformedPassphrase(t *testing.T) {
+	keyFile := filepath.Join(t.TempDir(), "key")
+	require.NoError(t, os.WriteFile(keyFile, []byte("some random key material\n"), 0600))
+	encrypted, err := Encrypt([]byte(validConfig), EncryptOptions{Passphrase: "pass", KeyFile: keyFile})
+	require.NoError(t, err)
+
+	for name, set := range map[string]func(p map[string]interface{}){
+		"malformed salt": func(p map[string]interface{}) { p["salt"] = "not base64!" },
+		"bad params":     func(p map[string]interface{}) { p["n"] = 1000 },
+	} {
+		set := set
+		t.Run(name, func(t *testing.T) {
+			var doc map[string]interface{}
+			require.NoError(t, json.Unmarshal(encrypted, &doc))
+			set(doc[encryptionMetaKey].(map[string]interface{})["passphrase"].(map[string]interface{}))
+			crafted, err := json.Marshal(doc)
+			require.NoError(t, err)
+
+			// the keyfile still opens it
+			_, err = Decrypt(crafted, DecryptionKeys{Passphrase: "pass", KeyFile: keyFile})
+			require.NoError(t, err)
+
+			_, err = Decrypt(crafted, DecryptionKeys{Passphrase: "pass"})
+			require.ErrorIs(t, err, ErrNoKey)
+		})
+	}
+}
+
+func TestEncryptRequiresKeySource(t *testing.T) {
+	_, err := Encrypt([]byte(validConfig), EncryptOptions{})
+	require.Error(t, err)
+}
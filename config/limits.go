@@ -0,0 +1,96 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Rate is a number of bytes per second written as a string in config, ex: "20MB/s" or "512KiB/s"
+type Rate float64
+
+var rateUnits = []struct {
+	suffix string
+	bytes  float64
+}{
+	// longest suffixes first so "KiB" isn't read as "B"
+	{"KiB", 1 << 10}, {"MiB", 1 << 20}, {"GiB", 1 << 30},
+	{"KB", 1e3}, {"MB", 1e6}, {"GB", 1e9},
+	{"K", 1e3}, {"M", 1e6}, {"G", 1e9},
+	{"B", 1},
+}
+
+// ParseRate reads a rate like "20MB/s", "1.5MiB/s" or "800K". KB, MB and GB are powers of 1000,
+// KiB, MiB and GiB powers of 1024, the "/s" is optional
+func ParseRate(s string) (Rate, error) {
+	num := strings.TrimSuffix(strings.TrimSpace(s), "/s")
+	factor := 1.0
+	for _, u := range rateUnits {
+		if strings.HasSuffix(num, u.suffix) {
+			num, factor = strings.TrimSpace(strings.TrimSuffix(num, u.suffix)), u.bytes
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(num, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid rate %q, expected something like \"20MB/s\"", s)
+	}
+	if n < 0 {
+		return 0, fmt.Errorf("rate %q is negative", s)
+	}
+	return Rate(n * factor), nil
+}
+
+func (r Rate) String() string {
+	for _, u := range []struct {
+		suffix string
+		bytes  float64
+	}{{"GB", 1e9}, {"MB", 1e6}, {"KB", 1e3}} {
+		if float64(r) >= u.bytes {
+			return strconv.FormatFloat(float64(r)/u.bytes, 'f', -1, 64) + u.suffix + "/s"
+		}
+	}
+	return strconv.FormatFloat(float64(r), 'f', -1, 64) + "B/s"
+}
+
+// Set lets a Rate be a command line flag
+func (r *Rate) Set(s string) error {
+	parsed, err := ParseRate(s)
+	if err != nil {
+		return err
+	}
+	*r = parsed
+	return nil
+}
+
+func (r Rate) MarshalJSON() ([]byte, error) {
+	return json.Marshal(r.String())
+}
+
+func (r *Rate) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("rate must be a string like \"20MB/s\": %w", err)
+	}
+	return r.Set(s)
+}
+
+// Limits cap what uploads to a provider may use, zero means no limit
+type Limits struct {
+	// Bandwidth caps the bytes read for uploads to the provider
+	Bandwidth Rate `json:"bandwidth,omitempty"`
+	// Requests caps the requests sent to the provider per second
+	Requests float64 `json:"requests,omitempty"`
+}
+
+func (l *Limits) UnmarshalJSON(b []byte) error {
+	type limits Limits
+	if err := json.Unmarshal(b, (*limits)(l)); err != nil {
+		return err
+	}
+	if l.Requests < 0 {
+		return fmt.Errorf("requests limit %v is negative", l.Requests)
+	}
+	return nil
+}
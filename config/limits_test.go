@@ -0,0 +1,48 @@
+package config
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestParseRate(t *testing.T) {
+	tc := map[string]struct {
+		in       string
+		expected Rate
+		err      bool
+	}{
+		"megabytes per second": {"20MB/s", 20e6, false},
+		"mebibytes":            {"1.5MiB/s", 1.5 * (1 << 20), false},
+		"short unit":           {"800K", 800e3, false},
+		"bytes":                {"512", 512, false},
+		"spaces":               {" 10 KB/s ", 10e3, false},
+		"unknown unit":         {"20TB/s", 0, true},
+		"negative":             {"-1MB/s", 0, true},
+		"empty":                {"", 0, true},
+	}
+	for name, tt := range tc {
+		tt := tt
+		t.Run(name, func(t *testing.T) {
+			r, err := ParseRate(tt.in)
+			if tt.err {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, r)
+		})
+	}
+}
+
+func TestLimits(t *testing.T) {
+	in := `{"bandwidth": "50MB/s", "gcp": {"credentials": {"filename": "f", "scopes": ["s"]}, "limits": {"bandwidth": "5MB/s", "requests": 20}}}`
+	cfg, err := NewFromJSON(bytes.NewReader([]byte(in)))
+	require.NoError(t, err)
+	require.Equal(t, Rate(50e6), cfg.Bandwidth)
+	require.Equal(t, &Limits{Bandwidth: 5e6, Requests: 20}, cfg.GCP.Limits)
+	require.Equal(t, "5MB/s", cfg.GCP.Limits.Bandwidth.String())
+
+	_, err = NewFromJSON(bytes.NewReader([]byte(`{"gcp": {"credentials": {"filename": "f", "scopes": ["s"]}, "limits": {"requests": -1}}}`)))
+	require.Error(t, err)
+}
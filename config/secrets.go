@@ -0,0 +1,222 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// Credential fields may hold a reference instead of a literal value:
+//
+//	env:NAME                   value of environment variable NAME
+//	file:/run/secrets/x        contents of the file, trailing newline trimmed
+//	vault:secret/data/app#key  field "key" of a Vault KV secret (v1 or v2)
+//
+// Anything without one of these prefixes is used as-is.
+const (
+	envRefPrefix   = "env:"
+	fileRefPrefix  = "file:"
+	vaultRefPrefix = "vault:"
+
+	// defaultVaultField is read when a vault reference has no #field suffix
+	defaultVaultField = "value"
+)
+
+// ErrSecretNotFound is returned when a reference points at nothing
+var ErrSecretNotFound = errors.New("secret not found")
+
+// SecretResolver turns secret references into their values.
+// Errors it returns name the reference, never the resolved value.
+type SecretResolver struct {
+	LookupEnv func(string) (string, bool)
+	ReadFile  func(string) ([]byte, error)
+	Vault     *VaultClient
+}
+
+// NewSecretResolver returns a resolver backed by the process environment, the local
+// filesystem and, when VAULT_ADDR is set, a Vault server
+func NewSecretResolver() *SecretResolver {
+	r := &SecretResolver{
+		LookupEnv: os.LookupEnv,
+		ReadFile:  os.ReadFile,
+	}
+	if addr := os.Getenv("VAULT_ADDR"); addr != "" {
+		r.Vault = NewVaultClient(addr, os.Getenv("VAULT_TOKEN"))
+		r.Vault.Namespace = os.Getenv("VAULT_NAMESPACE")
+	}
+	return r
+}
+
+// IsSecretRef reports whether s is a reference rather than a literal value
+func IsSecretRef(s string) bool {
+	return strings.HasPrefix(s, envRefPrefix) ||
+		strings.HasPrefix(s, fileRefPrefix) ||
+		strings.HasPrefix(s, vaultRefPrefix)
+}
+
+// Resolve returns the value ref points to, or ref itself if it is not a reference
+func (r *SecretResolver) Resolve(ref string) (string, error) {
+	switch {
+	case strings.HasPrefix(ref, envRefPrefix):
+		name := strings.TrimPrefix(ref, envRefPrefix)
+		v, ok := r.LookupEnv(name)
+		if !ok {
+			return "", fmt.Errorf("env variable %q: %w", name, ErrSecretNotFound)
+		}
+		return v, nil
+	case strings.HasPrefix(ref, fileRefPrefix):
+		path := strings.TrimPrefix(ref, fileRefPrefix)
+		b, err := r.ReadFile(path)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				return "", fmt.Errorf("secret file %q: %w", path, ErrSecretNotFound)
+			}
+			// the os error only carries the path and the reason, not the contents
+			return "", err
+		}
+		return strings.TrimRight(string(b), "\r\n"), nil
+	case strings.HasPrefix(ref, vaultRefPrefix):
+		if r.Vault == nil {
+			return "", errors.New("vault reference used but VAULT_ADDR is not set")
+		}
+		path, field := splitVaultRef(strings.TrimPrefix(ref, vaultRefPrefix))
+		return r.Vault.Read(path, field)
+	default:
+		return ref, nil
+	}
+}
+
+func splitVaultRef(ref string) (path, field string) {
+	if i := strings.LastIndex(ref, "#"); i >= 0 {
+		return ref[:i], ref[i+1:]
+	}
+	return ref, defaultVaultField
+}
+
+// ResolveSecrets replaces every secret reference in the credential fields with its value
+func (c *Config) ResolveSecrets(r *SecretResolver) error {
+	fields, store := c.secretFields()
+	for name, field := range fields {
+		if !IsSecretRef(*field) {
+			continue
+		}
+		v, err := r.Resolve(*field)
+		if err != nil {
+			return fmt.Errorf("resolving %s: %w", name, err)
+		}
+		*field = v
+	}
+	store()
+	return nil
+}
+
+// secretFields lists the credential fields that may hold references, keyed by their json path.
+// Map values can't be pointed to, the fields of tracing headers are copies put back by store
+func (c *Config) secretFields() (fields map[string]*string, store func()) {
+	fields = map[string]*string{}
+	if c.AWS != nil && c.AWS.Credentials != nil {
+		fields["aws.credentials.filename"] = &c.AWS.Credentials.Filename
+		fields["aws.credentials.profile"] = &c.AWS.Credentials.Profile
+	}
+	if c.Azure != nil && c.Azure.Credentials != nil {
+		fields["azure.credentials.accountName"] = &c.Azure.Credentials.AccountName
+		fields["azure.credentials.accountKey"] = &c.Azure.Credentials.AccountKey
+	}
+	if c.GCP != nil && c.GCP.Credentials != nil {
+		fields["gcp.credentials.filename"] = &c.GCP.Credentials.Filename
+	}
+	headers := map[string]*string{}
+	if c.Tracing != nil {
+		for k, v := range c.Tracing.Headers {
+			v := v
+			headers[k] = &v
+			fields["tracing.headers."+k] = &v
+		}
+	}
+	return fields, func() {
+		for k, v := range headers {
+			c.Tracing.Headers[k] = *v
+		}
+	}
+}
+
+// VaultClient reads secrets from a Vault server over its HTTP API
+type VaultClient struct {
+	Addr      string
+	Token     string
+	Namespace string
+	HTTP      *http.Client
+}
+
+func NewVaultClient(addr, token string) *VaultClient {
+	return &VaultClient{
+		Addr:  strings.TrimRight(addr, "/"),
+		Token: token,
+		HTTP:  &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+type vaultResponse struct {
+	Data map[string]json.RawMessage `json:"data"`
+}
+
+// Read returns field of the secret at path. KV v2 paths include the data segment,
+// e.g. secret/data/uploader
+func (v *VaultClient) Read(path, field string) (string, error) {
+	req, err := http.NewRequest(http.MethodGet, v.Addr+"/v1/"+strings.TrimLeft(path, "/"), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Vault-Token", v.Token)
+	if v.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", v.Namespace)
+	}
+
+	res, err := v.HTTP.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("vault request for %q failed: %w", path, err)
+	}
+	defer res.Body.Close()
+
+	switch {
+	case res.StatusCode == http.StatusNotFound:
+		return "", fmt.Errorf("vault path %q: %w", path, ErrSecretNotFound)
+	case res.StatusCode != http.StatusOK:
+		return "", fmt.Errorf("vault path %q: unexpected status %d", path, res.StatusCode)
+	}
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return "", err
+	}
+	var vr vaultResponse
+	if err = json.Unmarshal(body, &vr); err != nil {
+		return "", fmt.Errorf("vault path %q: malformed response", path)
+	}
+
+	data := vr.Data
+	// KV v2 nests the secret one level deeper, alongside its metadata
+	if nested, ok := data["data"]; ok {
+		if _, hasMeta := data["metadata"]; hasMeta {
+			data = nil
+			if err = json.Unmarshal(nested, &data); err != nil {
+				return "", fmt.Errorf("vault path %q: malformed response", path)
+			}
+		}
+	}
+
+	raw, ok := data[field]
+	if !ok {
+		return "", fmt.Errorf("vault path %q field %q: %w", path, field, ErrSecretNotFound)
+	}
+	var s string
+	if err = json.Unmarshal(raw, &s); err != nil {
+		return "", fmt.Errorf("vault path %q field %q is not a string", path, field)
+	}
+	return s, nil
+}
@@ -0,0 +1,163 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func testResolver(env map[string]string) *SecretResolver {
+	return &SecretResolver{
+		LookupEnv: func(k string) (string, bool) {
+			v, ok := env[k]
+			return v, ok
+		},
+		ReadFile: os.ReadFile,
+	}
+}
+
+// fakeVault serves KV v2 secrets the way a dev-mode server does
+func fakeVault(t *testing.T, token string, secrets map[string]map[string]string) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != token {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		data, ok := secrets[strings.TrimPrefix(r.URL.Path, "/v1/")]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"data": map[string]interface{}{
+				"data":     data,
+				"metadata": map[string]interface{}{"version": 1},
+			},
+		})
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestSecretResolver_Resolve(t *testing.T) {
+	dir := t.TempDir()
+	secretFile := filepath.Join(dir, "azure_key")
+	require.NoError(t, os.WriteFile(secretFile, []byte("file-secret\n"), 0600))
+
+	vault := fakeVault(t, "root", map[string]map[string]string{
+		"secret/data/uploader": {"accountKey": "vault-secret", "value": "default-field"},
+	})
+
+	r := testResolver(map[string]string{"AZURE_KEY": "env-secret"})
+	r.Vault = NewVaultClient(vault.URL, "root")
+
+	tc := map[string]struct {
+		ref      string
+		expected string
+		err      bool
+	}{
+		"literal values pass through":    {"plain", "plain", false},
+		"env reference":                  {"env:AZURE_KEY", "env-secret", false},
+		"missing env reference errors":   {"env:NOPE", "", true},
+		"file reference trims newline":   {"file:" + secretFile, "file-secret", false},
+		"missing file reference errors":  {"file:" + filepath.Join(dir, "nope"), "", true},
+		"vault reference with field":     {"vault:secret/data/uploader#accountKey", "vault-secret", false},
+		"vault reference default field":  {"vault:secret/data/uploader", "default-field", false},
+		"vault reference missing field":  {"vault:secret/data/uploader#nope", "", true},
+		"vault reference missing secret": {"vault:secret/data/nope#accountKey", "", true},
+	}
+
+	for name, tt := range tc {
+		t.Run(name, func(t *testing.T) {
+			actual, err := r.Resolve(tt.ref)
+			if tt.err {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+			require.Equal(t, tt.expected, actual)
+		})
+	}
+}
+
+func TestSecretResolver_VaultRequiresAddr(t *testing.T) {
+	_, err := testResolver(nil).Resolve("vault:secret/data/uploader#accountKey")
+	require.Error(t, err)
+}
+
+func TestNewFromJSONResolvesSecrets(t *testing.T) {
+	in := `{"azure": {"credentials": {"accountName": "env:AZURE_NAME", "accountKey": "env:AZURE_KEY"}}}`
+	r := testResolver(map[string]string{"AZURE_NAME": "name", "AZURE_KEY": "s3cr3t"})
+
+	cfg, err := newFromJSON(bytes.NewReader([]byte(in)), r)
+	require.NoError(t, err)
+	require.Equal(t, "name", cfg.Azure.Credentials.AccountName)
+	require.Equal(t, "s3cr3t", cfg.Azure.Credentials.AccountKey)
+}
+
+func TestNewFromJSONResolvesTracingHeaders(t *testing.T) {
+	in := `{"azure": {"credentials": {"accountName": "name", "accountKey": "key"}},
+		"tracing": {"endpoint": "https://otel.example.com", "headers": {"x-api-key": "env:OTEL_KEY", "x-team": "uploads"}}}`
+	r := testResolver(map[string]string{"OTEL_KEY": "s3cr3t"})
+
+	cfg, err := newFromJSON(bytes.NewReader([]byte(in)), r)
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"x-api-key": "s3cr3t", "x-team": "uploads"}, cfg.Tracing.Headers)
+}
+
+func TestNewFromJSONSecretErrorsDoNotLeakValues(t *testing.T) {
+	// accountName resolves, accountKey resolves to empty and fails validation
+	in := `{"azure": {"credentials": {"accountName": "env:AZURE_NAME", "accountKey": "env:AZURE_KEY"}}}`
+	r := testResolver(map[string]string{"AZURE_NAME": "s3cr3t-name", "AZURE_KEY": ""})
+
+	_, err := newFromJSON(bytes.NewReader([]byte(in)), r)
+	require.Error(t, err)
+	require.NotContains(t, err.Error(), "s3cr3t-name")
+
+	in = `{"azure": {"credentials": {"accountName": "env:AZURE_NAME", "accountKey": "env:MISSING"}}}`
+	_, err = newFromJSON(bytes.NewReader([]byte(in)), r)
+	require.ErrorIs(t, err, ErrSecretNotFound)
+	require.Contains(t, err.Error(), "azure.credentials.accountKey")
+	require.NotContains(t, err.Error(), "s3cr3t-name")
+}
+
+// TestVaultDevServer runs against a real dev-mode server, see `make test-vault`
+func TestVaultDevServer(t *testing.T) {
+	addr, token := os.Getenv("UPLOADER_TEST_VAULT_ADDR"), os.Getenv("UPLOADER_TEST_VAULT_TOKEN")
+	if addr == "" {
+		t.Skip("UPLOADER_TEST_VAULT_ADDR not set")
+	}
+
+	body := bytes.NewBufferString(`{"data": {"accountKey": "dev-secret"}}`)
+	req, err := http.NewRequest(http.MethodPost, addr+"/v1/secret/data/uploader-test", body)
+	require.NoError(t, err)
+	req.Header.Set("X-Vault-Token", token)
+	res, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	require.NoError(t, res.Body.Close())
+	require.Equal(t, http.StatusOK, res.StatusCode)
+
+	actual, err := NewVaultClient(addr, token).Read("secret/data/uploader-test", "accountKey")
+	require.NoError(t, err)
+	require.Equal(t, "dev-secret", actual)
+}
+
+func TestNewUnresolvedKeepsReferences(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	in := `{"azure": {"credentials": {"accountName": "name", "accountKey": "env:UPLOADER_TEST_UNSET"}}}`
+	require.NoError(t, os.WriteFile(path, []byte(in), 0600))
+
+	_, err := New(path)
+	require.ErrorIs(t, err, ErrSecretNotFound)
+
+	cfg, err := NewUnresolved(path)
+	require.NoError(t, err)
+	require.Equal(t, "env:UPLOADER_TEST_UNSET", cfg.Azure.Credentials.AccountKey)
+}
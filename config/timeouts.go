@@ -0,0 +1,40 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Duration is a time.Duration written as a string in config, ex: "30s" or "5m"
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duration must be a string like \"30s\": %w", err)
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	if parsed < 0 {
+		return fmt.Errorf("duration %q is negative", s)
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// Timeouts bound how long requests to a provider may take, zero means no limit
+type Timeouts struct {
+	// Connect bounds dialing the provider and the TLS handshake
+	Connect Duration `json:"connect,omitempty"`
+	// Idle fails an upload that read nothing for this long
+	Idle Duration `json:"idle,omitempty"`
+	// Total bounds a whole upload, verification included
+	Total Duration `json:"total,omitempty"`
+}
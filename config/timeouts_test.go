@@ -0,0 +1,44 @@
+package config
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestTimeouts(t *testing.T) {
+	tc := map[string]struct {
+		in       string
+		expected *Timeouts
+		err      bool
+	}{
+		"durations": {
+			`{"connect": "5s", "idle": "1m", "total": "20m"}`,
+			&Timeouts{Connect: Duration(5 * time.Second), Idle: Duration(time.Minute), Total: Duration(20 * time.Minute)},
+			false,
+		},
+		"missing ones are unlimited": {
+			`{"total": "90s"}`,
+			&Timeouts{Total: Duration(90 * time.Second)},
+			false,
+		},
+		"numbers are rejected": {`{"idle": 30}`, nil, true},
+		"invalid duration":     {`{"idle": "30 seconds"}`, nil, true},
+		"negative duration":    {`{"total": "-1m"}`, nil, true},
+	}
+
+	for name, tt := range tc {
+		tt := tt
+		t.Run(name, func(t *testing.T) {
+			in := `{"aws": {"credentials": {"filename": "f", "profile": "p"}, "timeouts": ` + tt.in + `}}`
+			cfg, err := NewFromJSON(bytes.NewReader([]byte(in)))
+			if tt.err {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, cfg.AWS.Timeouts)
+		})
+	}
+}
@@ -0,0 +1,33 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+)
+
+// Tracing exports OpenTelemetry traces of uploads to a collector over OTLP/HTTP
+type Tracing struct {
+	// Endpoint is the collector's URL, ex: http://localhost:4318. Without a path traces are sent
+	// to /v1/traces
+	Endpoint string `json:"endpoint"`
+	// Headers are sent with every export, ex: the API key of a tracing backend
+	Headers map[string]string `json:"headers,omitempty"`
+	// ServiceName names the uploader in traces, "uploader" when empty
+	ServiceName string `json:"serviceName,omitempty"`
+	// SampleRatio is the fraction of uploads traced, every one when not set
+	SampleRatio *float64 `json:"sampleRatio,omitempty"`
+}
+
+func (t *Tracing) Validate() error {
+	u, err := url.Parse(t.Endpoint)
+	if err != nil {
+		return fmt.Errorf("tracing endpoint: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		return fmt.Errorf("tracing endpoint %q must be an http or https URL, ex: http://localhost:4318", t.Endpoint)
+	}
+	if t.SampleRatio != nil && (*t.SampleRatio < 0 || *t.SampleRatio > 1) {
+		return fmt.Errorf("tracing sample ratio %v must be between 0 and 1", *t.SampleRatio)
+	}
+	return nil
+}
@@ -0,0 +1,42 @@
+package config
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestTracing_Validate(t *testing.T) {
+	ratio := func(r float64) *float64 { return &r }
+	tc := map[string]struct {
+		tracing Tracing
+		err     bool
+	}{
+		"http":           {Tracing{Endpoint: "http://localhost:4318"}, false},
+		"https and path": {Tracing{Endpoint: "https://otel.example.com/otlp/v1/traces", SampleRatio: ratio(0.1)}, false},
+		"no scheme":      {Tracing{Endpoint: "localhost:4318"}, true},
+		"grpc":           {Tracing{Endpoint: "grpc://localhost:4317"}, true},
+		"empty":          {Tracing{}, true},
+		"ratio above 1":  {Tracing{Endpoint: "http://localhost:4318", SampleRatio: ratio(2)}, true},
+	}
+	for name, tt := range tc {
+		tt := tt
+		t.Run(name, func(t *testing.T) {
+			err := tt.tracing.Validate()
+			if tt.err {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestTracing_Secrets(t *testing.T) {
+	in := `{"tracing": {"endpoint": "https://otel.example.com", "headers": {"x-api-key": "hunter2"}}}`
+	cfg, err := NewFromJSON(bytes.NewReader([]byte(in)))
+	require.NoError(t, err)
+	require.Equal(t, []string{"hunter2"}, cfg.Secrets())
+	require.Equal(t, "REDACTED", cfg.Redacted().Tracing.Headers["x-api-key"])
+	require.Equal(t, "hunter2", cfg.Tracing.Headers["x-api-key"], "the original is untouched")
+}
@@ -0,0 +1,198 @@
+package files
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// File is a local regular file and the key it is uploaded to
+type File struct {
+	Path    string
+	Key     string
+	Size    int64
+	ModTime time.Time
+}
+
+type Options struct {
+	// Prefix is prepended to every key as is, include the trailing slash for a directory
+	Prefix string
+	// Include keeps only files matching one of the patterns, all files when empty
+	Include []string
+	// Exclude drops files matching any of the patterns, it wins over Include
+	Exclude []string
+	// FollowSymlinks follows symlinks found while walking a directory, they are skipped
+	// otherwise. Symlinks named directly are always followed
+	FollowSymlinks bool
+}
+
+// Skipped is a path left out of the expansion and why
+type Skipped struct {
+	Path   string
+	Reason string
+}
+
+var ErrNoMatch = errors.New("no files match")
+
+// Expand turns file paths, glob patterns and directories into the files to upload.
+//
+// A file is keyed by its base name, a directory is walked recursively and each file keyed by
+// its slash separated path relative to the directory. Include and exclude patterns use
+// path.Match syntax and are matched against both that relative key and the base name.
+// Files come back sorted by key, two files mapping to the same key is an error
+func Expand(args []string, opts Options) ([]File, []Skipped, error) {
+	for _, patterns := range [][]string{opts.Include, opts.Exclude} {
+		for _, p := range patterns {
+			if _, err := path.Match(p, ""); err != nil {
+				return nil, nil, fmt.Errorf("bad pattern %q: %w", p, err)
+			}
+		}
+	}
+
+	e := expander{opts: opts, keys: map[string]string{}, visited: map[string]bool{}}
+	for _, arg := range args {
+		matches := []string{arg}
+		if hasMeta(arg) {
+			var err error
+			if matches, err = filepath.Glob(arg); err != nil {
+				return nil, nil, fmt.Errorf("bad pattern %q: %w", arg, err)
+			}
+			if len(matches) == 0 {
+				return nil, nil, fmt.Errorf("%w %q", ErrNoMatch, arg)
+			}
+		}
+		for _, m := range matches {
+			if err := e.add(m); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+
+	sort.Slice(e.files, func(i, j int) bool { return e.files[i].Key < e.files[j].Key })
+	return e.files, e.skipped, nil
+}
+
+type expander struct {
+	opts    Options
+	files   []File
+	skipped []Skipped
+	// keys maps each key to the path claiming it
+	keys map[string]string
+	// visited holds the real path of every walked directory so symlink loops end
+	visited map[string]bool
+}
+
+func (e *expander) add(p string) error {
+	info, err := os.Stat(p)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return e.walk(p, "")
+	}
+	return e.addFile(p, path.Base(filepath.ToSlash(p)), info)
+}
+
+// walk adds every file under dir, rel is dir's key relative to the directory being uploaded
+func (e *expander) walk(dir, rel string) error {
+	real, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return err
+	}
+	if abs, err := filepath.Abs(real); err == nil {
+		real = abs
+	}
+	if e.visited[real] {
+		e.skipped = append(e.skipped, Skipped{dir, "directory already visited through a symlink"})
+		return nil
+	}
+	e.visited[real] = true
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		p := filepath.Join(dir, entry.Name())
+		key := path.Join(rel, entry.Name())
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		if info.Mode()&fs.ModeSymlink != 0 {
+			if !e.opts.FollowSymlinks {
+				e.skipped = append(e.skipped, Skipped{p, "symlink"})
+				continue
+			}
+			if info, err = os.Stat(p); err != nil {
+				e.skipped = append(e.skipped, Skipped{p, "broken symlink"})
+				continue
+			}
+		}
+
+		if info.IsDir() {
+			if err = e.walk(p, key); err != nil {
+				return err
+			}
+			continue
+		}
+		if err = e.addFile(p, key, info); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *expander) addFile(p, rel string, info fs.FileInfo) error {
+	if !info.Mode().IsRegular() {
+		e.skipped = append(e.skipped, Skipped{p, "not a regular file"})
+		return nil
+	}
+	if !e.opts.Selected(rel) {
+		return nil
+	}
+
+	key := e.opts.Prefix + rel
+	if other, ok := e.keys[key]; ok {
+		if other == p {
+			return nil
+		}
+		return fmt.Errorf("%s and %s both map to key %q", other, p, key)
+	}
+	e.keys[key] = p
+	e.files = append(e.files, File{Path: p, Key: key, Size: info.Size(), ModTime: info.ModTime()})
+	return nil
+}
+
+// Selected reports whether a file at rel, relative to what is being uploaded, passes the
+// include and exclude patterns
+func (o Options) Selected(rel string) bool {
+	if matchAny(o.Exclude, rel) {
+		return false
+	}
+	return len(o.Include) == 0 || matchAny(o.Include, rel)
+}
+
+func matchAny(patterns []string, rel string) bool {
+	for _, p := range patterns {
+		// a bad pattern never matches, Expand reports them up front
+		if ok, _ := path.Match(p, rel); ok {
+			return true
+		}
+		if ok, _ := path.Match(p, path.Base(rel)); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func hasMeta(p string) bool {
+	return strings.ContainsAny(p, `*?[`)
+}
@@ -0,0 +1,88 @@
+package files
+
+import (
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// tree creates files under a temp dir, paths ending in / are directories
+func tree(t *testing.T, paths ...string) string {
+	root := t.TempDir()
+	for _, p := range paths {
+		full := filepath.Join(root, filepath.FromSlash(p))
+		if p[len(p)-1] == '/' {
+			require.NoError(t, os.MkdirAll(full, 0755))
+			continue
+		}
+		require.NoError(t, os.MkdirAll(filepath.Dir(full), 0755))
+		require.NoError(t, os.WriteFile(full, []byte(p), 0644))
+	}
+	return root
+}
+
+func keys(files []File) []string {
+	var k []string
+	for _, f := range files {
+		k = append(k, f.Key)
+	}
+	return k
+}
+
+func TestExpand(t *testing.T) {
+	root := tree(t, "a.txt", "b.log", "dir/c.txt", "dir/sub/d.txt", "dir/sub/e.log", "empty/", "other/a.txt")
+	in := func(p string) string { return filepath.Join(root, p) }
+
+	tests := []struct {
+		name    string
+		args    []string
+		opts    Options
+		want    []string
+		wantErr bool
+	}{
+		{"single file uses base name", []string{in("a.txt")}, Options{}, []string{"a.txt"}, false},
+		{"prefix is prepended", []string{in("a.txt")}, Options{Prefix: "backup/"}, []string{"backup/a.txt"}, false},
+		{"glob", []string{in("*.txt")}, Options{}, []string{"a.txt"}, false},
+		{"directory is walked relative to itself", []string{in("dir")}, Options{Prefix: "p/"}, []string{"p/c.txt", "p/sub/d.txt", "p/sub/e.log"}, false},
+		{"include by base name", []string{in("dir")}, Options{Include: []string{"*.log"}}, []string{"sub/e.log"}, false},
+		{"exclude by relative path", []string{in("dir")}, Options{Exclude: []string{"sub/*"}}, []string{"c.txt"}, false},
+		{"exclude wins over include", []string{in("dir")}, Options{Include: []string{"*.txt"}, Exclude: []string{"d.txt"}}, []string{"c.txt"}, false},
+		{"same file twice is uploaded once", []string{in("a.txt"), in("*.txt")}, Options{}, []string{"a.txt"}, false},
+		{"empty directory gives nothing", []string{in("empty")}, Options{}, nil, false},
+		{"colliding keys error", []string{in("a.txt"), in("other")}, Options{}, nil, true},
+		{"glob without matches errors", []string{in("*.csv")}, Options{}, nil, true},
+		{"missing file errors", []string{in("nope.txt")}, Options{}, nil, true},
+		{"bad pattern errors", []string{in("a.txt")}, Options{Include: []string{"["}}, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, _, err := Expand(tt.args, tt.opts)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, keys(got))
+		})
+	}
+}
+
+func TestExpandSymlinks(t *testing.T) {
+	root := tree(t, "dir/a.txt", "other/b.txt")
+	dir := filepath.Join(root, "dir")
+	require.NoError(t, os.Symlink(filepath.Join(root, "other"), filepath.Join(dir, "linked")))
+	require.NoError(t, os.Symlink(dir, filepath.Join(dir, "loop")))
+	require.NoError(t, os.Symlink(filepath.Join(root, "gone"), filepath.Join(dir, "broken")))
+
+	got, skipped, err := Expand([]string{dir}, Options{})
+	require.NoError(t, err)
+	require.Equal(t, []string{"a.txt"}, keys(got))
+	require.Len(t, skipped, 3)
+
+	got, skipped, err = Expand([]string{dir}, Options{FollowSymlinks: true})
+	require.NoError(t, err)
+	require.Equal(t, []string{"a.txt", "linked/b.txt"}, keys(got))
+	require.Len(t, skipped, 2, "the loop and the broken link")
+}
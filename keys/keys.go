@@ -0,0 +1,202 @@
+package keys
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/stevequadros/uploader/files"
+	"github.com/stevequadros/uploader/providers"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// ShortLength is how many characters the short function keeps
+const ShortLength = 12
+
+// ShardWidth is how many characters each directory level made by the shard function holds
+const ShardWidth = 2
+
+var errNoContent = errors.New("the content isn't known before a streamed upload")
+
+// Data is what a key template is evaluated against, once per file and destination
+type Data struct {
+	// Path is the local file, - for stdin
+	Path string
+	// Basename is the file name, ex: report.tar.gz
+	Basename string
+	// Name is the file name without its last extension, ex: report.tar
+	Name string
+	// Ext is the last extension with its dot, ex: .gz
+	Ext string
+	// Dir is the slash separated directory of Key, . when there is none
+	Dir string
+	// Key is the key the file would get without a template, -prefix plus its relative path
+	Key     string
+	Size    int64
+	ModTime time.Time
+	// Provider is the destination's name, ex: aws
+	Provider string
+	Bucket   string
+	Hostname string
+	// Now is when the run started, every key in a run shares it
+	Now time.Time
+
+	sum *lazySum
+}
+
+// Date formats Now in UTC with a Go time layout, ex: {{.Date "2006/01/02"}}
+func (d Data) Date(layout string) string {
+	return d.Now.UTC().Format(layout)
+}
+
+// SHA256 is the hex SHA-256 of the file, read once however many keys use it
+func (d Data) SHA256() (string, error) {
+	if d.sum == nil {
+		return "", errNoContent
+	}
+	return d.sum.get()
+}
+
+type lazySum struct {
+	path string
+	once sync.Once
+	sum  string
+	err  error
+}
+
+func (l *lazySum) get() (string, error) {
+	l.once.Do(func() {
+		f, err := os.Open(l.path)
+		if err != nil {
+			l.err = err
+			return
+		}
+		defer f.Close()
+		h := sha256.New()
+		if _, err = io.Copy(h, f); err != nil {
+			l.err = err
+			return
+		}
+		l.sum = hex.EncodeToString(h.Sum(nil))
+	})
+	return l.sum, l.err
+}
+
+// FileData describes a local file for templating, Provider and Bucket are set per destination
+func FileData(f files.File, hostname string, now time.Time) Data {
+	d := baseData(f.Path, f.Key, hostname, now)
+	d.Size, d.ModTime = f.Size, f.ModTime
+	d.sum = &lazySum{path: f.Path}
+	return d
+}
+
+// StreamData describes stdin, whose content and size aren't known when the key is needed
+func StreamData(hostname string, now time.Time) Data {
+	return baseData("-", "", hostname, now)
+}
+
+func baseData(p, key, hostname string, now time.Time) Data {
+	d := Data{Path: p, Key: key, Hostname: hostname, Now: now, Dir: "."}
+	if p != "-" {
+		d.Basename = filepath.Base(p)
+		d.Ext = filepath.Ext(d.Basename)
+		d.Name = strings.TrimSuffix(d.Basename, d.Ext)
+	}
+	if key != "" {
+		d.Dir = path.Dir(key)
+	}
+	return d
+}
+
+var funcs = template.FuncMap{
+	"short": func(s string) string {
+		if len(s) > ShortLength {
+			return s[:ShortLength]
+		}
+		return s
+	},
+	"lower": strings.ToLower,
+	"upper": strings.ToUpper,
+	"shard": shard,
+}
+
+// shard prefixes s with levels directories made of its leading characters so no single
+// prefix ends up holding every object, ex: {{.SHA256 | shard 2}} gives ab/cd/abcd...
+func shard(levels int, s string) string {
+	b := strings.Builder{}
+	for i := 0; i < levels && (i+1)*ShardWidth <= len(s); i++ {
+		b.WriteString(s[i*ShardWidth : (i+1)*ShardWidth])
+		b.WriteByte('/')
+	}
+	b.WriteString(s)
+	return b.String()
+}
+
+// ContentAddressed is the template naming every file by the SHA-256 of its content under
+// prefix, sharded into levels directories when levels is above zero
+func ContentAddressed(prefix string, levels int) string {
+	// the prefix is escaped so braces in it are taken literally
+	lit := fmt.Sprintf("{{%q}}", prefix)
+	if levels > 0 {
+		return fmt.Sprintf("%s{{.SHA256 | shard %d}}", lit, levels)
+	}
+	return lit + "{{.SHA256}}"
+}
+
+// IsTemplate reports whether key has template actions, otherwise it is used as is
+func IsTemplate(key string) bool {
+	return strings.Contains(key, "{{")
+}
+
+type Template struct {
+	tmpl *template.Template
+	// content is whether the template reads the file, which a stream can't provide
+	content bool
+}
+
+// Parse compiles a key template. It is tried against sample data so a misspelled field
+// fails here rather than halfway through a run
+func Parse(src string) (*Template, error) {
+	tmpl, err := template.New("key").Funcs(funcs).Option("missingkey=error").Parse(src)
+	if err != nil {
+		return nil, fmt.Errorf("key template: %w", err)
+	}
+	t := &Template{tmpl: tmpl}
+
+	sample := baseData("dir/file.txt", "dir/file.txt", "host", time.Now())
+	sample.sum = &lazySum{}
+	sample.sum.once.Do(func() { sample.sum.sum = strings.Repeat("0", sha256.Size*2) })
+	if err = tmpl.Execute(io.Discard, sample); err != nil {
+		return nil, fmt.Errorf("key template: %w", err)
+	}
+	t.content = strings.Contains(src, ".SHA256")
+	return t, nil
+}
+
+// NeedsContent reports whether rendering reads the file, so it can't be used for stdin
+func (t *Template) NeedsContent() bool {
+	return t.content
+}
+
+// Render evaluates the template for d and, when d.Provider is set, checks the key against
+// that provider's naming rules
+func (t *Template) Render(d Data) (string, error) {
+	b := strings.Builder{}
+	if err := t.tmpl.Execute(&b, d); err != nil {
+		return "", fmt.Errorf("key template for %s: %w", d.Path, err)
+	}
+	key := b.String()
+	if d.Provider != "" {
+		if err := providers.ValidateKey(providers.Provider(d.Provider), key); err != nil {
+			return "", err
+		}
+	}
+	return key, nil
+}
@@ -0,0 +1,130 @@
+package keys
+
+import (
+	"errors"
+	"github.com/stevequadros/uploader/files"
+	"github.com/stevequadros/uploader/providers"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRender(t *testing.T) {
+	dir := t.TempDir()
+	p := filepath.Join(dir, "report.tar.gz")
+	require.NoError(t, os.WriteFile(p, []byte("hello"), 0644))
+	modTime := time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC)
+	now := time.Date(2022, 1, 2, 23, 0, 0, 0, time.FixedZone("x", -3*3600))
+	data := FileData(files.File{Path: p, Key: "in/report.tar.gz", Size: 5, ModTime: modTime}, "build-01", now)
+	data.Provider, data.Bucket = string(providers.AWS), "bucket"
+
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{"date is utc", `{{.Date "2006/01/02"}}`, "2022/01/03"},
+		{"file attributes", `{{.Dir}}/{{.Name}}{{.Ext}}-{{.Size}}-{{.ModTime.Format "2006"}}`, "in/report.tar.gz-5-2021"},
+		{"hash and short", `{{.SHA256 | short}}-{{.Basename}}`, "2cf24dba5fb0-report.tar.gz"},
+		{"destination", `{{.Provider | upper}}/{{.Bucket}}/{{.Hostname}}/{{.Key}}`, "AWS/bucket/build-01/in/report.tar.gz"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpl, err := Parse(tt.src)
+			require.NoError(t, err)
+			got, err := tmpl.Render(data)
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, src := range []string{`{{.Hostnam}}`, `{{.Date}}`, `{{nope .Basename}}`, `{{`} {
+		_, err := Parse(src)
+		require.Error(t, err, src)
+	}
+}
+
+func TestRenderValidatesPerProvider(t *testing.T) {
+	tmpl, err := Parse(`{{.Basename}}{{if eq .Provider "azure"}}.{{end}}`)
+	require.NoError(t, err)
+	data := FileData(files.File{Path: "a.txt", Key: "a.txt"}, "host", time.Now())
+
+	for _, p := range []providers.Provider{providers.AWS, providers.GCP} {
+		data.Provider = string(p)
+		key, err := tmpl.Render(data)
+		require.NoError(t, err)
+		require.Equal(t, "a.txt", key)
+	}
+	data.Provider = string(providers.Azure)
+	_, err = tmpl.Render(data)
+	require.True(t, errors.Is(err, providers.ErrInvalidKey), err)
+}
+
+func TestValidateKey(t *testing.T) {
+	long := strings.Repeat("a", providers.MaxKeyLength+1)
+	tests := []struct {
+		provider providers.Provider
+		key      string
+		valid    bool
+	}{
+		{providers.AWS, "a/b/c.txt", true},
+		{providers.AWS, "", false},
+		{providers.AWS, long, false},
+		{providers.AWS, "ends/", true},
+		{providers.GCP, "line\nbreak", false},
+		{providers.GCP, "..", false},
+		{providers.GCP, ".well-known/acme-challenge/x", false},
+		{providers.Azure, "ends/", false},
+		{providers.Azure, "ends.", false},
+		{providers.Azure, "tab\tkey", false},
+		{providers.Azure, strings.Repeat("é", providers.MaxKeyLength), true},
+		{providers.GCP, strings.Repeat("é", providers.MaxKeyLength), false},
+	}
+
+	for _, tt := range tests {
+		err := providers.ValidateKey(tt.provider, tt.key)
+		require.Equal(t, tt.valid, err == nil, "%s %.20q: %v", tt.provider, tt.key, err)
+	}
+}
+
+func TestStreamData(t *testing.T) {
+	tmpl, err := Parse(`{{.SHA256}}`)
+	require.NoError(t, err)
+	require.True(t, tmpl.NeedsContent())
+	_, err = tmpl.Render(StreamData("host", time.Now()))
+	require.Error(t, err)
+}
+
+func TestContentAddressed(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "a.txt")
+	require.NoError(t, os.WriteFile(p, []byte("hello"), 0644))
+	data := FileData(files.File{Path: p, Key: "a.txt"}, "host", time.Now())
+	const sum = "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"
+
+	tests := []struct {
+		prefix string
+		levels int
+		want   string
+	}{
+		{"", 0, sum},
+		{"cas/", 0, "cas/" + sum},
+		{"cas/", 2, "cas/2c/f2/" + sum},
+		{`{{odd}}/`, 1, "{{odd}}/2c/" + sum},
+	}
+	for _, tt := range tests {
+		tmpl, err := Parse(ContentAddressed(tt.prefix, tt.levels))
+		require.NoError(t, err)
+		require.True(t, tmpl.NeedsContent())
+		got, err := tmpl.Render(data)
+		require.NoError(t, err)
+		require.Equal(t, tt.want, got)
+	}
+
+	require.Equal(t, "a", shard(2, "a"), "too short to shard")
+}
@@ -0,0 +1,297 @@
+package auditor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/stevequadros/uploader/providers"
+	"github.com/stevequadros/uploader/providers/coordinator"
+	"github.com/stevequadros/uploader/providers/ratelimit"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Kind is how the copies of an object disagree
+type Kind string
+
+const (
+	// KindMissing is an object some providers don't have
+	KindMissing Kind = "missing"
+	// KindSize is an object stored with different sizes
+	KindSize Kind = "size"
+	// KindChecksum is an object of the same size whose checksums differ
+	KindChecksum Kind = "checksum"
+)
+
+// Issue is one key the providers disagree on
+type Issue struct {
+	Key  string `json:"key"`
+	Kind Kind   `json:"kind"`
+	// Source holds the version taken as correct, the most recently modified one
+	Source providers.Provider `json:"source"`
+	// Stale are the providers missing the object or holding another version of it
+	Stale   []providers.Provider                        `json:"stale"`
+	Objects map[providers.Provider]providers.ObjectInfo `json:"objects"`
+	// Repaired and RepairErrors are filled in by Repair
+	Repaired     []providers.Provider          `json:"repaired,omitempty"`
+	RepairErrors map[providers.Provider]string `json:"repairErrors,omitempty"`
+}
+
+// Fixed reports whether Repair brought every stale provider in line
+func (i Issue) Fixed() bool {
+	return len(i.Repaired) == len(i.Stale)
+}
+
+type ProviderSummary struct {
+	Provider providers.Provider `json:"provider"`
+	Objects  int                `json:"objects"`
+	Bytes    int64              `json:"bytes"`
+	// Error is set when the provider could not be listed, it is left out of the comparison then
+	Error string `json:"error,omitempty"`
+}
+
+type Report struct {
+	Bucket    string            `json:"bucket"`
+	Prefix    string            `json:"prefix"`
+	Providers []ProviderSummary `json:"providers"`
+	// Keys is how many distinct keys were compared
+	Keys int `json:"keys"`
+	// Unverified are keys stored everywhere with the same size but no checksum two providers
+	// report in common, they count as in sync
+	Unverified []string `json:"unverified,omitempty"`
+	Issues     []Issue  `json:"issues"`
+}
+
+// OK reports whether every provider was listed and they all agree
+func (r Report) OK() bool {
+	for _, p := range r.Providers {
+		if p.Error != "" {
+			return false
+		}
+	}
+	return len(r.Issues) == 0
+}
+
+// Run lists prefix on every uploader and compares the copies of each key. Sizes are compared
+// first, then the strongest checksum two copies have in common: the sha256 uploader stores in
+// metadata, then the provider's SHA-256, MD5 and CRC32C. Objects are only stat'ed when their
+// listing leaves nothing to compare
+func Run(ctx context.Context, uploaders []providers.Uploader, bucket, prefix string) Report {
+	report := Report{Bucket: bucket, Prefix: prefix, Providers: make([]ProviderSummary, len(uploaders))}
+	listings := make([]map[string]providers.ObjectInfo, len(uploaders))
+	wg := sync.WaitGroup{}
+	for i, u := range uploaders {
+		wg.Add(1)
+		go func(i int, u providers.Uploader) {
+			defer wg.Done()
+			report.Providers[i], listings[i] = list(ctx, u, bucket, prefix)
+		}(i, u)
+	}
+	wg.Wait()
+
+	keys := map[string]struct{}{}
+	for _, l := range listings {
+		for key := range l {
+			keys[key] = struct{}{}
+		}
+	}
+	sorted := make([]string, 0, len(keys))
+	for key := range keys {
+		sorted = append(sorted, key)
+	}
+	sort.Strings(sorted)
+	report.Keys = len(sorted)
+
+	for _, key := range sorted {
+		copies := map[int]providers.ObjectInfo{}
+		for i, l := range listings {
+			if l == nil {
+				continue
+			}
+			if obj, ok := l[key]; ok {
+				copies[i] = obj
+			}
+		}
+		issue, verified := compare(ctx, uploaders, bucket, key, listings, copies)
+		if issue != nil {
+			report.Issues = append(report.Issues, *issue)
+		} else if !verified {
+			report.Unverified = append(report.Unverified, key)
+		}
+	}
+	return report
+}
+
+func list(ctx context.Context, u providers.Uploader, bucket, prefix string) (ProviderSummary, map[string]providers.ObjectInfo) {
+	summary := ProviderSummary{Provider: u.GetName()}
+	l, ok := u.(providers.Lister)
+	if !ok {
+		summary.Error = "listing not supported"
+		return summary, nil
+	}
+	objects, err := providers.ListAll(ctx, l, bucket, providers.ListOptions{Prefix: prefix})
+	// a missing bucket holds nothing, every object elsewhere is missing from it
+	if err != nil && !errors.Is(err, providers.ErrNotFound) {
+		summary.Error = err.Error()
+		return summary, nil
+	}
+	listing := map[string]providers.ObjectInfo{}
+	for _, o := range objects {
+		// directory placeholders some consoles create aren't replicated objects
+		if strings.HasSuffix(o.Key, "/") && o.Size == 0 {
+			continue
+		}
+		listing[o.Key] = o
+		summary.Objects++
+		summary.Bytes += o.Size
+	}
+	return summary, listing
+}
+
+// compare returns the issue with key, if any, and whether the copies were proven identical
+func compare(ctx context.Context, uploaders []providers.Uploader, bucket, key string, listings []map[string]providers.ObjectInfo, copies map[int]providers.ObjectInfo) (*Issue, bool) {
+	source := -1
+	for i := range uploaders {
+		obj, ok := copies[i]
+		if ok && (source < 0 || obj.LastModified.After(copies[source].LastModified)) {
+			source = i
+		}
+	}
+	issue := &Issue{Key: key, Source: uploaders[source].GetName(), Objects: map[providers.Provider]providers.ObjectInfo{}}
+	for i, obj := range copies {
+		issue.Objects[uploaders[i].GetName()] = obj
+	}
+
+	verified, srcStated := true, false
+	src := copies[source]
+	for i, u := range uploaders {
+		if listings[i] == nil || i == source {
+			continue
+		}
+		obj, ok := copies[i]
+		switch {
+		case !ok:
+			issue.Kind = worst(issue.Kind, KindMissing)
+			issue.Stale = append(issue.Stale, u.GetName())
+			continue
+		case obj.Size != src.Size:
+			issue.Kind = worst(issue.Kind, KindSize)
+			issue.Stale = append(issue.Stale, u.GetName())
+			continue
+		}
+
+		same, known := sameContent(src, obj)
+		if !known {
+			if !srcStated {
+				src, srcStated = stat(ctx, uploaders[source], bucket, src), true
+				issue.Objects[issue.Source] = src
+			}
+			obj = stat(ctx, u, bucket, obj)
+			issue.Objects[u.GetName()] = obj
+			same, known = sameContent(src, obj)
+		}
+		if !known {
+			verified = false
+		} else if !same {
+			issue.Kind = worst(issue.Kind, KindChecksum)
+			issue.Stale = append(issue.Stale, u.GetName())
+		}
+	}
+	if len(issue.Stale) == 0 {
+		return nil, verified
+	}
+	return issue, verified
+}
+
+// worst keeps the kind that says the most about how far apart the copies are
+func worst(a, b Kind) Kind {
+	rank := map[Kind]int{"": 0, KindChecksum: 1, KindSize: 2, KindMissing: 3}
+	if rank[b] > rank[a] {
+		return b
+	}
+	return a
+}
+
+func stat(ctx context.Context, u providers.Uploader, bucket string, obj providers.ObjectInfo) providers.ObjectInfo {
+	s, ok := u.(providers.Stater)
+	if !ok {
+		return obj
+	}
+	info, err := s.Stat(ctx, bucket, obj.Key)
+	if err != nil {
+		return obj
+	}
+	return info
+}
+
+// sameContent compares the strongest checksum a and b both have, known is false when they
+// have none in common
+func sameContent(a, b providers.ObjectInfo) (same, known bool) {
+	pairs := [][2]string{
+		{a.MetadataValue(providers.MetadataSHA256), b.MetadataValue(providers.MetadataSHA256)},
+		{a.Checksums.SHA256, b.Checksums.SHA256},
+		{a.Checksums.MD5, b.Checksums.MD5},
+		{a.Checksums.CRC32C, b.Checksums.CRC32C},
+	}
+	for _, p := range pairs {
+		if p[0] != "" && p[1] != "" {
+			return strings.EqualFold(p[0], p[1]), true
+		}
+	}
+	return false, false
+}
+
+// Repair copies each issue's source version to its stale providers, recording the outcome on
+// the issue. The object is staged in a temp file and its checksums are sent with every upload,
+// metadata and content type are copied from the source. Uploads keep to the providers' limits
+// and bandwidth's, which may be nil
+func Repair(ctx context.Context, uploaders []providers.Uploader, bandwidth *ratelimit.Bucket, bucket string, issues []Issue) {
+	byName := map[providers.Provider]providers.Uploader{}
+	for _, u := range uploaders {
+		byName[u.GetName()] = u
+	}
+	for i := range issues {
+		repair(ctx, byName, bandwidth, bucket, &issues[i])
+	}
+}
+
+func repair(ctx context.Context, uploaders map[providers.Provider]providers.Uploader, bandwidth *ratelimit.Bucket, bucket string, issue *Issue) {
+	issue.Repaired, issue.RepairErrors = nil, map[providers.Provider]string{}
+	fail := func(err error) {
+		for _, p := range issue.Stale {
+			issue.RepairErrors[p] = err.Error()
+		}
+	}
+
+	// listings leave out metadata on some providers
+	src := stat(ctx, uploaders[issue.Source], bucket, issue.Objects[issue.Source])
+	staged, sums, err := providers.Stage(ctx, uploaders[issue.Source], bucket, issue.Key)
+	if err != nil {
+		fail(fmt.Errorf("downloading from %s: %w", issue.Source, err))
+		return
+	}
+	defer os.Remove(staged.Name())
+	defer staged.Close()
+
+	opts := providers.UploadOptions{ContentType: src.ContentType, Metadata: src.Metadata, Checksums: sums}
+	for _, p := range issue.Stale {
+		if _, err = staged.Seek(0, io.SeekStart); err == nil {
+			err = coordinator.Upload(ctx, uploaders[p], bandwidth, bucket, issue.Key, nopCloser{staged}, opts)
+		}
+		if err != nil {
+			issue.RepairErrors[p] = err.Error()
+			continue
+		}
+		issue.Repaired = append(issue.Repaired, p)
+	}
+}
+
+// nopCloser keeps uploaders that close their reader from closing the staged file between uploads
+type nopCloser struct {
+	*os.File
+}
+
+func (nopCloser) Close() error { return nil }
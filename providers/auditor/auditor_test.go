@@ -0,0 +1,185 @@
+package auditor
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/stevequadros/uploader/providers"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memStore is an in-memory provider that reports md5 checksums only when withMD5 is set
+type memStore struct {
+	name    providers.Provider
+	withMD5 bool
+	listErr error
+	mu      sync.Mutex
+	objects map[string]memObject
+}
+
+type memObject struct {
+	content  string
+	modified time.Time
+	metadata map[string]string
+}
+
+var _ providers.OptionsUploader = (*memStore)(nil)
+var _ providers.Lister = (*memStore)(nil)
+var _ providers.Stater = (*memStore)(nil)
+var _ providers.Downloader = (*memStore)(nil)
+
+func newMemStore(name providers.Provider, withMD5 bool, objects map[string]memObject) *memStore {
+	if objects == nil {
+		objects = map[string]memObject{}
+	}
+	return &memStore{name: name, withMD5: withMD5, objects: objects}
+}
+
+func (m *memStore) GetName() providers.Provider { return m.name }
+func (m *memStore) Upload(ctx context.Context, bucket, key string, r io.ReadSeekCloser) error {
+	return m.UploadWithOptions(ctx, bucket, key, r, providers.UploadOptions{})
+}
+func (m *memStore) UploadWithOptions(ctx context.Context, bucket, key string, r io.ReadSeekCloser, opts providers.UploadOptions) error {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.objects[key] = memObject{string(b), time.Now(), opts.Metadata}
+	return nil
+}
+func (m *memStore) info(key string, o memObject) providers.ObjectInfo {
+	info := providers.ObjectInfo{Key: key, Size: int64(len(o.content)), LastModified: o.modified, Metadata: o.metadata}
+	if m.withMD5 {
+		info.Checksums, _, _ = providers.ComputeChecksums(strings.NewReader(o.content))
+		info.Checksums.CRC32C, info.Checksums.SHA256 = "", ""
+	}
+	return info
+}
+func (m *memStore) Stat(ctx context.Context, bucket, key string) (providers.ObjectInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	o, ok := m.objects[key]
+	if !ok {
+		return providers.ObjectInfo{}, providers.NewError(m.GetName(), "stat", http.StatusNotFound, "", "", errors.New("missing"))
+	}
+	return m.info(key, o), nil
+}
+func (m *memStore) List(ctx context.Context, bucket string, opts providers.ListOptions) (providers.ListPage, error) {
+	if m.listErr != nil {
+		return providers.ListPage{}, m.listErr
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var page providers.ListPage
+	for key, o := range m.objects {
+		if strings.HasPrefix(key, opts.Prefix) {
+			info := m.info(key, o)
+			// like S3, listings leave metadata out
+			info.Metadata = nil
+			page.Objects = append(page.Objects, info)
+		}
+	}
+	return page, nil
+}
+func (m *memStore) Download(ctx context.Context, bucket, key string) (io.ReadCloser, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	o, ok := m.objects[key]
+	if !ok {
+		return nil, providers.NewError(m.GetName(), "download", http.StatusNotFound, "", "", errors.New("missing"))
+	}
+	return io.NopCloser(bytes.NewReader([]byte(o.content))), nil
+}
+
+func TestRun(t *testing.T) {
+	old, recent := time.Now().Add(-time.Hour), time.Now()
+	sha := map[string]string{providers.MetadataSHA256: "abc"}
+	aws := newMemStore("aws", true, map[string]memObject{
+		"p/same":     {"same", old, nil},
+		"p/missing":  {"gone", old, nil},
+		"p/size":     {"short", old, nil},
+		"p/checksum": {"aaaa", recent, nil},
+		"p/meta":     {"meta", old, sha},
+		"other/key":  {"outside the prefix", old, nil},
+	})
+	gcp := newMemStore("gcp", true, map[string]memObject{
+		"p/same":     {"same", recent, nil},
+		"p/size":     {"longer", recent, nil},
+		"p/checksum": {"bbbb", old, nil},
+		"p/meta":     {"meta", old, nil},
+	})
+	azure := newMemStore("azure", false, map[string]memObject{
+		"p/same":     {"same", old, nil},
+		"p/missing":  {"gone", recent, nil},
+		"p/size":     {"longer", old, nil},
+		"p/checksum": {"aaaa", old, nil},
+		"p/meta":     {"meta", old, sha},
+	})
+	uploaders := []providers.Uploader{aws, gcp, azure}
+
+	report := Run(context.Background(), uploaders, "bucket", "p/")
+	require.False(t, report.OK())
+	require.Equal(t, 5, report.Keys)
+	require.Equal(t, ProviderSummary{Provider: "aws", Objects: 5, Bytes: 21}, report.Providers[0])
+
+	got := map[string]string{}
+	for _, i := range report.Issues {
+		got[i.Key] = string(i.Kind) + " " + string(i.Source)
+		for _, p := range i.Stale {
+			got[i.Key] += " " + string(p)
+		}
+	}
+	require.Equal(t, map[string]string{
+		"p/missing":  "missing azure gcp",
+		"p/size":     "size gcp aws",
+		"p/checksum": "checksum aws gcp",
+	}, got)
+	// azure reports no checksum, p/same and p/meta can only be compared through their metadata
+	require.Equal(t, []string{"p/same"}, report.Unverified)
+}
+
+func TestRunListFailure(t *testing.T) {
+	broken, ok := newMemStore("broken", true, nil), newMemStore("ok", true, map[string]memObject{"a": {"a", time.Now(), nil}})
+	broken.listErr = errors.New("AccessDenied")
+
+	report := Run(context.Background(), []providers.Uploader{broken, ok}, "bucket", "")
+	require.False(t, report.OK())
+	require.Equal(t, "AccessDenied", report.Providers[0].Error)
+	require.Empty(t, report.Issues, "an unlisted provider isn't reported as missing everything")
+}
+
+func TestRepair(t *testing.T) {
+	now := time.Now()
+	source := newMemStore("source", true, map[string]memObject{
+		"a": {"new", now, map[string]string{providers.MetadataSHA256: "abc"}},
+		"b": {"b", now, nil},
+	})
+	stale := newMemStore("stale", true, map[string]memObject{"a": {"old", now.Add(-time.Minute), nil}})
+	uploaders := []providers.Uploader{source, stale}
+
+	report := Run(context.Background(), uploaders, "bucket", "")
+	require.Len(t, report.Issues, 2)
+	Repair(context.Background(), uploaders, nil, "bucket", report.Issues)
+	for _, i := range report.Issues {
+		require.True(t, i.Fixed(), i.RepairErrors)
+		require.Equal(t, []providers.Provider{"stale"}, i.Repaired)
+	}
+	require.Equal(t, "new", stale.objects["a"].content)
+	require.Equal(t, "abc", stale.objects["a"].metadata[providers.MetadataSHA256], "metadata left out of listings is copied")
+
+	require.True(t, Run(context.Background(), uploaders, "bucket", "").OK())
+
+	// a source that vanished since the audit fails the repair
+	issues := []Issue{{Key: "gone", Source: "source", Stale: []providers.Provider{"stale"}}}
+	Repair(context.Background(), uploaders, nil, "bucket", issues)
+	require.False(t, issues[0].Fixed())
+	require.Contains(t, issues[0].RepairErrors["stale"], "downloading from source")
+}
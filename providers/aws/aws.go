@@ -2,26 +2,46 @@ package aws
 
 import (
 	"context"
+	"encoding/base64"
+	"encoding/hex"
 	"errors"
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/s3/s3manager"
 	"github.com/stevequadros/uploader/config"
 	"github.com/stevequadros/uploader/providers"
+	"github.com/stevequadros/uploader/providers/logging"
+	"github.com/stevequadros/uploader/providers/tracing"
+	"go.opentelemetry.io/otel/attribute"
 	"golang.org/x/oauth2/google"
 	"io"
+	"strings"
 )
 
 type AWSUploader struct {
 	credentials *google.Credentials
 	client      *s3manager.Uploader
+	timeouts    config.Timeouts
+	limiters    providers.Limiters
+	log         *logging.Logger
 }
 
 var _ providers.Uploader = (*AWSUploader)(nil)
+var _ providers.OptionsUploader = (*AWSUploader)(nil)
+var _ providers.StreamUploader = (*AWSUploader)(nil)
+var _ providers.BucketEnsurer = (*AWSUploader)(nil)
+var _ providers.Downloader = (*AWSUploader)(nil)
+var _ providers.Deleter = (*AWSUploader)(nil)
+var _ providers.Stater = (*AWSUploader)(nil)
+var _ providers.Lister = (*AWSUploader)(nil)
+var _ providers.TimeoutsConfigured = (*AWSUploader)(nil)
+var _ providers.RateLimited = (*AWSUploader)(nil)
 
-func New(config *config.AWS) (*AWSUploader, error) {
+// New builds the uploader of config, logging to log, which may be nil
+func New(config *config.AWS, log *logging.Logger) (*AWSUploader, error) {
 	if config == nil || config.Credentials == nil {
 		return nil, errors.New("AWS credentials are empty")
 	}
@@ -31,9 +51,11 @@ func New(config *config.AWS) (*AWSUploader, error) {
 		Profile:  config.Credentials.Profile,
 	}
 	creds := credentials.NewCredentials(provider)
+	limiters := providers.NewLimiters(config.Limits)
 	sess, err := session.NewSession(&aws.Config{
 		Credentials: creds,
 		Region:      aws.String("us-east-1"),
+		HTTPClient:  providers.HTTPClient(config.Timeouts, limiters.Requests, log),
 	})
 	if err != nil {
 		return nil, err
@@ -41,28 +63,226 @@ func New(config *config.AWS) (*AWSUploader, error) {
 
 	// Create an uploader with the session and default options
 	client := s3manager.NewUploader(sess)
-	return &AWSUploader{
-		client: client,
-	}, nil
+	u := &AWSUploader{
+		client:   client,
+		limiters: limiters,
+		log:      log.With("provider", providers.AWS),
+	}
+	if config.Timeouts != nil {
+		u.timeouts = *config.Timeouts
+	}
+	return u, nil
+}
+
+func (u *AWSUploader) Timeouts() config.Timeouts {
+	return u.timeouts
+}
+
+func (u *AWSUploader) Limiters() providers.Limiters {
+	return u.limiters
 }
 
 func (u *AWSUploader) GetName() providers.Provider {
 	return providers.AWS
 }
 
+func (u *AWSUploader) EnsureBucket(ctx context.Context, bucket string) (err error) {
+	ctx, span := tracing.Start(ctx, "ensure bucket", attribute.String("provider", string(providers.AWS)), attribute.String("bucket", bucket))
+	defer func() { tracing.End(span, err) }()
+	_, err = u.client.S3.HeadBucketWithContext(ctx, &s3.HeadBucketInput{Bucket: aws.String(bucket)})
+	if err == nil {
+		return nil
+	}
+	var aerr awserr.Error
+	if !errors.As(err, &aerr) || aerr.Code() != "NotFound" {
+		return wrapError("ensure bucket", err)
+	}
+	_, err = u.client.S3.CreateBucketWithContext(ctx, &s3.CreateBucketInput{Bucket: aws.String(bucket)})
+	return wrapError("create bucket", err)
+}
+
 func (u *AWSUploader) Upload(ctx context.Context, bucket, key string, reader io.ReadSeekCloser) error {
-	_, err := u.client.S3.CreateBucket(&s3.CreateBucketInput{Bucket: aws.String(bucket)})
+	return u.UploadWithOptions(ctx, bucket, key, reader, providers.UploadOptions{})
+}
+
+func (u *AWSUploader) UploadWithOptions(ctx context.Context, bucket, key string, reader io.ReadSeekCloser, opts providers.UploadOptions) error {
+	return u.UploadStream(ctx, bucket, key, reader, opts)
+}
+
+// UploadStream uploads in parts buffered from reader, s3manager still reads seekable readers
+// in place. A multipart upload that fails or is cancelled is aborted so its parts aren't kept
+func (u *AWSUploader) UploadStream(ctx context.Context, bucket, key string, reader io.Reader, opts providers.UploadOptions) error {
+	err := u.EnsureBucket(ctx, bucket)
 	if err != nil {
 		return err
 	}
-	// Upload the file to S3.
-	_, err = u.client.UploadWithContext(ctx, &s3manager.UploadInput{
+	in := &s3manager.UploadInput{
 		Bucket: aws.String(bucket),
 		Key:    aws.String(key),
 		Body:   reader,
+	}
+	if opts.ContentType != "" {
+		in.ContentType = aws.String(opts.ContentType)
+	}
+	if len(opts.Metadata) > 0 {
+		in.Metadata = aws.StringMap(opts.Metadata)
+	}
+	// checked by S3 on single part uploads, s3manager leaves them out of multipart ones
+	if md5 := hexToBase64(opts.Checksums.MD5); md5 != "" {
+		in.ContentMD5 = aws.String(md5)
+	}
+	if sha := hexToBase64(opts.Checksums.SHA256); sha != "" {
+		in.ChecksumSHA256 = aws.String(sha)
+	}
+	// Upload the file to S3. s3manager's own abort would run with ctx, which is already
+	// cancelled when the upload was interrupted, so the parts are aborted here instead
+	_, err = u.client.UploadWithContext(ctx, in, func(u *s3manager.Uploader) {
+		u.LeavePartsOnError = true
 	})
 	if err != nil {
-		return providers.NewUploadError("AWS", err)
+		var multi s3manager.MultiUploadFailure
+		if errors.As(err, &multi) && multi.UploadID() != "" {
+			actx, cancel := providers.AbortContext(ctx)
+			defer cancel()
+			if aerr := u.AbortResumable(actx, providers.Checkpoint{Bucket: bucket, Key: key, UploadID: multi.UploadID()}); aerr != nil {
+				u.log.Warn("aborting the multipart upload failed, gc removes its parts", "bucket", bucket, "key", key, "upload_id", multi.UploadID(), "error", aerr)
+			} else {
+				u.log.Debug("multipart upload aborted", "bucket", bucket, "key", key, "upload_id", multi.UploadID())
+			}
+		}
+		return wrapError("upload", err)
 	}
 	return nil
 }
+
+func (u *AWSUploader) Download(ctx context.Context, bucket, key string) (io.ReadCloser, error) {
+	out, err := u.client.S3.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, wrapError("download", err)
+	}
+	return out.Body, nil
+}
+
+func (u *AWSUploader) Delete(ctx context.Context, bucket, key string) error {
+	_, err := u.client.S3.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	return wrapError("delete", err)
+}
+
+func (u *AWSUploader) Stat(ctx context.Context, bucket, key string) (providers.ObjectInfo, error) {
+	out, err := u.client.S3.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+		Bucket:       aws.String(bucket),
+		Key:          aws.String(key),
+		ChecksumMode: aws.String(s3.ChecksumModeEnabled),
+	})
+	if err != nil {
+		return providers.ObjectInfo{}, wrapError("stat", err)
+	}
+	info := providers.ObjectInfo{
+		Key:          key,
+		Size:         aws.Int64Value(out.ContentLength),
+		ETag:         strings.Trim(aws.StringValue(out.ETag), `"`),
+		LastModified: aws.TimeValue(out.LastModified),
+		ContentType:  aws.StringValue(out.ContentType),
+		VersionID:    aws.StringValue(out.VersionId),
+		Metadata:     aws.StringValueMap(out.Metadata),
+	}
+	info.Checksums = providers.Checksums{
+		MD5:    md5FromETag(info.ETag),
+		CRC32C: base64ToHex(aws.StringValue(out.ChecksumCRC32C)),
+		SHA256: base64ToHex(aws.StringValue(out.ChecksumSHA256)),
+	}
+	return info, nil
+}
+
+func (u *AWSUploader) List(ctx context.Context, bucket string, opts providers.ListOptions) (providers.ListPage, error) {
+	in := &s3.ListObjectsV2Input{
+		Bucket: aws.String(bucket),
+		Prefix: aws.String(opts.Prefix),
+	}
+	if opts.PageToken != "" {
+		in.ContinuationToken = aws.String(opts.PageToken)
+	}
+	if opts.MaxKeys > 0 {
+		in.MaxKeys = aws.Int64(int64(opts.MaxKeys))
+	}
+	out, err := u.client.S3.ListObjectsV2WithContext(ctx, in)
+	if err != nil {
+		return providers.ListPage{}, wrapError("list", err)
+	}
+
+	var page providers.ListPage
+	for _, o := range out.Contents {
+		etag := strings.Trim(aws.StringValue(o.ETag), `"`)
+		page.Objects = append(page.Objects, providers.ObjectInfo{
+			Key:          aws.StringValue(o.Key),
+			Size:         aws.Int64Value(o.Size),
+			ETag:         etag,
+			LastModified: aws.TimeValue(o.LastModified),
+			Checksums:    providers.Checksums{MD5: md5FromETag(etag)},
+		})
+	}
+	if aws.BoolValue(out.IsTruncated) {
+		page.NextPageToken = aws.StringValue(out.NextContinuationToken)
+	}
+	return page, nil
+}
+
+// wrapError classifies err, the failure of op, by the status, code and request ID of S3's
+// response. The SDK's errors don't unwrap, the response is found through their OrigErr
+func wrapError(op string, err error) error {
+	if err == nil {
+		return nil
+	}
+	var status int
+	var code, requestID string
+	for e := err; e != nil; {
+		if reqErr, ok := e.(awserr.RequestFailure); ok {
+			status, code, requestID = reqErr.StatusCode(), reqErr.Code(), reqErr.RequestID()
+			break
+		}
+		aerr, ok := e.(awserr.Error)
+		if !ok {
+			e = errors.Unwrap(e)
+			continue
+		}
+		if code == "" {
+			code = aerr.Code()
+		}
+		e = aerr.OrigErr()
+	}
+	return providers.NewError(providers.AWS, op, status, code, requestID, err)
+}
+
+// md5FromETag returns the etag when it is the object's md5, which is the case unless the
+// object was uploaded in parts or encrypted with SSE-KMS
+func md5FromETag(etag string) string {
+	if len(etag) != 32 {
+		return ""
+	}
+	if _, err := hex.DecodeString(etag); err != nil {
+		return ""
+	}
+	return strings.ToLower(etag)
+}
+
+func hexToBase64(s string) string {
+	b, err := hex.DecodeString(s)
+	if err != nil || len(b) == 0 {
+		return ""
+	}
+	return base64.StdEncoding.EncodeToString(b)
+}
+
+func base64ToHex(s string) string {
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil || len(b) == 0 {
+		return ""
+	}
+	return hex.EncodeToString(b)
+}
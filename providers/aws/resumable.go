@@ -0,0 +1,196 @@
+package aws
+
+import (
+	"context"
+	"errors"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/stevequadros/uploader/providers"
+	"github.com/stevequadros/uploader/providers/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"io"
+	"sort"
+	"time"
+)
+
+// resumablePartSize is the smallest part uploaded by UploadResumable, larger files get larger
+// parts to stay under S3's 10,000 parts
+const resumablePartSize = 16 << 20
+
+var _ providers.ResumableUploader = (*AWSUploader)(nil)
+var _ providers.PendingLister = (*AWSUploader)(nil)
+
+// UploadResumable uploads the file as a multipart upload, one part at a time. S3 keeps the
+// parts of an upload until it is completed or aborted, so a later run only uploads the parts
+// still missing
+func (u *AWSUploader) UploadResumable(ctx context.Context, bucket, key string, r io.ReaderAt, size int64, opts providers.UploadOptions, cp *providers.Checkpoint, save func(providers.Checkpoint) error) error {
+	if err := u.EnsureBucket(ctx, bucket); err != nil {
+		return err
+	}
+
+	stored := map[int64]providers.Part{}
+	if cp.UploadID != "" {
+		parts, err := u.listParts(ctx, bucket, key, cp.UploadID)
+		switch {
+		case isNoSuchUpload(err):
+			// completed, aborted or expired since, start over
+			cp.UploadID, cp.Parts = "", nil
+		case err != nil:
+			return wrapError("upload", err)
+		}
+		for _, p := range parts {
+			stored[int64(p.Number)] = p
+		}
+	}
+	if cp.UploadID == "" {
+		in := &s3.CreateMultipartUploadInput{Bucket: aws.String(bucket), Key: aws.String(key)}
+		if opts.ContentType != "" {
+			in.ContentType = aws.String(opts.ContentType)
+		}
+		if len(opts.Metadata) > 0 {
+			in.Metadata = aws.StringMap(opts.Metadata)
+		}
+		out, err := u.client.S3.CreateMultipartUploadWithContext(ctx, in)
+		if err != nil {
+			return wrapError("upload", err)
+		}
+		cp.UploadID = aws.StringValue(out.UploadId)
+		cp.PartSize = providers.PartSize(size, resumablePartSize, s3manager.MaxUploadParts)
+		cp.Parts = nil
+		if err = save(*cp); err != nil {
+			return err
+		}
+	}
+
+	// an empty file is still uploaded as one empty part
+	cp.Parts = nil
+	for n, off := int64(1), int64(0); off < size || n == 1; n, off = n+1, off+cp.PartSize {
+		length := cp.PartSize
+		if size-off < length {
+			length = size - off
+		}
+		if p, ok := stored[n]; ok && p.Size == length {
+			cp.Parts = append(cp.Parts, p)
+			continue
+		}
+		pctx, span := tracing.Start(ctx, "upload part", attribute.Int64("part", n), attribute.Int64("size", length))
+		out, err := u.client.S3.UploadPartWithContext(pctx, &s3.UploadPartInput{
+			Bucket:        aws.String(bucket),
+			Key:           aws.String(key),
+			UploadId:      aws.String(cp.UploadID),
+			PartNumber:    aws.Int64(n),
+			Body:          io.NewSectionReader(r, off, length),
+			ContentLength: aws.Int64(length),
+		})
+		tracing.End(span, err)
+		if err != nil {
+			return wrapError("upload", err)
+		}
+		cp.Parts = append(cp.Parts, providers.Part{Number: int(n), ID: aws.StringValue(out.ETag), Size: length})
+		if err = save(*cp); err != nil {
+			return err
+		}
+	}
+
+	completed := make([]*s3.CompletedPart, len(cp.Parts))
+	for i, p := range cp.Parts {
+		completed[i] = &s3.CompletedPart{ETag: aws.String(p.ID), PartNumber: aws.Int64(int64(p.Number))}
+	}
+	_, err := u.client.S3.CompleteMultipartUploadWithContext(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(bucket),
+		Key:             aws.String(key),
+		UploadId:        aws.String(cp.UploadID),
+		MultipartUpload: &s3.CompletedMultipartUpload{Parts: completed},
+	})
+	if err != nil {
+		return wrapError("upload", err)
+	}
+	return nil
+}
+
+// AbortResumable aborts the multipart upload, deleting its parts
+func (u *AWSUploader) AbortResumable(ctx context.Context, cp providers.Checkpoint) error {
+	if cp.UploadID == "" {
+		return nil
+	}
+	_, err := u.client.S3.AbortMultipartUploadWithContext(ctx, &s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(cp.Bucket),
+		Key:      aws.String(cp.Key),
+		UploadId: aws.String(cp.UploadID),
+	})
+	if isNoSuchUpload(err) {
+		return nil
+	}
+	return wrapError("abort upload", err)
+}
+
+// listParts returns the parts S3 holds for an upload, ordered by number
+func (u *AWSUploader) listParts(ctx context.Context, bucket, key, uploadID string) ([]providers.Part, error) {
+	var parts []providers.Part
+	in := &s3.ListPartsInput{Bucket: aws.String(bucket), Key: aws.String(key), UploadId: aws.String(uploadID)}
+	err := u.client.S3.ListPartsPagesWithContext(ctx, in, func(page *s3.ListPartsOutput, last bool) bool {
+		for _, p := range page.Parts {
+			parts = append(parts, providers.Part{
+				Number: int(aws.Int64Value(p.PartNumber)),
+				ID:     aws.StringValue(p.ETag),
+				Size:   aws.Int64Value(p.Size),
+			})
+		}
+		return true
+	})
+	sort.Slice(parts, func(i, j int) bool { return parts[i].Number < parts[j].Number })
+	return parts, err
+}
+
+func isNoSuchUpload(err error) bool {
+	var aerr awserr.Error
+	return errors.As(err, &aerr) && aerr.Code() == s3.ErrCodeNoSuchUpload
+}
+
+// ListPending returns the multipart uploads started before the given time, with the size of the
+// parts each one stores
+func (u *AWSUploader) ListPending(ctx context.Context, bucket string, before time.Time) ([]providers.PendingUpload, error) {
+	var pending []providers.PendingUpload
+	in := &s3.ListMultipartUploadsInput{Bucket: aws.String(bucket)}
+	err := u.client.S3.ListMultipartUploadsPagesWithContext(ctx, in, func(page *s3.ListMultipartUploadsOutput, last bool) bool {
+		for _, m := range page.Uploads {
+			started := aws.TimeValue(m.Initiated)
+			if !started.Before(before) {
+				continue
+			}
+			pending = append(pending, providers.PendingUpload{
+				Provider: providers.AWS,
+				Bucket:   bucket,
+				Key:      aws.StringValue(m.Key),
+				UploadID: aws.StringValue(m.UploadId),
+				Started:  started,
+			})
+		}
+		return true
+	})
+	if err != nil {
+		return nil, wrapError("list pending", err)
+	}
+	for i, p := range pending {
+		parts, err := u.listParts(ctx, bucket, p.Key, p.UploadID)
+		if isNoSuchUpload(err) {
+			// completed or aborted since it was listed
+			continue
+		}
+		if err != nil {
+			return nil, wrapError("list pending", err)
+		}
+		pending[i].Parts = len(parts)
+		for _, part := range parts {
+			pending[i].Size += part.Size
+		}
+	}
+	return pending, nil
+}
+
+// AbortPending aborts the multipart upload, deleting its parts
+func (u *AWSUploader) AbortPending(ctx context.Context, p providers.PendingUpload) error {
+	return u.AbortResumable(ctx, providers.Checkpoint{Bucket: p.Bucket, Key: p.Key, UploadID: p.UploadID})
+}
@@ -2,23 +2,46 @@ package azure
 
 import (
 	"context"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
 	"github.com/stevequadros/uploader/config"
 	"github.com/stevequadros/uploader/providers"
+	"github.com/stevequadros/uploader/providers/logging"
+	"github.com/stevequadros/uploader/providers/tracing"
+	"go.opentelemetry.io/otel/attribute"
 	"io"
-	"io/ioutil"
-	"os"
+	"strings"
+	"time"
+)
+
+const (
+	// streamBlockSize and streamBuffers bound UploadStream's memory to 16 MiB per upload
+	streamBlockSize = 4 << 20
+	streamBuffers   = 4
 )
 
 type AzureUploader struct {
-	client *azblob.ServiceClient
+	client   *azblob.ServiceClient
+	timeouts config.Timeouts
+	limiters providers.Limiters
+	log      *logging.Logger
 }
 
 var _ providers.Uploader = (*AzureUploader)(nil)
+var _ providers.OptionsUploader = (*AzureUploader)(nil)
+var _ providers.StreamUploader = (*AzureUploader)(nil)
+var _ providers.BucketEnsurer = (*AzureUploader)(nil)
+var _ providers.Downloader = (*AzureUploader)(nil)
+var _ providers.Deleter = (*AzureUploader)(nil)
+var _ providers.Stater = (*AzureUploader)(nil)
+var _ providers.Lister = (*AzureUploader)(nil)
+var _ providers.TimeoutsConfigured = (*AzureUploader)(nil)
+var _ providers.RateLimited = (*AzureUploader)(nil)
 
-func New(config *config.Azure) (*AzureUploader, error) {
+// New builds the uploader of config, logging to log, which may be nil
+func New(config *config.Azure, log *logging.Logger) (*AzureUploader, error) {
 	if config == nil || config.Credentials == nil {
 		return nil, errors.New("azure credentials are empty")
 	}
@@ -28,55 +51,223 @@ func New(config *config.Azure) (*AzureUploader, error) {
 		config.Credentials.AccountName,
 		config.Credentials.AccountKey,
 	)
-	serviceClient, err := azblob.NewServiceClientFromConnectionString(connStr, nil)
+	limiters := providers.NewLimiters(config.Limits)
+	opts := &azblob.ClientOptions{Transporter: providers.HTTPClient(config.Timeouts, limiters.Requests, log)}
+	serviceClient, err := azblob.NewServiceClientFromConnectionString(connStr, opts)
 	if err != nil {
 		return nil, err
 	}
-	return &AzureUploader{client: &serviceClient}, nil
+	u := &AzureUploader{client: &serviceClient, limiters: limiters, log: log.With("provider", providers.Azure)}
+	if config.Timeouts != nil {
+		u.timeouts = *config.Timeouts
+	}
+	return u, nil
+}
+
+func (u *AzureUploader) Timeouts() config.Timeouts {
+	return u.timeouts
+}
+
+func (u *AzureUploader) Limiters() providers.Limiters {
+	return u.limiters
 }
 
 func (u *AzureUploader) GetName() providers.Provider {
 	return providers.Azure
 }
 
-func (u *AzureUploader) Upload(ctx context.Context, bucket, key string, reader io.ReadSeekCloser) error {
+func (u *AzureUploader) EnsureBucket(ctx context.Context, bucket string) (err error) {
+	ctx, span := tracing.Start(ctx, "ensure bucket", attribute.String("provider", string(providers.Azure)), attribute.String("bucket", bucket))
+	defer func() { tracing.End(span, err) }()
 	containerClient := u.client.NewContainerClient(bucket)
 	// if container already exists, proceed without creation
-	res, err := containerClient.GetProperties(ctx, nil)
-	if res.ETag == nil {
+	_, err = containerClient.GetProperties(ctx, nil)
+	var storageErr *azblob.StorageError
+	if errors.As(err, &storageErr) && storageErr.ErrorCode == azblob.StorageErrorCodeContainerNotFound {
 		_, err = containerClient.Create(ctx, &azblob.CreateContainerOptions{})
-		if err != nil {
-			return err
-		}
+		return wrapError("create bucket", err)
 	}
+	return wrapError("ensure bucket", err)
+}
+
+func (u *AzureUploader) Upload(ctx context.Context, bucket, key string, reader io.ReadSeekCloser) error {
+	return u.UploadWithOptions(ctx, bucket, key, reader, providers.UploadOptions{})
+}
 
-	// azure closes the file, which will cause future calls to fail
-	// this is a workaround for now, but we'll copy it over to avoid this - in real application
-	// I'd prefer a better method since this could cause issues with large files
-	// NOTE - azureblob.Upload closes file, so no need to call
-	tmp, err := ioutil.TempFile("", "filescom-tmp-")
+func (u *AzureUploader) UploadWithOptions(ctx context.Context, bucket, key string, reader io.ReadSeekCloser, opts providers.UploadOptions) error {
+	err := u.EnsureBucket(ctx, bucket)
 	if err != nil {
 		return err
 	}
-	defer func() {
-		os.Remove(tmp.Name())
-	}()
+	containerClient := u.client.NewContainerClient(bucket)
 
-	_, err = io.Copy(tmp, reader)
+	blobClient := containerClient.NewBlockBlobClient(key)
+	uploadOpts := &azblob.UploadBlockBlobOptions{Metadata: opts.Metadata}
+	// checked by Azure against the body of the single Put Blob request
+	if md5, err := hex.DecodeString(opts.Checksums.MD5); err == nil && len(md5) > 0 {
+		uploadOpts.TransactionalContentMD5 = md5
+	}
+	if opts.ContentType != "" {
+		uploadOpts.HTTPHeaders = &azblob.BlobHTTPHeaders{BlobContentType: &opts.ContentType}
+	}
+	// azblob closes the body it is given, the reader is left for the caller to close
+	_, err = blobClient.Upload(ctx, nopCloser{reader}, uploadOpts)
 	if err != nil {
+		return wrapError("upload", err)
+	}
+	return nil
+}
+
+// UploadStream stages reader as blocks and commits them once it ends, nothing is committed if
+// reading fails and the staged blocks are discarded. The stream needs no temp file since blocks are read into memory one at a time
+func (u *AzureUploader) UploadStream(ctx context.Context, bucket, key string, reader io.Reader, opts providers.UploadOptions) error {
+	if err := u.EnsureBucket(ctx, bucket); err != nil {
 		return err
 	}
+	blobClient := u.client.NewContainerClient(bucket).NewBlockBlobClient(key)
+	// azblob v0.3.0 doesn't pass Metadata or HTTPHeaders on to the block list commit, they are
+	// set here for when it does
+	streamOpts := azblob.UploadStreamToBlockBlobOptions{
+		BufferSize: streamBlockSize,
+		MaxBuffers: streamBuffers,
+		Metadata:   opts.Metadata,
+	}
+	if opts.ContentType != "" {
+		streamOpts.HTTPHeaders = &azblob.BlobHTTPHeaders{BlobContentType: &opts.ContentType}
+	}
+	if _, err := blobClient.UploadStreamToBlockBlob(ctx, reader, streamOpts); err != nil {
+		// the blocks staged before the failure would otherwise stay for a week
+		actx, cancel := providers.AbortContext(ctx)
+		defer cancel()
+		if derr := discardBlocks(actx, blobClient); derr != nil {
+			u.log.Warn("discarding the staged blocks failed, gc removes them", "bucket", bucket, "key", key, "error", derr)
+		} else {
+			u.log.Debug("staged blocks discarded", "bucket", bucket, "key", key)
+		}
+		return wrapError("upload", err)
+	}
+	return nil
+}
 
-	// reset file to start after copy
-	_, err = tmp.Seek(0, 0)
+func (u *AzureUploader) Download(ctx context.Context, bucket, key string) (io.ReadCloser, error) {
+	blobClient := u.client.NewContainerClient(bucket).NewBlobClient(key)
+	res, err := blobClient.Download(ctx, nil)
 	if err != nil {
-		return err
+		return nil, wrapError("download", err)
 	}
+	return res.Body(nil), nil
+}
 
-	blobClient := containerClient.NewBlockBlobClient(key)
-	_, err = blobClient.Upload(ctx, tmp, &azblob.UploadBlockBlobOptions{})
+func (u *AzureUploader) Delete(ctx context.Context, bucket, key string) error {
+	blobClient := u.client.NewContainerClient(bucket).NewBlobClient(key)
+	_, err := blobClient.Delete(ctx, nil)
+	return wrapError("delete", err)
+}
+
+func (u *AzureUploader) Stat(ctx context.Context, bucket, key string) (providers.ObjectInfo, error) {
+	blobClient := u.client.NewContainerClient(bucket).NewBlobClient(key)
+	props, err := blobClient.GetProperties(ctx, nil)
 	if err != nil {
-		return providers.NewUploadError("Azure", err)
+		return providers.ObjectInfo{}, wrapError("stat", err)
 	}
-	return nil
+	return providers.ObjectInfo{
+		Key:          key,
+		Size:         derefInt64(props.ContentLength),
+		ETag:         strings.Trim(derefString(props.ETag), `"`),
+		LastModified: derefTime(props.LastModified),
+		ContentType:  derefString(props.ContentType),
+		VersionID:    derefString(props.VersionID),
+		Metadata:     props.Metadata,
+		Checksums:    providers.Checksums{MD5: hex.EncodeToString(props.ContentMD5)},
+	}, nil
+}
+
+func (u *AzureUploader) List(ctx context.Context, bucket string, opts providers.ListOptions) (providers.ListPage, error) {
+	listOpts := &azblob.ContainerListBlobFlatSegmentOptions{
+		Include: []azblob.ListBlobsIncludeItem{azblob.ListBlobsIncludeItemMetadata},
+		Prefix:  &opts.Prefix,
+	}
+	if opts.PageToken != "" {
+		listOpts.Marker = &opts.PageToken
+	}
+	if opts.MaxKeys > 0 {
+		maxResults := int32(opts.MaxKeys)
+		listOpts.Maxresults = &maxResults
+	}
+	pager := u.client.NewContainerClient(bucket).ListBlobsFlat(listOpts)
+
+	var page providers.ListPage
+	if pager.NextPage(ctx) {
+		res := pager.PageResponse()
+		if res.Segment != nil {
+			for _, item := range res.Segment.BlobItems {
+				page.Objects = append(page.Objects, blobInfo(item))
+			}
+		}
+		page.NextPageToken = derefString(res.NextMarker)
+	}
+	if err := pager.Err(); err != nil {
+		return providers.ListPage{}, wrapError("list", err)
+	}
+	return page, nil
+}
+
+func blobInfo(item *azblob.BlobItemInternal) providers.ObjectInfo {
+	info := providers.ObjectInfo{
+		Key:       derefString(item.Name),
+		VersionID: derefString(item.VersionID),
+	}
+	if len(item.Metadata) > 0 {
+		info.Metadata = map[string]string{}
+		for k, v := range item.Metadata {
+			info.Metadata[k] = derefString(v)
+		}
+	}
+	if p := item.Properties; p != nil {
+		info.Size = derefInt64(p.ContentLength)
+		info.ETag = strings.Trim(derefString(p.Etag), `"`)
+		info.LastModified = derefTime(p.LastModified)
+		info.ContentType = derefString(p.ContentType)
+		info.Checksums.MD5 = hex.EncodeToString(p.ContentMD5)
+	}
+	return info
+}
+
+// wrapError classifies err, the failure of op, by the status, error code and request ID of
+// Azure's response
+func wrapError(op string, err error) error {
+	if err == nil {
+		return nil
+	}
+	var status int
+	var code, requestID string
+	var storageErr *azblob.StorageError
+	if errors.As(err, &storageErr) {
+		code = string(storageErr.ErrorCode)
+		if resp := storageErr.Response(); resp != nil {
+			status, requestID = resp.StatusCode, logging.RequestID(resp.Header)
+		}
+	}
+	return providers.NewError(providers.Azure, op, status, code, requestID, err)
+}
+
+func derefString(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+func derefInt64(i *int64) int64 {
+	if i == nil {
+		return 0
+	}
+	return *i
+}
+
+func derefTime(t *time.Time) time.Time {
+	if t == nil {
+		return time.Time{}
+	}
+	return *t
 }
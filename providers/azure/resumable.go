@@ -0,0 +1,204 @@
+package azure
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"github.com/stevequadros/uploader/providers"
+	"github.com/stevequadros/uploader/providers/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"io"
+	"time"
+)
+
+const (
+	// resumableBlockSize is the smallest block staged by UploadResumable, larger files get larger
+	// blocks to stay under the 50,000 blocks a blob can hold
+	resumableBlockSize = 16 << 20
+	maxBlocks          = 50000
+)
+
+var _ providers.ResumableUploader = (*AzureUploader)(nil)
+var _ providers.PendingLister = (*AzureUploader)(nil)
+
+// UploadResumable stages the file as blocks and commits them once all are staged. Uncommitted
+// blocks are kept by Azure for a week, so a later run only stages the blocks still missing
+func (u *AzureUploader) UploadResumable(ctx context.Context, bucket, key string, r io.ReaderAt, size int64, opts providers.UploadOptions, cp *providers.Checkpoint, save func(providers.Checkpoint) error) error {
+	if err := u.EnsureBucket(ctx, bucket); err != nil {
+		return err
+	}
+	blobClient := u.client.NewContainerClient(bucket).NewBlockBlobClient(key)
+
+	staged := map[string]int64{}
+	if cp.UploadID != "" {
+		var err error
+		if staged, err = uncommittedBlocks(ctx, "upload", blobClient); err != nil {
+			return err
+		}
+	} else {
+		prefix := make([]byte, 8)
+		if _, err := rand.Read(prefix); err != nil {
+			return err
+		}
+		// every block ID of a blob must have the same length, the prefix keeps them apart
+		// from blocks staged by other uploads of the same key
+		cp.UploadID = hex.EncodeToString(prefix)
+		cp.PartSize = providers.PartSize(size, resumableBlockSize, maxBlocks)
+	}
+
+	// an empty file is committed with no blocks
+	var ids []string
+	cp.Parts = nil
+	for n, off := 1, int64(0); off < size; n, off = n+1, off+cp.PartSize {
+		length := cp.PartSize
+		if size-off < length {
+			length = size - off
+		}
+		id := blockID(cp.UploadID, n)
+		ids = append(ids, id)
+		cp.Parts = append(cp.Parts, providers.Part{Number: n, ID: id, Size: length})
+		if s, ok := staged[id]; ok && s == length {
+			continue
+		}
+		body := nopCloser{io.NewSectionReader(r, off, length)}
+		pctx, span := tracing.Start(ctx, "upload part", attribute.Int("part", n), attribute.Int64("size", length))
+		_, err := blobClient.StageBlock(pctx, id, body, nil)
+		tracing.End(span, err)
+		if err != nil {
+			return wrapError("upload", err)
+		}
+		if err := save(*cp); err != nil {
+			return err
+		}
+	}
+
+	commitOpts := &azblob.CommitBlockListOptions{Metadata: opts.Metadata}
+	if opts.ContentType != "" {
+		commitOpts.BlobHTTPHeaders = &azblob.BlobHTTPHeaders{BlobContentType: &opts.ContentType}
+	}
+	if _, err := blobClient.CommitBlockList(ctx, ids, commitOpts); err != nil {
+		return wrapError("upload", err)
+	}
+	return nil
+}
+
+// AbortResumable discards the staged blocks
+func (u *AzureUploader) AbortResumable(ctx context.Context, cp providers.Checkpoint) error {
+	if cp.UploadID == "" {
+		return nil
+	}
+	return discardBlocks(ctx, u.client.NewContainerClient(cp.Bucket).NewBlockBlobClient(cp.Key))
+}
+
+// discardBlocks drops the uncommitted blocks of a blob. When the blob doesn't exist yet they are
+// dropped by committing an empty blob and deleting it, otherwise committing would replace the
+// existing blob so they are left for Azure to discard within a week
+func discardBlocks(ctx context.Context, blobClient azblob.BlockBlobClient) error {
+	_, err := blobClient.GetProperties(ctx, nil)
+	if err == nil {
+		return nil
+	}
+	if err = wrapError("abort upload", err); !errors.Is(err, providers.ErrNotFound) {
+		return err
+	}
+	if _, err = blobClient.CommitBlockList(ctx, []string{}, nil); err != nil {
+		return wrapError("abort upload", err)
+	}
+	_, err = blobClient.Delete(ctx, nil)
+	return wrapError("abort upload", err)
+}
+
+// uncommittedBlocks returns the size of each block staged but not committed, errors are those of op
+func uncommittedBlocks(ctx context.Context, op string, blobClient azblob.BlockBlobClient) (map[string]int64, error) {
+	blocks := map[string]int64{}
+	resp, err := blobClient.GetBlockList(ctx, azblob.BlockListTypeUncommitted, nil)
+	if err = wrapError(op, err); errors.Is(err, providers.ErrNotFound) {
+		return blocks, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, b := range resp.UncommittedBlocks {
+		if b != nil {
+			blocks[derefString(b.Name)] = derefInt64(b.Size)
+		}
+	}
+	return blocks, nil
+}
+
+func blockID(prefix string, n int) string {
+	return base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%s-%06d", prefix, n)))
+}
+
+type nopCloser struct {
+	io.ReadSeeker
+}
+
+func (nopCloser) Close() error { return nil }
+
+// ListPending returns the blobs that only have uncommitted blocks, staged before the given time,
+// with the size of those blocks. Listings include such blobs when asked to, so they are the
+// names listed only then. Blocks staged for a blob that exists can't be told apart this way
+func (u *AzureUploader) ListPending(ctx context.Context, bucket string, before time.Time) ([]providers.PendingUpload, error) {
+	containerClient := u.client.NewContainerClient(bucket)
+	committed := map[string]bool{}
+	if err := listBlobs(ctx, containerClient, nil, func(item *azblob.BlobItemInternal) {
+		committed[derefString(item.Name)] = true
+	}); err != nil {
+		return nil, err
+	}
+
+	var pending []providers.PendingUpload
+	include := []azblob.ListBlobsIncludeItem{azblob.ListBlobsIncludeItemUncommittedblobs}
+	if err := listBlobs(ctx, containerClient, include, func(item *azblob.BlobItemInternal) {
+		name := derefString(item.Name)
+		if committed[name] || item.Properties == nil {
+			return
+		}
+		// an age we can't tell could be an upload in progress
+		started := derefTime(item.Properties.CreationTime)
+		if started.IsZero() {
+			started = derefTime(item.Properties.LastModified)
+		}
+		if started.IsZero() || !started.Before(before) {
+			return
+		}
+		pending = append(pending, providers.PendingUpload{Provider: providers.Azure, Bucket: bucket, Key: name, Started: started})
+	}); err != nil {
+		return nil, err
+	}
+
+	for i, p := range pending {
+		blocks, err := uncommittedBlocks(ctx, "list pending", containerClient.NewBlockBlobClient(p.Key))
+		if err != nil {
+			return nil, err
+		}
+		pending[i].Parts = len(blocks)
+		for _, size := range blocks {
+			pending[i].Size += size
+		}
+	}
+	return pending, nil
+}
+
+// AbortPending discards the blob's uncommitted blocks
+func (u *AzureUploader) AbortPending(ctx context.Context, p providers.PendingUpload) error {
+	return discardBlocks(ctx, u.client.NewContainerClient(p.Bucket).NewBlockBlobClient(p.Key))
+}
+
+// listBlobs calls fn with every blob of the container
+func listBlobs(ctx context.Context, containerClient azblob.ContainerClient, include []azblob.ListBlobsIncludeItem, fn func(*azblob.BlobItemInternal)) error {
+	pager := containerClient.ListBlobsFlat(&azblob.ContainerListBlobFlatSegmentOptions{Include: include})
+	for pager.NextPage(ctx) {
+		if res := pager.PageResponse(); res.Segment != nil {
+			for _, item := range res.Segment.BlobItems {
+				fn(item)
+			}
+		}
+	}
+	return wrapError("list pending", pager.Err())
+}
@@ -0,0 +1,174 @@
+package checkpoint
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/stevequadros/uploader/providers"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DefaultMaxAge is how long a checkpoint is kept, GCS sessions and uncommitted Azure blocks
+// expire after a week so older checkpoints can't be resumed anyway
+const DefaultMaxAge = 6 * 24 * time.Hour
+
+// Store keeps one checkpoint per provider, bucket and key as JSON files in a directory. Every
+// save replaces the file atomically, so a crash leaves the previous checkpoint
+type Store struct {
+	dir string
+}
+
+// DefaultDir is the checkpoint directory under the user's config directory
+func DefaultDir() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "uploader", "checkpoints"), nil
+}
+
+// Open returns the store in dir, creating it if needed
+func Open(dir string) (*Store, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &Store{dir: dir}, nil
+}
+
+// Dir is where the checkpoints are kept
+func (s *Store) Dir() string {
+	return s.dir
+}
+
+// Load returns the checkpoint of an upload, false if there is none
+func (s *Store) Load(provider providers.Provider, bucket, key string) (providers.Checkpoint, bool, error) {
+	cp, err := read(s.path(provider, bucket, key))
+	if errors.Is(err, os.ErrNotExist) {
+		return providers.Checkpoint{}, false, nil
+	}
+	if err != nil {
+		return providers.Checkpoint{}, false, err
+	}
+	return cp, true, nil
+}
+
+// Save writes cp, replacing the upload's previous checkpoint
+func (s *Store) Save(cp providers.Checkpoint) error {
+	now := time.Now().UTC()
+	if cp.Created.IsZero() {
+		cp.Created = now
+	}
+	cp.Updated = now
+	b, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(s.dir, ".checkpoint-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err = tmp.Write(b); err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path(cp.Provider, cp.Bucket, cp.Key))
+}
+
+// Delete removes an upload's checkpoint, a missing one isn't an error
+func (s *Store) Delete(cp providers.Checkpoint) error {
+	err := os.Remove(s.path(cp.Provider, cp.Bucket, cp.Key))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// List returns every checkpoint, least recently updated first. Unreadable files are skipped
+func (s *Store) List() ([]providers.Checkpoint, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	var cps []providers.Checkpoint
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ".json") {
+			continue
+		}
+		cp, err := read(filepath.Join(s.dir, e.Name()))
+		if err != nil {
+			continue
+		}
+		cps = append(cps, cp)
+	}
+	sort.Slice(cps, func(i, j int) bool { return cps[i].Updated.Before(cps[j].Updated) })
+	return cps, nil
+}
+
+// Cleaned is a stale checkpoint removed by Clean, Err is set when its parts couldn't be discarded
+type Cleaned struct {
+	Checkpoint providers.Checkpoint
+	Err        error
+}
+
+// Clean aborts the uploads of checkpoints not updated for maxAge, discarding their orphaned
+// parts, and removes them. Checkpoints of providers missing from uploaders are left alone, as
+// are those whose abort failed so a later run tries again
+func (s *Store) Clean(ctx context.Context, uploaders []providers.Uploader, maxAge time.Duration) ([]Cleaned, error) {
+	cps, err := s.List()
+	if err != nil {
+		return nil, err
+	}
+	byName := map[providers.Provider]providers.Uploader{}
+	for _, u := range uploaders {
+		byName[u.GetName()] = u
+	}
+	var cleaned []Cleaned
+	for _, cp := range cps {
+		if time.Since(cp.Updated) < maxAge {
+			continue
+		}
+		u, ok := byName[cp.Provider]
+		if !ok {
+			continue
+		}
+		c := Cleaned{Checkpoint: cp}
+		if ru, ok := u.(providers.ResumableUploader); ok {
+			c.Err = ru.AbortResumable(ctx, cp)
+		}
+		if c.Err == nil {
+			c.Err = s.Delete(cp)
+		}
+		cleaned = append(cleaned, c)
+	}
+	return cleaned, nil
+}
+
+func (s *Store) path(provider providers.Provider, bucket, key string) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s\x00%s\x00%s", provider, bucket, key)))
+	return filepath.Join(s.dir, hex.EncodeToString(sum[:])+".json")
+}
+
+func read(path string) (providers.Checkpoint, error) {
+	var cp providers.Checkpoint
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return cp, err
+	}
+	if err = json.Unmarshal(b, &cp); err != nil {
+		return cp, fmt.Errorf("checkpoint %s: %w", path, err)
+	}
+	return cp, nil
+}
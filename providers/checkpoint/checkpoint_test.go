@@ -0,0 +1,102 @@
+package checkpoint
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/stevequadros/uploader/providers"
+	"github.com/stretchr/testify/require"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// abortUploader records the checkpoints it was asked to abort
+type abortUploader struct {
+	name    providers.Provider
+	err     error
+	aborted []string
+}
+
+var _ providers.ResumableUploader = (*abortUploader)(nil)
+
+func (u *abortUploader) GetName() providers.Provider { return u.name }
+func (u *abortUploader) Upload(ctx context.Context, bucket, key string, r io.ReadSeekCloser) error {
+	return nil
+}
+func (u *abortUploader) UploadResumable(ctx context.Context, bucket, key string, r io.ReaderAt, size int64, opts providers.UploadOptions, cp *providers.Checkpoint, save func(providers.Checkpoint) error) error {
+	return nil
+}
+func (u *abortUploader) AbortResumable(ctx context.Context, cp providers.Checkpoint) error {
+	if u.err != nil {
+		return u.err
+	}
+	u.aborted = append(u.aborted, cp.Key)
+	return nil
+}
+
+func TestStore(t *testing.T) {
+	s, err := Open(filepath.Join(t.TempDir(), "checkpoints"))
+	require.NoError(t, err)
+
+	_, found, err := s.Load(providers.AWS, "b", "k")
+	require.NoError(t, err)
+	require.False(t, found)
+
+	cp := providers.Checkpoint{Provider: providers.AWS, Bucket: "b", Key: "k", UploadID: "id", Parts: []providers.Part{{Number: 1, ID: "etag", Size: 5}}}
+	require.NoError(t, s.Save(cp))
+	cp.Parts = append(cp.Parts, providers.Part{Number: 2, ID: "etag2", Size: 3})
+	require.NoError(t, s.Save(cp))
+	require.NoError(t, s.Save(providers.Checkpoint{Provider: providers.GCP, Bucket: "b", Key: "k", Offset: 7}))
+
+	loaded, found, err := s.Load(providers.AWS, "b", "k")
+	require.NoError(t, err)
+	require.True(t, found)
+	require.Equal(t, int64(8), loaded.Uploaded())
+	require.False(t, loaded.Updated.IsZero())
+
+	all, err := s.List()
+	require.NoError(t, err)
+	require.Len(t, all, 2, "a checkpoint per provider, bucket and key")
+
+	require.NoError(t, s.Delete(cp))
+	require.NoError(t, s.Delete(cp), "deleting twice is fine")
+	all, err = s.List()
+	require.NoError(t, err)
+	require.Len(t, all, 1)
+}
+
+func TestClean(t *testing.T) {
+	s, err := Open(t.TempDir())
+	require.NoError(t, err)
+	require.NoError(t, s.Save(providers.Checkpoint{Provider: providers.AWS, Bucket: "b", Key: "fresh"}))
+	// Save stamps the current time, stale checkpoints are written as a run days ago would have
+	old := time.Now().Add(-2 * DefaultMaxAge)
+	for _, cp := range []providers.Checkpoint{
+		{Provider: providers.AWS, Bucket: "b", Key: "stale"},
+		{Provider: providers.GCP, Bucket: "b", Key: "unconfigured"},
+		{Provider: providers.Azure, Bucket: "b", Key: "failing"},
+	} {
+		cp.Updated = old
+		b, err := json.Marshal(cp)
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(s.path(cp.Provider, cp.Bucket, cp.Key), b, 0600))
+	}
+
+	aws := &abortUploader{name: providers.AWS}
+	azure := &abortUploader{name: providers.Azure, err: errors.New("forbidden")}
+	cleaned, err := s.Clean(context.Background(), []providers.Uploader{aws, azure}, DefaultMaxAge)
+	require.NoError(t, err)
+	require.Len(t, cleaned, 2)
+	require.Equal(t, []string{"stale"}, aws.aborted)
+
+	left, err := s.List()
+	require.NoError(t, err)
+	var keys []string
+	for _, cp := range left {
+		keys = append(keys, cp.Key)
+	}
+	require.ElementsMatch(t, []string{"fresh", "unconfigured", "failing"}, keys, "unconfigured providers and failed aborts are kept")
+}
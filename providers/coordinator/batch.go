@@ -0,0 +1,398 @@
+package coordinator
+
+import (
+	"context"
+	"errors"
+	"github.com/stevequadros/uploader/providers"
+	"go.opentelemetry.io/otel/attribute"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// DefaultWorkers is how many uploads DoBatch runs at once when no worker count is given
+const DefaultWorkers = 8
+
+// BatchFile is a local file to upload to Key, or to the key Keys has for a provider
+type BatchFile struct {
+	Path string
+	Key  string
+	Keys ProviderKeys
+	// SHA256 is the file's hex digest when known, WithSkipExisting compares it to stored metadata
+	SHA256 string
+}
+
+// KeyFor is the key f is uploaded to on provider p
+func (f BatchFile) KeyFor(p providers.Provider) string {
+	if key, ok := f.Keys[p]; ok {
+		return key
+	}
+	return f.Key
+}
+
+type FileResult struct {
+	Path string
+	Key  string
+	Size int64
+	Done []providers.Provider
+	// Skipped already held the file, they count as successes
+	Skipped []providers.Provider
+	Failed  []DoError
+	// Deferred were cut short by WithSoftDeadline, they are neither done nor failed
+	Deferred []providers.Provider
+	Duration time.Duration
+	// Checksums, Verified and Versions are set WithVerify, as for DoResult
+	Checksums providers.Checksums
+	Verified  map[providers.Provider]providers.Checksums
+	Versions  map[providers.Provider]string
+	// Resumed holds how many bytes each provider already had from an earlier run, WithCheckpoints
+	Resumed map[providers.Provider]int64
+}
+
+// OK reports whether the file reached every provider
+func (r FileResult) OK() bool {
+	return len(r.Failed) == 0
+}
+
+type BatchResult struct {
+	// Files are in the order they were given to DoBatch
+	Files []FileResult
+	// Breakers is the state of the circuit breakers once the batch ended, WithCircuitBreaker
+	Breakers []BreakerStats
+}
+
+// Failed counts the files that failed on at least one provider
+func (r BatchResult) Failed() int {
+	var n int
+	for _, f := range r.Files {
+		if !f.OK() {
+			n++
+		}
+	}
+	return n
+}
+
+// NotStarted counts the files that reached no provider because DoBatch was cancelled first
+func (r BatchResult) NotStarted() int {
+	var n int
+	for _, f := range r.Files {
+		if len(f.Done) > 0 || len(f.Skipped) > 0 || len(f.Failed) == 0 {
+			continue
+		}
+		started := false
+		for _, e := range f.Failed {
+			started = started || !errors.Is(e.Error, ErrNotStarted)
+		}
+		if !started {
+			n++
+		}
+	}
+	return n
+}
+
+// Deferred counts the uploads WithSoftDeadline left to the repair journal
+func (r BatchResult) Deferred() int {
+	var n int
+	for _, f := range r.Files {
+		n += len(f.Deferred)
+	}
+	return n
+}
+
+// Bytes sums the size of the files that reached every provider, skipped uploads included
+func (r BatchResult) Bytes() int64 {
+	var n int64
+	for _, f := range r.Files {
+		if f.OK() {
+			n += f.Size
+		}
+	}
+	return n
+}
+
+// SkippedBytes sums the size of every upload skipped because the provider held the file
+func (r BatchResult) SkippedBytes() int64 {
+	var n int64
+	for _, f := range r.Files {
+		n += f.Size * int64(len(f.Skipped))
+	}
+	return n
+}
+
+// ProviderCounts tallies done, skipped and failed uploads per provider
+func (r BatchResult) ProviderCounts() (done, skipped, failed map[providers.Provider]int) {
+	done, skipped, failed = map[providers.Provider]int{}, map[providers.Provider]int{}, map[providers.Provider]int{}
+	for _, f := range r.Files {
+		for _, p := range f.Done {
+			done[p]++
+		}
+		for _, p := range f.Skipped {
+			skipped[p]++
+		}
+		for _, e := range f.Failed {
+			failed[e.Provider]++
+		}
+	}
+	return done, skipped, failed
+}
+
+// DoBatch uploads every file to every uploader, running at most workers uploads at a time
+// across all files and providers. Each upload opens its own handle on the file so uploads
+// never share an offset. Failures are recorded per file and provider, they don't stop the batch
+func (c *Coordinator) DoBatch(ctx context.Context, bucket string, files []BatchFile, workers int) BatchResult {
+	ctx, span := c.startSpan(ctx, "coordinator.DoBatch", attribute.String("bucket", bucket), attribute.Int("files", len(files)))
+	res := c.doBatch(ctx, bucket, files, workers)
+	endBatch(span, res)
+	return res
+}
+
+func (c *Coordinator) doBatch(ctx context.Context, bucket string, files []BatchFile, workers int) BatchResult {
+	if workers <= 0 {
+		workers = DefaultWorkers
+	}
+
+	type job struct {
+		file     int
+		uploader providers.Uploader
+	}
+	jobs := make(chan job)
+	result := BatchResult{Files: make([]FileResult, len(files))}
+	started := make([]time.Time, len(files))
+	hedges := make([]*hedge, len(files))
+	remaining := make([]int, len(files))
+	sums := make([]*fileChecksums, len(files))
+	for i, f := range files {
+		result.Files[i] = FileResult{Path: f.Path, Key: f.Key}
+		remaining[i] = len(c.uploaders)
+		if c.verify {
+			sums[i] = &fileChecksums{}
+			result.Files[i].Verified = map[providers.Provider]providers.Checksums{}
+			result.Files[i].Versions = map[providers.Provider]string{}
+		}
+	}
+
+	uploadCtx, cancel := c.inFlight(ctx)
+	defer cancel()
+	mu := sync.Mutex{}
+	wg := sync.WaitGroup{}
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				p := j.uploader.GetName()
+				mu.Lock()
+				if started[j.file].IsZero() {
+					started[j.file] = time.Now()
+					hedges[j.file] = c.newHedge()
+				}
+				h := hedges[j.file]
+				mu.Unlock()
+
+				var out fileOutcome
+				var deferred bool
+				if ctx.Err() != nil {
+					out.err = ErrNotStarted
+				} else if hctx, ok := h.start(uploadCtx, p); !ok {
+					deferred = true
+				} else if out.err = c.allow(c.breakers[p]); out.err != nil {
+					h.finish(p, out.err)
+				} else {
+					out = c.uploadFile(hctx, j.uploader, bucket, files[j.file], sums[j.file])
+					c.record(c.breakers[p], out.err, hctx.Err() != nil)
+					deferred = h.finish(p, out.err)
+				}
+				if c.observed() {
+					f := files[j.file]
+					ev := Event{Provider: p, Bucket: bucket, Path: f.Path, Key: f.KeyFor(p), Size: out.size, Attempt: out.attempts}
+					if ev.Size == 0 {
+						// never opened, the size still takes it out of the expected total
+						ev.Size = statSize(f.Path)
+					}
+					c.emitResult(ev, deferred, out.skipped, out.err)
+				}
+
+				mu.Lock()
+				res := &result.Files[j.file]
+				if out.size > 0 {
+					res.Size = out.size
+				}
+				if out.resumed > 0 {
+					if res.Resumed == nil {
+						res.Resumed = map[providers.Provider]int64{}
+					}
+					res.Resumed[p] = out.resumed
+				}
+				switch {
+				case deferred:
+					res.Deferred = append(res.Deferred, p)
+				case out.err != nil:
+					res.Failed = append(res.Failed, DoError{p, out.err})
+				case out.skipped:
+					res.Skipped = append(res.Skipped, p)
+				default:
+					res.Done = append(res.Done, p)
+					if c.verify {
+						res.Verified[p] = out.verified
+						if out.version != "" {
+							res.Versions[p] = out.version
+						}
+					}
+				}
+				if remaining[j.file]--; remaining[j.file] == 0 {
+					res.Duration = time.Since(started[j.file])
+					h.stop()
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	// file by file so early files finish first instead of every file progressing together
+	for i := range files {
+		for _, u := range c.uploaders {
+			jobs <- job{i, u}
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	for i, s := range sums {
+		if s != nil {
+			result.Files[i].Checksums = s.sums
+		}
+	}
+	result.Breakers = c.Breakers()
+	return result
+}
+
+// fileChecksums computes a file's checksums once for every provider it is uploaded to
+type fileChecksums struct {
+	once sync.Once
+	sums providers.Checksums
+	err  error
+}
+
+func (f *fileChecksums) get(file *os.File) (providers.Checksums, error) {
+	f.once.Do(func() {
+		f.sums, _, f.err = readerChecksums(file)
+	})
+	return f.sums, f.err
+}
+
+// statSize is the size of the file at path, 0 when it can't be stat'd
+func statSize(path string) int64 {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0
+	}
+	return info.Size()
+}
+
+// fileOutcome is how uploading one file to one provider went
+type fileOutcome struct {
+	size     int64
+	skipped  bool
+	verified providers.Checksums
+	version  string
+	// resumed is how many bytes an earlier run had already uploaded
+	resumed  int64
+	attempts int
+	err      error
+}
+
+// uploadFile uploads f to u within u's timeouts, sums is only given WithVerify
+func (c *Coordinator) uploadFile(ctx context.Context, u providers.Uploader, bucket string, f BatchFile, sums *fileChecksums) (out fileOutcome) {
+	if out.err = ctx.Err(); out.err != nil {
+		return out
+	}
+	file, err := os.Open(f.Path)
+	if err != nil {
+		out.err = err
+		return out
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		out.err = err
+		return out
+	}
+	out.size = info.Size()
+
+	var opts providers.UploadOptions
+	if sums != nil {
+		if opts.Checksums, out.err = sums.get(file); out.err != nil {
+			return out
+		}
+		if f.SHA256 == "" {
+			f.SHA256 = opts.Checksums.SHA256
+		}
+	}
+	if f.SHA256 != "" {
+		// stored so a later run can tell the object apart from a different file of the same size
+		opts.Metadata = map[string]string{providers.MetadataSHA256: f.SHA256}
+	}
+
+	// after checksumming, which reads the whole file before any provider is contacted
+	l := c.limit(ctx, u)
+	defer func() {
+		out.err = l.done(out.err)
+		out.attempts = l.progress.attempts()
+	}()
+	key := f.KeyFor(u.GetName())
+	c.track(l, Event{Provider: u.GetName(), Bucket: bucket, Path: f.Path, Key: key, Size: out.size})
+	ctx = l.ctx
+	if c.skipExisting && alreadyStored(ctx, u, bucket, key, out.size, f.SHA256) {
+		out.skipped = true
+		return out
+	}
+	ru, resumable := u.(providers.ResumableUploader)
+	if resumable && c.checkpoints != nil && out.size >= c.resumeThreshold {
+		out.resumed, out.err = c.uploadResumable(ctx, ru, bucket, key, file, l.readerAt(file), info, opts)
+	} else {
+		out.err = providers.UploadWithOptions(ctx, u, bucket, key, l.reader(file), opts)
+	}
+	if out.err != nil || sums == nil {
+		return out
+	}
+	out.verified, out.version, out.err = verifyUpload(ctx, u, bucket, key, opts.Checksums, out.size)
+	return out
+}
+
+// uploadResumable uploads file, read through r, from its checkpoint, if any, and returns how
+// many bytes the checkpoint said were already uploaded. The checkpoint is kept when the upload
+// fails so the next run resumes it, and removed once it succeeds
+func (c *Coordinator) uploadResumable(ctx context.Context, u providers.ResumableUploader, bucket, key string, file *os.File, r io.ReaderAt, info os.FileInfo, opts providers.UploadOptions) (int64, error) {
+	cp, found, err := c.checkpoints.Load(u.GetName(), bucket, key)
+	if err != nil {
+		return 0, err
+	}
+	if found && (cp.Path != file.Name() || cp.Size != info.Size() || !cp.ModTime.Equal(info.ModTime())) {
+		// the parts stored belong to another file or an older version of this one
+		if err = u.AbortResumable(ctx, cp); err != nil {
+			return 0, err
+		}
+		if err = c.checkpoints.Delete(cp); err != nil {
+			return 0, err
+		}
+		found = false
+	}
+	if !found {
+		cp = providers.Checkpoint{
+			Provider: u.GetName(),
+			Bucket:   bucket,
+			Key:      key,
+			Path:     file.Name(),
+			Size:     info.Size(),
+			ModTime:  info.ModTime(),
+			Created:  time.Now().UTC(),
+		}
+	}
+	resumed := cp.Uploaded()
+	if err = u.UploadResumable(ctx, bucket, key, r, info.Size(), opts, &cp, c.checkpoints.Save); err != nil {
+		return resumed, err
+	}
+	// the object is stored, a checkpoint left behind only costs the next run a restarted upload
+	_ = c.checkpoints.Delete(cp)
+	return resumed, nil
+}
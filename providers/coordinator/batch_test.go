@@ -0,0 +1,253 @@
+package coordinator
+
+import (
+	"context"
+	"errors"
+	"github.com/stevequadros/uploader/providers"
+	"github.com/stevequadros/uploader/providers/checkpoint"
+	"github.com/stretchr/testify/require"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// storeUploader keeps uploaded content by key and tracks how many uploads run at once
+type storeUploader struct {
+	name    providers.Provider
+	fail    map[string]bool
+	mu      sync.Mutex
+	objects map[string]string
+	running *int
+	peak    *int
+	shared  *sync.Mutex
+}
+
+func (u *storeUploader) GetName() providers.Provider { return u.name }
+func (u *storeUploader) Upload(ctx context.Context, bucket, key string, r io.ReadSeekCloser) error {
+	u.shared.Lock()
+	*u.running++
+	if *u.running > *u.peak {
+		*u.peak = *u.running
+	}
+	u.shared.Unlock()
+	defer func() {
+		u.shared.Lock()
+		*u.running--
+		u.shared.Unlock()
+	}()
+
+	if u.fail[key] {
+		return io.ErrUnexpectedEOF
+	}
+	b, err := io.ReadAll(r)
+	u.mu.Lock()
+	u.objects[key] = string(b)
+	u.mu.Unlock()
+	return err
+}
+
+func TestCoordinator_DoBatch(t *testing.T) {
+	dir := t.TempDir()
+	var batch []BatchFile
+	for _, name := range []string{"a", "b", "c", "d", "e"} {
+		p := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(p, []byte("content "+name), 0644))
+		batch = append(batch, BatchFile{Path: p, Key: "k/" + name})
+	}
+	batch = append(batch, BatchFile{Path: filepath.Join(dir, "missing"), Key: "k/missing"})
+
+	var running, peak int
+	shared := &sync.Mutex{}
+	one := &storeUploader{name: "1", objects: map[string]string{}, running: &running, peak: &peak, shared: shared}
+	two := &storeUploader{name: "2", fail: map[string]bool{"k/b": true}, objects: map[string]string{}, running: &running, peak: &peak, shared: shared}
+	c := &Coordinator{uploaders: []providers.Uploader{one, two}}
+
+	res := c.DoBatch(context.Background(), "bucket", batch, 2)
+	require.Len(t, res.Files, len(batch))
+	require.LessOrEqual(t, peak, 2)
+
+	for _, f := range res.Files[:5] {
+		require.Equal(t, "content "+filepath.Base(f.Path), one.objects[f.Key])
+	}
+	require.True(t, res.Files[0].OK())
+	require.False(t, res.Files[1].OK(), "b fails on provider 2")
+	require.Equal(t, []providers.Provider{"1"}, res.Files[1].Done)
+	require.False(t, res.Files[5].OK(), "a missing file fails everywhere")
+	require.Len(t, res.Files[5].Failed, 2)
+	require.Equal(t, 2, res.Failed())
+	require.Equal(t, int64(4*len("content a")), res.Bytes())
+
+	done, _, failed := res.ProviderCounts()
+	require.Equal(t, map[providers.Provider]int{"1": 5, "2": 4}, done)
+	require.Equal(t, map[providers.Provider]int{"1": 1, "2": 2}, failed)
+}
+
+func TestCoordinator_DoBatchSkipExisting(t *testing.T) {
+	dir := t.TempDir()
+	p := filepath.Join(dir, "a")
+	require.NoError(t, os.WriteFile(p, []byte("content"), 0644))
+
+	stored := &statUploader{objects: map[string]providers.ObjectInfo{
+		"same":     {Size: 7, Metadata: map[string]string{providers.MetadataSHA256: "ABC"}},
+		"no-sum":   {Size: 7},
+		"size":     {Size: 3},
+		"checksum": {Size: 7, Metadata: map[string]string{providers.MetadataSHA256: "def"}},
+	}}
+	c, _ := NewCoordinator([]providers.Uploader{stored}, WithSkipExisting())
+
+	var batch []BatchFile
+	for _, key := range []string{"same", "no-sum", "size", "checksum", "missing"} {
+		batch = append(batch, BatchFile{Path: p, Key: key, SHA256: "abc"})
+	}
+	res := c.DoBatch(context.Background(), "bucket", batch, 2)
+	require.Equal(t, 0, res.Failed())
+	for i, skip := range []bool{true, true, false, false, false} {
+		require.Equal(t, skip, len(res.Files[i].Skipped) == 1, res.Files[i].Key)
+		require.True(t, res.Files[i].OK())
+	}
+	require.ElementsMatch(t, []string{"size", "checksum", "missing"}, stored.uploads)
+	require.Equal(t, "abc", stored.metadata["missing"][providers.MetadataSHA256])
+	require.Equal(t, int64(14), res.SkippedBytes())
+
+	done, skipped, _ := res.ProviderCounts()
+	require.Equal(t, 3, done["stat"])
+	require.Equal(t, 2, skipped["stat"])
+}
+
+func TestCoordinator_DoBatchVerify(t *testing.T) {
+	dir := t.TempDir()
+	var batch []BatchFile
+	for _, name := range []string{"a", "b"} {
+		p := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(p, []byte("content "+name), 0644))
+		batch = append(batch, BatchFile{Path: p, Key: name})
+	}
+	good, bad := &checksumStore{name: "good"}, &checksumStore{name: "bad", corrupt: true}
+	c, _ := NewCoordinator([]providers.Uploader{good, bad}, WithVerify())
+
+	res := c.DoBatch(context.Background(), "bucket", batch, 4)
+	require.Equal(t, 2, res.Failed())
+	for _, f := range res.Files {
+		require.NotEmpty(t, f.Checksums.SHA256)
+		require.Equal(t, []providers.Provider{"good"}, f.Done)
+		require.Equal(t, f.Checksums.MD5, f.Verified["good"].MD5)
+		require.NotContains(t, f.Verified, providers.Provider("bad"))
+		require.True(t, errors.Is(f.Failed[0].Error, providers.ErrChecksumMismatch))
+	}
+}
+
+// partUploader uploads in parts of partSize bytes, failing once failAfter parts were sent by a run
+type partUploader struct {
+	parts     map[int]string
+	failAfter int
+	sent      int
+	aborted   int
+	object    string
+}
+
+var _ providers.ResumableUploader = (*partUploader)(nil)
+
+func (u *partUploader) GetName() providers.Provider { return "parts" }
+func (u *partUploader) Upload(ctx context.Context, bucket, key string, r io.ReadSeekCloser) error {
+	return errors.New("only resumable uploads")
+}
+func (u *partUploader) UploadResumable(ctx context.Context, bucket, key string, r io.ReaderAt, size int64, opts providers.UploadOptions, cp *providers.Checkpoint, save func(providers.Checkpoint) error) error {
+	const partSize = 4
+	if cp.UploadID == "" {
+		cp.UploadID, cp.PartSize, u.parts = "upload", partSize, map[int]string{}
+	}
+	u.sent = 0
+	var object string
+	for n, off := 1, int64(0); off < size; n, off = n+1, off+partSize {
+		if _, ok := u.parts[n]; !ok {
+			if u.sent == u.failAfter {
+				return io.ErrUnexpectedEOF
+			}
+			b := make([]byte, partSize)
+			read, _ := r.ReadAt(b, off)
+			u.parts[n] = string(b[:read])
+			u.sent++
+			cp.Parts = append(cp.Parts, providers.Part{Number: n, Size: int64(read)})
+			if err := save(*cp); err != nil {
+				return err
+			}
+		}
+		object += u.parts[n]
+	}
+	u.object = object
+	return nil
+}
+func (u *partUploader) AbortResumable(ctx context.Context, cp providers.Checkpoint) error {
+	u.aborted++
+	u.parts = nil
+	return nil
+}
+
+func TestCoordinator_DoBatchResume(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "big")
+	require.NoError(t, os.WriteFile(path, []byte("0123456789abcdef"), 0644))
+	store, err := checkpoint.Open(filepath.Join(dir, "checkpoints"))
+	require.NoError(t, err)
+
+	u := &partUploader{failAfter: 2}
+	c, _ := NewCoordinator([]providers.Uploader{u}, WithCheckpoints(store, 8))
+	batch := []BatchFile{{Path: path, Key: "big"}}
+
+	res := c.DoBatch(context.Background(), "bucket", batch, 1)
+	require.False(t, res.Files[0].OK())
+	cp, found, err := store.Load("parts", "bucket", "big")
+	require.NoError(t, err)
+	require.True(t, found, "the failed upload left a checkpoint")
+	require.Equal(t, int64(8), cp.Uploaded())
+
+	u.failAfter = -1
+	res = c.DoBatch(context.Background(), "bucket", batch, 1)
+	require.True(t, res.Files[0].OK())
+	require.Equal(t, 2, u.sent, "only the missing parts are sent")
+	require.Equal(t, map[providers.Provider]int64{"parts": 8}, res.Files[0].Resumed)
+	require.Equal(t, "0123456789abcdef", u.object)
+	_, found, err = store.Load("parts", "bucket", "big")
+	require.NoError(t, err)
+	require.False(t, found, "the checkpoint is removed once the upload completes")
+
+	// a file that changed since its checkpoint is uploaded from the start
+	u.failAfter = 1
+	require.False(t, c.DoBatch(context.Background(), "bucket", batch, 1).Files[0].OK())
+	require.NoError(t, os.WriteFile(path, []byte("fedcba9876543210"), 0644))
+	later := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(path, later, later))
+	u.failAfter = -1
+	res = c.DoBatch(context.Background(), "bucket", batch, 1)
+	require.True(t, res.Files[0].OK())
+	require.Equal(t, 1, u.aborted)
+	require.Empty(t, res.Files[0].Resumed)
+	require.Equal(t, "fedcba9876543210", u.object)
+}
+
+func TestCoordinator_DoBatchInterrupted(t *testing.T) {
+	dir := t.TempDir()
+	var batch []BatchFile
+	for _, name := range []string{"a", "b", "c"} {
+		p := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(p, []byte(name), 0644))
+		batch = append(batch, BatchFile{Path: p, Key: name})
+	}
+	u := &blockingUploader{name: "slow", started: make(chan struct{}, 1), release: make(chan struct{})}
+	c, _ := NewCoordinator([]providers.Uploader{u}, WithGracePeriod(time.Minute))
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-u.started
+		cancel()
+		close(u.release)
+	}()
+
+	// one worker, so the first file is in flight when the batch is interrupted
+	res := c.DoBatch(ctx, "bucket", batch, 1)
+	require.True(t, res.Files[0].OK(), "the upload in flight finishes within the grace period")
+	require.Equal(t, 2, res.NotStarted())
+	require.ErrorIs(t, res.Files[2].Failed[0].Error, ErrNotStarted)
+}
@@ -0,0 +1,210 @@
+package coordinator
+
+import (
+	"errors"
+	"fmt"
+	"github.com/stevequadros/uploader/providers"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is the error of uploads failed without trying because their provider's circuit
+// breaker is open
+var ErrCircuitOpen = errors.New("circuit breaker open")
+
+const (
+	// DefaultBreakerWindow is how many of the latest uploads the error rate is measured over
+	DefaultBreakerWindow = 20
+	// DefaultBreakerCooldown is how long a breaker stays open before letting a probe through
+	DefaultBreakerCooldown = 30 * time.Second
+)
+
+// BreakerSettings configure the circuit breaker kept for every provider
+type BreakerSettings struct {
+	// Failures trips the breaker after this many consecutive failures, 0 to not trip on them
+	Failures int
+	// ErrorRate trips the breaker once this fraction of the latest Window uploads failed, 0 to
+	// not trip on it
+	ErrorRate float64
+	Window    int
+	// Cooldown is how long the breaker stays open before one upload is let through as a probe
+	Cooldown time.Duration
+}
+
+func (s BreakerSettings) enabled() bool {
+	return s.Failures > 0 || s.ErrorRate > 0
+}
+
+type BreakerState int
+
+const (
+	// BreakerClosed lets every upload through
+	BreakerClosed BreakerState = iota
+	// BreakerOpen fails uploads at once until the cooldown ends
+	BreakerOpen
+	// BreakerHalfOpen lets one probe through, closing the breaker if it succeeds and opening it
+	// again if it fails
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+// BreakerStats is the state of one provider's breaker
+type BreakerStats struct {
+	Provider providers.Provider
+	State    BreakerState
+	// Trips counts how many times the breaker opened, Rejected the uploads it failed at once
+	Trips    int
+	Rejected int
+	// LastError is the failure that last tripped the breaker
+	LastError error
+}
+
+// breaker is a provider's circuit breaker, a nil breaker lets everything through
+type breaker struct {
+	settings BreakerSettings
+
+	mu          sync.Mutex
+	stats       BreakerStats
+	consecutive int
+	// outcomes is a ring of the latest results, true for a failure
+	outcomes []bool
+	next     int
+	openedAt time.Time
+	probing  bool
+}
+
+func newBreaker(p providers.Provider, settings BreakerSettings) *breaker {
+	if settings.Window <= 0 {
+		settings.Window = DefaultBreakerWindow
+	}
+	if settings.Cooldown <= 0 {
+		settings.Cooldown = DefaultBreakerCooldown
+	}
+	return &breaker{settings: settings, stats: BreakerStats{Provider: p}}
+}
+
+// allow returns ErrCircuitOpen when the upload must fail at once. Once the cooldown ended a
+// single upload is let through, the others fail until it finishes
+func (b *breaker) allow() error {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.stats.State {
+	case BreakerClosed:
+		return nil
+	case BreakerOpen:
+		if time.Since(b.openedAt) >= b.settings.Cooldown {
+			b.stats.State, b.probing = BreakerHalfOpen, true
+			return nil
+		}
+	case BreakerHalfOpen:
+		if !b.probing {
+			// the last probe was cancelled before it told anything, this upload probes instead
+			b.probing = true
+			return nil
+		}
+	}
+	b.stats.Rejected++
+	return fmt.Errorf("%w after %v", ErrCircuitOpen, b.stats.LastError)
+}
+
+// record counts the outcome of an upload allow let through and reports whether it tripped the
+// breaker. Uploads cancelled before they could tell anything about the provider are not counted,
+// and errors of the object rather than the provider, ex: an invalid key, count as successes
+func (b *breaker) record(err error, cancelled bool) bool {
+	if b == nil {
+		return false
+	}
+	switch providers.ClassOf(err) {
+	case providers.ClassNotFound, providers.ClassConflict, providers.ClassInvalidInput:
+		err = nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.stats.State == BreakerHalfOpen {
+		if !b.probing {
+			// let through before the breaker opened, the probe decides
+			return false
+		}
+		b.probing = false
+		switch {
+		case cancelled:
+			// stays half open, the next upload probes
+		case err != nil:
+			b.trip(err)
+			return true
+		default:
+			b.reset()
+		}
+		return false
+	}
+	if cancelled || b.stats.State == BreakerOpen {
+		return false
+	}
+
+	if len(b.outcomes) < b.settings.Window {
+		b.outcomes = append(b.outcomes, err != nil)
+	} else {
+		b.outcomes[b.next] = err != nil
+		b.next = (b.next + 1) % b.settings.Window
+	}
+	if err == nil {
+		b.consecutive = 0
+		return false
+	}
+	b.consecutive++
+	if b.settings.Failures > 0 && b.consecutive >= b.settings.Failures ||
+		b.settings.ErrorRate > 0 && len(b.outcomes) == b.settings.Window && b.errorRate() >= b.settings.ErrorRate {
+		b.trip(err)
+		return true
+	}
+	return false
+}
+
+// record records an upload's outcome on b, logging and counting b tripping
+func (c *Coordinator) record(b *breaker, err error, cancelled bool) {
+	tripped := b.record(err, cancelled)
+	if tripped {
+		c.log.Warn("circuit breaker open, failing uploads at once", "provider", b.stats.Provider, "cooldown", b.settings.Cooldown, "error", err)
+	}
+	c.metrics.breakerChanged(b, tripped)
+}
+
+func (b *breaker) errorRate() float64 {
+	var failed int
+	for _, f := range b.outcomes {
+		if f {
+			failed++
+		}
+	}
+	return float64(failed) / float64(len(b.outcomes))
+}
+
+func (b *breaker) trip(err error) {
+	b.stats.State, b.stats.LastError = BreakerOpen, err
+	b.stats.Trips++
+	b.openedAt = time.Now()
+}
+
+func (b *breaker) reset() {
+	b.stats.State = BreakerClosed
+	b.consecutive, b.outcomes, b.next = 0, nil, 0
+}
+
+func (b *breaker) snapshot() BreakerStats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.stats
+}
@@ -0,0 +1,137 @@
+package coordinator
+
+import (
+	"context"
+	"errors"
+	"github.com/stevequadros/uploader/providers"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestBreaker(t *testing.T) {
+	failure := errors.New("503")
+	for _, tt := range []struct {
+		name     string
+		settings BreakerSettings
+		outcomes []error
+		want     BreakerState
+	}{
+		{
+			name:     "trips on consecutive failures",
+			settings: BreakerSettings{Failures: 3},
+			outcomes: []error{failure, failure, failure},
+			want:     BreakerOpen,
+		},
+		{
+			name:     "a success resets the run",
+			settings: BreakerSettings{Failures: 3},
+			outcomes: []error{failure, failure, nil, failure, failure},
+			want:     BreakerClosed,
+		},
+		{
+			name:     "trips on the error rate once the window is full",
+			settings: BreakerSettings{ErrorRate: 0.5, Window: 4},
+			outcomes: []error{nil, failure, nil, failure},
+			want:     BreakerOpen,
+		},
+		{
+			name:     "errors of the object don't count",
+			settings: BreakerSettings{Failures: 2},
+			outcomes: []error{failure, &providers.Error{Class: providers.ClassInvalidInput, Err: failure}, failure},
+			want:     BreakerClosed,
+		},
+		{
+			name:     "throttling counts",
+			settings: BreakerSettings{Failures: 2},
+			outcomes: []error{failure, &providers.Error{Class: providers.ClassThrottled, Err: failure}},
+			want:     BreakerOpen,
+		},
+		{
+			name:     "error rate below the threshold",
+			settings: BreakerSettings{ErrorRate: 0.5, Window: 4},
+			outcomes: []error{failure, nil, nil, nil, failure, nil},
+			want:     BreakerClosed,
+		},
+	} {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			b := newBreaker("p", tt.settings)
+			for _, err := range tt.outcomes {
+				require.NoError(t, b.allow())
+				b.record(err, false)
+			}
+			require.Equal(t, tt.want, b.snapshot().State)
+		})
+	}
+}
+
+func TestBreakerHalfOpen(t *testing.T) {
+	b := newBreaker("p", BreakerSettings{Failures: 1, Cooldown: 20 * time.Millisecond})
+	require.NoError(t, b.allow())
+	b.record(errors.New("503"), false)
+	require.ErrorIs(t, b.allow(), ErrCircuitOpen)
+
+	// one probe once the cooldown ended, the others still fail fast
+	time.Sleep(30 * time.Millisecond)
+	require.NoError(t, b.allow())
+	require.Equal(t, BreakerHalfOpen, b.snapshot().State)
+	require.ErrorIs(t, b.allow(), ErrCircuitOpen)
+	b.record(errors.New("503"), false)
+	require.Equal(t, BreakerOpen, b.snapshot().State)
+
+	time.Sleep(30 * time.Millisecond)
+	require.NoError(t, b.allow())
+	b.record(nil, false)
+	stats := b.snapshot()
+	require.Equal(t, BreakerClosed, stats.State)
+	require.Equal(t, 2, stats.Trips)
+	require.Equal(t, 2, stats.Rejected)
+	require.NoError(t, b.allow())
+}
+
+func TestBreakerHalfOpenCancelledProbe(t *testing.T) {
+	b := newBreaker("p", BreakerSettings{Failures: 1, Cooldown: time.Hour})
+	require.NoError(t, b.allow())
+	b.record(errors.New("503"), false)
+	b.openedAt = time.Now().Add(-2 * time.Hour)
+
+	require.NoError(t, b.allow())
+	b.record(context.Canceled, true)
+	require.Equal(t, BreakerHalfOpen, b.snapshot().State)
+
+	// the cancelled probe is replaced by the next upload, the others still fail fast
+	require.NoError(t, b.allow())
+	require.ErrorIs(t, b.allow(), ErrCircuitOpen)
+	b.record(errors.New("503"), false)
+	stats := b.snapshot()
+	require.Equal(t, BreakerOpen, stats.State)
+	require.Equal(t, 2, stats.Trips)
+	require.ErrorIs(t, b.allow(), ErrCircuitOpen, "a new cooldown started")
+}
+
+func TestCoordinator_DoBatchCircuitBreaker(t *testing.T) {
+	dir := t.TempDir()
+	var batch []BatchFile
+	for _, name := range []string{"a", "b", "c", "d", "e"} {
+		p := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(p, []byte(name), 0644))
+		batch = append(batch, BatchFile{Path: p, Key: name})
+	}
+	up, down := &testUploader{name: "up"}, &testUploader{name: "down", wantErr: true}
+	c, _ := NewCoordinator([]providers.Uploader{up, down}, WithCircuitBreaker(BreakerSettings{Failures: 2, Cooldown: time.Hour}))
+
+	// one worker so uploads run in order
+	res := c.DoBatch(context.Background(), "bucket", batch, 1)
+	for i, f := range res.Files {
+		require.Equal(t, []providers.Provider{"up"}, f.Done)
+		require.Len(t, f.Failed, 1)
+		require.Equal(t, i >= 2, errors.Is(f.Failed[0].Error, ErrCircuitOpen), "file %d", i)
+	}
+	require.Equal(t, []BreakerStats{
+		{Provider: "up", State: BreakerClosed},
+		{Provider: "down", State: BreakerOpen, Trips: 1, Rejected: 3, LastError: res.Files[1].Failed[0].Error},
+	}, res.Breakers)
+}
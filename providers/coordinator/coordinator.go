@@ -3,21 +3,222 @@ package coordinator
 import (
 	"context"
 	"errors"
+	"fmt"
 	"github.com/stevequadros/uploader/config"
 	"github.com/stevequadros/uploader/providers"
+	"github.com/stevequadros/uploader/providers/checkpoint"
+	"github.com/stevequadros/uploader/providers/logging"
+	"github.com/stevequadros/uploader/providers/ratelimit"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"io"
+	"strings"
 	"sync"
+	"time"
 )
 
 type Coordinator struct {
 	config    config.Config
 	uploaders []providers.Uploader
+	// streamChunkSize and streamChunks bound how much of a stream DoStream buffers per provider
+	streamChunkSize int
+	streamChunks    int
+	// skipExisting stats each provider before uploading and skips those already holding the object
+	skipExisting bool
+	// verify checksums the content and checks it against what each provider stored
+	verify bool
+	// checkpoints keeps the progress of DoBatch uploads of at least resumeThreshold bytes
+	checkpoints     *checkpoint.Store
+	resumeThreshold int64
+	// grace is how long uploads in flight may still run once the context is cancelled
+	grace time.Duration
+	// softDeadline is how long Do and DoBatch wait on an object once minSuccess providers have it
+	softDeadline time.Duration
+	minSuccess   int
+	// breakers hold the circuit breaker of every provider, nil without WithCircuitBreaker
+	breakerSettings BreakerSettings
+	breakers        map[providers.Provider]*breaker
+	// bandwidth limits the bytes read for every upload together, on top of each provider's limit
+	bandwidth *ratelimit.Bucket
+	// progress receives the events of every upload, nil without WithProgress
+	progress ProgressFunc
+	log      *logging.Logger
+	metrics  *Metrics
+	// tracer traces uploads, nil without WithTracerProvider
+	tracer trace.Tracer
 }
 
-func NewCoordinator(uploaders []providers.Uploader) (Coordinator, error) {
-	return Coordinator{
-		uploaders: uploaders,
-	}, nil
+// Option configures a Coordinator
+type Option func(*Coordinator)
+
+// WithStreamBuffer sets how DoStream buffers for each provider: chunks of chunkSize bytes, at
+// most chunks of them queued before the slowest provider holds back reading
+func WithStreamBuffer(chunkSize, chunks int) Option {
+	return func(c *Coordinator) {
+		if chunkSize > 0 {
+			c.streamChunkSize = chunkSize
+		}
+		if chunks > 0 {
+			c.streamChunks = chunks
+		}
+	}
+}
+
+// WithSkipExisting makes Do and DoBatch stat each provider first and skip the upload when
+// the provider already holds an object of the same size, and the same sha256 when one was
+// stored with it. Meant for content-addressed keys, where the key already names the content
+func WithSkipExisting() Option {
+	return func(c *Coordinator) {
+		c.skipExisting = true
+	}
+}
+
+// WithVerify makes Do, DoBatch and DoStream checksum the content as it is uploaded and compare
+// it with what each provider reports storing, an upload that doesn't match fails. Files are
+// checksummed before uploading so providers that take checksums check them on arrival too.
+// Providers that can't stat, or report no checksum comparable to ours, are only checked on size
+func WithVerify() Option {
+	return func(c *Coordinator) {
+		c.verify = true
+	}
+}
+
+// DefaultResumeThreshold is the smallest file WithCheckpoints uploads resumably
+const DefaultResumeThreshold = 64 << 20
+
+// WithCheckpoints makes DoBatch upload files of at least threshold bytes in parts to providers
+// that support it, saving progress to store after each part. A later DoBatch of the same file
+// and key continues from the checkpoint, a checkpoint left by a file that changed since is
+// aborted and the upload started over
+func WithCheckpoints(store *checkpoint.Store, threshold int64) Option {
+	return func(c *Coordinator) {
+		c.checkpoints = store
+		c.resumeThreshold = DefaultResumeThreshold
+		if threshold > 0 {
+			c.resumeThreshold = threshold
+		}
+	}
+}
+
+// WithGracePeriod lets uploads already in flight run for up to grace once the context given to
+// Do, DoBatch or DoStream is cancelled, DoBatch starts no new ones. Uploads still running after
+// that are cancelled, and providers abort what they stored of them
+func WithGracePeriod(grace time.Duration) Option {
+	return func(c *Coordinator) {
+		c.grace = grace
+	}
+}
+
+// WithSoftDeadline stops Do and DoBatch from waiting on slow providers: once an object's
+// uploads have run for deadline and at least minSuccess providers hold it, the uploads still
+// running are cancelled and those not started are skipped. They are reported as Deferred, not
+// failed, for the repair journal to complete later. Until minSuccess providers succeed every
+// upload is waited for as usual
+func WithSoftDeadline(deadline time.Duration, minSuccess int) Option {
+	return func(c *Coordinator) {
+		c.softDeadline = deadline
+		c.minSuccess = 1
+		if minSuccess > 0 {
+			c.minSuccess = minSuccess
+		}
+	}
+}
+
+// WithCircuitBreaker keeps a circuit breaker for every provider across the uploads of the
+// Coordinator. It trips after settings.Failures consecutive failures or once settings.ErrorRate
+// of the latest uploads failed, uploads to the provider then fail at once with ErrCircuitOpen.
+// After settings.Cooldown one upload probes the provider, closing the breaker if it succeeds
+func WithCircuitBreaker(settings BreakerSettings) Option {
+	return func(c *Coordinator) {
+		c.breakerSettings = settings
+	}
+}
+
+// WithBandwidthLimit limits the bytes read for Do, DoBatch and DoStream uploads to every provider
+// together to the rate of bucket, on top of the bandwidth limit of each provider. The bucket's
+// rate can be changed while uploads run
+func WithBandwidthLimit(bucket *ratelimit.Bucket) Option {
+	return func(c *Coordinator) {
+		c.bandwidth = bucket
+	}
+}
+
+// WithLogger logs every upload's start and outcome, retries, breakers tripping and downloads
+// to log
+func WithLogger(log *logging.Logger) Option {
+	return func(c *Coordinator) {
+		c.log = log
+	}
+}
+
+func NewCoordinator(uploaders []providers.Uploader, opts ...Option) (Coordinator, error) {
+	c := Coordinator{
+		uploaders:       uploaders,
+		streamChunkSize: DefaultStreamChunkSize,
+		streamChunks:    DefaultStreamChunks,
+	}
+	for _, opt := range opts {
+		opt(&c)
+	}
+	if c.breakerSettings.enabled() {
+		c.breakers = map[providers.Provider]*breaker{}
+		for _, u := range uploaders {
+			c.breakers[u.GetName()] = newBreaker(u.GetName(), c.breakerSettings)
+		}
+	}
+	return c, nil
+}
+
+// Breakers returns the state of every provider's circuit breaker, in the order of the
+// uploaders, nil without WithCircuitBreaker
+func (c *Coordinator) Breakers() []BreakerStats {
+	if c.breakers == nil {
+		return nil
+	}
+	stats := make([]BreakerStats, len(c.uploaders))
+	for i, u := range c.uploaders {
+		stats[i] = c.breakers[u.GetName()].snapshot()
+	}
+	return stats
+}
+
+var errNoKey = errors.New("no key for provider")
+
+// ErrNotStarted is the error of DoBatch uploads that never started because the context was cancelled
+var ErrNotStarted = errors.New("not started, the upload was interrupted")
+
+// inFlight returns the context uploads run with. Without a grace period it is cancelled with
+// ctx, otherwise it keeps ctx's values and is only cancelled c.grace after ctx is
+func (c *Coordinator) inFlight(ctx context.Context) (context.Context, context.CancelFunc) {
+	if c.grace <= 0 {
+		return context.WithCancel(ctx)
+	}
+	uploadCtx, cancel := context.WithCancel(providers.Detach(ctx))
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-uploadCtx.Done():
+			return
+		}
+		timer := time.NewTimer(c.grace)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+			cancel()
+		case <-uploadCtx.Done():
+		}
+	}()
+	return uploadCtx, cancel
+}
+
+// ProviderKeys holds the key each provider uploads to, for keys rendered per destination
+type ProviderKeys map[providers.Provider]string
+
+func (k ProviderKeys) with(uploaders []providers.Uploader, key string) ProviderKeys {
+	for _, u := range uploaders {
+		k[u.GetName()] = key
+	}
+	return k
 }
 
 type DoError struct {
@@ -26,30 +227,118 @@ type DoError struct {
 }
 
 type DoResult struct {
-	Done   []providers.Provider
-	Failed []DoError
+	Done []providers.Provider
+	// Skipped already held the object, they count as successes
+	Skipped []providers.Provider
+	Failed  []DoError
+	// Deferred were cut short by WithSoftDeadline, they are neither done nor failed
+	Deferred []providers.Provider
+	// Breakers is the state of the circuit breakers once the upload ended, WithCircuitBreaker
+	Breakers []BreakerStats
+	// Size is how many bytes DoStream read from the stream
+	Size int64
+	// Checksums of the uploaded content and, per provider, those the provider reported and
+	// matched, only set WithVerify
+	Checksums providers.Checksums
+	Verified  map[providers.Provider]providers.Checksums
+	// Versions holds the version ID each provider gave the object, for those that report one
+	// when the upload is verified
+	Versions map[providers.Provider]string
 }
 
 func (c *Coordinator) Do(ctx context.Context, bucket, key string, reader io.ReadSeekCloser) (DoResult, error) {
+	ctx, span := c.startSpan(ctx, "coordinator.Do", attribute.String("bucket", bucket), attribute.String("key", key))
+	res, err := c.do(ctx, bucket, key, reader)
+	endCall(span, res, err)
+	return res, err
+}
+
+func (c *Coordinator) do(ctx context.Context, bucket, key string, reader io.ReadSeekCloser) (DoResult, error) {
+	ctx, cancel := c.inFlight(ctx)
+	defer cancel()
 	uploadErrors := make(chan DoError, len(c.uploaders))
 	success := make(chan providers.Provider, len(c.uploaders))
+	skipped := make(chan providers.Provider, len(c.uploaders))
+	deferred := make(chan providers.Provider, len(c.uploaders))
 	wg := sync.WaitGroup{}
+	h := c.newHedge()
+	defer h.stop()
+
+	var size int64
+	var sums providers.Checksums
+	var verified, versions sync.Map
+	if c.verify {
+		var err error
+		if sums, size, err = readerChecksums(reader); err != nil {
+			return DoResult{}, err
+		}
+	} else if c.skipExisting || c.observed() {
+		var err error
+		if size, err = readerSize(reader); err != nil {
+			return DoResult{}, err
+		}
+	}
+	readers, err := splitReader(reader, len(c.uploaders))
+	if err != nil {
+		return DoResult{}, err
+	}
 
 	var count int
 	for i, u := range c.uploaders {
 		wg.Add(1)
 		go func(client providers.Uploader, n int) {
+			defer wg.Done()
 			p := client.GetName()
-			if uploadErr := client.Upload(ctx, bucket, key, reader); uploadErr != nil {
+			ev := Event{Provider: p, Bucket: bucket, Key: key, Size: size}
+			hctx, ok := h.start(ctx, p)
+			if !ok {
+				c.emitResult(ev, true, false, nil)
+				deferred <- p
+				return
+			}
+			b := c.breakers[p]
+			if err := c.allow(b); err != nil {
+				h.finish(p, err)
+				c.emitResult(ev, false, false, err)
+				uploadErrors <- DoError{p, err}
+				return
+			}
+			l := c.limit(hctx, client)
+			c.track(l, ev)
+			if c.skipExisting && alreadyStored(l.ctx, client, bucket, key, size, sums.SHA256) {
+				l.done(nil)
+				c.record(b, nil, false)
+				h.finish(p, nil)
+				ev.Attempt = 1
+				c.emitResult(ev, false, true, nil)
+				skipped <- p
+				return
+			}
+			uploadErr := providers.UploadWithOptions(l.ctx, client, bucket, key, l.reader(readers[n]), providers.UploadOptions{Checksums: sums})
+			if uploadErr == nil && c.verify {
+				var v providers.Checksums
+				var version string
+				v, version, uploadErr = verifyUpload(l.ctx, client, bucket, key, sums, size)
+				verified.Store(p, v)
+				versions.Store(p, version)
+			}
+			uploadErr = l.done(uploadErr)
+			c.record(b, uploadErr, hctx.Err() != nil)
+			isDeferred := h.finish(p, uploadErr)
+			ev.Attempt = l.progress.attempts()
+			c.emitResult(ev, isDeferred, false, uploadErr)
+			switch {
+			case isDeferred:
+				deferred <- p
+			case uploadErr != nil:
 				uploadErrors <- DoError{p, uploadErr}
-			} else {
+			default:
 				success <- p
 			}
-			wg.Done()
 		}(u, i)
 	}
 
-	var done []providers.Provider
+	var done, skips, deferrals []providers.Provider
 	var failed []DoError
 	for count < len(c.uploaders) {
 		select {
@@ -59,15 +348,37 @@ func (c *Coordinator) Do(ctx context.Context, bucket, key string, reader io.Read
 		case p := <-success:
 			done = append(done, p)
 			count++
+		case p := <-skipped:
+			skips = append(skips, p)
+			count++
+		case p := <-deferred:
+			deferrals = append(deferrals, p)
+			count++
 		}
 	}
 
 	wg.Wait()
 	close(uploadErrors)
 	close(success)
+	close(skipped)
+	close(deferred)
 	doResult := DoResult{
-		Done:   done,
-		Failed: failed,
+		Done:     done,
+		Skipped:  skips,
+		Failed:   failed,
+		Deferred: deferrals,
+		Breakers: c.Breakers(),
+	}
+	if c.verify {
+		doResult.Checksums, doResult.Verified = sums, map[providers.Provider]providers.Checksums{}
+		doResult.Versions = map[providers.Provider]string{}
+		for _, p := range done {
+			v, _ := verified.Load(p)
+			doResult.Verified[p] = v.(providers.Checksums)
+			if version, ok := versions.Load(p); ok && version.(string) != "" {
+				doResult.Versions[p] = version.(string)
+			}
+		}
 	}
 
 	if len(failed) == len(c.uploaders) {
@@ -78,3 +389,150 @@ func (c *Coordinator) Do(ctx context.Context, bucket, key string, reader io.Read
 		return doResult, nil
 	}
 }
+
+// Download reads key from whichever uploader starts responding first, the slower ones are
+// cancelled. Uploaders that can't download are skipped
+func (c *Coordinator) Download(ctx context.Context, bucket, key string) (io.ReadCloser, providers.Provider, error) {
+	type downloadResult struct {
+		provider providers.Provider
+		reader   io.ReadCloser
+		err      error
+	}
+
+	results := make(chan downloadResult, len(c.uploaders))
+	cancels := map[providers.Provider]context.CancelFunc{}
+	for _, u := range c.uploaders {
+		d, ok := u.(providers.Downloader)
+		if !ok {
+			continue
+		}
+		dctx, cancel := context.WithCancel(ctx)
+		cancels[u.GetName()] = cancel
+		go func(p providers.Provider) {
+			r, err := d.Download(dctx, bucket, key)
+			results <- downloadResult{p, r, err}
+		}(u.GetName())
+	}
+	if len(cancels) == 0 {
+		return nil, "", errors.New("no provider supports downloads")
+	}
+
+	var failed []string
+	for i := 0; i < len(cancels); i++ {
+		res := <-results
+		if res.err != nil {
+			cancels[res.provider]()
+			failed = append(failed, fmt.Sprintf("%s: %v", res.provider, res.err))
+			c.log.Warn("download failed", "provider", res.provider, "bucket", bucket, "key", key, "error", res.err)
+			continue
+		}
+		c.log.Info("downloading", "provider", res.provider, "bucket", bucket, "key", key)
+		for p, cancel := range cancels {
+			if p != res.provider {
+				cancel()
+			}
+		}
+		// release any slower reader that made it through before being cancelled
+		go func(remaining int) {
+			for j := 0; j < remaining; j++ {
+				if loser := <-results; loser.reader != nil {
+					loser.reader.Close()
+				}
+			}
+		}(len(cancels) - i - 1)
+		return cancelCloser{res.reader, cancels[res.provider]}, res.provider, nil
+	}
+	return nil, "", fmt.Errorf("download failed from every provider: %s", strings.Join(failed, "; "))
+}
+
+// cancelCloser releases the download's context once the reader is closed
+type cancelCloser struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c cancelCloser) Close() error {
+	defer c.cancel()
+	return c.ReadCloser.Close()
+}
+
+// alreadyStored reports whether u holds key with the given size, and sha256 when both the
+// caller and the stored metadata have one. Any stat failure means the object is uploaded
+func alreadyStored(ctx context.Context, u providers.Uploader, bucket, key string, size int64, sha256 string) bool {
+	s, ok := u.(providers.Stater)
+	if !ok {
+		return false
+	}
+	info, err := s.Stat(ctx, bucket, key)
+	if err != nil || info.Size != size {
+		return false
+	}
+	stored := info.MetadataValue(providers.MetadataSHA256)
+	return sha256 == "" || stored == "" || strings.EqualFold(stored, sha256)
+}
+
+// verifyUpload compares what u reports storing under key with the content that was sent, and
+// returns the version ID u gave it
+func verifyUpload(ctx context.Context, u providers.Uploader, bucket, key string, sent providers.Checksums, size int64) (providers.Checksums, string, error) {
+	s, ok := u.(providers.Stater)
+	if !ok {
+		return providers.Checksums{}, "", nil
+	}
+	info, err := s.Stat(ctx, bucket, key)
+	if err != nil {
+		return providers.Checksums{}, "", fmt.Errorf("verifying upload: %w", err)
+	}
+	verified, err := providers.VerifyChecksums(sent, size, info)
+	return verified, info.VersionID, err
+}
+
+// readerChecksums reads reader once to checksum it and rewinds it for the uploads
+func readerChecksums(reader io.ReadSeeker) (providers.Checksums, int64, error) {
+	sums, size, err := providers.ComputeChecksums(reader)
+	if err != nil {
+		return sums, size, err
+	}
+	_, err = reader.Seek(0, io.SeekStart)
+	return sums, size, err
+}
+
+func readerSize(reader io.ReadSeeker) (int64, error) {
+	size, err := reader.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, err
+	}
+	_, err = reader.Seek(0, io.SeekStart)
+	return size, err
+}
+
+// splitReader gives every uploader its own view of reader so concurrent uploads don't
+// move each other's offset. Readers that can't be read at an offset are shared as before
+func splitReader(reader io.ReadSeekCloser, n int) ([]io.ReadSeekCloser, error) {
+	readers := make([]io.ReadSeekCloser, n)
+	ra, ok := reader.(io.ReaderAt)
+	if !ok || n < 2 {
+		for i := range readers {
+			readers[i] = reader
+		}
+		return readers, nil
+	}
+
+	size, err := reader.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = reader.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	for i := range readers {
+		readers[i] = sectionCloser{io.NewSectionReader(ra, 0, size)}
+	}
+	return readers, nil
+}
+
+// sectionCloser leaves closing the underlying reader to the caller of Do
+type sectionCloser struct {
+	*io.SectionReader
+}
+
+func (sectionCloser) Close() error { return nil }
@@ -1,14 +1,20 @@
 package coordinator
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"github.com/stevequadros/uploader/config"
 	"github.com/stevequadros/uploader/providers"
 	"github.com/stretchr/testify/require"
 	"io"
+	"net/http"
+	"os"
 	"sort"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 type testUploader struct {
@@ -142,3 +148,373 @@ func TestCoordinator_Do(t *testing.T) {
 		})
 	}
 }
+
+// readingUploader records everything it reads from the upload reader
+type readingUploader struct {
+	name providers.Provider
+	got  []byte
+}
+
+func (u *readingUploader) GetName() providers.Provider { return u.name }
+func (u *readingUploader) Upload(ctx context.Context, bucket, key string, r io.ReadSeekCloser) error {
+	b, err := io.ReadAll(r)
+	u.got = b
+	return err
+}
+
+func TestCoordinator_DoGivesEveryUploaderTheWholeFile(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 10000)
+	f, err := os.CreateTemp(t.TempDir(), "upload")
+	require.NoError(t, err)
+	_, err = f.Write(content)
+	require.NoError(t, err)
+	_, err = f.Seek(0, io.SeekStart)
+	require.NoError(t, err)
+	defer f.Close()
+
+	uploaders := []*readingUploader{{name: "1"}, {name: "2"}, {name: "3"}}
+	c := &Coordinator{uploaders: []providers.Uploader{uploaders[0], uploaders[1], uploaders[2]}}
+	_, err = c.Do(context.Background(), "bucket", "key", f)
+	require.NoError(t, err)
+	for _, u := range uploaders {
+		require.Equal(t, content, u.got, u.name)
+	}
+}
+
+// downloadUploader serves content after delay, or fails with err
+type downloadUploader struct {
+	testUploader
+	content string
+	delay   time.Duration
+	err     error
+}
+
+func (u *downloadUploader) Download(ctx context.Context, bucket, key string) (io.ReadCloser, error) {
+	select {
+	case <-time.After(u.delay):
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	if u.err != nil {
+		return nil, u.err
+	}
+	return io.NopCloser(strings.NewReader(u.content)), nil
+}
+
+func TestCoordinator_Download(t *testing.T) {
+	tests := []struct {
+		name      string
+		uploaders []providers.Uploader
+		want      providers.Provider
+		wantErr   bool
+	}{
+		{
+			name: "fastest provider wins",
+			uploaders: []providers.Uploader{
+				&downloadUploader{testUploader: testUploader{name: "slow"}, content: "slow", delay: time.Second},
+				&downloadUploader{testUploader: testUploader{name: "fast"}, content: "fast"},
+			},
+			want: "fast",
+		},
+		{
+			name: "failures fall through to the next provider",
+			uploaders: []providers.Uploader{
+				&downloadUploader{testUploader: testUploader{name: "broken"}, err: errors.New("boom")},
+				&downloadUploader{testUploader: testUploader{name: "ok"}, content: "ok", delay: 10 * time.Millisecond},
+			},
+			want: "ok",
+		},
+		{
+			name: "every provider failing is an error",
+			uploaders: []providers.Uploader{
+				&downloadUploader{testUploader: testUploader{name: "1"}, err: errors.New("boom")},
+				&downloadUploader{testUploader: testUploader{name: "2"}, err: errors.New("boom")},
+			},
+			wantErr: true,
+		},
+		{
+			name:      "no downloaders is an error",
+			uploaders: []providers.Uploader{&testUploader{name: "1"}},
+			wantErr:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Coordinator{uploaders: tt.uploaders}
+			r, p, err := c.Download(context.Background(), "bucket", "key")
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			defer r.Close()
+			require.Equal(t, tt.want, p)
+			b, err := io.ReadAll(r)
+			require.NoError(t, err)
+			require.Equal(t, string(tt.want), string(b))
+		})
+	}
+}
+
+func TestSplitReader(t *testing.T) {
+	f, err := os.CreateTemp(t.TempDir(), "upload")
+	require.NoError(t, err)
+	defer f.Close()
+	_, err = f.WriteString("0123456789")
+	require.NoError(t, err)
+
+	readers, err := splitReader(f, 2)
+	require.NoError(t, err)
+	require.NotSame(t, f, readers[0])
+	// every reader starts at 0 and moves on its own
+	b := make([]byte, 4)
+	_, err = readers[0].Read(b)
+	require.NoError(t, err)
+	require.Equal(t, "0123", string(b))
+	all, err := io.ReadAll(readers[1])
+	require.NoError(t, err)
+	require.Equal(t, "0123456789", string(all))
+	require.NoError(t, readers[1].Close())
+	_, err = f.Stat()
+	require.NoError(t, err, "closing a split reader leaves the file open")
+
+	// readers that can't be read at an offset, and single uploaders, share the reader
+	shared, err := splitReader(readerSeekerCloser{}, 2)
+	require.NoError(t, err)
+	require.Equal(t, []io.ReadSeekCloser{readerSeekerCloser{}, readerSeekerCloser{}}, shared)
+	single, err := splitReader(f, 1)
+	require.NoError(t, err)
+	require.Equal(t, []io.ReadSeekCloser{f}, single)
+}
+
+// closeRecorder reports closing through closed
+type closeRecorder struct {
+	io.Reader
+	closed chan struct{}
+}
+
+func (r closeRecorder) Close() error {
+	close(r.closed)
+	return nil
+}
+
+// lateUploader's download ignores cancellation and answers after delay
+type lateUploader struct {
+	testUploader
+	delay  time.Duration
+	closed chan struct{}
+	ctx    chan context.Context
+}
+
+func (u *lateUploader) Download(ctx context.Context, bucket, key string) (io.ReadCloser, error) {
+	time.Sleep(u.delay)
+	u.ctx <- ctx
+	return closeRecorder{strings.NewReader(string(u.name)), u.closed}, nil
+}
+
+func TestCoordinator_DownloadReleasesReaders(t *testing.T) {
+	fast := &lateUploader{testUploader: testUploader{name: "fast"}, closed: make(chan struct{}), ctx: make(chan context.Context, 1)}
+	slow := &lateUploader{testUploader: testUploader{name: "slow"}, delay: 20 * time.Millisecond, closed: make(chan struct{}), ctx: make(chan context.Context, 1)}
+	c := &Coordinator{uploaders: []providers.Uploader{fast, slow}}
+
+	r, p, err := c.Download(context.Background(), "bucket", "key")
+	require.NoError(t, err)
+	require.Equal(t, providers.Provider("fast"), p)
+
+	// the slower download made it through despite being cancelled, its reader is closed
+	select {
+	case <-slow.closed:
+	case <-time.After(time.Second):
+		t.Fatal("the slower provider's reader was never closed")
+	}
+	require.Error(t, (<-slow.ctx).Err())
+
+	ctx := <-fast.ctx
+	require.NoError(t, ctx.Err(), "the winner's download runs until its reader is closed")
+	require.NoError(t, r.Close())
+	<-fast.closed
+	require.Error(t, ctx.Err())
+}
+
+// statUploader holds objects that can be stat'ed and records what it uploads
+type statUploader struct {
+	objects  map[string]providers.ObjectInfo
+	mu       sync.Mutex
+	uploads  []string
+	metadata map[string]map[string]string
+}
+
+var _ providers.OptionsUploader = (*statUploader)(nil)
+var _ providers.Stater = (*statUploader)(nil)
+
+func (u *statUploader) GetName() providers.Provider { return "stat" }
+func (u *statUploader) Upload(ctx context.Context, bucket, key string, r io.ReadSeekCloser) error {
+	return u.UploadWithOptions(ctx, bucket, key, r, providers.UploadOptions{})
+}
+func (u *statUploader) UploadWithOptions(ctx context.Context, bucket, key string, r io.ReadSeekCloser, opts providers.UploadOptions) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.uploads = append(u.uploads, key)
+	if u.metadata == nil {
+		u.metadata = map[string]map[string]string{}
+	}
+	u.metadata[key] = opts.Metadata
+	return nil
+}
+func (u *statUploader) Stat(ctx context.Context, bucket, key string) (providers.ObjectInfo, error) {
+	info, ok := u.objects[key]
+	if !ok {
+		return providers.ObjectInfo{}, providers.NewError(u.GetName(), "stat", http.StatusNotFound, "", "", errors.New("missing"))
+	}
+	return info, nil
+}
+
+func TestCoordinator_DoSkipExisting(t *testing.T) {
+	stored := &statUploader{objects: map[string]providers.ObjectInfo{"key": {Size: 5}}}
+	plain := &testUploader{name: "plain"}
+	c, _ := NewCoordinator([]providers.Uploader{stored, plain}, WithSkipExisting())
+
+	res, err := c.Do(context.Background(), "bucket", "key", sectionCloser{io.NewSectionReader(strings.NewReader("hello"), 0, 5)})
+	require.NoError(t, err)
+	require.Equal(t, []providers.Provider{"stat"}, res.Skipped)
+	require.Equal(t, []providers.Provider{"plain"}, res.Done, "providers that can't stat always upload")
+	require.Empty(t, stored.uploads)
+
+	res, err = c.Do(context.Background(), "bucket", "other", sectionCloser{io.NewSectionReader(strings.NewReader("hello"), 0, 5)})
+	require.NoError(t, err)
+	require.Empty(t, res.Skipped)
+	require.Equal(t, []string{"other"}, stored.uploads)
+}
+
+// checksumStore reports the checksums of what it stored, corrupt flips the first byte on the way
+type checksumStore struct {
+	name    providers.Provider
+	corrupt bool
+	mu      sync.Mutex
+	objects map[string][]byte
+	sent    []providers.Checksums
+}
+
+var _ providers.OptionsUploader = (*checksumStore)(nil)
+var _ providers.StreamUploader = (*checksumStore)(nil)
+var _ providers.Stater = (*checksumStore)(nil)
+
+func (u *checksumStore) GetName() providers.Provider { return u.name }
+func (u *checksumStore) Upload(ctx context.Context, bucket, key string, r io.ReadSeekCloser) error {
+	return u.UploadStream(ctx, bucket, key, r, providers.UploadOptions{})
+}
+func (u *checksumStore) UploadWithOptions(ctx context.Context, bucket, key string, r io.ReadSeekCloser, opts providers.UploadOptions) error {
+	return u.UploadStream(ctx, bucket, key, r, opts)
+}
+func (u *checksumStore) UploadStream(ctx context.Context, bucket, key string, r io.Reader, opts providers.UploadOptions) error {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	if u.corrupt && len(b) > 0 {
+		b[0] ^= 0xff
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.objects == nil {
+		u.objects = map[string][]byte{}
+	}
+	u.objects[key] = b
+	u.sent = append(u.sent, opts.Checksums)
+	return nil
+}
+func (u *checksumStore) Stat(ctx context.Context, bucket, key string) (providers.ObjectInfo, error) {
+	u.mu.Lock()
+	b, ok := u.objects[key]
+	u.mu.Unlock()
+	if !ok {
+		return providers.ObjectInfo{}, providers.NewError(u.GetName(), "stat", http.StatusNotFound, "", "", errors.New("missing"))
+	}
+	sums, _, _ := providers.ComputeChecksums(bytes.NewReader(b))
+	// like an S3 multipart upload, only the md5 is reported
+	return providers.ObjectInfo{Key: key, Size: int64(len(b)), Checksums: providers.Checksums{MD5: sums.MD5}, VersionID: "v-" + string(u.name)}, nil
+}
+
+func TestCoordinator_DoVerify(t *testing.T) {
+	good, bad := &checksumStore{name: "good"}, &checksumStore{name: "bad", corrupt: true}
+	plain := &testUploader{name: "plain"}
+	c, _ := NewCoordinator([]providers.Uploader{good, bad, plain}, WithVerify())
+
+	f, err := os.CreateTemp(t.TempDir(), "upload")
+	require.NoError(t, err)
+	defer f.Close()
+	_, err = f.WriteString("hello")
+	require.NoError(t, err)
+	_, err = f.Seek(0, io.SeekStart)
+	require.NoError(t, err)
+
+	res, err := c.Do(context.Background(), "bucket", "key", f)
+	require.Error(t, err)
+	const md5 = "5d41402abc4b2a76b9719d911017c592"
+	require.Equal(t, md5, res.Checksums.MD5)
+	require.Equal(t, []providers.Checksums{res.Checksums}, good.sent, "checksums are sent with the upload")
+	require.Equal(t, "hello", string(good.objects["key"]))
+	require.Equal(t, map[providers.Provider]providers.Checksums{
+		"good":  {MD5: md5},
+		"plain": {},
+	}, res.Verified)
+	require.Equal(t, map[providers.Provider]string{"good": "v-good"}, res.Versions)
+	require.Len(t, res.Failed, 1)
+	require.Equal(t, providers.Provider("bad"), res.Failed[0].Provider)
+	require.True(t, errors.Is(res.Failed[0].Error, providers.ErrChecksumMismatch), res.Failed[0].Error)
+}
+
+// blockingUploader holds every upload until release is closed or its context is cancelled
+type blockingUploader struct {
+	name    providers.Provider
+	started chan struct{}
+	release chan struct{}
+}
+
+func (u *blockingUploader) GetName() providers.Provider { return u.name }
+func (u *blockingUploader) Upload(ctx context.Context, bucket, key string, r io.ReadSeekCloser) error {
+	u.started <- struct{}{}
+	select {
+	case <-u.release:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func TestCoordinator_DoGracePeriod(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		grace   time.Duration
+		release bool
+		wantErr error
+	}{
+		{name: "finishes within the grace period", grace: time.Minute, release: true},
+		{name: "cancelled once the grace period ends", grace: 10 * time.Millisecond, wantErr: context.Canceled},
+		{name: "cancelled at once without one", wantErr: context.Canceled},
+	} {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			u := &blockingUploader{name: "slow", started: make(chan struct{}, 1), release: make(chan struct{})}
+			c, _ := NewCoordinator([]providers.Uploader{u}, WithGracePeriod(tt.grace))
+			ctx, cancel := context.WithCancel(context.Background())
+			go func() {
+				<-u.started
+				cancel()
+				if tt.release {
+					time.Sleep(20 * time.Millisecond)
+					close(u.release)
+				}
+			}()
+
+			res, _ := c.Do(ctx, "bucket", "key", readerSeekerCloser{})
+			if tt.wantErr == nil {
+				require.Equal(t, []providers.Provider{"slow"}, res.Done)
+				return
+			}
+			require.Len(t, res.Failed, 1)
+			require.ErrorIs(t, res.Failed[0].Error, tt.wantErr)
+		})
+	}
+}
@@ -0,0 +1,104 @@
+package coordinator
+
+import (
+	"context"
+	"errors"
+	"github.com/stevequadros/uploader/providers"
+	"sync"
+	"time"
+)
+
+// ErrDeferred is the error of uploads left to the repair journal by WithSoftDeadline, they
+// are reported in Deferred rather than as failures
+var ErrDeferred = errors.New("deferred past the soft deadline")
+
+// hedge tracks the uploads of one object to every provider for WithSoftDeadline. Once the
+// deadline passed with at least minSuccess providers done, it cancels the uploads still running
+// and the ones not started yet are deferred. A nil hedge defers nothing
+type hedge struct {
+	minSuccess int
+	deadline   time.Time
+	timer      *time.Timer
+
+	mu        sync.Mutex
+	succeeded int
+	deferring bool
+	running   map[providers.Provider]context.CancelFunc
+	cancelled map[providers.Provider]bool
+}
+
+// newHedge starts the soft deadline of an object, nil without WithSoftDeadline
+func (c *Coordinator) newHedge() *hedge {
+	if c.softDeadline <= 0 {
+		return nil
+	}
+	h := &hedge{
+		minSuccess: c.minSuccess,
+		deadline:   time.Now().Add(c.softDeadline),
+		running:    map[providers.Provider]context.CancelFunc{},
+		cancelled:  map[providers.Provider]bool{},
+	}
+	// held until timer is set, for a deadline that passes right away
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.timer = time.AfterFunc(c.softDeadline, func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		h.check()
+	})
+	return h
+}
+
+// start returns the context of the upload to p, false when it is deferred instead
+func (h *hedge) start(ctx context.Context, p providers.Provider) (context.Context, bool) {
+	if h == nil {
+		return ctx, true
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.deferring {
+		return ctx, false
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	h.running[p] = cancel
+	return ctx, true
+}
+
+// finish records how the upload to p went and reports whether it was cut short to be deferred
+func (h *hedge) finish(p providers.Provider, err error) bool {
+	if h == nil {
+		return false
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if cancel, ok := h.running[p]; ok {
+		cancel()
+		delete(h.running, p)
+	}
+	if err == nil {
+		h.succeeded++
+		h.check()
+		return false
+	}
+	return h.cancelled[p]
+}
+
+// check starts deferring once the deadline passed and enough providers succeeded, h.mu is held
+func (h *hedge) check() {
+	if h.deferring || h.succeeded < h.minSuccess || time.Now().Before(h.deadline) {
+		return
+	}
+	h.deferring = true
+	h.timer.Stop()
+	for p, cancel := range h.running {
+		h.cancelled[p] = true
+		cancel()
+	}
+}
+
+// stop releases the hedge once every upload of the object finished
+func (h *hedge) stop() {
+	if h != nil {
+		h.timer.Stop()
+	}
+}
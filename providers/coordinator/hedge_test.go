@@ -0,0 +1,59 @@
+package coordinator
+
+import (
+	"context"
+	"github.com/stevequadros/uploader/providers"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCoordinator_DoSoftDeadline(t *testing.T) {
+	for _, tt := range []struct {
+		name         string
+		minSuccess   int
+		wantDone     []providers.Provider
+		wantDeferred []providers.Provider
+	}{
+		{name: "slow provider deferred", minSuccess: 1, wantDone: []providers.Provider{"fast"}, wantDeferred: []providers.Provider{"slow"}},
+		{name: "waits until enough providers succeed", minSuccess: 2, wantDone: []providers.Provider{"fast", "slow"}},
+	} {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			slow := &blockingUploader{name: "slow", started: make(chan struct{}, 1), release: make(chan struct{})}
+			c, _ := NewCoordinator([]providers.Uploader{&testUploader{name: "fast"}, slow}, WithSoftDeadline(20*time.Millisecond, tt.minSuccess))
+			// released long after the deadline, only when the upload wasn't deferred by then
+			timer := time.AfterFunc(200*time.Millisecond, func() { close(slow.release) })
+			defer timer.Stop()
+
+			res, err := c.Do(context.Background(), "bucket", "key", readerSeekerCloser{})
+			require.NoError(t, err)
+			require.ElementsMatch(t, tt.wantDone, res.Done)
+			require.Equal(t, tt.wantDeferred, res.Deferred)
+			require.Empty(t, res.Failed)
+		})
+	}
+}
+
+func TestCoordinator_DoBatchSoftDeadline(t *testing.T) {
+	dir := t.TempDir()
+	var batch []BatchFile
+	for _, name := range []string{"a", "b"} {
+		p := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(p, []byte(name), 0644))
+		batch = append(batch, BatchFile{Path: p, Key: name})
+	}
+	slow := &blockingUploader{name: "slow", started: make(chan struct{}, 2), release: make(chan struct{})}
+	defer close(slow.release)
+	c, _ := NewCoordinator([]providers.Uploader{&testUploader{name: "fast"}, slow}, WithSoftDeadline(20*time.Millisecond, 1))
+
+	res := c.DoBatch(context.Background(), "bucket", batch, 2)
+	for _, f := range res.Files {
+		require.True(t, f.OK())
+		require.Equal(t, []providers.Provider{"fast"}, f.Done)
+		require.Equal(t, []providers.Provider{"slow"}, f.Deferred)
+	}
+	require.Equal(t, 2, res.Deferred())
+}
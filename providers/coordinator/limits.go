@@ -0,0 +1,206 @@
+package coordinator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/stevequadros/uploader/providers"
+	"github.com/stevequadros/uploader/providers/ratelimit"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrTimeout is the error of uploads cut short by their provider's total or idle timeout
+var ErrTimeout = errors.New("upload timed out")
+
+// limits bounds one upload to one provider by the provider's total and idle timeouts, and
+// limits its bandwidth to the provider's and the Coordinator's
+type limits struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	parent context.Context
+	total  time.Duration
+	idle   time.Duration
+	// timer cancels ctx once nothing was read for idle, idled is set when it did
+	timer *time.Timer
+	idled int32
+	// waiting counts the reads waiting on the bandwidth buckets, the timer stays stopped until
+	// the last of them was granted its bytes
+	mu      sync.Mutex
+	waiting int
+	// bandwidth are the buckets every byte read is taken from
+	bandwidth []*ratelimit.Bucket
+	// progress reports the bytes read, nil when nothing listens
+	progress *uploadProgress
+}
+
+// limit returns the limits of an upload to u run with ctx, release them with done
+func (c *Coordinator) limit(ctx context.Context, u providers.Uploader) *limits {
+	timeouts := providers.TimeoutsOf(u)
+	l := &limits{parent: ctx, total: time.Duration(timeouts.Total), idle: time.Duration(timeouts.Idle)}
+	for _, b := range []*ratelimit.Bucket{providers.LimitersOf(u).Bandwidth, c.bandwidth} {
+		if b != nil {
+			l.bandwidth = append(l.bandwidth, b)
+		}
+	}
+	if l.total > 0 {
+		l.ctx, l.cancel = context.WithTimeout(ctx, l.total)
+	} else {
+		l.ctx, l.cancel = context.WithCancel(ctx)
+	}
+	if l.idle > 0 {
+		l.timer = time.AfterFunc(l.idle, func() {
+			atomic.StoreInt32(&l.idled, 1)
+			l.cancel()
+		})
+	}
+	return l
+}
+
+// Upload uploads r to u with opts within u's timeouts and bandwidth limit, and bandwidth's when
+// not nil, as a Coordinator would. It is for the uploads sync, repair and audit make one
+// provider at a time
+func Upload(ctx context.Context, u providers.Uploader, bandwidth *ratelimit.Bucket, bucket, key string, r io.ReadSeekCloser, opts providers.UploadOptions) error {
+	c := Coordinator{bandwidth: bandwidth}
+	l := c.limit(ctx, u)
+	return l.done(providers.UploadWithOptions(l.ctx, u, bucket, key, l.reader(r), opts))
+}
+
+// touch restarts the idle timer after a read, a read that reached the end stops it since
+// what is left is waiting on the provider. A read waiting on the bandwidth buckets keeps the
+// timer stopped
+func (l *limits) touch(err error) {
+	if l.timer == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err == io.EOF || l.waiting > 0 {
+		l.timer.Stop()
+		return
+	}
+	l.timer.Reset(l.idle)
+}
+
+// wait takes n bytes from the bandwidth buckets with the idle timer stopped, waiting on our own
+// limit isn't the provider stalling. The timer is restarted by touch once no read is waiting
+func (l *limits) wait(n int) error {
+	if l.timer == nil {
+		return ratelimit.Wait(l.ctx, n, l.bandwidth...)
+	}
+	l.mu.Lock()
+	l.waiting++
+	l.timer.Stop()
+	l.mu.Unlock()
+	err := ratelimit.Wait(l.ctx, n, l.bandwidth...)
+	l.mu.Lock()
+	l.waiting--
+	l.mu.Unlock()
+	return err
+}
+
+// done releases the limits and returns err, as ErrTimeout when a timeout caused it
+func (l *limits) done(err error) error {
+	if l.timer != nil {
+		l.timer.Stop()
+	}
+	timedOut := l.ctx.Err() != nil && l.parent.Err() == nil
+	l.cancel()
+	switch {
+	case err == nil || !timedOut:
+	case atomic.LoadInt32(&l.idled) == 1:
+		err = fmt.Errorf("%w: nothing read for %s: %v", ErrTimeout, l.idle, err)
+	default:
+		err = fmt.Errorf("%w: still running after %s: %v", ErrTimeout, l.total, err)
+	}
+	l.progress.finish(err)
+	return err
+}
+
+// reader wraps r so reading from it is limited and restarts the idle timer, keeping ReadAt
+// when r has it
+func (l *limits) reader(r io.ReadSeekCloser) io.ReadSeekCloser {
+	if !l.wraps() {
+		return r
+	}
+	if ra, ok := r.(io.ReaderAt); ok {
+		return limitedReaderAt{limitedReader{r, l}, ra}
+	}
+	return limitedReader{r, l}
+}
+
+// readerAt wraps r so reading from it is limited and restarts the idle timer
+func (l *limits) readerAt(r io.ReaderAt) io.ReaderAt {
+	if !l.wraps() {
+		return r
+	}
+	return limitedReaderAt{ReaderAt: r, limitedReader: limitedReader{l: l}}
+}
+
+// stream wraps r so reading from it is limited and restarts the idle timer
+func (l *limits) stream(r io.Reader) io.Reader {
+	if !l.wraps() {
+		return r
+	}
+	return limitedStream{r, l}
+}
+
+func (l *limits) wraps() bool {
+	return l.timer != nil || len(l.bandwidth) > 0 || l.progress != nil
+}
+
+// read reads into b with read, in chunks small enough to spread the bandwidth evenly unless
+// whole reads are needed, then takes the bytes read from the bandwidth buckets. off is where a
+// ReadAt read from, -1 for sequential reads
+func (l *limits) read(read func([]byte) (int, error), b []byte, whole bool, off int64) (int, error) {
+	if len(l.bandwidth) > 0 && !whole && len(b) > ratelimit.ChunkSize {
+		b = b[:ratelimit.ChunkSize]
+	}
+	n, err := read(b)
+	if len(l.bandwidth) > 0 && n > 0 {
+		if werr := l.wait(n); werr != nil {
+			return n, werr
+		}
+	}
+	l.touch(err)
+	l.progress.add(n, off)
+	return n, err
+}
+
+type limitedReader struct {
+	io.ReadSeekCloser
+	l *limits
+}
+
+func (r limitedReader) Read(b []byte) (int, error) {
+	return r.l.read(r.ReadSeekCloser.Read, b, false, -1)
+}
+
+func (r limitedReader) Seek(offset int64, whence int) (int64, error) {
+	pos, err := r.ReadSeekCloser.Seek(offset, whence)
+	if err == nil {
+		r.l.progress.seeked(pos)
+	}
+	return pos, err
+}
+
+type limitedReaderAt struct {
+	limitedReader
+	io.ReaderAt
+}
+
+func (r limitedReaderAt) ReadAt(b []byte, off int64) (int, error) {
+	// ReadAt can't return less than asked without an error
+	return r.l.read(func(b []byte) (int, error) { return r.ReaderAt.ReadAt(b, off) }, b, true, off)
+}
+
+type limitedStream struct {
+	io.Reader
+	l *limits
+}
+
+func (r limitedStream) Read(b []byte) (int, error) {
+	return r.l.read(r.Reader.Read, b, false, -1)
+}
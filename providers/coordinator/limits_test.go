@@ -0,0 +1,152 @@
+package coordinator
+
+import (
+	"bytes"
+	"context"
+	"github.com/stevequadros/uploader/config"
+	"github.com/stevequadros/uploader/providers"
+	"github.com/stevequadros/uploader/providers/ratelimit"
+	"github.com/stretchr/testify/require"
+	"io"
+	"os"
+	"testing"
+	"time"
+)
+
+// slowUploader reads a byte every interval for reads times, then holds the upload until its
+// context ends when stall is set
+type slowUploader struct {
+	name     providers.Provider
+	timeouts config.Timeouts
+	reads    int
+	interval time.Duration
+	stall    bool
+}
+
+func (u *slowUploader) GetName() providers.Provider { return u.name }
+func (u *slowUploader) Timeouts() config.Timeouts   { return u.timeouts }
+func (u *slowUploader) Upload(ctx context.Context, bucket, key string, r io.ReadSeekCloser) error {
+	for i := 0; i < u.reads; i++ {
+		select {
+		case <-time.After(u.interval):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		if _, err := r.Read(make([]byte, 1)); err != nil {
+			return err
+		}
+	}
+	if u.stall {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	return nil
+}
+
+func TestCoordinator_DoTimeouts(t *testing.T) {
+	ms := func(n int) config.Duration { return config.Duration(time.Duration(n) * time.Millisecond) }
+	for _, tt := range []struct {
+		name     string
+		uploader *slowUploader
+		wantErr  string
+	}{
+		{
+			name:     "total timeout",
+			uploader: &slowUploader{timeouts: config.Timeouts{Total: ms(20)}, stall: true},
+			wantErr:  "still running after 20ms",
+		},
+		{
+			name:     "idle once reading stalls",
+			uploader: &slowUploader{timeouts: config.Timeouts{Idle: ms(50)}, reads: 3, interval: 10 * time.Millisecond, stall: true},
+			wantErr:  "nothing read for 50ms",
+		},
+		{
+			name:     "reading keeps the idle timeout away",
+			uploader: &slowUploader{timeouts: config.Timeouts{Idle: ms(50)}, reads: 8, interval: 10 * time.Millisecond},
+		},
+	} {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			tt.uploader.name = "slow"
+			c, _ := NewCoordinator([]providers.Uploader{tt.uploader})
+			res, _ := c.Do(context.Background(), "bucket", "key", readerSeekerCloser{})
+			if tt.wantErr == "" {
+				require.Equal(t, []providers.Provider{"slow"}, res.Done)
+				return
+			}
+			require.Len(t, res.Failed, 1)
+			require.ErrorIs(t, res.Failed[0].Error, ErrTimeout)
+			require.Contains(t, res.Failed[0].Error.Error(), tt.wantErr)
+		})
+	}
+}
+
+func TestCoordinator_DoBandwidthLimit(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 6000)
+	f, err := os.CreateTemp(t.TempDir(), "upload")
+	require.NoError(t, err)
+	_, err = f.Write(content)
+	require.NoError(t, err)
+	_, err = f.Seek(0, io.SeekStart)
+	require.NoError(t, err)
+	defer f.Close()
+
+	// two providers share 100KB/s: the first second's worth is free, the other 200ms waited for
+	uploaders := []*readingUploader{{name: "1"}, {name: "2"}}
+	c, _ := NewCoordinator([]providers.Uploader{uploaders[0], uploaders[1]}, WithBandwidthLimit(ratelimit.New(100e3)))
+	began := time.Now()
+	_, err = c.Do(context.Background(), "bucket", "key", f)
+	require.NoError(t, err)
+	require.GreaterOrEqual(t, time.Since(began), 150*time.Millisecond)
+	for _, u := range uploaders {
+		require.Equal(t, content, u.got, u.name)
+	}
+}
+
+func TestLimits_IdleTimerWaitsForBandwidth(t *testing.T) {
+	// the first read drains the bucket's 10KB burst, the next two are granted after 100ms and
+	// 200ms: the first of them finishing must not restart the idle timer while the other waits
+	u := &slowUploader{name: "slow", timeouts: config.Timeouts{Idle: config.Duration(30 * time.Millisecond)}}
+	c, _ := NewCoordinator([]providers.Uploader{u}, WithBandwidthLimit(ratelimit.New(10e3)))
+	l := c.limit(context.Background(), u)
+	r := l.readerAt(bytes.NewReader(make([]byte, 12e3)))
+	_, err := r.ReadAt(make([]byte, 10e3), 0)
+	require.NoError(t, err)
+
+	errs := make(chan error, 2)
+	for _, off := range []int64{10e3, 11e3} {
+		off := off
+		go func() {
+			_, err := r.ReadAt(make([]byte, 1e3), off)
+			errs <- err
+		}()
+	}
+	for i := 0; i < 2; i++ {
+		require.NoError(t, <-errs)
+	}
+	require.NoError(t, l.ctx.Err())
+	require.NoError(t, l.done(nil))
+}
+
+func TestUpload(t *testing.T) {
+	// the bucket's 10KB burst is free, the other 5KB waited for
+	content := bytes.Repeat([]byte("x"), 15e3)
+	f, err := os.CreateTemp(t.TempDir(), "upload")
+	require.NoError(t, err)
+	defer f.Close()
+	_, err = f.Write(content)
+	require.NoError(t, err)
+	_, err = f.Seek(0, io.SeekStart)
+	require.NoError(t, err)
+
+	u := &readingUploader{name: "1"}
+	began := time.Now()
+	err = Upload(context.Background(), u, ratelimit.New(10e3), "bucket", "key", f, providers.UploadOptions{})
+	require.NoError(t, err)
+	require.Equal(t, content, u.got)
+	require.GreaterOrEqual(t, time.Since(began), 400*time.Millisecond)
+
+	stalled := &slowUploader{name: "slow", timeouts: config.Timeouts{Idle: config.Duration(20 * time.Millisecond)}, stall: true}
+	err = Upload(context.Background(), stalled, nil, "bucket", "key", readerSeekerCloser{}, providers.UploadOptions{})
+	require.ErrorIs(t, err, ErrTimeout)
+}
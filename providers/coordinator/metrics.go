@@ -0,0 +1,155 @@
+package coordinator
+
+import (
+	"context"
+	"errors"
+	"github.com/stevequadros/uploader/providers"
+	"github.com/stevequadros/uploader/providers/metrics"
+	"sync"
+	"time"
+)
+
+// Metrics counts the uploads of a Coordinator by destination in a metrics.Registry
+type Metrics struct {
+	uploads    *metrics.Counter
+	bytes      *metrics.Counter
+	errors     *metrics.Counter
+	retries    *metrics.Counter
+	duration   *metrics.Histogram
+	throughput *metrics.Histogram
+	inFlight   *metrics.Gauge
+	breaker    *metrics.Gauge
+	trips      *metrics.Counter
+
+	mu      sync.Mutex
+	started map[uploadID]time.Time
+}
+
+// uploadID tells apart the uploads in flight
+type uploadID struct {
+	provider          providers.Provider
+	bucket, path, key string
+}
+
+// NewMetrics registers the upload metrics in reg
+func NewMetrics(reg *metrics.Registry) *Metrics {
+	return &Metrics{
+		uploads: reg.NewCounter("uploader_uploads_total",
+			"Uploads finished, by provider and status: done, skipped, failed or deferred", "provider", "status"),
+		bytes: reg.NewCounter("uploader_upload_bytes_total",
+			"Bytes of the uploads done", "provider"),
+		errors: reg.NewCounter("uploader_upload_errors_total",
+			"Uploads failed, by provider and error class", "provider", "class"),
+		retries: reg.NewCounter("uploader_upload_retries_total",
+			"Times a provider sent part of an upload again", "provider"),
+		duration: reg.NewHistogram("uploader_upload_duration_seconds",
+			"How long the uploads done took",
+			[]float64{0.1, 0.5, 1, 5, 10, 30, 60, 300, 900, 3600}, "provider"),
+		throughput: reg.NewHistogram("uploader_upload_throughput_bytes_per_second",
+			"Bytes per second of the uploads done",
+			metrics.ExponentialBuckets(64<<10, 4, 8), "provider"),
+		inFlight: reg.NewGauge("uploader_uploads_in_flight",
+			"Uploads running", "provider"),
+		breaker: reg.NewGauge("uploader_circuit_breaker_state",
+			"State of the provider's circuit breaker: 0 closed, 1 open, 2 half-open", "provider"),
+		trips: reg.NewCounter("uploader_circuit_breaker_trips_total",
+			"Times the provider's circuit breaker opened", "provider"),
+		started: map[uploadID]time.Time{},
+	}
+}
+
+// WithMetrics counts the uploads of Do, DoBatch and DoStream in m
+func WithMetrics(m *Metrics) Option {
+	return func(c *Coordinator) {
+		c.metrics = m
+	}
+}
+
+// observe counts an upload's event
+func (m *Metrics) observe(ev Event) {
+	if m == nil {
+		return
+	}
+	p := string(ev.Provider)
+	id := uploadID{ev.Provider, ev.Bucket, ev.Path, ev.Key}
+	switch ev.Type {
+	case EventStarted:
+		m.mu.Lock()
+		m.started[id] = ev.Time
+		m.mu.Unlock()
+		m.inFlight.Add(1, p)
+		return
+	case EventProgress:
+		return
+	case EventRetry:
+		m.retries.Inc(p)
+		return
+	}
+
+	// the upload ended, rejected or deferred ones never started
+	m.mu.Lock()
+	began, ok := m.started[id]
+	delete(m.started, id)
+	m.mu.Unlock()
+	if ok {
+		m.inFlight.Add(-1, p)
+	}
+	switch ev.Type {
+	case EventDone:
+		m.uploads.Inc(p, "done")
+		m.bytes.Add(float64(ev.Sent), p)
+		if ok {
+			took := ev.Time.Sub(began).Seconds()
+			m.duration.Observe(took, p)
+			if took > 0 {
+				m.throughput.Observe(float64(ev.Sent)/took, p)
+			}
+		}
+	case EventSkipped:
+		m.uploads.Inc(p, "skipped")
+	case EventDeferred:
+		m.uploads.Inc(p, "deferred")
+	case EventFailed:
+		m.uploads.Inc(p, "failed")
+		m.errors.Inc(p, ErrorClass(ev.Err))
+	}
+}
+
+// breakerChanged sets the state of b's gauge, counting a trip when tripped
+func (m *Metrics) breakerChanged(b *breaker, tripped bool) {
+	if m == nil || b == nil {
+		return
+	}
+	stats := b.snapshot()
+	m.breaker.Set(float64(stats.State), string(stats.Provider))
+	if tripped {
+		m.trips.Inc(string(stats.Provider))
+	}
+}
+
+// allow is b.allow keeping the breaker's gauge current, the cooldown ending half opens it
+func (c *Coordinator) allow(b *breaker) error {
+	err := b.allow()
+	c.metrics.breakerChanged(b, false)
+	return err
+}
+
+// ErrorClass sorts an upload's error into a class scripts and dashboards can act on:
+// interrupted, timeout, circuit-open, checksum-mismatch, the provider's class of the error (auth,
+// not-found, throttled, transient, conflict, quota or invalid-input) or error
+func ErrorClass(err error) string {
+	switch {
+	case errors.Is(err, ErrNotStarted), errors.Is(err, context.Canceled):
+		return "interrupted"
+	case errors.Is(err, ErrTimeout), errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	case errors.Is(err, ErrCircuitOpen):
+		return "circuit-open"
+	case errors.Is(err, providers.ErrChecksumMismatch):
+		return "checksum-mismatch"
+	}
+	if class := providers.ClassOf(err); class != providers.ClassUnknown {
+		return class.String()
+	}
+	return "error"
+}
@@ -0,0 +1,68 @@
+package coordinator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/stevequadros/uploader/providers"
+	"github.com/stevequadros/uploader/providers/metrics"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCoordinator_DoBatchMetrics(t *testing.T) {
+	dir := t.TempDir()
+	var batch []BatchFile
+	for _, name := range []string{"a", "b", "c"} {
+		p := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(p, make([]byte, 100), 0644))
+		batch = append(batch, BatchFile{Path: p, Key: name})
+	}
+
+	reg := metrics.NewRegistry()
+	uploaders := []providers.Uploader{&retryingUploader{name: "retry"}, &testUploader{name: "fail", wantErr: true}}
+	c, _ := NewCoordinator(uploaders, WithMetrics(NewMetrics(reg)), WithCircuitBreaker(BreakerSettings{Failures: 2}))
+	c.DoBatch(context.Background(), "bucket", batch, 1)
+
+	out := strings.Builder{}
+	_, err := reg.WriteTo(&out)
+	require.NoError(t, err)
+	text := out.String()
+	for _, want := range []string{
+		`uploader_uploads_total{provider="retry",status="done"} 3`,
+		`uploader_uploads_total{provider="fail",status="failed"} 3`,
+		`uploader_upload_bytes_total{provider="retry"} 300`,
+		`uploader_upload_retries_total{provider="retry"} 3`,
+		`uploader_upload_errors_total{provider="fail",class="error"} 2`,
+		`uploader_upload_errors_total{provider="fail",class="circuit-open"} 1`,
+		`uploader_upload_duration_seconds_count{provider="retry"} 3`,
+		`uploader_uploads_in_flight{provider="fail"} 0`,
+		`uploader_uploads_in_flight{provider="retry"} 0`,
+		`uploader_circuit_breaker_state{provider="fail"} 1`,
+		`uploader_circuit_breaker_state{provider="retry"} 0`,
+		`uploader_circuit_breaker_trips_total{provider="fail"} 1`,
+	} {
+		require.Contains(t, text, want+"\n")
+	}
+}
+
+func TestErrorClass(t *testing.T) {
+	for _, tt := range []struct {
+		err  error
+		want string
+	}{
+		{ErrNotStarted, "interrupted"},
+		{fmt.Errorf("%w after x", ErrCircuitOpen), "circuit-open"},
+		{fmt.Errorf("verifying upload: %w", providers.ErrChecksumMismatch), "checksum-mismatch"},
+		{context.Canceled, "interrupted"},
+		{providers.NewError(providers.AWS, "upload", 503, "SlowDown", "r1", errors.New("slow down")), "throttled"},
+		{fmt.Errorf("stat: %w", providers.NewError(providers.GCP, "stat", 404, "", "", errors.New("missing"))), "not-found"},
+		{providers.ErrInvalidKey, "invalid-input"},
+		{errors.New("boom"), "error"},
+	} {
+		require.Equal(t, tt.want, ErrorClass(tt.err), tt.err.Error())
+	}
+}
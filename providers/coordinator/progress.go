@@ -0,0 +1,373 @@
+package coordinator
+
+import (
+	"github.com/stevequadros/uploader/providers"
+	"go.opentelemetry.io/otel/trace"
+	"sync"
+	"time"
+)
+
+type EventType int
+
+const (
+	// EventStarted is sent once an upload to a provider begins
+	EventStarted EventType = iota
+	// EventProgress reports the bytes an upload read so far, at most every ProgressInterval
+	EventProgress
+	// EventRetry is sent when a provider rewinds an upload to send it again
+	EventRetry
+	EventDone
+	EventSkipped
+	EventFailed
+	EventDeferred
+)
+
+func (t EventType) String() string {
+	switch t {
+	case EventStarted:
+		return "started"
+	case EventProgress:
+		return "progress"
+	case EventRetry:
+		return "retry"
+	case EventDone:
+		return "done"
+	case EventSkipped:
+		return "skipped"
+	case EventFailed:
+		return "failed"
+	case EventDeferred:
+		return "deferred"
+	}
+	return "unknown"
+}
+
+// ProgressInterval is how often an upload reports the bytes it read
+const ProgressInterval = 100 * time.Millisecond
+
+// Event is a change in an upload of one object to one provider
+type Event struct {
+	Type     EventType
+	Provider providers.Provider
+	Bucket   string
+	// Path is the local file uploaded, empty for Do and streams, Key the key on the provider
+	Path string
+	Key  string
+	// Attempt counts the tries of the upload so far, 0 when it never started
+	Attempt int
+	// Sent is how many bytes the upload read so far, Size the object's size or -1 when unknown
+	Sent int64
+	Size int64
+	// Err is set for EventFailed
+	Err  error
+	Time time.Time
+}
+
+// ProgressFunc receives the events of every upload. It is called from the uploads' goroutines
+// and must return quickly
+type ProgressFunc func(Event)
+
+// WithProgress sends the events of Do, DoBatch and DoStream uploads to fn
+func WithProgress(fn ProgressFunc) Option {
+	return func(c *Coordinator) {
+		c.progress = fn
+	}
+}
+
+// observed reports whether anything listens to the events of uploads
+func (c *Coordinator) observed() bool {
+	return c.progress != nil || c.log != nil || c.metrics != nil || c.tracer != nil
+}
+
+func (c *Coordinator) emit(ev Event) {
+	if !c.observed() {
+		return
+	}
+	ev.Time = time.Now()
+	c.logEvent(ev)
+	c.metrics.observe(ev)
+	if c.progress != nil {
+		c.progress(ev)
+	}
+}
+
+// emitResult sends the event ending the upload ev describes
+func (c *Coordinator) emitResult(ev Event, deferred, skipped bool, err error) {
+	ev.Err = err
+	switch {
+	case deferred:
+		ev.Type = EventDeferred
+	case err != nil:
+		ev.Type = EventFailed
+	case skipped:
+		ev.Type = EventSkipped
+	default:
+		ev.Type, ev.Sent = EventDone, ev.Size
+	}
+	c.emit(ev)
+}
+
+// logEvent logs the changes in an upload's state, not its progress
+func (c *Coordinator) logEvent(ev Event) {
+	if c.log == nil || ev.Type == EventProgress {
+		return
+	}
+	args := []interface{}{"provider", ev.Provider, "bucket", ev.Bucket, "key", ev.Key}
+	if ev.Path != "" {
+		args = append(args, "path", ev.Path)
+	}
+	if ev.Attempt > 0 {
+		args = append(args, "attempt", ev.Attempt)
+	}
+	switch ev.Type {
+	case EventStarted:
+		c.log.Debug("upload started", append(args, "size", ev.Size)...)
+	case EventRetry:
+		c.log.Info("upload retried", append(args, "sent", ev.Sent)...)
+	case EventDone:
+		c.log.Info("upload done", append(args, "size", ev.Sent)...)
+	case EventSkipped:
+		c.log.Info("upload skipped, already stored", args...)
+	case EventFailed:
+		c.log.Warn("upload failed", append(args, "error", ev.Err)...)
+	case EventDeferred:
+		c.log.Warn("upload deferred past the soft deadline", args...)
+	}
+}
+
+// uploadProgress counts the bytes an upload reads and reports them
+type uploadProgress struct {
+	c     *Coordinator
+	event Event
+
+	mu   sync.Mutex
+	sent int64
+	// pos and read detect a provider seeking back over what it read to send it again
+	pos  int64
+	read bool
+	last time.Time
+	// span is the upload's and retry the latest retry's, nil when it isn't traced
+	span  trace.Span
+	retry trace.Span
+}
+
+// track reports the progress of the upload ev describes, which l limits, starting with
+// EventStarted
+func (c *Coordinator) track(l *limits, ev Event) {
+	if !c.observed() {
+		return
+	}
+	ev.Attempt = 1
+	l.progress = &uploadProgress{c: c, event: ev}
+	if c.tracer != nil {
+		l.progress.span = c.traceUpload(l, ev)
+	}
+	ev.Type = EventStarted
+	c.emit(ev)
+}
+
+// attempts is how many times the upload was tried, 0 when it isn't tracked
+func (u *uploadProgress) attempts() int {
+	if u == nil {
+		return 0
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.event.Attempt
+}
+
+// add counts n bytes read, at off for reads at an offset and -1 for sequential ones
+func (u *uploadProgress) add(n int, off int64) {
+	if u == nil || n <= 0 {
+		return
+	}
+	u.mu.Lock()
+	if off < 0 {
+		u.pos += int64(n)
+		u.read = true
+		if u.pos > u.sent {
+			u.sent = u.pos
+		}
+	} else {
+		u.sent += int64(n)
+	}
+	if u.event.Size >= 0 && u.sent > u.event.Size {
+		u.sent = u.event.Size
+	}
+	if time.Since(u.last) < ProgressInterval {
+		u.mu.Unlock()
+		return
+	}
+	u.last = time.Now()
+	ev := u.event
+	ev.Type, ev.Sent = EventProgress, u.sent
+	u.mu.Unlock()
+	u.c.emit(ev)
+}
+
+// seeked records the reader moving to pos, back over bytes it read is a retry
+func (u *uploadProgress) seeked(pos int64) {
+	if u == nil {
+		return
+	}
+	u.mu.Lock()
+	retry := u.read && pos < u.pos
+	u.pos, u.read = pos, false
+	if retry {
+		u.event.Attempt++
+		u.retried()
+	}
+	ev := u.event
+	ev.Type, ev.Sent = EventRetry, u.sent
+	u.mu.Unlock()
+	if retry {
+		u.c.emit(ev)
+	}
+}
+
+// ProviderProgress is how the uploads to one provider are going
+type ProviderProgress struct {
+	Provider providers.Provider
+	// Sent and Total are bytes, Total those of every upload expected or started and not failed,
+	// skipped or deferred since
+	Sent  int64
+	Total int64
+	// Files counts the uploads expected or started
+	Files    int
+	Active   int
+	Done     int
+	Skipped  int
+	Failed   int
+	Deferred int
+	Retries  int
+	// Throughput is in bytes per second since the first upload started
+	Throughput float64
+	// ETA is 0 when unknown
+	ETA time.Duration
+}
+
+// Tracker sums the events of uploads per provider, for progress bars and logs. Its Handle method
+// is a ProgressFunc
+type Tracker struct {
+	mu        sync.Mutex
+	order     []providers.Provider
+	providers map[providers.Provider]*trackedProvider
+}
+
+type trackedProvider struct {
+	progress ProviderProgress
+	// expected is set by Expect, Files and Total then don't grow as uploads start
+	expected bool
+	began    time.Time
+	uploads  map[string]int64
+}
+
+// NewTracker tracks the given providers, in that order
+func NewTracker(uploaders []providers.Uploader) *Tracker {
+	t := &Tracker{providers: map[providers.Provider]*trackedProvider{}}
+	for _, u := range uploaders {
+		t.provider(u.GetName())
+	}
+	return t
+}
+
+func (t *Tracker) provider(p providers.Provider) *trackedProvider {
+	tp, ok := t.providers[p]
+	if !ok {
+		tp = &trackedProvider{progress: ProviderProgress{Provider: p}, uploads: map[string]int64{}}
+		t.providers[p] = tp
+		t.order = append(t.order, p)
+	}
+	return tp
+}
+
+// Expect sets how many uploads of how many bytes every provider will get, for totals and ETAs
+// known before the uploads start
+func (t *Tracker) Expect(files int, bytes int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, tp := range t.providers {
+		tp.expected = true
+		tp.progress.Files, tp.progress.Total = files, bytes
+	}
+}
+
+func (t *Tracker) Handle(ev Event) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	tp := t.provider(ev.Provider)
+	id := ev.Path + "\x00" + ev.Key
+	size := ev.Size
+	if size < 0 {
+		size = 0
+	}
+	p := &tp.progress
+	switch ev.Type {
+	case EventStarted:
+		if tp.began.IsZero() {
+			tp.began = ev.Time
+		}
+		if !tp.expected {
+			p.Files++
+			p.Total += size
+		}
+		p.Active++
+		tp.uploads[id] = 0
+	case EventProgress:
+		p.Sent += ev.Sent - tp.uploads[id]
+		tp.uploads[id] = ev.Sent
+	case EventRetry:
+		p.Retries++
+	case EventDone:
+		if ev.Size < 0 {
+			// a stream, its size is only known once it ended
+			p.Total += ev.Sent
+		}
+		p.Sent += ev.Sent - tp.uploads[id]
+		p.Done++
+		t.finish(tp, id)
+	case EventSkipped, EventFailed, EventDeferred:
+		// uploads that never started were only counted in Total when it was expected
+		if _, started := tp.uploads[id]; started || tp.expected {
+			p.Total -= size
+		}
+		p.Sent -= tp.uploads[id]
+		switch ev.Type {
+		case EventSkipped:
+			p.Skipped++
+		case EventFailed:
+			p.Failed++
+		default:
+			p.Deferred++
+		}
+		t.finish(tp, id)
+	}
+}
+
+func (t *Tracker) finish(tp *trackedProvider, id string) {
+	if _, ok := tp.uploads[id]; ok {
+		tp.progress.Active--
+		delete(tp.uploads, id)
+	}
+}
+
+// Progress returns the progress of every provider, in the order they were given
+func (t *Tracker) Progress() []ProviderProgress {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	progress := make([]ProviderProgress, len(t.order))
+	for i, p := range t.order {
+		tp := t.providers[p]
+		progress[i] = tp.progress
+		if tp.began.IsZero() {
+			continue
+		}
+		if elapsed := time.Since(tp.began).Seconds(); elapsed > 0 {
+			progress[i].Throughput = float64(tp.progress.Sent) / elapsed
+		}
+		if left := tp.progress.Total - tp.progress.Sent; left > 0 && progress[i].Throughput > 0 {
+			progress[i].ETA = time.Duration(float64(left) / progress[i].Throughput * float64(time.Second))
+		}
+	}
+	return progress
+}
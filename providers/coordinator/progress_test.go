@@ -0,0 +1,149 @@
+package coordinator
+
+import (
+	"bytes"
+	"context"
+	"github.com/stevequadros/uploader/providers"
+	"github.com/stevequadros/uploader/providers/logging"
+	"github.com/stretchr/testify/require"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// retryingUploader reads half the upload, rewinds and reads it all, like a retried request
+type retryingUploader struct {
+	name providers.Provider
+}
+
+func (u *retryingUploader) GetName() providers.Provider { return u.name }
+func (u *retryingUploader) Upload(ctx context.Context, bucket, key string, r io.ReadSeekCloser) error {
+	size, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := io.CopyN(io.Discard, r, size/2); err != nil {
+		return err
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	_, err = io.Copy(io.Discard, r)
+	return err
+}
+
+func TestCoordinator_DoBatchProgress(t *testing.T) {
+	dir := t.TempDir()
+	var batch []BatchFile
+	var total int64
+	for _, name := range []string{"a", "b"} {
+		p := filepath.Join(dir, name)
+		content := make([]byte, 1000*len(batch)+500)
+		require.NoError(t, os.WriteFile(p, content, 0644))
+		batch = append(batch, BatchFile{Path: p, Key: name})
+		total += int64(len(content))
+	}
+
+	uploaders := []providers.Uploader{&retryingUploader{name: "retry"}, &testUploader{name: "fail", wantErr: true}}
+	tracker := NewTracker(uploaders)
+	tracker.Expect(len(batch), total)
+	mu := sync.Mutex{}
+	events := map[providers.Provider][]EventType{}
+	c, _ := NewCoordinator(uploaders, WithProgress(func(ev Event) {
+		tracker.Handle(ev)
+		mu.Lock()
+		defer mu.Unlock()
+		if ev.Type != EventProgress {
+			events[ev.Provider] = append(events[ev.Provider], ev.Type)
+		}
+	}))
+	c.DoBatch(context.Background(), "bucket", batch, 1)
+
+	require.ElementsMatch(t, []EventType{EventStarted, EventRetry, EventDone, EventStarted, EventRetry, EventDone}, events["retry"])
+	require.ElementsMatch(t, []EventType{EventStarted, EventFailed, EventStarted, EventFailed}, events["fail"])
+
+	progress := tracker.Progress()
+	require.Len(t, progress, 2)
+	retry, fail := progress[0], progress[1]
+	require.Equal(t, providers.Provider("retry"), retry.Provider)
+	require.Equal(t, total, retry.Sent)
+	require.Equal(t, total, retry.Total)
+	require.Equal(t, 2, retry.Done)
+	require.Equal(t, 2, retry.Retries)
+	require.Zero(t, retry.Active)
+	require.Zero(t, retry.ETA)
+
+	require.Zero(t, fail.Sent)
+	require.Zero(t, fail.Total)
+	require.Equal(t, 2, fail.Failed)
+	require.Zero(t, fail.Active)
+}
+
+func TestTracker_RejectedWithoutExpect(t *testing.T) {
+	dir := t.TempDir()
+	var batch []BatchFile
+	for _, name := range []string{"a", "b", "c"} {
+		p := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(p, []byte("hello"), 0644))
+		batch = append(batch, BatchFile{Path: p, Key: name})
+	}
+
+	// the breaker rejects the third upload before it starts, it was never added to Total
+	uploaders := []providers.Uploader{&testUploader{name: "fail", wantErr: true}}
+	tracker := NewTracker(uploaders)
+	c, _ := NewCoordinator(uploaders, WithProgress(tracker.Handle), WithCircuitBreaker(BreakerSettings{Failures: 2, Cooldown: time.Hour}))
+	c.DoBatch(context.Background(), "bucket", batch, 1)
+
+	progress := tracker.Progress()[0]
+	require.Equal(t, 3, progress.Failed)
+	require.Equal(t, 2, progress.Files)
+	require.Zero(t, progress.Total)
+	require.Zero(t, progress.Sent)
+}
+
+func TestTracker_Stream(t *testing.T) {
+	tracker := NewTracker(nil)
+	tracker.Handle(Event{Type: EventStarted, Provider: "aws", Key: "key", Size: -1})
+	tracker.Handle(Event{Type: EventProgress, Provider: "aws", Key: "key", Sent: 40, Size: -1})
+	progress := tracker.Progress()
+	require.Equal(t, int64(40), progress[0].Sent)
+	require.Zero(t, progress[0].Total)
+	require.Equal(t, 1, progress[0].Active)
+
+	tracker.Handle(Event{Type: EventDone, Provider: "aws", Key: "key", Sent: 100, Size: -1})
+	progress = tracker.Progress()
+	require.Equal(t, int64(100), progress[0].Sent)
+	require.Equal(t, int64(100), progress[0].Total)
+	require.Equal(t, 1, progress[0].Done)
+	require.Zero(t, progress[0].Active)
+}
+
+func TestCoordinator_DoLogs(t *testing.T) {
+	out := bytes.Buffer{}
+	log := logging.New(logging.NewTextHandler(&out, logging.HandlerOptions{Level: logging.LevelInfo}))
+	uploaders := []providers.Uploader{&retryingUploader{name: "retry"}, &testUploader{name: "fail", wantErr: true}}
+	c, _ := NewCoordinator(uploaders, WithLogger(log), WithCircuitBreaker(BreakerSettings{Failures: 1}))
+
+	f, err := os.CreateTemp(t.TempDir(), "upload")
+	require.NoError(t, err)
+	defer f.Close()
+	_, err = f.WriteString("hello")
+	require.NoError(t, err)
+	_, err = f.Seek(0, io.SeekStart)
+	require.NoError(t, err)
+	_, err = c.Do(context.Background(), "bucket", "key", f)
+	require.Error(t, err)
+
+	logs := out.String()
+	require.Contains(t, logs, `msg="upload retried" provider=retry bucket=bucket key=key attempt=2`)
+	require.Contains(t, logs, `msg="upload done" provider=retry bucket=bucket key=key attempt=2 size=5`)
+	require.Contains(t, logs, `msg="upload failed" provider=fail bucket=bucket key=key attempt=1`)
+	require.Contains(t, logs, `msg="circuit breaker open, failing uploads at once" provider=fail`)
+	require.NotContains(t, logs, "upload started", "started is logged at debug")
+}
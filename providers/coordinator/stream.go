@@ -0,0 +1,217 @@
+package coordinator
+
+import (
+	"context"
+	"errors"
+	"github.com/stevequadros/uploader/providers"
+	"go.opentelemetry.io/otel/attribute"
+	"io"
+	"sync"
+)
+
+const (
+	// DefaultStreamChunkSize is the size of the chunks DoStream reads and hands to providers
+	DefaultStreamChunkSize = 1 << 20
+	// DefaultStreamChunks is how many chunks DoStream queues for each provider
+	DefaultStreamChunks = 8
+)
+
+var errStreamNotSupported = errors.New("streaming uploads not supported")
+
+// DoStream uploads reader to every uploader at once without seeking or knowing its size, for
+// stdin and other pipes. Chunks are shared by every provider and each provider queues a
+// bounded number of them, so a slow provider holds back reading from reader instead of the
+// stream piling up in memory or on disk. A provider that fails stops receiving chunks while
+// the others carry on, a failed read from reader fails every upload
+func (c *Coordinator) DoStream(ctx context.Context, bucket, key string, reader io.Reader) (DoResult, error) {
+	return c.DoStreamTo(ctx, bucket, ProviderKeys{}.with(c.uploaders, key), reader)
+}
+
+// DoStreamTo is DoStream with a key for each provider, providers missing from keys fail
+func (c *Coordinator) DoStreamTo(ctx context.Context, bucket string, keys ProviderKeys, reader io.Reader) (DoResult, error) {
+	ctx, span := c.startSpan(ctx, "coordinator.DoStream", attribute.String("bucket", bucket))
+	res, err := c.doStream(ctx, bucket, keys, reader)
+	endCall(span, res, err)
+	return res, err
+}
+
+func (c *Coordinator) doStream(ctx context.Context, bucket string, keys ProviderKeys, reader io.Reader) (DoResult, error) {
+	ctx, cancel := c.inFlight(ctx)
+	defer cancel()
+	chunkSize, chunks := c.streamChunkSize, c.streamChunks
+	if chunkSize <= 0 {
+		chunkSize = DefaultStreamChunkSize
+	}
+	if chunks <= 0 {
+		chunks = DefaultStreamChunks
+	}
+
+	type outcome struct {
+		uploader providers.Uploader
+		err      error
+	}
+	results := make(chan outcome, len(c.uploaders))
+	var pipes []*streamPipe
+	wg := sync.WaitGroup{}
+	for _, u := range c.uploaders {
+		su, ok := u.(providers.StreamUploader)
+		if !ok {
+			results <- outcome{u, errStreamNotSupported}
+			continue
+		}
+		key, ok := keys[u.GetName()]
+		if !ok {
+			results <- outcome{u, errNoKey}
+			continue
+		}
+		b := c.breakers[u.GetName()]
+		if err := c.allow(b); err != nil {
+			results <- outcome{u, err}
+			continue
+		}
+		pipe := newStreamPipe(chunks)
+		pipes = append(pipes, pipe)
+		wg.Add(1)
+		go func(u providers.Uploader, key string) {
+			defer wg.Done()
+			l := c.limit(ctx, u)
+			c.track(l, Event{Provider: u.GetName(), Bucket: bucket, Key: key, Size: -1})
+			err := su.UploadStream(l.ctx, bucket, key, l.stream(pipe), providers.UploadOptions{})
+			if err == nil && !pipe.eof {
+				err = errors.New("upload finished before the end of the stream")
+			}
+			pipe.stop()
+			err = l.done(err)
+			c.record(b, err, ctx.Err() != nil)
+			results <- outcome{u, err}
+		}(u, key)
+	}
+
+	var hasher *providers.Hasher
+	if c.verify {
+		// hashed as it is read, the stream can't be read twice
+		hasher = providers.NewHasher()
+		reader = io.TeeReader(reader, hasher)
+	}
+	size, readErr := fanOut(ctx, reader, pipes, chunkSize)
+	for _, p := range pipes {
+		p.finish(readErr)
+	}
+	wg.Wait()
+	close(results)
+
+	doResult := DoResult{Size: size, Breakers: c.Breakers()}
+	if hasher != nil {
+		doResult.Checksums, doResult.Verified = hasher.Sum(), map[providers.Provider]providers.Checksums{}
+		doResult.Versions = map[providers.Provider]string{}
+	}
+	for o := range results {
+		p, err := o.uploader.GetName(), o.err
+		if err == nil && hasher != nil {
+			var verified providers.Checksums
+			var version string
+			if verified, version, err = verifyUpload(ctx, o.uploader, bucket, keys[p], doResult.Checksums, size); err == nil {
+				doResult.Verified[p] = verified
+				if version != "" {
+					doResult.Versions[p] = version
+				}
+			}
+		}
+		if err != nil {
+			doResult.Failed = append(doResult.Failed, DoError{p, err})
+			c.emitResult(Event{Provider: p, Bucket: bucket, Key: keys[p], Size: -1}, false, false, err)
+		} else {
+			doResult.Done = append(doResult.Done, p)
+			// the stream's size is only known now
+			c.emit(Event{Type: EventDone, Provider: p, Bucket: bucket, Key: keys[p], Attempt: 1, Sent: size, Size: -1})
+		}
+	}
+
+	if len(doResult.Failed) == len(c.uploaders) {
+		return doResult, errors.New("all uploads Failed")
+	} else if len(doResult.Failed) > 0 {
+		return doResult, errors.New("some uploads Failed")
+	}
+	return doResult, nil
+}
+
+// fanOut reads reader in chunks and queues each chunk on every pipe still uploading, it stops
+// early once no upload is left to feed
+func fanOut(ctx context.Context, reader io.Reader, pipes []*streamPipe, chunkSize int) (int64, error) {
+	var size int64
+	active := len(pipes)
+	for active > 0 {
+		buf := make([]byte, chunkSize)
+		n, err := io.ReadFull(reader, buf)
+		if n > 0 {
+			size += int64(n)
+			chunk := buf[:n]
+			for _, p := range pipes {
+				if p.stopped {
+					continue
+				}
+				select {
+				case p.chunks <- chunk:
+				case <-p.done:
+					p.stopped = true
+					active--
+				case <-ctx.Done():
+					return size, ctx.Err()
+				}
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return size, nil
+		}
+		if err != nil {
+			return size, err
+		}
+	}
+	return size, nil
+}
+
+// streamPipe is the reader an upload sees, fed with chunks by fanOut
+type streamPipe struct {
+	chunks chan []byte
+	// done is closed once the upload returns, so fanOut stops queueing for it
+	done     chan struct{}
+	stopOnce sync.Once
+	// stopped is only used by fanOut
+	stopped bool
+
+	cur []byte
+	// err is set before chunks is closed, eof is only used by the upload's goroutine
+	err error
+	eof bool
+}
+
+func newStreamPipe(chunks int) *streamPipe {
+	return &streamPipe{chunks: make(chan []byte, chunks), done: make(chan struct{})}
+}
+
+func (p *streamPipe) Read(b []byte) (int, error) {
+	for len(p.cur) == 0 {
+		chunk, ok := <-p.chunks
+		if !ok {
+			if p.err != nil {
+				return 0, p.err
+			}
+			p.eof = true
+			return 0, io.EOF
+		}
+		p.cur = chunk
+	}
+	n := copy(b, p.cur)
+	p.cur = p.cur[n:]
+	return n, nil
+}
+
+func (p *streamPipe) stop() {
+	p.stopOnce.Do(func() { close(p.done) })
+}
+
+// finish ends the stream, with err when reading the source failed
+func (p *streamPipe) finish(err error) {
+	p.err = err
+	close(p.chunks)
+}
@@ -0,0 +1,126 @@
+package coordinator
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/stevequadros/uploader/providers"
+	"github.com/stretchr/testify/require"
+	"io"
+	"math/rand"
+	"sort"
+	"sync/atomic"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+// streamUploader reads the whole stream, failing after failAfter bytes when it is set
+type streamUploader struct {
+	testUploader
+	got       []byte
+	failAfter int
+	// release, when set, holds the upload back before it reads anything
+	release chan struct{}
+}
+
+func (u *streamUploader) UploadStream(ctx context.Context, bucket, key string, r io.Reader, opts providers.UploadOptions) error {
+	if u.release != nil {
+		<-u.release
+	}
+	if u.failAfter > 0 {
+		_, _ = io.CopyN(io.Discard, r, int64(u.failAfter))
+		return errors.New("connection reset")
+	}
+	b, err := io.ReadAll(r)
+	u.got = b
+	return err
+}
+
+// countingReader counts the bytes read from it
+type countingReader struct {
+	r    io.Reader
+	read int64
+}
+
+func (c *countingReader) Read(b []byte) (int, error) {
+	n, err := c.r.Read(b)
+	atomic.AddInt64(&c.read, int64(n))
+	return n, err
+}
+
+func TestCoordinator_DoStream(t *testing.T) {
+	content := make([]byte, 1<<20+123)
+	rand.New(rand.NewSource(1)).Read(content)
+
+	ok1, ok2 := &streamUploader{testUploader: testUploader{name: "1"}}, &streamUploader{testUploader: testUploader{name: "2"}}
+	failing := &streamUploader{testUploader: testUploader{name: "3"}, failAfter: 1000}
+	plain := &testUploader{name: "4"}
+	c, err := NewCoordinator([]providers.Uploader{ok1, ok2, failing, plain}, WithStreamBuffer(4096, 4))
+	require.NoError(t, err)
+
+	// a plain reader, neither seekable nor sized
+	res, err := c.DoStream(context.Background(), "bucket", "key", io.MultiReader(bytes.NewReader(content)))
+	require.Error(t, err)
+	require.Equal(t, int64(len(content)), res.Size)
+	require.Equal(t, content, ok1.got)
+	require.Equal(t, content, ok2.got)
+
+	sort.Slice(res.Failed, func(i, j int) bool { return res.Failed[i].Provider < res.Failed[j].Provider })
+	require.ElementsMatch(t, []providers.Provider{"1", "2"}, res.Done)
+	require.Len(t, res.Failed, 2)
+	require.Equal(t, providers.Provider("3"), res.Failed[0].Provider)
+	require.ErrorIs(t, res.Failed[1].Error, errStreamNotSupported)
+}
+
+func TestCoordinator_DoStreamReadErrorFailsEveryUpload(t *testing.T) {
+	u1, u2 := &streamUploader{testUploader: testUploader{name: "1"}}, &streamUploader{testUploader: testUploader{name: "2"}}
+	c, err := NewCoordinator([]providers.Uploader{u1, u2}, WithStreamBuffer(16, 2))
+	require.NoError(t, err)
+
+	broken := io.MultiReader(bytes.NewReader(make([]byte, 100)), iotest.ErrReader(errors.New("pipe broke")))
+	res, err := c.DoStream(context.Background(), "bucket", "key", broken)
+	require.Error(t, err)
+	require.Empty(t, res.Done)
+	for _, f := range res.Failed {
+		require.EqualError(t, f.Error, "pipe broke")
+	}
+}
+
+func TestCoordinator_DoStreamBackpressure(t *testing.T) {
+	chunkSize, chunks := 1024, 4
+	slow := &streamUploader{testUploader: testUploader{name: "slow"}, release: make(chan struct{})}
+	fast := &streamUploader{testUploader: testUploader{name: "fast"}}
+	c, err := NewCoordinator([]providers.Uploader{slow, fast}, WithStreamBuffer(chunkSize, chunks))
+	require.NoError(t, err)
+
+	source := &countingReader{r: bytes.NewReader(make([]byte, 1<<20))}
+	done := make(chan DoResult)
+	go func() {
+		res, _ := c.DoStream(context.Background(), "bucket", "key", source)
+		done <- res
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	// the queued chunks plus the one being handed out
+	require.LessOrEqual(t, atomic.LoadInt64(&source.read), int64((chunks+1)*chunkSize))
+
+	close(slow.release)
+	res := <-done
+	require.Len(t, res.Done, 2)
+	require.Len(t, slow.got, 1<<20)
+}
+
+func TestCoordinator_DoStreamVerify(t *testing.T) {
+	good, bad := &checksumStore{name: "good"}, &checksumStore{name: "bad", corrupt: true}
+	c, _ := NewCoordinator([]providers.Uploader{good, bad}, WithVerify(), WithStreamBuffer(4, 2))
+
+	res, err := c.DoStream(context.Background(), "bucket", "key", iotest.OneByteReader(bytes.NewReader([]byte("hello world"))))
+	require.Error(t, err)
+	require.Equal(t, int64(11), res.Size)
+	require.Equal(t, "b94d27b9934d3e08a52e52d7da7dabfac484efe37a5380ee9088f7ace2efcde9", res.Checksums.SHA256)
+	require.Equal(t, []providers.Provider{"good"}, res.Done)
+	require.Equal(t, res.Checksums.MD5, res.Verified["good"].MD5)
+	require.Equal(t, []providers.Checksums{{}}, good.sent, "a stream's checksums aren't known up front")
+	require.True(t, errors.Is(res.Failed[0].Error, providers.ErrChecksumMismatch))
+}
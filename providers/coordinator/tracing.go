@@ -0,0 +1,101 @@
+package coordinator
+
+import (
+	"context"
+	"fmt"
+	"github.com/stevequadros/uploader/providers/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// WithTracerProvider traces Do, DoBatch and DoStream with tp: a span for the call with a child
+// for every destination upload, itself the parent of the provider's bucket checks, parts and
+// requests, and of a span for every retry
+func WithTracerProvider(tp trace.TracerProvider) Option {
+	return func(c *Coordinator) {
+		c.tracer = tp.Tracer(tracing.Name)
+	}
+}
+
+// startSpan starts a span of c's tracer as a child of the one in ctx, nothing is recorded
+// without WithTracerProvider
+func (c *Coordinator) startSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	if c.tracer == nil {
+		return ctx, trace.SpanFromContext(context.Background())
+	}
+	return c.tracer.Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// endCall ends the span of a Do or DoStream call with how its uploads ended
+func endCall(span trace.Span, res DoResult, err error) {
+	span.SetAttributes(
+		attribute.Int("done", len(res.Done)),
+		attribute.Int("skipped", len(res.Skipped)),
+		attribute.Int("failed", len(res.Failed)),
+		attribute.Int("deferred", len(res.Deferred)),
+	)
+	tracing.End(span, err)
+}
+
+// endBatch ends the span of a DoBatch call with how its files ended
+func endBatch(span trace.Span, res BatchResult) {
+	span.SetAttributes(attribute.Int("failed", res.Failed()), attribute.Int("deferred", res.Deferred()))
+	if res.Failed() > 0 {
+		span.SetStatus(codes.Error, fmt.Sprintf("%d of %d files failed", res.Failed(), len(res.Files)))
+	}
+	span.End()
+}
+
+// traceUpload starts the span of the upload ev describes, the parent of what l.ctx is used for
+// from then on
+func (c *Coordinator) traceUpload(l *limits, ev Event) trace.Span {
+	attrs := []attribute.KeyValue{
+		attribute.String("provider", string(ev.Provider)),
+		attribute.String("bucket", ev.Bucket),
+		attribute.String("key", ev.Key),
+	}
+	if ev.Path != "" {
+		attrs = append(attrs, attribute.String("path", ev.Path))
+	}
+	if ev.Size >= 0 {
+		attrs = append(attrs, attribute.Int64("size", ev.Size))
+	}
+	var span trace.Span
+	l.ctx, span = c.startSpan(l.ctx, "upload "+string(ev.Provider), attrs...)
+	return span
+}
+
+// retried starts the span of a retry, ending the previous retry's. It runs with u.mu held
+func (u *uploadProgress) retried() {
+	if u.c.tracer == nil {
+		return
+	}
+	if u.retry != nil {
+		u.retry.End()
+	}
+	_, u.retry = u.c.startSpan(trace.ContextWithSpan(context.Background(), u.span), "retry",
+		attribute.Int("attempt", u.event.Attempt),
+		attribute.Int64("sent", u.sent),
+	)
+	u.span.AddEvent("retry", trace.WithAttributes(attribute.Int("attempt", u.event.Attempt)))
+}
+
+// finish ends the spans of the upload, failed with err when there is one
+func (u *uploadProgress) finish(err error) {
+	if u == nil {
+		return
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.span == nil {
+		return
+	}
+	if u.retry != nil {
+		tracing.End(u.retry, err)
+		u.retry = nil
+	}
+	u.span.SetAttributes(attribute.Int("attempts", u.event.Attempt), attribute.Int64("sent", u.sent))
+	tracing.End(u.span, err)
+	u.span = nil
+}
@@ -0,0 +1,55 @@
+package coordinator
+
+import (
+	"context"
+	"github.com/stevequadros/uploader/providers"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"io"
+	"os"
+	"testing"
+)
+
+func TestCoordinator_DoTraces(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	uploaders := []providers.Uploader{&retryingUploader{name: "retry"}, &testUploader{name: "fail", wantErr: true}}
+	c, _ := NewCoordinator(uploaders, WithTracerProvider(tp))
+
+	f, err := os.CreateTemp(t.TempDir(), "upload")
+	require.NoError(t, err)
+	defer f.Close()
+	_, err = f.WriteString("hello")
+	require.NoError(t, err)
+	_, err = f.Seek(0, io.SeekStart)
+	require.NoError(t, err)
+	_, err = c.Do(context.Background(), "bucket", "key", f)
+	require.Error(t, err)
+
+	spans := map[string]tracetest.SpanStub{}
+	for _, s := range exporter.GetSpans() {
+		spans[s.Name] = s
+	}
+	require.Len(t, spans, 4)
+	do, retry, fail, retried := spans["coordinator.Do"], spans["upload retry"], spans["upload fail"], spans["retry"]
+	require.False(t, do.Parent.IsValid())
+	require.Equal(t, codes.Error, do.Status.Code)
+	require.Equal(t, do.SpanContext.SpanID(), retry.Parent.SpanID())
+	require.Equal(t, do.SpanContext.SpanID(), fail.Parent.SpanID())
+	require.Equal(t, retry.SpanContext.SpanID(), retried.Parent.SpanID())
+	require.Equal(t, codes.Unset, retry.Status.Code)
+	require.Equal(t, codes.Error, fail.Status.Code)
+	require.Len(t, retry.Events, 1)
+	require.Equal(t, do.SpanContext.TraceID(), retried.SpanContext.TraceID())
+}
+
+func TestCoordinator_DoUntraced(t *testing.T) {
+	c, _ := NewCoordinator([]providers.Uploader{&testUploader{name: "ok"}})
+	ctx, span := c.startSpan(context.Background(), "coordinator.Do")
+	require.False(t, span.IsRecording())
+	require.Equal(t, context.Background(), ctx)
+	_, err := c.Do(context.Background(), "bucket", "key", readerSeekerCloser{})
+	require.NoError(t, err)
+}
@@ -0,0 +1,262 @@
+package doctor
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/stevequadros/uploader/config"
+	"github.com/stevequadros/uploader/providers"
+	"github.com/stevequadros/uploader/providers/initializer"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+type Step string
+
+const (
+	StepCredentials Step = "credentials"
+	StepBucket      Step = "bucket"
+	StepWrite       Step = "write"
+	StepRead        Step = "read"
+	StepDelete      Step = "delete"
+)
+
+// Steps lists every check in the order they run, a failed step skips the ones after it
+var Steps = []Step{StepCredentials, StepBucket, StepWrite, StepRead, StepDelete}
+
+// ProbePrefix is where probe objects are written, they are deleted again by the delete step
+const ProbePrefix = ".uploader-doctor/"
+
+var errNotSupported = errors.New("not supported by provider")
+
+type StepResult struct {
+	Step    Step   `json:"step"`
+	OK      bool   `json:"ok"`
+	Skipped bool   `json:"skipped,omitempty"`
+	Error   string `json:"error,omitempty"`
+	Hint    string `json:"hint,omitempty"`
+}
+
+type ProviderReport struct {
+	Provider providers.Provider `json:"provider"`
+	Steps    []StepResult       `json:"steps"`
+	Duration time.Duration      `json:"duration"`
+}
+
+// OK reports whether every step passed
+func (r ProviderReport) OK() bool {
+	for _, s := range r.Steps {
+		if !s.OK {
+			return false
+		}
+	}
+	return len(r.Steps) > 0
+}
+
+// Step returns the result for step s
+func (r ProviderReport) Step(s Step) StepResult {
+	for _, res := range r.Steps {
+		if res.Step == s {
+			return res
+		}
+	}
+	return StepResult{Step: s, Skipped: true}
+}
+
+type Report struct {
+	Bucket    string           `json:"bucket"`
+	Providers []ProviderReport `json:"providers"`
+}
+
+// OK reports whether every provider passed every step
+func (r Report) OK() bool {
+	for _, p := range r.Providers {
+		if !p.OK() {
+			return false
+		}
+	}
+	return true
+}
+
+// Run resolves the credentials of every provider in cfg, builds an uploader for each with
+// initializer.Init and checks it. Providers are handled separately so one provider's bad
+// credentials don't hide the others, cfg may come from config.NewUnresolved
+func Run(ctx context.Context, cfg config.Config, bucket string) Report {
+	var single []config.Config
+	var names []providers.Provider
+	if cfg.AWS != nil {
+		single, names = append(single, config.Config{AWS: cfg.AWS}), append(names, providers.AWS)
+	}
+	if cfg.Azure != nil {
+		single, names = append(single, config.Config{Azure: cfg.Azure}), append(names, providers.Azure)
+	}
+	if cfg.GCP != nil {
+		single, names = append(single, config.Config{GCP: cfg.GCP}), append(names, providers.GCP)
+	}
+
+	report := Report{Bucket: bucket, Providers: make([]ProviderReport, len(single))}
+	wg := sync.WaitGroup{}
+	for i := range single {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			start := time.Now()
+			uploaders, err := initProvider(ctx, single[i])
+			if err != nil {
+				report.Providers[i] = failedReport(names[i], StepCredentials, err)
+				report.Providers[i].Duration = time.Since(start)
+				return
+			}
+			report.Providers[i] = Check(ctx, uploaders[0], bucket)
+			report.Providers[i].Duration = time.Since(start)
+		}(i)
+	}
+	wg.Wait()
+	return report
+}
+
+func initProvider(ctx context.Context, cfg config.Config) ([]providers.Uploader, error) {
+	if err := cfg.ResolveSecrets(config.NewSecretResolver()); err != nil {
+		return nil, err
+	}
+	for _, check := range cfg.CheckCredentials() {
+		if check.Err != nil {
+			return nil, check.Err
+		}
+	}
+	return initializer.Init(ctx, cfg, nil)
+}
+
+// CheckAll runs Check against already built uploaders concurrently
+func CheckAll(ctx context.Context, uploaders []providers.Uploader, bucket string) Report {
+	report := Report{Bucket: bucket, Providers: make([]ProviderReport, len(uploaders))}
+	wg := sync.WaitGroup{}
+	for i, u := range uploaders {
+		wg.Add(1)
+		go func(i int, u providers.Uploader) {
+			defer wg.Done()
+			report.Providers[i] = Check(ctx, u, bucket)
+		}(i, u)
+	}
+	wg.Wait()
+	return report
+}
+
+// Check verifies an uploader can reach bucket, creating it if needed, and write, read back and
+// delete a small probe object. Credentials are considered resolved since the uploader was built
+func Check(ctx context.Context, u providers.Uploader, bucket string) ProviderReport {
+	start := time.Now()
+	p := u.GetName()
+	report := ProviderReport{Provider: p}
+	probe, key := newProbe()
+
+	steps := []struct {
+		step Step
+		run  func() error
+	}{
+		{StepCredentials, func() error { return nil }},
+		{StepBucket, func() error {
+			ensurer, ok := u.(providers.BucketEnsurer)
+			if !ok {
+				return errNotSupported
+			}
+			return ensurer.EnsureBucket(ctx, bucket)
+		}},
+		{StepWrite, func() error {
+			return u.Upload(ctx, bucket, key, nopCloser{bytes.NewReader(probe)})
+		}},
+		{StepRead, func() error {
+			downloader, ok := u.(providers.Downloader)
+			if !ok {
+				return errNotSupported
+			}
+			r, err := downloader.Download(ctx, bucket, key)
+			if err != nil {
+				return err
+			}
+			defer r.Close()
+			got, err := io.ReadAll(r)
+			if err != nil {
+				return err
+			}
+			if !bytes.Equal(got, probe) {
+				return fmt.Errorf("probe object read back %d bytes that differ from the %d written", len(got), len(probe))
+			}
+			return nil
+		}},
+		{StepDelete, func() error {
+			deleter, ok := u.(providers.Deleter)
+			if !ok {
+				return errNotSupported
+			}
+			return deleter.Delete(ctx, bucket, key)
+		}},
+	}
+
+	failed, written := false, false
+	for _, s := range steps {
+		// once the probe is written it is deleted whatever failed since, not left in the bucket
+		if failed && !(s.step == StepDelete && written) {
+			report.Steps = append(report.Steps, StepResult{Step: s.step, Skipped: true})
+			continue
+		}
+		if err := s.run(); err != nil {
+			failed = true
+			report.Steps = append(report.Steps, StepResult{Step: s.step, Error: err.Error(), Hint: Hint(p, s.step, err)})
+			continue
+		}
+		written = written || s.step == StepWrite
+		report.Steps = append(report.Steps, StepResult{Step: s.step, OK: true})
+	}
+	report.Duration = time.Since(start)
+	return report
+}
+
+func failedReport(p providers.Provider, step Step, err error) ProviderReport {
+	report := ProviderReport{Provider: p}
+	failed := false
+	for _, s := range Steps {
+		switch {
+		case failed:
+			report.Steps = append(report.Steps, StepResult{Step: s, Skipped: true})
+		case s == step:
+			failed = true
+			report.Steps = append(report.Steps, StepResult{Step: s, Error: err.Error(), Hint: Hint(p, s, err)})
+		default:
+			report.Steps = append(report.Steps, StepResult{Step: s, OK: true})
+		}
+	}
+	return report
+}
+
+// Handler serves CheckAll as json for health endpoints, with status 200 when every provider
+// passes and 503 otherwise
+func Handler(uploaders []providers.Uploader, bucket string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		report := CheckAll(r.Context(), uploaders, bucket)
+		w.Header().Set("Content-Type", "application/json")
+		if !report.OK() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		_ = json.NewEncoder(w).Encode(report)
+	})
+}
+
+func newProbe() (content []byte, key string) {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	id := hex.EncodeToString(b)
+	return []byte("uploader doctor probe " + id + "\n"), ProbePrefix + id
+}
+
+type nopCloser struct {
+	io.ReadSeeker
+}
+
+func (nopCloser) Close() error { return nil }
@@ -0,0 +1,156 @@
+package doctor
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/stevequadros/uploader/config"
+	"github.com/stevequadros/uploader/providers"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// memUploader stores objects in memory, failing the operations named in fail
+type memUploader struct {
+	name    providers.Provider
+	objects map[string][]byte
+	fail    map[Step]error
+	corrupt bool
+}
+
+var _ providers.Uploader = (*memUploader)(nil)
+var _ providers.BucketEnsurer = (*memUploader)(nil)
+var _ providers.Downloader = (*memUploader)(nil)
+var _ providers.Deleter = (*memUploader)(nil)
+
+func newMemUploader(name providers.Provider, fail map[Step]error) *memUploader {
+	return &memUploader{name: name, objects: map[string][]byte{}, fail: fail}
+}
+
+func (u *memUploader) GetName() providers.Provider { return u.name }
+
+func (u *memUploader) EnsureBucket(ctx context.Context, bucket string) error {
+	return u.fail[StepBucket]
+}
+
+func (u *memUploader) Upload(ctx context.Context, bucket, key string, r io.ReadSeekCloser) error {
+	if err := u.fail[StepWrite]; err != nil {
+		return err
+	}
+	b, err := io.ReadAll(r)
+	if u.corrupt {
+		b = append(b, 'x')
+	}
+	u.objects[bucket+"/"+key] = b
+	return err
+}
+
+func (u *memUploader) Download(ctx context.Context, bucket, key string) (io.ReadCloser, error) {
+	if err := u.fail[StepRead]; err != nil {
+		return nil, err
+	}
+	return io.NopCloser(bytes.NewReader(u.objects[bucket+"/"+key])), nil
+}
+
+func (u *memUploader) Delete(ctx context.Context, bucket, key string) error {
+	if err := u.fail[StepDelete]; err != nil {
+		return err
+	}
+	delete(u.objects, bucket+"/"+key)
+	return nil
+}
+
+// writeOnly supports nothing beyond the Uploader interface
+type writeOnly struct{}
+
+func (writeOnly) GetName() providers.Provider { return "writeonly" }
+func (writeOnly) Upload(ctx context.Context, bucket, key string, r io.ReadSeekCloser) error {
+	return nil
+}
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		name       string
+		uploader   providers.Uploader
+		failedStep Step
+	}{
+		{"all steps pass", newMemUploader(providers.AWS, nil), ""},
+		{"bucket failure skips the rest", newMemUploader(providers.AWS, map[Step]error{StepBucket: errors.New("AccessDenied")}), StepBucket},
+		{"write failure", newMemUploader(providers.GCP, map[Step]error{StepWrite: errors.New("boom")}), StepWrite},
+		{"delete failure", newMemUploader(providers.Azure, map[Step]error{StepDelete: errors.New("boom")}), StepDelete},
+		{"corrupted read back fails", &memUploader{name: providers.AWS, objects: map[string][]byte{}, corrupt: true}, StepRead},
+		{"unsupported checks fail", writeOnly{}, StepBucket},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			report := Check(context.Background(), tt.uploader, "bucket")
+			require.Len(t, report.Steps, len(Steps))
+			require.Equal(t, tt.failedStep == "", report.OK())
+
+			failed := false
+			for _, s := range report.Steps {
+				switch {
+				case failed && s.Step == StepDelete && tt.failedStep == StepRead:
+					require.True(t, s.OK, "the probe is deleted after a failed read")
+				case failed:
+					require.True(t, s.Skipped, s.Step)
+				case s.Step == tt.failedStep:
+					failed = true
+					require.False(t, s.OK)
+					require.NotEmpty(t, s.Error)
+					require.NotEmpty(t, s.Hint)
+				default:
+					require.True(t, s.OK, s.Step)
+				}
+			}
+		})
+	}
+}
+
+func TestCheckCleansUpProbe(t *testing.T) {
+	u := newMemUploader(providers.AWS, nil)
+	require.True(t, Check(context.Background(), u, "bucket").OK())
+	require.Empty(t, u.objects)
+
+	u = newMemUploader(providers.AWS, map[Step]error{StepRead: errors.New("boom")})
+	report := Check(context.Background(), u, "bucket")
+	require.False(t, report.OK())
+	require.Equal(t, StepResult{Step: StepDelete, OK: true}, report.Steps[len(report.Steps)-1])
+	require.Empty(t, u.objects, "the probe is deleted after a failed read")
+}
+
+func TestRunReportsCredentialFailuresPerProvider(t *testing.T) {
+	cfg := config.Config{GCP: config.NewGCP("/does/not/exist.json")}
+	report := Run(context.Background(), cfg, "bucket")
+	require.Len(t, report.Providers, 1)
+	require.False(t, report.OK())
+	require.Equal(t, providers.GCP, report.Providers[0].Provider)
+	require.False(t, report.Providers[0].Step(StepCredentials).OK)
+	require.True(t, report.Providers[0].Step(StepBucket).Skipped)
+}
+
+func TestHandler(t *testing.T) {
+	healthy := Handler([]providers.Uploader{newMemUploader(providers.AWS, nil)}, "bucket")
+	rec := httptest.NewRecorder()
+	healthy.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/health", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	unhealthy := Handler([]providers.Uploader{
+		newMemUploader(providers.AWS, nil),
+		newMemUploader(providers.GCP, map[Step]error{StepWrite: errors.New("boom")}),
+	}, "bucket")
+	rec = httptest.NewRecorder()
+	unhealthy.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/health", nil))
+	require.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	require.Contains(t, rec.Body.String(), `"provider":"gcp"`)
+}
+
+func TestHint(t *testing.T) {
+	require.Contains(t, Hint(providers.AWS, StepWrite, errors.New("AccessDenied: denied")), "s3:PutObject")
+	require.Contains(t, Hint(providers.Azure, StepBucket, errors.New("AuthenticationFailed")), "azure account name and key")
+	require.Contains(t, Hint(providers.GCP, StepCredentials, errors.New("no file")), "config validate")
+}
@@ -0,0 +1,79 @@
+package doctor
+
+import (
+	"errors"
+	"github.com/stevequadros/uploader/providers"
+	"strings"
+)
+
+// permissions each step needs, by provider
+var permissions = map[providers.Provider]map[Step]string{
+	providers.AWS: {
+		StepBucket: "s3:ListBucket and s3:CreateBucket",
+		StepWrite:  "s3:PutObject",
+		StepRead:   "s3:GetObject",
+		StepDelete: "s3:DeleteObject",
+	},
+	providers.GCP: {
+		StepBucket: "storage.buckets.get and storage.buckets.create",
+		StepWrite:  "storage.objects.create",
+		StepRead:   "storage.objects.get",
+		StepDelete: "storage.objects.delete",
+	},
+	providers.Azure: {
+		StepBucket: "container read and create (Storage Blob Data Contributor)",
+		StepWrite:  "blob write (Storage Blob Data Contributor)",
+		StepRead:   "blob read (Storage Blob Data Reader)",
+		StepDelete: "blob delete (Storage Blob Data Contributor)",
+	},
+}
+
+var credentialHints = map[providers.Provider]string{
+	providers.AWS:   "check the aws credentials filename and profile in the config",
+	providers.GCP:   "check the gcp credentials file is a valid service account json",
+	providers.Azure: "check the azure account name and key in the config",
+}
+
+// authMarkers are fragments of provider error messages that mean the credentials were rejected
+var authMarkers = []string{
+	"InvalidAccessKeyId", "SignatureDoesNotMatch", "ExpiredToken", "NoCredentialProviders",
+	"AuthenticationFailed", "invalid_grant", "401",
+}
+
+var deniedMarkers = []string{"AccessDenied", "AuthorizationPermissionMismatch", "AuthorizationFailure", "403"}
+
+// Hint suggests a fix for step failing on provider p with err
+func Hint(p providers.Provider, step Step, err error) string {
+	if errors.Is(err, errNotSupported) {
+		return "this provider does not support the check, upgrade the uploader"
+	}
+
+	msg := err.Error()
+	switch {
+	case step == StepCredentials:
+		return credentialHints[p] + ", `uploader config validate` checks them without contacting " + string(p)
+	case containsAny(msg, authMarkers):
+		return "credentials were rejected: " + credentialHints[p]
+	case containsAny(msg, deniedMarkers):
+		return "credentials are valid but lack permission, grant " + permissions[p][step]
+	case step == StepBucket && containsAny(msg, []string{"BucketAlreadyExists", "ContainerBeingDeleted", "409"}):
+		return "bucket names are global and this one is taken or being deleted, choose another -bucket"
+	case step == StepBucket && containsAny(msg, []string{"InvalidBucketName", "InvalidResourceName", "Invalid bucket name"}):
+		return "bucket name is invalid for " + string(p) + ", use 3-63 lowercase letters, digits and hyphens"
+	case containsAny(msg, []string{"timeout", "no such host", "connection refused", "deadline exceeded"}):
+		return "could not reach " + string(p) + ", check network access and proxies"
+	case step == StepRead:
+		return "object was written but could not be read back, check " + permissions[p][step] + " and bucket consistency settings"
+	default:
+		return "check the credentials have " + permissions[p][step]
+	}
+}
+
+func containsAny(s string, markers []string) bool {
+	for _, m := range markers {
+		if strings.Contains(s, m) {
+			return true
+		}
+	}
+	return false
+}
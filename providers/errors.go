@@ -0,0 +1,267 @@
+package providers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// Class sorts provider errors by what can be done about them
+type Class int
+
+const (
+	// ClassUnknown errors couldn't be sorted, ex: an interrupted upload
+	ClassUnknown Class = iota
+	// ClassAuth errors come from credentials that are wrong, expired or lack a permission
+	ClassAuth
+	// ClassNotFound errors name a bucket or object that doesn't exist
+	ClassNotFound
+	// ClassThrottled errors ask to slow down, the request may succeed later
+	ClassThrottled
+	// ClassTransient errors are network failures and server errors, the request may succeed
+	// if sent again
+	ClassTransient
+	// ClassConflict errors come from the state of the bucket or object, ex: a bucket name taken
+	// by another account or a blob under lease
+	ClassConflict
+	// ClassQuota errors come from a storage or usage quota being exhausted
+	ClassQuota
+	// ClassInvalidInput errors reject the request itself, ex: an invalid key or bucket name
+	ClassInvalidInput
+)
+
+// Errors of every class, errors.Is matches an Error with the one of its class
+var (
+	ErrAuth         = errors.New("not authorized")
+	ErrThrottled    = errors.New("throttled")
+	ErrTransient    = errors.New("transient failure")
+	ErrConflict     = errors.New("conflict")
+	ErrQuota        = errors.New("quota exceeded")
+	ErrInvalidInput = errors.New("invalid input")
+)
+
+func (c Class) String() string {
+	switch c {
+	case ClassAuth:
+		return "auth"
+	case ClassNotFound:
+		return "not-found"
+	case ClassThrottled:
+		return "throttled"
+	case ClassTransient:
+		return "transient"
+	case ClassConflict:
+		return "conflict"
+	case ClassQuota:
+		return "quota"
+	case ClassInvalidInput:
+		return "invalid-input"
+	default:
+		return "unknown"
+	}
+}
+
+// Err is the error errors.Is matches errors of the class with, nil for ClassUnknown
+func (c Class) Err() error {
+	switch c {
+	case ClassAuth:
+		return ErrAuth
+	case ClassNotFound:
+		return ErrNotFound
+	case ClassThrottled:
+		return ErrThrottled
+	case ClassTransient:
+		return ErrTransient
+	case ClassConflict:
+		return ErrConflict
+	case ClassQuota:
+		return ErrQuota
+	case ClassInvalidInput:
+		return ErrInvalidInput
+	default:
+		return nil
+	}
+}
+
+// Retryable reports whether trying again later may succeed. Errors of the credentials or of the
+// object itself come back the same until someone fixes them, the others may pass
+func (c Class) Retryable() bool {
+	switch c {
+	case ClassAuth, ClassNotFound, ClassConflict, ClassInvalidInput:
+		return false
+	default:
+		return true
+	}
+}
+
+// Error is a failed request to a provider, classified by the provider's response
+type Error struct {
+	Provider Provider
+	// Op is what failed, ex: "upload" or "ensure bucket"
+	Op    string
+	Class Class
+	// StatusCode is the HTTP status of the provider's response, 0 when there was none
+	StatusCode int
+	// Code is the provider's error code, ex: SlowDown or ContainerNotFound
+	Code string
+	// RequestID identifies the request to the provider's support
+	RequestID string
+	Err       error
+}
+
+var _ error = (*Error)(nil)
+
+func (e *Error) Error() string {
+	b := strings.Builder{}
+	if e.Provider != "" {
+		b.WriteString(string(e.Provider))
+		b.WriteByte(' ')
+	}
+	if e.Op != "" {
+		b.WriteString(e.Op)
+		b.WriteByte(' ')
+	}
+	if b.Len() > 0 {
+		b.WriteString("failed: ")
+	}
+	b.WriteString(e.Err.Error())
+	var details []string
+	if e.StatusCode != 0 {
+		details = append(details, fmt.Sprintf("status %d", e.StatusCode))
+	}
+	if e.RequestID != "" {
+		details = append(details, "request ID "+e.RequestID)
+	}
+	if len(details) > 0 {
+		fmt.Fprintf(&b, " (%s)", strings.Join(details, ", "))
+	}
+	return b.String()
+}
+
+func (e *Error) Unwrap() error { return e.Err }
+
+func (e *Error) Is(target error) bool {
+	return target != nil && target == e.Class.Err()
+}
+
+// NewError classifies err, the failure of op on provider p, by the HTTP status and error code
+// of the provider's response. Errors already classified are returned as they are
+func NewError(p Provider, op string, status int, code, requestID string, err error) error {
+	if err == nil {
+		return nil
+	}
+	var perr *Error
+	if errors.As(err, &perr) {
+		return err
+	}
+	class := Classify(status, code)
+	if class == ClassUnknown {
+		class = ClassOf(err)
+	}
+	return &Error{Provider: p, Op: op, Class: class, StatusCode: status, Code: code, RequestID: requestID, Err: err}
+}
+
+// codeClasses sorts the error codes of S3, GCS and Azure whose HTTP status doesn't tell, or
+// tells wrong, ex: S3 throttles with 503 SlowDown. Codes are matched without case
+var codeClasses = map[string]Class{
+	"slowdown":                        ClassThrottled,
+	"throttling":                      ClassThrottled,
+	"throttlingexception":             ClassThrottled,
+	"requestlimitexceeded":            ClassThrottled,
+	"serverbusy":                      ClassThrottled,
+	"ratelimitexceeded":               ClassThrottled,
+	"userratelimitexceeded":           ClassThrottled,
+	"quotaexceeded":                   ClassQuota,
+	"dailylimitexceeded":              ClassQuota,
+	"toomanybuckets":                  ClassQuota,
+	"accessdenied":                    ClassAuth,
+	"invalidaccesskeyid":              ClassAuth,
+	"signaturedoesnotmatch":           ClassAuth,
+	"expiredtoken":                    ClassAuth,
+	"authenticationfailed":            ClassAuth,
+	"authorizationfailure":            ClassAuth,
+	"authorizationpermissionmismatch": ClassAuth,
+	"accountisdisabled":               ClassAuth,
+	"bucketalreadyexists":             ClassConflict,
+	"operationaborted":                ClassConflict,
+	"containeralreadyexists":          ClassConflict,
+	"containerbeingdeleted":           ClassConflict,
+	"leaseidmissing":                  ClassConflict,
+	"invalidbucketname":               ClassInvalidInput,
+	"keytoolongerror":                 ClassInvalidInput,
+	"invalidresourcename":             ClassInvalidInput,
+	"invalidblocklist":                ClassInvalidInput,
+	"entitytoolarge":                  ClassInvalidInput,
+	"requesterror":                    ClassTransient,
+	"requesttimeout":                  ClassTransient,
+	"operationtimedout":               ClassTransient,
+	"internalerror":                   ClassTransient,
+	"backenderror":                    ClassTransient,
+}
+
+// Classify sorts a provider's response by its error code, or its HTTP status for codes not known
+func Classify(status int, code string) Class {
+	if c, ok := codeClasses[strings.ToLower(code)]; ok {
+		return c
+	}
+	switch status {
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return ClassAuth
+	case http.StatusNotFound, http.StatusGone:
+		return ClassNotFound
+	case http.StatusTooManyRequests:
+		return ClassThrottled
+	case http.StatusRequestTimeout, http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return ClassTransient
+	case http.StatusConflict, http.StatusPreconditionFailed:
+		return ClassConflict
+	case http.StatusInsufficientStorage:
+		return ClassQuota
+	case http.StatusBadRequest, http.StatusLengthRequired, http.StatusRequestEntityTooLarge, http.StatusRequestURITooLong, http.StatusRequestedRangeNotSatisfiable, http.StatusUnprocessableEntity:
+		return ClassInvalidInput
+	}
+	return ClassUnknown
+}
+
+// ClassOf is the class of err. Interrupted and timed out uploads are ClassUnknown, network
+// failures without a response ClassTransient
+func ClassOf(err error) Class {
+	var perr *Error
+	var nerr net.Error
+	switch {
+	case err == nil, errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return ClassUnknown
+	case errors.As(err, &perr):
+		return perr.Class
+	case errors.Is(err, ErrInvalidKey):
+		return ClassInvalidInput
+	case errors.As(err, &nerr):
+		return ClassTransient
+	}
+	for c := ClassAuth; c <= ClassInvalidInput; c++ {
+		if errors.Is(err, c.Err()) {
+			return c
+		}
+	}
+	return ClassUnknown
+}
+
+// UploadError is the error uploads used to return
+//
+// Deprecated: match *Error with errors.As instead
+type UploadError = Error
+
+// NewUploadError classifies err, an upload to provider that failed
+//
+// Deprecated: providers return an *Error with the status and request ID, use NewError
+func NewUploadError(provider Provider, err error) *UploadError {
+	return &Error{Provider: provider, Op: "upload", Class: ClassOf(err), Err: err}
+}
+
+// Retryable reports whether retrying what failed with err later may succeed, see Class.Retryable
+func Retryable(err error) bool {
+	return ClassOf(err).Retryable()
+}
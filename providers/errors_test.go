@@ -0,0 +1,86 @@
+package providers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/require"
+	"net"
+	"testing"
+)
+
+func TestClassify(t *testing.T) {
+	for _, tt := range []struct {
+		status int
+		code   string
+		want   Class
+	}{
+		{403, "AccessDenied", ClassAuth},
+		{401, "", ClassAuth},
+		{404, "NoSuchKey", ClassNotFound},
+		{503, "SlowDown", ClassThrottled},
+		{503, "ServerBusy", ClassThrottled},
+		{429, "", ClassThrottled},
+		{500, "", ClassTransient},
+		{409, "BucketAlreadyExists", ClassConflict},
+		{403, "quotaExceeded", ClassQuota},
+		{400, "InvalidBucketName", ClassInvalidInput},
+		{400, "", ClassInvalidInput},
+		{0, "", ClassUnknown},
+		{302, "", ClassUnknown},
+	} {
+		require.Equal(t, tt.want, Classify(tt.status, tt.code), "%d %s", tt.status, tt.code)
+	}
+}
+
+func TestNewError(t *testing.T) {
+	cause := errors.New("slow down")
+	err := NewError(AWS, "upload", 503, "SlowDown", "req-1", cause)
+	require.EqualError(t, err, "aws upload failed: slow down (status 503, request ID req-1)")
+	require.True(t, errors.Is(err, ErrThrottled))
+	require.True(t, errors.Is(err, cause))
+	require.False(t, errors.Is(err, ErrTransient))
+	require.True(t, Retryable(err))
+
+	var perr *Error
+	require.True(t, errors.As(fmt.Errorf("batch: %w", err), &perr))
+	require.Equal(t, Error{Provider: AWS, Op: "upload", Class: ClassThrottled, StatusCode: 503, Code: "SlowDown", RequestID: "req-1", Err: cause}, *perr)
+
+	// classified errors pass through, the innermost op is kept
+	require.Same(t, err, NewError(AWS, "upload", 0, "", "", err))
+	require.NoError(t, NewError(AWS, "upload", 500, "", "", nil))
+}
+
+func TestNewUploadError(t *testing.T) {
+	var uerr *UploadError
+	err := fmt.Errorf("batch: %w", NewUploadError(GCP, ErrNotFound))
+	require.True(t, errors.As(err, &uerr))
+	require.Equal(t, GCP, uerr.Provider)
+	require.True(t, errors.Is(err, ErrNotFound))
+}
+
+func TestClassOf(t *testing.T) {
+	for _, tt := range []struct {
+		err  error
+		want Class
+	}{
+		{nil, ClassUnknown},
+		{errors.New("boom"), ClassUnknown},
+		{context.Canceled, ClassUnknown},
+		{NewError(GCP, "upload", 500, "", "", context.DeadlineExceeded), ClassUnknown},
+		{NewError(Azure, "stat", 404, "BlobNotFound", "", errors.New("missing")), ClassNotFound},
+		{fmt.Errorf("key: %w", ErrInvalidKey), ClassInvalidInput},
+		{&net.OpError{Op: "dial", Err: errors.New("connection refused")}, ClassTransient},
+		{fmt.Errorf("ensure bucket: %w", ErrAuth), ClassAuth},
+	} {
+		require.Equal(t, tt.want, ClassOf(tt.err), "%v", tt.err)
+	}
+	for _, c := range []Class{ClassAuth, ClassNotFound, ClassConflict, ClassInvalidInput} {
+		require.False(t, c.Retryable(), c)
+	}
+	for _, c := range []Class{ClassUnknown, ClassThrottled, ClassTransient, ClassQuota} {
+		require.True(t, c.Retryable(), c)
+	}
+	require.True(t, Retryable(context.DeadlineExceeded))
+	require.True(t, errors.Is(NewError(Azure, "stat", 404, "", "", errors.New("missing")), ErrNotFound))
+}
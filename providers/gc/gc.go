@@ -0,0 +1,147 @@
+package gc
+
+import (
+	"context"
+	"github.com/stevequadros/uploader/providers"
+	"github.com/stevequadros/uploader/providers/checkpoint"
+	"sort"
+	"time"
+)
+
+// Item is an abandoned upload found on a provider or in a local checkpoint
+type Item struct {
+	providers.PendingUpload
+	// Checkpoint is set when a local checkpoint tracks the upload, GCS sessions are only
+	// known this way since GCS can't list them
+	Checkpoint *providers.Checkpoint
+	Aborted    bool
+	Err        error
+	// listed is set when the provider listed the upload, not only a checkpoint
+	listed bool
+}
+
+// ProviderSummary is what was found on one provider
+type ProviderSummary struct {
+	Provider providers.Provider
+	// Supported is false for providers that can't list unfinished uploads, only their
+	// checkpoints are collected
+	Supported bool
+	Err       error
+}
+
+type Report struct {
+	Bucket    string
+	Before    time.Time
+	DryRun    bool
+	Providers []ProviderSummary
+	// Items are ordered by provider, then oldest first
+	Items []Item
+}
+
+// Bytes sums what the items store, reclaimed ones only unless it was a dry run
+func (r Report) Bytes() int64 {
+	var n int64
+	for _, it := range r.Items {
+		if it.Aborted || r.DryRun {
+			n += it.Size
+		}
+	}
+	return n
+}
+
+// Failed counts the items that couldn't be aborted
+func (r Report) Failed() int {
+	var n int
+	for _, it := range r.Items {
+		if it.Err != nil {
+			n++
+		}
+	}
+	return n
+}
+
+// Run finds the uploads of bucket started before the given time and never completed, on every
+// provider and in store's checkpoints, and aborts them unless dryRun is set. store may be nil
+func Run(ctx context.Context, uploaders []providers.Uploader, store *checkpoint.Store, bucket string, before time.Time, dryRun bool) Report {
+	report := Report{Bucket: bucket, Before: before, DryRun: dryRun}
+	var cps []providers.Checkpoint
+	if store != nil {
+		all, err := store.List()
+		if err == nil {
+			for _, cp := range all {
+				if cp.Bucket == bucket && cp.Updated.Before(before) {
+					cps = append(cps, cp)
+				}
+			}
+		}
+	}
+
+	for _, u := range uploaders {
+		p := u.GetName()
+		summary := ProviderSummary{Provider: p}
+		var items []Item
+		if l, ok := u.(providers.PendingLister); ok {
+			summary.Supported = true
+			pending, err := l.ListPending(ctx, bucket, before)
+			summary.Err = err
+			for _, pu := range pending {
+				items = append(items, Item{PendingUpload: pu, listed: true})
+			}
+		}
+		for i := range cps {
+			cp := cps[i]
+			if cp.Provider != p {
+				continue
+			}
+			if it := tracked(items, cp); it != nil {
+				it.Checkpoint = &cp
+				continue
+			}
+			items = append(items, Item{
+				PendingUpload: providers.PendingUpload{
+					Provider: p,
+					Bucket:   bucket,
+					Key:      cp.Key,
+					UploadID: cp.UploadID,
+					Started:  cp.Created,
+					Parts:    len(cp.Parts),
+					Size:     cp.Uploaded(),
+				},
+				Checkpoint: &cp,
+			})
+		}
+		sort.Slice(items, func(i, j int) bool { return items[i].Started.Before(items[j].Started) })
+		if !dryRun {
+			for i := range items {
+				items[i].Err = abort(ctx, u, store, items[i])
+				items[i].Aborted = items[i].Err == nil
+			}
+		}
+		report.Providers = append(report.Providers, summary)
+		report.Items = append(report.Items, items...)
+	}
+	return report
+}
+
+// tracked returns the item of the upload cp tracks, if one was listed
+func tracked(items []Item, cp providers.Checkpoint) *Item {
+	for i := range items {
+		if items[i].Key == cp.Key && (items[i].UploadID == cp.UploadID || items[i].UploadID == "") {
+			return &items[i]
+		}
+	}
+	return nil
+}
+
+func abort(ctx context.Context, u providers.Uploader, store *checkpoint.Store, it Item) error {
+	var err error
+	if l, ok := u.(providers.PendingLister); ok && it.listed {
+		err = l.AbortPending(ctx, it.PendingUpload)
+	} else if ru, ok := u.(providers.ResumableUploader); ok && it.Checkpoint != nil {
+		err = ru.AbortResumable(ctx, *it.Checkpoint)
+	}
+	if err != nil || it.Checkpoint == nil {
+		return err
+	}
+	return store.Delete(*it.Checkpoint)
+}
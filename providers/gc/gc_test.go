@@ -0,0 +1,122 @@
+package gc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/stevequadros/uploader/providers"
+	"github.com/stevequadros/uploader/providers/checkpoint"
+	"github.com/stretchr/testify/require"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// pendingStore lists the pending uploads it holds, and records what was aborted
+type pendingStore struct {
+	name    providers.Provider
+	pending []providers.PendingUpload
+	list    bool
+	failKey string
+	aborted []string
+}
+
+var _ providers.PendingLister = (*listingStore)(nil)
+var _ providers.ResumableUploader = (*pendingStore)(nil)
+
+func (s *pendingStore) GetName() providers.Provider { return s.name }
+func (s *pendingStore) Upload(ctx context.Context, bucket, key string, r io.ReadSeekCloser) error {
+	return nil
+}
+func (s *pendingStore) UploadResumable(ctx context.Context, bucket, key string, r io.ReaderAt, size int64, opts providers.UploadOptions, cp *providers.Checkpoint, save func(providers.Checkpoint) error) error {
+	return nil
+}
+func (s *pendingStore) AbortResumable(ctx context.Context, cp providers.Checkpoint) error {
+	s.aborted = append(s.aborted, "checkpoint:"+cp.Key)
+	return nil
+}
+
+// listingStore can also list its pending uploads, like S3 and Azure
+type listingStore struct {
+	pendingStore
+}
+
+func (s *listingStore) ListPending(ctx context.Context, bucket string, before time.Time) ([]providers.PendingUpload, error) {
+	var pending []providers.PendingUpload
+	for _, p := range s.pending {
+		if p.Started.Before(before) {
+			pending = append(pending, p)
+		}
+	}
+	return pending, nil
+}
+func (s *listingStore) AbortPending(ctx context.Context, p providers.PendingUpload) error {
+	if p.Key == s.failKey {
+		return errors.New("denied")
+	}
+	s.aborted = append(s.aborted, p.Key)
+	return nil
+}
+
+// writeCheckpoint saves cp as if it was last updated at the given time
+func writeCheckpoint(t *testing.T, store *checkpoint.Store, cp providers.Checkpoint, updated time.Time) {
+	require.NoError(t, store.Save(cp))
+	entries, err := os.ReadDir(store.Dir())
+	require.NoError(t, err)
+	for _, e := range entries {
+		path := filepath.Join(store.Dir(), e.Name())
+		b, err := os.ReadFile(path)
+		require.NoError(t, err)
+		var saved providers.Checkpoint
+		require.NoError(t, json.Unmarshal(b, &saved))
+		if saved.Provider == cp.Provider && saved.Key == cp.Key {
+			saved.Updated, saved.Created = updated, updated
+			b, err = json.Marshal(saved)
+			require.NoError(t, err)
+			require.NoError(t, os.WriteFile(path, b, 0600))
+		}
+	}
+}
+
+func TestRun(t *testing.T) {
+	now := time.Now()
+	old, recent := now.Add(-48*time.Hour), now.Add(-time.Hour)
+	store, err := checkpoint.Open(t.TempDir())
+	require.NoError(t, err)
+
+	aws := &listingStore{pendingStore{name: providers.AWS, pending: []providers.PendingUpload{
+		{Provider: providers.AWS, Bucket: "b", Key: "old", UploadID: "1", Started: old, Size: 100},
+		{Provider: providers.AWS, Bucket: "b", Key: "denied", UploadID: "2", Started: old, Size: 10},
+		{Provider: providers.AWS, Bucket: "b", Key: "recent", UploadID: "3", Started: recent, Size: 1000},
+	}, failKey: "denied"}}
+	// GCS can't list its sessions, only the checkpointed ones are found
+	gcp := &pendingStore{name: providers.GCP}
+	writeCheckpoint(t, store, providers.Checkpoint{Provider: providers.AWS, Bucket: "b", Key: "old", UploadID: "1"}, old)
+	writeCheckpoint(t, store, providers.Checkpoint{Provider: providers.GCP, Bucket: "b", Key: "session", UploadID: "https://session", Offset: 7}, old)
+	writeCheckpoint(t, store, providers.Checkpoint{Provider: providers.GCP, Bucket: "b", Key: "active", UploadID: "https://active", Offset: 9}, recent)
+	writeCheckpoint(t, store, providers.Checkpoint{Provider: providers.GCP, Bucket: "other", Key: "session", Offset: 5}, old)
+	uploaders := []providers.Uploader{aws, gcp}
+	before := now.Add(-24 * time.Hour)
+
+	report := Run(context.Background(), uploaders, store, "b", before, true)
+	require.Len(t, report.Items, 3)
+	require.Equal(t, int64(117), report.Bytes())
+	require.Empty(t, aws.aborted, "a dry run aborts nothing")
+	require.False(t, report.Providers[1].Supported)
+
+	report = Run(context.Background(), uploaders, store, "b", before, false)
+	require.Equal(t, []string{"old"}, aws.aborted, "the checkpointed S3 upload is aborted once")
+	require.Equal(t, []string{"checkpoint:session"}, gcp.aborted)
+	require.Equal(t, 1, report.Failed())
+	require.Equal(t, int64(107), report.Bytes())
+
+	left, err := store.List()
+	require.NoError(t, err)
+	var keys []string
+	for _, cp := range left {
+		keys = append(keys, string(cp.Provider)+":"+cp.Bucket+"/"+cp.Key)
+	}
+	require.ElementsMatch(t, []string{"gcp:b/active", "gcp:other/session"}, keys)
+}
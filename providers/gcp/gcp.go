@@ -3,23 +3,48 @@ package gcp
 import (
 	"cloud.google.com/go/storage"
 	"context"
+	"encoding/hex"
 	"errors"
+	"fmt"
 	"github.com/stevequadros/uploader/config"
 	"github.com/stevequadros/uploader/providers"
+	"github.com/stevequadros/uploader/providers/logging"
+	"github.com/stevequadros/uploader/providers/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/google"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/iterator"
 	"google.golang.org/api/option"
 	"io"
+	"net/http"
 	"os"
+	"strconv"
 )
 
 type GCPUploader struct {
 	credentials *google.Credentials
 	client      *storage.Client
+	// http sends the requests of resumable uploads
+	http     *http.Client
+	timeouts config.Timeouts
+	limiters providers.Limiters
+	log      *logging.Logger
 }
 
 var _ providers.Uploader = (*GCPUploader)(nil)
+var _ providers.OptionsUploader = (*GCPUploader)(nil)
+var _ providers.StreamUploader = (*GCPUploader)(nil)
+var _ providers.BucketEnsurer = (*GCPUploader)(nil)
+var _ providers.Downloader = (*GCPUploader)(nil)
+var _ providers.Deleter = (*GCPUploader)(nil)
+var _ providers.Stater = (*GCPUploader)(nil)
+var _ providers.Lister = (*GCPUploader)(nil)
+var _ providers.TimeoutsConfigured = (*GCPUploader)(nil)
+var _ providers.RateLimited = (*GCPUploader)(nil)
 
-func New(ctx context.Context, config *config.GCP) (*GCPUploader, error) {
+// New builds the uploader of config, logging to log, which may be nil
+func New(ctx context.Context, config *config.GCP, log *logging.Logger) (*GCPUploader, error) {
 	if config == nil || config.Credentials == nil {
 		return nil, errors.New("gcp credentials are empty")
 	}
@@ -36,35 +61,156 @@ func New(ctx context.Context, config *config.GCP) (*GCPUploader, error) {
 	if err != nil {
 		return nil, err
 	}
-	opts := option.WithCredentials(credentials)
-	client, err := storage.NewClient(ctx, opts)
+	// the token source and the storage client both send through the client bound by the
+	// timeouts and limits
+	limiters := providers.NewLimiters(config.Limits)
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, providers.HTTPClient(config.Timeouts, limiters.Requests, log))
+	authorized := oauth2.NewClient(ctx, credentials.TokenSource)
+	client, err := storage.NewClient(ctx, option.WithHTTPClient(authorized))
 	if err != nil {
 		return nil, err
 	}
-	return &GCPUploader{credentials: credentials, client: client}, nil
+	u := &GCPUploader{credentials: credentials, client: client, http: authorized, limiters: limiters, log: log.With("provider", providers.GCP)}
+	if config.Timeouts != nil {
+		u.timeouts = *config.Timeouts
+	}
+	return u, nil
+}
+
+func (u *GCPUploader) Timeouts() config.Timeouts {
+	return u.timeouts
+}
+
+func (u *GCPUploader) Limiters() providers.Limiters {
+	return u.limiters
 }
 
 func (u *GCPUploader) GetName() providers.Provider {
 	return providers.GCP
 }
 
-func (u *GCPUploader) Upload(ctx context.Context, bucketName, key string, reader io.ReadSeekCloser) error {
+func (u *GCPUploader) EnsureBucket(ctx context.Context, bucketName string) (err error) {
+	ctx, span := tracing.Start(ctx, "ensure bucket", attribute.String("provider", string(providers.GCP)), attribute.String("bucket", bucketName))
+	defer func() { tracing.End(span, err) }()
 	bucket := u.client.Bucket(bucketName)
-	if bucketAttrs, err := bucket.Attrs(ctx); bucketAttrs == nil {
-		if err = bucket.Create(ctx, u.credentials.ProjectID, &storage.BucketAttrs{}); err != nil {
-			return err
-		}
+	_, err = bucket.Attrs(ctx)
+	if errors.Is(err, storage.ErrBucketNotExist) {
+		return wrapError("create bucket", bucket.Create(ctx, u.credentials.ProjectID, &storage.BucketAttrs{}))
 	}
+	return wrapError("ensure bucket", err)
+}
 
-	obj := bucket.Object(key)
-	writer := obj.NewWriter(ctx)
-	content, err := io.ReadAll(reader)
-	if err != nil {
+func (u *GCPUploader) Upload(ctx context.Context, bucketName, key string, reader io.ReadSeekCloser) error {
+	return u.UploadWithOptions(ctx, bucketName, key, reader, providers.UploadOptions{})
+}
+
+func (u *GCPUploader) UploadWithOptions(ctx context.Context, bucketName, key string, reader io.ReadSeekCloser, opts providers.UploadOptions) error {
+	return u.UploadStream(ctx, bucketName, key, reader, opts)
+}
+
+// UploadStream copies reader into a resumable upload, which only creates the object once the
+// writer is closed, so a failed read cancels it instead of storing part of the stream
+func (u *GCPUploader) UploadStream(ctx context.Context, bucketName, key string, reader io.Reader, opts providers.UploadOptions) error {
+	if err := u.EnsureBucket(ctx, bucketName); err != nil {
 		return err
 	}
-	_, err = writer.Write(content)
+
+	wctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	obj := u.client.Bucket(bucketName).Object(key)
+	writer := obj.NewWriter(wctx)
+	writer.ContentType = opts.ContentType
+	writer.Metadata = opts.Metadata
+	// GCS rejects the object when the content doesn't match the checksums sent with it
+	if md5, err := hex.DecodeString(opts.Checksums.MD5); err == nil && len(md5) > 0 {
+		writer.MD5 = md5
+	}
+	if crc, err := strconv.ParseUint(opts.Checksums.CRC32C, 16, 32); err == nil {
+		writer.CRC32C, writer.SendCRC32C = uint32(crc), true
+	}
+	if _, err := io.Copy(writer, reader); err != nil {
+		cancel()
+		_ = writer.Close()
+		return wrapError("upload", err)
+	}
+	if err := writer.Close(); err != nil {
+		return wrapError("upload", err)
+	}
+	return nil
+}
+
+func (u *GCPUploader) Download(ctx context.Context, bucket, key string) (io.ReadCloser, error) {
+	r, err := u.client.Bucket(bucket).Object(key).NewReader(ctx)
+	if err != nil {
+		return nil, wrapError("download", err)
+	}
+	return r, nil
+}
+
+func (u *GCPUploader) Delete(ctx context.Context, bucket, key string) error {
+	return wrapError("delete", u.client.Bucket(bucket).Object(key).Delete(ctx))
+}
+
+func (u *GCPUploader) Stat(ctx context.Context, bucket, key string) (providers.ObjectInfo, error) {
+	attrs, err := u.client.Bucket(bucket).Object(key).Attrs(ctx)
+	if err != nil {
+		return providers.ObjectInfo{}, wrapError("stat", err)
+	}
+	return objectInfo(attrs), nil
+}
+
+func (u *GCPUploader) List(ctx context.Context, bucket string, opts providers.ListOptions) (providers.ListPage, error) {
+	pageSize := opts.MaxKeys
+	if pageSize <= 0 {
+		pageSize = 1000
+	}
+	it := u.client.Bucket(bucket).Objects(ctx, &storage.Query{Prefix: opts.Prefix})
+	var attrs []*storage.ObjectAttrs
+	next, err := iterator.NewPager(it, pageSize, opts.PageToken).NextPage(&attrs)
 	if err != nil {
-		return providers.NewUploadError("GCP", err)
+		return providers.ListPage{}, wrapError("list", err)
+	}
+
+	page := providers.ListPage{NextPageToken: next}
+	for _, a := range attrs {
+		page.Objects = append(page.Objects, objectInfo(a))
+	}
+	return page, nil
+}
+
+func objectInfo(attrs *storage.ObjectAttrs) providers.ObjectInfo {
+	return providers.ObjectInfo{
+		Key:          attrs.Name,
+		Size:         attrs.Size,
+		ETag:         attrs.Etag,
+		LastModified: attrs.Updated,
+		ContentType:  attrs.ContentType,
+		VersionID:    strconv.FormatInt(attrs.Generation, 10),
+		Metadata:     attrs.Metadata,
+		Checksums: providers.Checksums{
+			MD5:    hex.EncodeToString(attrs.MD5),
+			CRC32C: fmt.Sprintf("%08x", attrs.CRC32C),
+		},
+	}
+}
+
+// wrapError classifies err, the failure of op, by the status, reason and request ID of GCS's
+// response. The client's not exist errors carry no response and are classified as not found
+func wrapError(op string, err error) error {
+	if err == nil {
+		return nil
+	}
+	var status int
+	var code, requestID string
+	var gerr *googleapi.Error
+	switch {
+	case errors.As(err, &gerr):
+		status, requestID = gerr.Code, logging.RequestID(gerr.Header)
+		if len(gerr.Errors) > 0 {
+			code = gerr.Errors[0].Reason
+		}
+	case errors.Is(err, storage.ErrObjectNotExist), errors.Is(err, storage.ErrBucketNotExist):
+		status = http.StatusNotFound
 	}
-	return writer.Close()
+	return providers.NewError(providers.GCP, op, status, code, requestID, err)
 }
@@ -0,0 +1,226 @@
+package gcp
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/stevequadros/uploader/providers"
+	"github.com/stevequadros/uploader/providers/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"google.golang.org/api/googleapi"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const (
+	uploadEndpoint = "https://storage.googleapis.com/upload/storage/v1/b/%s/o?uploadType=resumable&name=%s"
+	// resumableChunkSize is sent per request, GCS needs chunks in multiples of 256 KiB
+	resumableChunkSize = 16 << 20
+	// statusResumeIncomplete is what GCS answers while an upload is missing bytes
+	statusResumeIncomplete = 308
+	// statusClientClosed is what GCS answers once a session is cancelled
+	statusClientClosed = 499
+)
+
+var _ providers.ResumableUploader = (*GCPUploader)(nil)
+
+// errSessionGone is returned when GCS no longer knows a session, it completed or expired
+var errSessionGone = errors.New("resumable session expired")
+
+// UploadResumable uploads the file through a resumable session, which GCS keeps for a week.
+// A later run asks the session how many bytes it persisted and sends the rest
+func (u *GCPUploader) UploadResumable(ctx context.Context, bucket, key string, r io.ReaderAt, size int64, opts providers.UploadOptions, cp *providers.Checkpoint, save func(providers.Checkpoint) error) error {
+	if err := u.EnsureBucket(ctx, bucket); err != nil {
+		return err
+	}
+	client := u.http
+
+	if cp.UploadID != "" {
+		offset, done, err := sessionOffset(ctx, client, cp.UploadID, size)
+		switch {
+		case err == errSessionGone:
+			cp.UploadID, cp.Offset = "", 0
+		case err != nil:
+			return wrapError("upload", err)
+		case done:
+			return nil
+		default:
+			cp.Offset = offset
+		}
+	}
+	if cp.UploadID == "" {
+		session, err := startSession(ctx, client, bucket, key, size, opts)
+		if err != nil {
+			return wrapError("upload", err)
+		}
+		cp.UploadID, cp.Offset, cp.PartSize = session, 0, resumableChunkSize
+		if err = save(*cp); err != nil {
+			return err
+		}
+	}
+
+	for {
+		length := cp.PartSize
+		if size-cp.Offset < length {
+			length = size - cp.Offset
+		}
+		pctx, span := tracing.Start(ctx, "upload part", attribute.Int64("offset", cp.Offset), attribute.Int64("size", length))
+		offset, done, err := putChunk(pctx, client, cp.UploadID, io.NewSectionReader(r, cp.Offset, length), cp.Offset, length, size)
+		tracing.End(span, err)
+		if err != nil {
+			return wrapError("upload", err)
+		}
+		if done {
+			return nil
+		}
+		if offset <= cp.Offset {
+			return wrapError("upload", fmt.Errorf("gcs persisted nothing past byte %d", cp.Offset))
+		}
+		cp.Offset = offset
+		if err = save(*cp); err != nil {
+			return err
+		}
+	}
+}
+
+// AbortResumable cancels the session, discarding what it persisted
+func (u *GCPUploader) AbortResumable(ctx context.Context, cp providers.Checkpoint) error {
+	if cp.UploadID == "" {
+		return nil
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, cp.UploadID, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := u.http.Do(req)
+	if err != nil {
+		return wrapError("abort upload", err)
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case statusClientClosed, http.StatusNoContent, http.StatusNotFound, http.StatusGone:
+		return nil
+	}
+	return wrapError("abort upload", responseError(resp))
+}
+
+// startSession creates a resumable session for the object and returns its URI
+func startSession(ctx context.Context, client *http.Client, bucket, key string, size int64, opts providers.UploadOptions) (string, error) {
+	attrs := map[string]interface{}{"name": key}
+	if opts.ContentType != "" {
+		attrs["contentType"] = opts.ContentType
+	}
+	if len(opts.Metadata) > 0 {
+		attrs["metadata"] = opts.Metadata
+	}
+	// GCS rejects the object when the content doesn't match the checksums sent with it
+	if md5, err := hex.DecodeString(opts.Checksums.MD5); err == nil && len(md5) > 0 {
+		attrs["md5Hash"] = base64.StdEncoding.EncodeToString(md5)
+	}
+	if crc, err := strconv.ParseUint(opts.Checksums.CRC32C, 16, 32); err == nil {
+		b := make([]byte, 4)
+		binary.BigEndian.PutUint32(b, uint32(crc))
+		attrs["crc32c"] = base64.StdEncoding.EncodeToString(b)
+	}
+	body, err := json.Marshal(attrs)
+	if err != nil {
+		return "", err
+	}
+	endpoint := fmt.Sprintf(uploadEndpoint, url.PathEscape(bucket), url.QueryEscape(key))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+	req.Header.Set("X-Upload-Content-Length", strconv.FormatInt(size, 10))
+	if opts.ContentType != "" {
+		req.Header.Set("X-Upload-Content-Type", opts.ContentType)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", responseError(resp)
+	}
+	session := resp.Header.Get("Location")
+	if session == "" {
+		return "", errors.New("no resumable session in the response")
+	}
+	return session, nil
+}
+
+// sessionOffset asks a session how many bytes it persisted, done is set once the object exists
+func sessionOffset(ctx context.Context, client *http.Client, session string, size int64) (int64, bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, session, nil)
+	if err != nil {
+		return 0, false, err
+	}
+	req.Header.Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+	return send(client, req)
+}
+
+// putChunk sends length bytes at offset, returning the offset GCS persisted up to
+func putChunk(ctx context.Context, client *http.Client, session string, chunk io.Reader, offset, length, size int64) (int64, bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, session, chunk)
+	if err != nil {
+		return 0, false, err
+	}
+	req.ContentLength = length
+	if length == 0 {
+		req.Body = http.NoBody
+		req.Header.Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+	} else {
+		req.Header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", offset, offset+length-1, size))
+	}
+	return send(client, req)
+}
+
+func send(client *http.Client, req *http.Request) (int64, bool, error) {
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, false, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusCreated:
+		return 0, true, nil
+	case statusResumeIncomplete:
+		return persisted(resp.Header.Get("Range")), false, nil
+	case http.StatusNotFound, http.StatusGone:
+		return 0, false, errSessionGone
+	}
+	return 0, false, responseError(resp)
+}
+
+// persisted parses the Range header of an incomplete upload, "bytes=0-N" means N+1 bytes are
+// stored and no header means none are
+func persisted(header string) int64 {
+	i := strings.LastIndex(header, "-")
+	if i < 0 {
+		return 0
+	}
+	last, err := strconv.ParseInt(header[i+1:], 10, 64)
+	if err != nil {
+		return 0
+	}
+	return last + 1
+}
+
+// responseError is the error of an unexpected response, a googleapi.Error as the storage client
+// returns so wrapError finds its status, reason and request ID
+func responseError(resp *http.Response) error {
+	if err := googleapi.CheckResponse(resp); err != nil {
+		return err
+	}
+	return &googleapi.Error{Code: resp.StatusCode, Message: "unexpected " + resp.Status, Header: resp.Header}
+}
@@ -8,9 +8,11 @@ import (
 	"github.com/stevequadros/uploader/providers/aws"
 	"github.com/stevequadros/uploader/providers/azure"
 	"github.com/stevequadros/uploader/providers/gcp"
+	"github.com/stevequadros/uploader/providers/logging"
 )
 
-func Init(ctx context.Context, config config.Config) ([]providers.Uploader, error) {
+// Init builds an uploader for every provider in config, logging to log, which may be nil
+func Init(ctx context.Context, config config.Config, log *logging.Logger) ([]providers.Uploader, error) {
 	var provs []providers.Provider
 	if config.AWS != nil {
 		provs = append(provs, providers.AWS)
@@ -26,19 +28,19 @@ func Init(ctx context.Context, config config.Config) ([]providers.Uploader, erro
 	for _, p := range provs {
 		switch p {
 		case providers.AWS:
-			u, err := initAWS(config.AWS)
+			u, err := initAWS(config.AWS, log)
 			if err != nil {
 				return uploaders, err
 			}
 			uploaders = append(uploaders, u)
 		case providers.GCP:
-			u, err := initGCP(ctx, config.GCP)
+			u, err := initGCP(ctx, config.GCP, log)
 			if err != nil {
 				return uploaders, err
 			}
 			uploaders = append(uploaders, u)
 		case providers.Azure:
-			u, err := initAzure(config.Azure)
+			u, err := initAzure(config.Azure, log)
 			if err != nil {
 				return uploaders, err
 			}
@@ -50,24 +52,24 @@ func Init(ctx context.Context, config config.Config) ([]providers.Uploader, erro
 	return uploaders, nil
 }
 
-func initAWS(cfg *config.AWS) (*aws.AWSUploader, error) {
-	client, err := aws.New(cfg)
+func initAWS(cfg *config.AWS, log *logging.Logger) (*aws.AWSUploader, error) {
+	client, err := aws.New(cfg, log)
 	if err != nil {
 		return nil, err
 	}
 	return client, nil
 }
 
-func initGCP(ctx context.Context, cfg *config.GCP) (*gcp.GCPUploader, error) {
-	client, err := gcp.New(ctx, cfg)
+func initGCP(ctx context.Context, cfg *config.GCP, log *logging.Logger) (*gcp.GCPUploader, error) {
+	client, err := gcp.New(ctx, cfg, log)
 	if err != nil {
 		return nil, err
 	}
 	return client, nil
 }
 
-func initAzure(cfg *config.Azure) (*azure.AzureUploader, error) {
-	client, err := azure.New(cfg)
+func initAzure(cfg *config.Azure, log *logging.Logger) (*azure.AzureUploader, error) {
+	client, err := azure.New(cfg, log)
 	if err != nil {
 		return nil, err
 	}
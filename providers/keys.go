@@ -0,0 +1,61 @@
+package providers
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// MaxKeyLength is the longest key every provider accepts, in bytes for aws and gcp and in
+// characters for azure
+const MaxKeyLength = 1024
+
+var ErrInvalidKey = errors.New("invalid key")
+
+// ValidateKey checks key against the naming rules of provider p, the error wraps ErrInvalidKey
+func ValidateKey(p Provider, key string) error {
+	invalid := func(format string, args ...interface{}) error {
+		return fmt.Errorf("%w %q for %s: %s", ErrInvalidKey, key, p, fmt.Sprintf(format, args...))
+	}
+
+	if key == "" {
+		return invalid("empty")
+	}
+	if !utf8.ValidString(key) {
+		return invalid("not valid UTF-8")
+	}
+
+	switch p {
+	case AWS:
+		if len(key) > MaxKeyLength {
+			return invalid("longer than %d bytes", MaxKeyLength)
+		}
+	case GCP:
+		if len(key) > MaxKeyLength {
+			return invalid("longer than %d bytes", MaxKeyLength)
+		}
+		if strings.ContainsAny(key, "\r\n") {
+			return invalid("contains a carriage return or line feed")
+		}
+		if key == "." || key == ".." {
+			return invalid("reserved name")
+		}
+		if strings.HasPrefix(key, ".well-known/acme-challenge/") {
+			return invalid("reserved prefix .well-known/acme-challenge/")
+		}
+	case Azure:
+		if utf8.RuneCountInString(key) > MaxKeyLength {
+			return invalid("longer than %d characters", MaxKeyLength)
+		}
+		// blobs ending in a dot or slash can't be addressed reliably
+		if strings.HasSuffix(key, ".") || strings.HasSuffix(key, "/") {
+			return invalid("ends with a dot or slash")
+		}
+		if strings.IndexFunc(key, unicode.IsControl) >= 0 {
+			return invalid("contains a control character")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package providers
+
+import (
+	"github.com/stevequadros/uploader/config"
+	"github.com/stevequadros/uploader/providers/logging"
+	"github.com/stevequadros/uploader/providers/ratelimit"
+	"github.com/stevequadros/uploader/providers/tracing"
+	"net"
+	"net/http"
+	"time"
+)
+
+// Limiters are the token buckets an uploader is limited by, one byte of upload or one request
+// per token. Their rates can be changed while uploads run
+type Limiters struct {
+	Bandwidth *ratelimit.Bucket
+	Requests  *ratelimit.Bucket
+}
+
+// NewLimiters returns the buckets of limits, unlimited ones included so they can be set later
+func NewLimiters(limits *config.Limits) Limiters {
+	if limits == nil {
+		limits = &config.Limits{}
+	}
+	return Limiters{
+		Bandwidth: ratelimit.New(float64(limits.Bandwidth)),
+		Requests:  ratelimit.New(limits.Requests),
+	}
+}
+
+// SetLimits changes the rates of l to those of limits, nil for no limits
+func (l Limiters) SetLimits(limits *config.Limits) {
+	if limits == nil {
+		limits = &config.Limits{}
+	}
+	l.Bandwidth.SetRate(float64(limits.Bandwidth))
+	l.Requests.SetRate(limits.Requests)
+}
+
+// RateLimited is implemented by uploaders built with Limiters. The requests limiter is applied
+// by the uploader's HTTP client, the coordinator applies the bandwidth one
+type RateLimited interface {
+	Limiters() Limiters
+}
+
+// LimitersOf returns u's limiters, nil buckets when it has none
+func LimitersOf(u Uploader) Limiters {
+	if l, ok := u.(RateLimited); ok {
+		return l.Limiters()
+	}
+	return Limiters{}
+}
+
+// HTTPClient is the client an uploader sends requests with, bounded by the connect timeout and
+// limited to the rate of requests. Every request is logged when log has wire logging on, and
+// traced within a traced upload
+func HTTPClient(timeouts *config.Timeouts, requests *ratelimit.Bucket, log *logging.Logger) *http.Client {
+	transport := http.DefaultTransport
+	if timeouts != nil && timeouts.Connect > 0 {
+		connect := time.Duration(timeouts.Connect)
+		t := http.DefaultTransport.(*http.Transport).Clone()
+		t.DialContext = (&net.Dialer{Timeout: connect, KeepAlive: 30 * time.Second}).DialContext
+		t.TLSHandshakeTimeout = connect
+		transport = t
+	}
+	if log.Wire() {
+		transport = &logging.Transport{Base: transport, Log: log}
+	}
+	transport = &tracing.Transport{Base: transport}
+	return &http.Client{Transport: &ratelimit.Transport{Base: transport, Bucket: requests}}
+}
@@ -0,0 +1,125 @@
+package logging
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// HandlerOptions configure the text and JSON handlers
+type HandlerOptions struct {
+	// Level is the lowest level written
+	Level Level
+	// Redactor removes the secrets it was given, secrets recognized by their shape are removed
+	// without one
+	Redactor *Redactor
+}
+
+// NewTextHandler writes records as key=value lines, ex:
+// time=2022-03-01T10:00:00.000Z level=INFO msg="upload done" provider=aws key=a.txt
+func NewTextHandler(w io.Writer, opts HandlerOptions) Handler {
+	return &handler{w: w, opts: opts}
+}
+
+// NewJSONHandler writes records as a JSON object per line
+func NewJSONHandler(w io.Writer, opts HandlerOptions) Handler {
+	return &handler{w: w, opts: opts, json: true}
+}
+
+type handler struct {
+	mu   sync.Mutex
+	w    io.Writer
+	opts HandlerOptions
+	json bool
+}
+
+func (h *handler) Enabled(level Level) bool {
+	return level >= h.opts.Level
+}
+
+func (h *handler) Handle(r Record) error {
+	format := formatText
+	b := strings.Builder{}
+	if h.json {
+		format = formatJSON
+		b.WriteByte('{')
+	}
+	format(&b, "time", r.Time.UTC().Format("2006-01-02T15:04:05.000Z07:00"))
+	format(&b, "level", r.Level.String())
+	format(&b, "msg", h.opts.Redactor.Redact(r.Message))
+	for _, a := range r.Attrs {
+		format(&b, a.Key, h.value(a))
+	}
+	if h.json {
+		b.WriteByte('}')
+	}
+	b.WriteByte('\n')
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, err := io.WriteString(h.w, b.String())
+	return err
+}
+
+// value makes a's value safe to write: numbers and booleans are kept, anything else becomes a
+// redacted string
+func (h *handler) value(a Attr) interface{} {
+	if sensitive(a.Key) && a.Value != nil && a.Value != "" {
+		return redacted
+	}
+	switch v := a.Value.(type) {
+	case nil:
+		return nil
+	case bool, int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64:
+		return v
+	case time.Duration:
+		return v.String()
+	case time.Time:
+		return v.UTC().Format(time.RFC3339Nano)
+	case error:
+		return h.opts.Redactor.Redact(v.Error())
+	case fmt.Stringer:
+		return h.opts.Redactor.Redact(v.String())
+	case string:
+		return h.opts.Redactor.Redact(v)
+	default:
+		return h.opts.Redactor.Redact(fmt.Sprint(v))
+	}
+}
+
+func formatText(b *strings.Builder, key string, value interface{}) {
+	if b.Len() > 0 {
+		b.WriteByte(' ')
+	}
+	b.WriteString(key)
+	b.WriteByte('=')
+	s, ok := value.(string)
+	if !ok {
+		s = fmt.Sprint(value)
+		if value == nil {
+			s = "<nil>"
+		}
+	}
+	if s == "" || strings.ContainsAny(s, " =\"\n\t") {
+		s = strconv.Quote(s)
+	}
+	b.WriteString(s)
+}
+
+func formatJSON(b *strings.Builder, key string, value interface{}) {
+	if b.Len() > 1 {
+		b.WriteByte(',')
+	}
+	k, _ := json.Marshal(key)
+	v, err := json.Marshal(value)
+	if err != nil {
+		// NaN and infinite floats
+		v, _ = json.Marshal(fmt.Sprint(value))
+	}
+	b.Write(k)
+	b.WriteByte(':')
+	b.Write(v)
+}
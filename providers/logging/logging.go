@@ -0,0 +1,140 @@
+package logging
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Level is how severe a log record is, the levels are spaced like log/slog's
+type Level int
+
+const (
+	LevelDebug Level = -4
+	LevelInfo  Level = 0
+	LevelWarn  Level = 4
+	LevelError Level = 8
+)
+
+func (l Level) String() string {
+	switch {
+	case l < LevelInfo:
+		return "DEBUG"
+	case l < LevelWarn:
+		return "INFO"
+	case l < LevelError:
+		return "WARN"
+	default:
+		return "ERROR"
+	}
+}
+
+// ParseLevel reads debug, info, warn or error, in any case
+func ParseLevel(s string) (Level, error) {
+	switch strings.ToLower(s) {
+	case "debug":
+		return LevelDebug, nil
+	case "info":
+		return LevelInfo, nil
+	case "warn", "warning":
+		return LevelWarn, nil
+	case "error":
+		return LevelError, nil
+	}
+	return 0, fmt.Errorf("unknown log level %q, use debug, info, warn or error", s)
+}
+
+// Attr is a field of a log record
+type Attr struct {
+	Key   string
+	Value interface{}
+}
+
+// Record is one log line before a handler formats it
+type Record struct {
+	Time    time.Time
+	Level   Level
+	Message string
+	Attrs   []Attr
+}
+
+// Handler writes the records a Logger passes it
+type Handler interface {
+	Enabled(Level) bool
+	Handle(Record) error
+}
+
+// Logger logs messages with fields to a Handler, a nil Logger logs nothing. Fields are given
+// as alternating keys and values, like log/slog
+type Logger struct {
+	handler Handler
+	attrs   []Attr
+	wire    bool
+}
+
+func New(h Handler) *Logger {
+	return &Logger{handler: h}
+}
+
+// With returns a logger adding the given fields to every record
+func (l *Logger) With(args ...interface{}) *Logger {
+	if l == nil {
+		return nil
+	}
+	w := *l
+	w.attrs = append(append([]Attr(nil), l.attrs...), attrs(args)...)
+	return &w
+}
+
+// WithWire returns a logger that also logs every HTTP request the providers send, at debug
+func (l *Logger) WithWire() *Logger {
+	if l == nil {
+		return nil
+	}
+	w := *l
+	w.wire = true
+	return &w
+}
+
+// Wire reports whether HTTP requests are to be logged
+func (l *Logger) Wire() bool {
+	return l != nil && l.wire && l.Enabled(LevelDebug)
+}
+
+func (l *Logger) Enabled(level Level) bool {
+	return l != nil && l.handler.Enabled(level)
+}
+
+func (l *Logger) Log(level Level, msg string, args ...interface{}) {
+	if !l.Enabled(level) {
+		return
+	}
+	r := Record{Time: time.Now(), Level: level, Message: msg, Attrs: append(append([]Attr(nil), l.attrs...), attrs(args)...)}
+	// logging must not fail what is being logged
+	_ = l.handler.Handle(r)
+}
+
+func (l *Logger) Debug(msg string, args ...interface{}) { l.Log(LevelDebug, msg, args...) }
+func (l *Logger) Info(msg string, args ...interface{})  { l.Log(LevelInfo, msg, args...) }
+func (l *Logger) Warn(msg string, args ...interface{})  { l.Log(LevelWarn, msg, args...) }
+func (l *Logger) Error(msg string, args ...interface{}) { l.Log(LevelError, msg, args...) }
+
+// attrs pairs keys with values, a value without a string key before it gets the key !BADKEY
+func attrs(args []interface{}) []Attr {
+	var as []Attr
+	for len(args) > 0 {
+		switch key := args[0].(type) {
+		case Attr:
+			as, args = append(as, key), args[1:]
+		case string:
+			if len(args) == 1 {
+				as, args = append(as, Attr{"!BADKEY", key}), nil
+				continue
+			}
+			as, args = append(as, Attr{key, args[1]}), args[2:]
+		default:
+			as, args = append(as, Attr{"!BADKEY", key}), args[1:]
+		}
+	}
+	return as
+}
@@ -0,0 +1,77 @@
+package logging
+
+import (
+	"regexp"
+	"strings"
+	"sync"
+)
+
+const redacted = "REDACTED"
+
+// patterns match secrets by their shape, the first group is kept and the rest replaced
+var patterns = []*regexp.Regexp{
+	// signed URLs and SAS tokens
+	regexp.MustCompile(`(?i)([?&](?:sig|signature|x-amz-signature|x-amz-credential|x-amz-security-token|x-goog-signature|x-goog-credential|googleaccessid|access_token|token)=)[^&\s"'<>]+`),
+	// Azure connection strings
+	regexp.MustCompile(`(?i)(\b(?:AccountKey|SharedAccessSignature|SharedAccessKey)=)[^;\s"']+`),
+	// headers, as dumped or as key value pairs
+	regexp.MustCompile(`(?i)(\b(?:authorization|proxy-authorization)["']?\s*[:=]\s*["']?)[^"'\r\n]+`),
+	regexp.MustCompile(`(?i)(\b(?:x-amz-security-token|x-ms-copy-source-authorization)["']?\s*[:=]\s*["']?)[^"'\s]+`),
+	// credentials files
+	regexp.MustCompile(`(?i)("(?:private_key|client_secret|refresh_token|access_token|secret_access_key)"\s*:\s*")[^"]*`),
+	regexp.MustCompile(`(?i)(\b(?:aws_secret_access_key|aws_session_token)\s*=\s*)\S+`),
+	regexp.MustCompile(`()-----BEGIN [A-Z ]*PRIVATE KEY-----[\s\S]*?-----END [A-Z ]*PRIVATE KEY-----`),
+}
+
+// sensitiveKeys are field keys whose values are always redacted, matched without case, dashes
+// or underscores
+var sensitiveKeys = []string{"password", "passphrase", "secret", "token", "accountkey", "authorization", "signature", "privatekey"}
+
+// Redactor removes secrets from what is logged: values shaped like account keys, SAS tokens,
+// signed URLs, authorization headers and private keys, and the secrets it was given. A nil
+// Redactor still removes those recognized by their shape
+type Redactor struct {
+	mu      sync.RWMutex
+	secrets []string
+}
+
+func NewRedactor(secrets ...string) *Redactor {
+	r := &Redactor{}
+	r.Add(secrets...)
+	return r
+}
+
+// Add has the redactor remove secrets wherever they appear, ex: an account key from the config
+func (r *Redactor) Add(secrets ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, s := range secrets {
+		if s != "" {
+			r.secrets = append(r.secrets, s)
+		}
+	}
+}
+
+func (r *Redactor) Redact(s string) string {
+	if r != nil {
+		r.mu.RLock()
+		for _, secret := range r.secrets {
+			s = strings.ReplaceAll(s, secret, redacted)
+		}
+		r.mu.RUnlock()
+	}
+	for _, p := range patterns {
+		s = p.ReplaceAllString(s, "${1}"+redacted)
+	}
+	return s
+}
+
+func sensitive(key string) bool {
+	key = strings.NewReplacer("-", "", "_", "", ".", "").Replace(strings.ToLower(key))
+	for _, k := range sensitiveKeys {
+		if strings.Contains(key, k) {
+			return true
+		}
+	}
+	return false
+}
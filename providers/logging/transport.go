@@ -0,0 +1,46 @@
+package logging
+
+import (
+	"net/http"
+	"time"
+)
+
+// requestIDHeaders are where each provider returns the ID support asks for
+var requestIDHeaders = []string{"X-Amz-Request-Id", "X-Ms-Request-Id", "X-Guploader-Uploadid"}
+
+// Transport logs every request sent through Base and its response at debug, URLs are redacted
+// like any other value so signed URLs and SAS tokens don't leak
+type Transport struct {
+	Base http.RoundTripper
+	Log  *Logger
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	began := time.Now()
+	t.Log.Debug("http request", "method", req.Method, "url", req.URL.String(), "length", req.ContentLength)
+	resp, err := base.RoundTrip(req)
+	if err != nil {
+		t.Log.Debug("http request failed", "method", req.Method, "url", req.URL.String(), "duration", time.Since(began), "error", err)
+		return resp, err
+	}
+	args := []interface{}{"method", req.Method, "url", req.URL.String(), "status", resp.StatusCode, "duration", time.Since(began)}
+	if id := RequestID(resp.Header); id != "" {
+		args = append(args, "request_id", id)
+	}
+	t.Log.Debug("http response", args...)
+	return resp, nil
+}
+
+// RequestID is the ID the provider gave the request in its response headers, empty without one
+func RequestID(h http.Header) string {
+	for _, name := range requestIDHeaders {
+		if id := h.Get(name); id != "" {
+			return id
+		}
+	}
+	return ""
+}
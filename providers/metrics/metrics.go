@@ -0,0 +1,271 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Registry holds metrics and writes them in the Prometheus text format, for a /metrics endpoint
+// or the textfile collector of node_exporter
+type Registry struct {
+	mu       sync.Mutex
+	families []*family
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// family is a metric and its series, one for every combination of label values seen
+type family struct {
+	name, help, kind string
+	labels           []string
+	buckets          []float64
+
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+type series struct {
+	values []string
+	value  float64
+	// counts and sum are a histogram's, counts[i] observations fell in buckets[i], the last in +Inf
+	counts []uint64
+	sum    float64
+}
+
+func (r *Registry) register(f *family) *family {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, existing := range r.families {
+		if existing.name == f.name {
+			panic(fmt.Sprintf("metric %s registered twice", f.name))
+		}
+	}
+	f.series = map[string]*series{}
+	r.families = append(r.families, f)
+	return f
+}
+
+// with returns the series of the label values, which must be as many as f has labels
+func (f *family) with(values []string) *series {
+	if len(values) != len(f.labels) {
+		panic(fmt.Sprintf("metric %s has labels %v, got values %v", f.name, f.labels, values))
+	}
+	key := strings.Join(values, "\xff")
+	s, ok := f.series[key]
+	if !ok {
+		s = &series{values: append([]string(nil), values...)}
+		if f.buckets != nil {
+			s.counts = make([]uint64, len(f.buckets)+1)
+		}
+		f.series[key] = s
+	}
+	return s
+}
+
+// Counter is a value that only goes up, ex: uploads done
+type Counter struct{ f *family }
+
+func (r *Registry) NewCounter(name, help string, labels ...string) *Counter {
+	return &Counter{r.register(&family{name: name, help: help, kind: "counter", labels: labels})}
+}
+
+// Add adds v, which must not be negative, to the series of the label values
+func (c *Counter) Add(v float64, labelValues ...string) {
+	if c == nil {
+		return
+	}
+	if v < 0 {
+		panic("counter " + c.f.name + " can't go down")
+	}
+	c.f.mu.Lock()
+	defer c.f.mu.Unlock()
+	c.f.with(labelValues).value += v
+}
+
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Gauge is a value that goes up and down, ex: uploads in flight
+type Gauge struct{ f *family }
+
+func (r *Registry) NewGauge(name, help string, labels ...string) *Gauge {
+	return &Gauge{r.register(&family{name: name, help: help, kind: "gauge", labels: labels})}
+}
+
+func (g *Gauge) Set(v float64, labelValues ...string) {
+	if g == nil {
+		return
+	}
+	g.f.mu.Lock()
+	defer g.f.mu.Unlock()
+	g.f.with(labelValues).value = v
+}
+
+func (g *Gauge) Add(v float64, labelValues ...string) {
+	if g == nil {
+		return
+	}
+	g.f.mu.Lock()
+	defer g.f.mu.Unlock()
+	g.f.with(labelValues).value += v
+}
+
+// Histogram counts observations in buckets by their upper bound, ex: upload durations
+type Histogram struct{ f *family }
+
+// NewHistogram counts observations in buckets, given as increasing upper bounds. Observations
+// above the last bound are only counted in +Inf
+func (r *Registry) NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	if !sort.Float64sAreSorted(buckets) {
+		panic("histogram " + name + " buckets must increase")
+	}
+	return &Histogram{r.register(&family{name: name, help: help, kind: "histogram", labels: labels, buckets: buckets})}
+}
+
+func (h *Histogram) Observe(v float64, labelValues ...string) {
+	if h == nil {
+		return
+	}
+	h.f.mu.Lock()
+	defer h.f.mu.Unlock()
+	s := h.f.with(labelValues)
+	s.counts[sort.SearchFloat64s(h.f.buckets, v)]++
+	s.sum += v
+}
+
+// ExponentialBuckets returns count bounds, the first start and each factor times the one before
+func ExponentialBuckets(start, factor float64, count int) []float64 {
+	buckets := make([]float64, count)
+	for i := range buckets {
+		buckets[i] = start
+		start *= factor
+	}
+	return buckets
+}
+
+// WriteTo writes every metric in the Prometheus text format, series sorted by label values
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	families := append([]*family(nil), r.families...)
+	r.mu.Unlock()
+
+	cw := &countingWriter{w: bufio.NewWriter(w)}
+	for _, f := range families {
+		f.write(cw)
+	}
+	if cw.err == nil {
+		cw.err = cw.w.(*bufio.Writer).Flush()
+	}
+	return cw.n, cw.err
+}
+
+func (f *family) write(w *countingWriter) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n", f.name, strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(f.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", f.name, f.kind)
+	keys := make([]string, 0, len(f.series))
+	for k := range f.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		s := f.series[k]
+		if f.buckets == nil {
+			fmt.Fprintf(w, "%s%s %s\n", f.name, labels(f.labels, s.values, ""), formatFloat(s.value))
+			continue
+		}
+		var cumulative uint64
+		for i, bound := range f.buckets {
+			cumulative += s.counts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", f.name, labels(f.labels, s.values, formatFloat(bound)), cumulative)
+		}
+		cumulative += s.counts[len(f.buckets)]
+		fmt.Fprintf(w, "%s_bucket%s %d\n", f.name, labels(f.labels, s.values, "+Inf"), cumulative)
+		fmt.Fprintf(w, "%s_sum%s %s\n", f.name, labels(f.labels, s.values, ""), formatFloat(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", f.name, labels(f.labels, s.values, ""), cumulative)
+	}
+}
+
+// labels formats the label pairs of a series, with le for a histogram bucket when given
+func labels(names, values []string, le string) string {
+	if len(names) == 0 && le == "" {
+		return ""
+	}
+	escape := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	var pairs []string
+	for i, n := range names {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, n, escape.Replace(values[i])))
+	}
+	if le != "" {
+		pairs = append(pairs, fmt.Sprintf(`le="%s"`, le))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+type countingWriter struct {
+	w   io.Writer
+	n   int64
+	err error
+}
+
+func (c *countingWriter) Write(b []byte) (int, error) {
+	if c.err != nil {
+		return 0, c.err
+	}
+	n, err := c.w.Write(b)
+	c.n += int64(n)
+	c.err = err
+	return n, err
+}
+
+// Handler serves the metrics for Prometheus to scrape
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		_, _ = r.WriteTo(w)
+	})
+}
+
+// WriteTextfile writes the metrics to path for node_exporter's textfile collector, through a
+// temporary file renamed over path so the collector never reads a partial file
+func (r *Registry) WriteTextfile(path string) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err = r.WriteTo(tmp); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	if err = os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,82 @@
+package metrics
+
+import (
+	"github.com/stretchr/testify/require"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRegistry_WriteTo(t *testing.T) {
+	reg := NewRegistry()
+	uploads := reg.NewCounter("uploads_total", "Uploads finished", "provider", "status")
+	inFlight := reg.NewGauge("in_flight", "Uploads running")
+	duration := reg.NewHistogram("duration_seconds", "Upload durations", []float64{1, 10}, "provider")
+
+	uploads.Inc("gcp", "done")
+	uploads.Add(2, "aws", "done")
+	uploads.Inc("aws", `fa"il`)
+	inFlight.Add(3)
+	inFlight.Add(-1)
+	duration.Observe(0.5, "aws")
+	duration.Observe(1, "aws")
+	duration.Observe(30, "aws")
+
+	out := strings.Builder{}
+	_, err := reg.WriteTo(&out)
+	require.NoError(t, err)
+	require.Equal(t, `# HELP uploads_total Uploads finished
+# TYPE uploads_total counter
+uploads_total{provider="aws",status="done"} 2
+uploads_total{provider="aws",status="fa\"il"} 1
+uploads_total{provider="gcp",status="done"} 1
+# HELP in_flight Uploads running
+# TYPE in_flight gauge
+in_flight 2
+# HELP duration_seconds Upload durations
+# TYPE duration_seconds histogram
+duration_seconds_bucket{provider="aws",le="1"} 2
+duration_seconds_bucket{provider="aws",le="10"} 2
+duration_seconds_bucket{provider="aws",le="+Inf"} 3
+duration_seconds_sum{provider="aws"} 31.5
+duration_seconds_count{provider="aws"} 3
+`, out.String())
+}
+
+func TestRegistry_Misuse(t *testing.T) {
+	reg := NewRegistry()
+	c := reg.NewCounter("c", "", "provider")
+	require.Panics(t, func() { reg.NewGauge("c", "") }, "registered twice")
+	require.Panics(t, func() { c.Inc() }, "missing label value")
+	require.Panics(t, func() { c.Add(-1, "aws") }, "counters don't go down")
+	require.Panics(t, func() { reg.NewHistogram("h", "", []float64{10, 1}) }, "buckets out of order")
+
+	var nilCounter *Counter
+	require.NotPanics(t, func() { nilCounter.Inc("aws") })
+}
+
+func TestRegistry_Export(t *testing.T) {
+	reg := NewRegistry()
+	reg.NewCounter("uploads_total", "Uploads").Inc()
+
+	rec := httptest.NewRecorder()
+	reg.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	require.Contains(t, rec.Header().Get("Content-Type"), "version=0.0.4")
+	require.Contains(t, rec.Body.String(), "uploads_total 1\n")
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "uploader.prom")
+	require.NoError(t, reg.WriteTextfile(path))
+	b, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, rec.Body.String(), string(b))
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, entries, 1, "the temporary file is renamed")
+}
+
+func TestExponentialBuckets(t *testing.T) {
+	require.Equal(t, []float64{1, 4, 16}, ExponentialBuckets(1, 4, 3))
+}
@@ -0,0 +1,181 @@
+package providers
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"strings"
+	"time"
+)
+
+// ErrNotFound is wrapped by Stat, Download and Delete errors when the object or bucket does not exist
+var ErrNotFound = errors.New("not found")
+
+// Checksums are lowercase hex digests, empty when a provider does not report one
+type Checksums struct {
+	MD5    string `json:"md5,omitempty"`
+	CRC32C string `json:"crc32c,omitempty"`
+	SHA256 string `json:"sha256,omitempty"`
+}
+
+// ErrChecksumMismatch is wrapped by VerifyChecksums errors when a provider stored other bytes
+// than were sent
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+// Hasher computes every Checksums digest at once from what is written to it, so content can be
+// hashed while it streams to providers
+type Hasher struct {
+	md5, crc, sha hash.Hash
+	w             io.Writer
+	n             int64
+}
+
+func NewHasher() *Hasher {
+	h := &Hasher{md5: md5.New(), crc: crc32.New(crc32.MakeTable(crc32.Castagnoli)), sha: sha256.New()}
+	h.w = io.MultiWriter(h.md5, h.crc, h.sha)
+	return h
+}
+
+func (h *Hasher) Write(b []byte) (int, error) {
+	n, err := h.w.Write(b)
+	h.n += int64(n)
+	return n, err
+}
+
+// Size is how many bytes were written
+func (h *Hasher) Size() int64 {
+	return h.n
+}
+
+// Sum returns the checksums of everything written so far
+func (h *Hasher) Sum() Checksums {
+	return Checksums{
+		MD5:    hex.EncodeToString(h.md5.Sum(nil)),
+		CRC32C: hex.EncodeToString(h.crc.Sum(nil)),
+		SHA256: hex.EncodeToString(h.sha.Sum(nil)),
+	}
+}
+
+// ComputeChecksums reads r to the end and returns its checksums and length
+func ComputeChecksums(r io.Reader) (Checksums, int64, error) {
+	h := NewHasher()
+	n, err := io.Copy(h, r)
+	if err != nil {
+		return Checksums{}, n, err
+	}
+	return h.Sum(), n, nil
+}
+
+// VerifyChecksums compares the size and checksums of what was sent with those a provider
+// reports for the stored object. It returns the checksums both sides have and agree on, empty
+// when the provider reports none, and an error wrapping ErrChecksumMismatch on any difference.
+// Stored metadata is not used, it only repeats what the uploader computed
+func VerifyChecksums(sent Checksums, size int64, stored ObjectInfo) (Checksums, error) {
+	if stored.Size != size {
+		return Checksums{}, fmt.Errorf("%w: sent %d bytes, %s holds %d", ErrChecksumMismatch, size, stored.Key, stored.Size)
+	}
+	var verified Checksums
+	pairs := []struct {
+		name         string
+		sent, stored string
+		into         *string
+	}{
+		{"md5", sent.MD5, stored.Checksums.MD5, &verified.MD5},
+		{"crc32c", sent.CRC32C, stored.Checksums.CRC32C, &verified.CRC32C},
+		{"sha256", sent.SHA256, stored.Checksums.SHA256, &verified.SHA256},
+	}
+	for _, p := range pairs {
+		if p.sent == "" || p.stored == "" {
+			continue
+		}
+		if !strings.EqualFold(p.sent, p.stored) {
+			return Checksums{}, fmt.Errorf("%w: sent %s %s, %s holds %s", ErrChecksumMismatch, p.name, p.sent, stored.Key, p.stored)
+		}
+		*p.into = strings.ToLower(p.sent)
+	}
+	return verified, nil
+}
+
+// Empty reports whether no checksum is set
+func (c Checksums) Empty() bool {
+	return c == Checksums{}
+}
+
+// Metadata keys written by uploader, in the lowercase form every provider accepts
+const (
+	// MetadataSHA256 holds the hex SHA-256 of the content
+	MetadataSHA256 = "sha256"
+	// MetadataMTime holds the source file's modification time as RFC 3339 in UTC
+	MetadataMTime = "mtime"
+)
+
+// ObjectInfo describes a stored object, fields a provider does not report are left empty
+type ObjectInfo struct {
+	Key          string            `json:"key"`
+	Size         int64             `json:"size"`
+	ETag         string            `json:"etag,omitempty"`
+	LastModified time.Time         `json:"lastModified"`
+	ContentType  string            `json:"contentType,omitempty"`
+	VersionID    string            `json:"versionId,omitempty"`
+	Checksums    Checksums         `json:"checksums"`
+	Metadata     map[string]string `json:"metadata,omitempty"`
+}
+
+// MetadataValue looks up a metadata key ignoring case, since some providers change it
+func (o ObjectInfo) MetadataValue(key string) string {
+	if v, ok := o.Metadata[key]; ok {
+		return v
+	}
+	for k, v := range o.Metadata {
+		if strings.EqualFold(k, key) {
+			return v
+		}
+	}
+	return ""
+}
+
+type ListOptions struct {
+	Prefix string
+	// PageToken continues a previous listing, taken from ListPage.NextPageToken
+	PageToken string
+	// MaxKeys caps the page size, zero uses the provider's default
+	MaxKeys int
+}
+
+type ListPage struct {
+	Objects []ObjectInfo
+	// NextPageToken is empty on the last page
+	NextPageToken string
+}
+
+// Stater is implemented by uploaders that can describe a stored object
+type Stater interface {
+	Stat(ctx context.Context, bucket, key string) (ObjectInfo, error)
+}
+
+// Lister is implemented by uploaders that can list a bucket one page at a time
+type Lister interface {
+	List(ctx context.Context, bucket string, opts ListOptions) (ListPage, error)
+}
+
+// ListAll pages through every object under opts.Prefix
+func ListAll(ctx context.Context, l Lister, bucket string, opts ListOptions) ([]ObjectInfo, error) {
+	var objects []ObjectInfo
+	for {
+		page, err := l.List(ctx, bucket, opts)
+		if err != nil {
+			return objects, err
+		}
+		objects = append(objects, page.Objects...)
+		if page.NextPageToken == "" {
+			return objects, nil
+		}
+		opts.PageToken = page.NextPageToken
+	}
+}
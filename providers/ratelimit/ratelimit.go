@@ -0,0 +1,124 @@
+package ratelimit
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Bucket is a token bucket refilled at a rate per second, holding at most a second's worth of
+// tokens. A rate of 0 never waits. The rate can change while the bucket is in use, waits
+// already under way keep the rate they started with. A nil Bucket never waits
+type Bucket struct {
+	mu     sync.Mutex
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+// New returns a bucket of the given rate, full
+func New(rate float64) *Bucket {
+	b := &Bucket{}
+	b.SetRate(rate)
+	return b
+}
+
+// SetRate changes the rate, 0 or less to stop limiting
+func (b *Bucket) SetRate(rate float64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if rate < 0 {
+		rate = 0
+	}
+	if b.rate == 0 || b.tokens > burst(rate) {
+		b.tokens = burst(rate)
+	}
+	b.rate, b.last = rate, time.Now()
+}
+
+func (b *Bucket) Rate() float64 {
+	if b == nil {
+		return 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.rate
+}
+
+// burst is how many tokens a bucket holds, a second's worth and at least one
+func burst(rate float64) float64 {
+	if rate < 1 {
+		return 1
+	}
+	return rate
+}
+
+// WaitN takes n tokens, waiting until the bucket has refilled enough for them or ctx is done.
+// n may exceed the burst, the wait is then longer than a second
+func (b *Bucket) WaitN(ctx context.Context, n int) error {
+	if b == nil || n <= 0 {
+		return nil
+	}
+	b.mu.Lock()
+	if b.rate == 0 {
+		b.mu.Unlock()
+		return nil
+	}
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if max := burst(b.rate); b.tokens > max {
+		b.tokens = max
+	}
+	b.last = now
+	// taken now so concurrent waiters queue up behind each other
+	b.tokens -= float64(n)
+	wait := time.Duration(-b.tokens / b.rate * float64(time.Second))
+	b.mu.Unlock()
+	if wait <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		b.mu.Lock()
+		b.tokens += float64(n)
+		b.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+// Wait takes n tokens from every bucket
+func Wait(ctx context.Context, n int, buckets ...*Bucket) error {
+	for _, b := range buckets {
+		if err := b.WaitN(ctx, n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ChunkSize is the most a limited reader reads at once, so the bandwidth is spread evenly
+// rather than spent in bursts of whole buffers
+const ChunkSize = 32 << 10
+
+// Transport limits the requests sent through Base to the rate of Bucket, one request per token
+type Transport struct {
+	Base   http.RoundTripper
+	Bucket *Bucket
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := t.Bucket.WaitN(req.Context(), 1); err != nil {
+		return nil, err
+	}
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return base.RoundTrip(req)
+}
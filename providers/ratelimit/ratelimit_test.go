@@ -0,0 +1,47 @@
+package ratelimit
+
+import (
+	"context"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestBucket(t *testing.T) {
+	b := New(100)
+	began := time.Now()
+	// a second's worth at once, then 20 more at 100 per second
+	require.NoError(t, b.WaitN(context.Background(), 100))
+	require.Less(t, int64(time.Since(began)), int64(50*time.Millisecond))
+	require.NoError(t, b.WaitN(context.Background(), 20))
+	require.GreaterOrEqual(t, time.Since(began), 150*time.Millisecond)
+
+	// cancelled waits give their tokens back
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	require.ErrorIs(t, b.WaitN(ctx, 1000), context.DeadlineExceeded)
+
+	b.SetRate(0)
+	began = time.Now()
+	require.NoError(t, b.WaitN(context.Background(), 1e6))
+	require.Less(t, int64(time.Since(began)), int64(50*time.Millisecond))
+
+	var unlimited *Bucket
+	require.NoError(t, unlimited.WaitN(context.Background(), 1e6))
+}
+
+func TestTransport(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+	client := &http.Client{Transport: &Transport{Bucket: New(10)}}
+
+	began := time.Now()
+	for i := 0; i < 12; i++ {
+		resp, err := client.Get(srv.URL)
+		require.NoError(t, err)
+		resp.Body.Close()
+	}
+	require.GreaterOrEqual(t, time.Since(began), 150*time.Millisecond)
+}
@@ -0,0 +1,236 @@
+package repair
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/stevequadros/uploader/providers"
+	"github.com/stevequadros/uploader/providers/coordinator"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Entry is an object that didn't reach one provider
+type Entry struct {
+	Provider providers.Provider `json:"provider"`
+	Bucket   string             `json:"bucket"`
+	Key      string             `json:"key"`
+	// Path is the local file it was uploaded from, empty for stdin
+	Path string `json:"path,omitempty"`
+	// Size and SHA256 describe the content that was uploaded, when known. A file that no
+	// longer matches them is not used, the object is copied from another provider instead
+	Size   int64  `json:"size,omitempty"`
+	SHA256 string `json:"sha256,omitempty"`
+	// Copies holds the key the object was stored under on each provider it did reach
+	Copies map[providers.Provider]string `json:"copies,omitempty"`
+	// Error is the latest failure, from the upload or the last repair attempt
+	Error       string    `json:"error"`
+	Attempts    int       `json:"attempts"`
+	Created     time.Time `json:"created"`
+	LastAttempt time.Time `json:"lastAttempt,omitempty"`
+	// Parked entries failed in a way retrying won't fix, or MaxAttempts times, repair leaves
+	// them alone unless asked for them
+	Parked bool `json:"parked,omitempty"`
+}
+
+// Deferred reports whether the entry was left to repair by a soft deadline rather than failed
+func (e Entry) Deferred() bool {
+	return e.Error == coordinator.ErrDeferred.Error()
+}
+
+// ID identifies an entry, a later failure of the same object on the same provider replaces it
+func (e Entry) ID() string {
+	return fmt.Sprintf("%s:%s/%s", e.Provider, e.Bucket, e.Key)
+}
+
+type op string
+
+const (
+	opAdd    op = "add"
+	opRemove op = "remove"
+)
+
+// record is one line of the journal
+type record struct {
+	Op    op    `json:"op"`
+	Entry Entry `json:"entry"`
+}
+
+// Queue is a journal of entries kept in a file, one JSON record per line. Changes are appended
+// and synced to disk before returning, so nothing queued is lost if the process dies. Compact
+// rewrites the journal with only the entries still queued
+type Queue struct {
+	path    string
+	mu      sync.Mutex
+	entries map[string]Entry
+}
+
+// DefaultPath is the journal under the user's config directory
+func DefaultPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "uploader", "repair-queue.jsonl"), nil
+}
+
+// Open replays the journal at path, a missing journal is an empty queue
+func Open(path string) (*Queue, error) {
+	q := &Queue{path: path, entries: map[string]Entry{}}
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return q, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var r record
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			// only the last line can be torn by a crash mid write, anything else is corruption
+			if !scanner.Scan() {
+				break
+			}
+			return nil, fmt.Errorf("repair queue %s line %d: %w", path, line, err)
+		}
+		switch r.Op {
+		case opAdd:
+			q.entries[r.Entry.ID()] = r.Entry
+		case opRemove:
+			delete(q.entries, r.Entry.ID())
+		}
+	}
+	return q, scanner.Err()
+}
+
+// Path is where the journal is kept
+func (q *Queue) Path() string {
+	return q.path
+}
+
+// Add queues entries, replacing any queued for the same object and provider
+func (q *Queue) Add(entries ...Entry) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	records := make([]record, len(entries))
+	for i, e := range entries {
+		if e.Created.IsZero() {
+			e.Created = time.Now().UTC()
+		}
+		if old, ok := q.entries[e.ID()]; ok {
+			e.Created = old.Created
+		}
+		records[i] = record{opAdd, e}
+	}
+	if err := q.append(records); err != nil {
+		return err
+	}
+	for _, r := range records {
+		q.entries[r.Entry.ID()] = r.Entry
+	}
+	return nil
+}
+
+// Remove drops an entry once it is repaired
+func (q *Queue) Remove(e Entry) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if err := q.append([]record{{opRemove, Entry{Provider: e.Provider, Bucket: e.Bucket, Key: e.Key}}}); err != nil {
+		return err
+	}
+	delete(q.entries, e.ID())
+	return nil
+}
+
+// Entries returns the queued entries, oldest first
+func (q *Queue) Entries() []Entry {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	entries := make([]Entry, 0, len(q.entries))
+	for _, e := range q.entries {
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if !entries[i].Created.Equal(entries[j].Created) {
+			return entries[i].Created.Before(entries[j].Created)
+		}
+		return entries[i].ID() < entries[j].ID()
+	})
+	return entries
+}
+
+// Len is how many entries are queued
+func (q *Queue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.entries)
+}
+
+func (q *Queue) append(records []record) error {
+	if err := os.MkdirAll(filepath.Dir(q.path), 0700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(q.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for _, r := range records {
+		if err = enc.Encode(r); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err = w.Flush(); err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// Compact rewrites the journal with only the queued entries, replacing it atomically
+func (q *Queue) Compact() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if err := os.MkdirAll(filepath.Dir(q.path), 0700); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(q.path), ".repair-queue-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	w := bufio.NewWriter(tmp)
+	enc := json.NewEncoder(w)
+	for _, e := range q.entries {
+		if err = enc.Encode(record{opAdd, e}); err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+	if err = w.Flush(); err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), q.path)
+}
@@ -0,0 +1,199 @@
+package repair
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/stevequadros/uploader/providers"
+	"github.com/stevequadros/uploader/providers/coordinator"
+	"github.com/stevequadros/uploader/providers/ratelimit"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+var errSourceChanged = errors.New("no longer matches what was uploaded")
+
+// Result is the outcome of retrying one entry
+type Result struct {
+	Entry Entry
+	// From is where the content came from, the local file or the provider it was copied from
+	From string
+	Err  error
+}
+
+// FromBatch turns every failed or deferred upload in res into an entry, files are in the order DoBatch was given
+func FromBatch(bucket string, files []coordinator.BatchFile, res coordinator.BatchResult) []Entry {
+	var entries []Entry
+	for i, f := range res.Files {
+		sha := files[i].SHA256
+		if f.Checksums.SHA256 != "" {
+			sha = f.Checksums.SHA256
+		}
+		entries = append(entries, failures(bucket, f.Path, files[i].KeyFor, f.Size, sha, f.Done, f.Skipped, withDeferred(f.Failed, f.Deferred))...)
+	}
+	return entries
+}
+
+// FromResult turns every failed or deferred upload of a single Do or DoStream into an entry, path is empty
+// for a stream
+func FromResult(bucket, path string, keys coordinator.ProviderKeys, res coordinator.DoResult) []Entry {
+	keyFor := func(p providers.Provider) string { return keys[p] }
+	return failures(bucket, path, keyFor, res.Size, res.Checksums.SHA256, res.Done, res.Skipped, withDeferred(res.Failed, res.Deferred))
+}
+
+// withDeferred adds the uploads cut short by the soft deadline to failed, they are repaired alike
+func withDeferred(failed []coordinator.DoError, deferred []providers.Provider) []coordinator.DoError {
+	if len(deferred) == 0 {
+		return failed
+	}
+	all := append([]coordinator.DoError{}, failed...)
+	for _, p := range deferred {
+		all = append(all, coordinator.DoError{Provider: p, Error: coordinator.ErrDeferred})
+	}
+	return all
+}
+
+func failures(bucket, path string, keyFor func(providers.Provider) string, size int64, sha string, done, skipped []providers.Provider, failed []coordinator.DoError) []Entry {
+	if len(failed) == 0 {
+		return nil
+	}
+	copies := map[providers.Provider]string{}
+	for _, p := range append(append([]providers.Provider{}, done...), skipped...) {
+		copies[p] = keyFor(p)
+	}
+	entries := make([]Entry, len(failed))
+	for i, f := range failed {
+		entries[i] = Entry{
+			Provider: f.Provider,
+			Bucket:   bucket,
+			Key:      keyFor(f.Provider),
+			Path:     path,
+			Size:     size,
+			SHA256:   sha,
+			Copies:   copies,
+			Error:    f.Error.Error(),
+			Parked:   !providers.Retryable(f.Error),
+		}
+	}
+	return entries
+}
+
+// MaxAttempts is how many repairs of an entry may fail before it is parked
+const MaxAttempts = 10
+
+// Run retries entries and updates q: repaired entries are removed, the others are queued
+// again with the new error and attempt count, parked when the error isn't Retryable or they
+// reached MaxAttempts. Entries are retried one at a time, within the providers' limits and
+// bandwidth's, which may be nil
+func Run(ctx context.Context, q *Queue, uploaders []providers.Uploader, bandwidth *ratelimit.Bucket, entries []Entry) []Result {
+	results := make([]Result, len(entries))
+	for i, e := range entries {
+		from, err := Retry(ctx, uploaders, bandwidth, e)
+		results[i] = Result{Entry: e, From: from, Err: err}
+		if err == nil {
+			if qErr := q.Remove(e); qErr != nil {
+				results[i].Err = fmt.Errorf("repaired but still queued: %w", qErr)
+			}
+			continue
+		}
+		e.Attempts++
+		e.LastAttempt = time.Now().UTC()
+		e.Error = err.Error()
+		e.Parked = !providers.Retryable(err) || e.Attempts >= MaxAttempts
+		results[i].Entry = e
+		if qErr := q.Add(e); qErr != nil {
+			results[i].Err = fmt.Errorf("%v, and updating the queue failed: %w", err, qErr)
+		}
+	}
+	return results
+}
+
+// Retry uploads e's object to its provider, from the local file when it still holds what was
+// uploaded, otherwise copied from a provider that has it. It returns where the content came from
+func Retry(ctx context.Context, uploaders []providers.Uploader, bandwidth *ratelimit.Bucket, e Entry) (string, error) {
+	byName := map[providers.Provider]providers.Uploader{}
+	for _, u := range uploaders {
+		byName[u.GetName()] = u
+	}
+	dest, ok := byName[e.Provider]
+	if !ok {
+		return "", fmt.Errorf("provider %s is not configured", e.Provider)
+	}
+
+	var errs []string
+	if e.Path != "" {
+		err := fromFile(ctx, dest, bandwidth, e)
+		if err == nil {
+			return e.Path, nil
+		}
+		if !errors.Is(err, errSourceChanged) && !errors.Is(err, os.ErrNotExist) {
+			return "", err
+		}
+		errs = append(errs, fmt.Sprintf("%s: %v", e.Path, err))
+	}
+
+	copies := make([]string, 0, len(e.Copies))
+	for p := range e.Copies {
+		copies = append(copies, string(p))
+	}
+	sort.Strings(copies)
+	for _, name := range copies {
+		p := providers.Provider(name)
+		src, ok := byName[p]
+		if !ok {
+			errs = append(errs, fmt.Sprintf("%s: not configured", p))
+			continue
+		}
+		if err := fromCopy(ctx, src, e.Copies[p], dest, bandwidth, e); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", p, err))
+			continue
+		}
+		return string(p), nil
+	}
+	if len(errs) == 0 {
+		return "", errors.New("no local file or copy to repair from")
+	}
+	return "", fmt.Errorf("no usable source, %s", strings.Join(errs, "; "))
+}
+
+func fromFile(ctx context.Context, dest providers.Uploader, bandwidth *ratelimit.Bucket, e Entry) error {
+	f, err := os.Open(e.Path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	sums, size, err := providers.ComputeChecksums(f)
+	if err != nil {
+		return err
+	}
+	if (e.Size > 0 && size != e.Size) || (e.SHA256 != "" && !strings.EqualFold(sums.SHA256, e.SHA256)) {
+		return errSourceChanged
+	}
+	if _, err = f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	return upload(ctx, dest, bandwidth, e, f, sums)
+}
+
+func fromCopy(ctx context.Context, src providers.Uploader, key string, dest providers.Uploader, bandwidth *ratelimit.Bucket, e Entry) error {
+	staged, sums, err := providers.Stage(ctx, src, e.Bucket, key)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(staged.Name())
+	defer staged.Close()
+	if e.SHA256 != "" && !strings.EqualFold(sums.SHA256, e.SHA256) {
+		return errSourceChanged
+	}
+	return upload(ctx, dest, bandwidth, e, staged, sums)
+}
+
+func upload(ctx context.Context, dest providers.Uploader, bandwidth *ratelimit.Bucket, e Entry, f *os.File, sums providers.Checksums) error {
+	return coordinator.Upload(ctx, dest, bandwidth, e.Bucket, e.Key, f, providers.UploadOptions{
+		Metadata:  map[string]string{providers.MetadataSHA256: sums.SHA256},
+		Checksums: sums,
+	})
+}
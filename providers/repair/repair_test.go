@@ -0,0 +1,180 @@
+package repair
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/stevequadros/uploader/providers"
+	"github.com/stevequadros/uploader/providers/coordinator"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// memStore is an in-memory provider, uploads fail while err is set
+type memStore struct {
+	name    providers.Provider
+	err     error
+	mu      sync.Mutex
+	objects map[string]string
+}
+
+var _ providers.Downloader = (*memStore)(nil)
+
+func newMemStore(name providers.Provider) *memStore {
+	return &memStore{name: name, objects: map[string]string{}}
+}
+
+func (m *memStore) GetName() providers.Provider { return m.name }
+func (m *memStore) Upload(ctx context.Context, bucket, key string, r io.ReadSeekCloser) error {
+	if m.err != nil {
+		return m.err
+	}
+	b, err := io.ReadAll(r)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.objects[key] = string(b)
+	return err
+}
+func (m *memStore) Download(ctx context.Context, bucket, key string) (io.ReadCloser, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	content, ok := m.objects[key]
+	if !ok {
+		return nil, providers.NewError(m.GetName(), "download", http.StatusNotFound, "", "", errors.New("missing"))
+	}
+	return io.NopCloser(bytes.NewReader([]byte(content))), nil
+}
+
+func TestQueue(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "nested", "queue.jsonl")
+	q, err := Open(path)
+	require.NoError(t, err)
+	require.Equal(t, 0, q.Len())
+
+	a := Entry{Provider: providers.GCP, Bucket: "b", Key: "a", Error: "timeout"}
+	b := Entry{Provider: providers.Azure, Bucket: "b", Key: "b", Error: "throttled"}
+	require.NoError(t, q.Add(a, b))
+	a.Error, a.Attempts = "still down", 1
+	require.NoError(t, q.Add(a))
+	require.NoError(t, q.Remove(b))
+
+	reopened, err := Open(path)
+	require.NoError(t, err)
+	entries := reopened.Entries()
+	require.Len(t, entries, 1)
+	require.Equal(t, "still down", entries[0].Error)
+	require.Equal(t, 1, entries[0].Attempts)
+	require.False(t, entries[0].Created.IsZero())
+
+	require.NoError(t, reopened.Compact())
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, 1, bytes.Count(content, []byte("\n")), "only the queued entry is left")
+
+	// a write torn by a crash is dropped, the entries before it are kept
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0600)
+	require.NoError(t, err)
+	_, err = f.WriteString(`{"op":"add","entry":{"prov`)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	reopened, err = Open(path)
+	require.NoError(t, err)
+	require.Equal(t, 1, reopened.Len())
+}
+
+func TestRun(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "a.txt")
+	require.NoError(t, os.WriteFile(path, []byte("aaa"), 0644))
+	sums, _, err := providers.ComputeChecksums(bytes.NewReader([]byte("aaa")))
+	require.NoError(t, err)
+
+	aws, gcp, azure := newMemStore(providers.AWS), newMemStore(providers.GCP), newMemStore(providers.Azure)
+	aws.objects["aws/a.txt"] = "aaa"
+	azure.err = errors.New("still down")
+	uploaders := []providers.Uploader{aws, gcp, azure}
+
+	q, err := Open(filepath.Join(dir, "queue.jsonl"))
+	require.NoError(t, err)
+	files := []coordinator.BatchFile{{Path: path, Key: "a.txt", Keys: coordinator.ProviderKeys{providers.AWS: "aws/a.txt"}}}
+	res := coordinator.BatchResult{Files: []coordinator.FileResult{{
+		Path: path, Key: "aws/a.txt", Size: 3, Done: []providers.Provider{providers.AWS}, Checksums: sums,
+		Failed:   []coordinator.DoError{{Provider: providers.GCP, Error: errors.New("timeout")}},
+		Deferred: []providers.Provider{providers.Azure},
+	}}}
+	entries := FromBatch("bucket", files, res)
+	require.Len(t, entries, 2)
+	require.Equal(t, coordinator.ErrDeferred.Error(), entries[1].Error)
+	require.False(t, entries[0].Deferred())
+	require.True(t, entries[1].Deferred())
+	require.Equal(t, map[providers.Provider]string{providers.AWS: "aws/a.txt"}, entries[0].Copies)
+	require.NoError(t, q.Add(entries...))
+
+	// the local file changed since, so gcp is repaired from the copy on aws
+	require.NoError(t, os.WriteFile(path, []byte("bbb"), 0644))
+	results := Run(context.Background(), q, uploaders, nil, q.Entries())
+	require.Len(t, results, 2)
+	for _, r := range results {
+		switch r.Entry.Provider {
+		case providers.GCP:
+			require.NoError(t, r.Err)
+			require.Equal(t, "aws", r.From)
+		case providers.Azure:
+			require.Error(t, r.Err)
+			require.Equal(t, 1, r.Entry.Attempts)
+			require.False(t, r.Entry.Parked)
+		}
+	}
+	require.Equal(t, "aaa", gcp.objects["a.txt"])
+	require.Equal(t, 1, q.Len())
+
+	// once the file is back to what was uploaded it is used directly
+	require.NoError(t, os.WriteFile(path, []byte("aaa"), 0644))
+	azure.err = nil
+	delete(aws.objects, "aws/a.txt")
+	results = Run(context.Background(), q, uploaders, nil, q.Entries())
+	require.NoError(t, results[0].Err)
+	require.Equal(t, path, results[0].From)
+	require.Equal(t, "aaa", azure.objects["a.txt"])
+	require.Equal(t, 0, q.Len())
+}
+
+func TestRunParks(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "a.txt")
+	require.NoError(t, os.WriteFile(path, []byte("aaa"), 0644))
+	q, err := Open(filepath.Join(dir, "queue.jsonl"))
+	require.NoError(t, err)
+
+	// bad credentials don't get better by retrying
+	gcp := newMemStore(providers.GCP)
+	gcp.err = providers.NewError(providers.GCP, "upload", http.StatusForbidden, "AccessDenied", "", errors.New("denied"))
+	entries := FromResult("bucket", path, nil, coordinator.DoResult{Failed: []coordinator.DoError{{Provider: providers.GCP, Error: gcp.err}}})
+	require.True(t, entries[0].Parked)
+	entries[0].Parked = false
+	results := Run(context.Background(), q, []providers.Uploader{gcp}, nil, entries)
+	require.True(t, results[0].Entry.Parked)
+
+	// nor does a provider failing every repair
+	gcp.err = errors.New("still down")
+	e := Entry{Provider: providers.GCP, Bucket: "bucket", Key: "a.txt", Path: path, Attempts: MaxAttempts - 2}
+	results = Run(context.Background(), q, []providers.Uploader{gcp}, nil, []Entry{e})
+	require.False(t, results[0].Entry.Parked)
+	results = Run(context.Background(), q, []providers.Uploader{gcp}, nil, []Entry{results[0].Entry})
+	require.True(t, results[0].Entry.Parked)
+	require.True(t, q.Entries()[0].Parked)
+}
+
+func TestRetryWithoutSource(t *testing.T) {
+	gcp := newMemStore(providers.GCP)
+	e := Entry{Provider: providers.GCP, Bucket: "b", Key: "k", Copies: map[providers.Provider]string{providers.AWS: "k"}}
+	_, err := Retry(context.Background(), []providers.Uploader{gcp}, nil, e)
+	require.Error(t, err)
+	_, err = Retry(context.Background(), nil, nil, e)
+	require.EqualError(t, err, "provider gcp is not configured")
+}
@@ -0,0 +1,88 @@
+package providers
+
+import (
+	"context"
+	"io"
+	"time"
+)
+
+// Part is a piece of a resumable upload the provider already stored
+type Part struct {
+	Number int `json:"number"`
+	// ID is the S3 part's ETag or the Azure block ID
+	ID   string `json:"id"`
+	Size int64  `json:"size"`
+}
+
+// Checkpoint is how far a resumable upload got on one provider. It is saved after every part so
+// a later run can pick up where the last one stopped
+type Checkpoint struct {
+	Provider Provider `json:"provider"`
+	Bucket   string   `json:"bucket"`
+	Key      string   `json:"key"`
+	// Path, Size and ModTime identify the file being uploaded, a checkpoint is only resumed for
+	// the same unchanged file
+	Path    string    `json:"path"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+	// UploadID is the S3 multipart upload ID, the GCS resumable session URI or the prefix of the
+	// Azure block IDs
+	UploadID string `json:"uploadId,omitempty"`
+	PartSize int64  `json:"partSize,omitempty"`
+	// Parts are the S3 parts and Azure blocks stored so far
+	Parts []Part `json:"parts,omitempty"`
+	// Offset is how many bytes GCS has persisted
+	Offset  int64     `json:"offset,omitempty"`
+	Created time.Time `json:"created"`
+	Updated time.Time `json:"updated"`
+}
+
+// Uploaded is how many bytes the provider already holds
+func (c Checkpoint) Uploaded() int64 {
+	n := c.Offset
+	for _, p := range c.Parts {
+		n += p.Size
+	}
+	return n
+}
+
+// ResumableUploader is implemented by uploaders that can upload in parts and continue an upload
+// started by an earlier process
+type ResumableUploader interface {
+	Uploader
+	// UploadResumable uploads size bytes of r, continuing from cp when it holds an upload in
+	// progress. cp is updated as parts are stored and passed to save after each one, an upload
+	// the provider no longer knows about is started over
+	UploadResumable(ctx context.Context, bucket, key string, r io.ReaderAt, size int64, opts UploadOptions, cp *Checkpoint, save func(Checkpoint) error) error
+	// AbortResumable discards what an unfinished upload stored
+	AbortResumable(ctx context.Context, cp Checkpoint) error
+}
+
+// PartSize is the smallest multiple of min that splits size into at most maxParts parts
+func PartSize(size, min int64, maxParts int) int64 {
+	per := (size + int64(maxParts) - 1) / int64(maxParts)
+	if per <= min {
+		return min
+	}
+	return (per + min - 1) / min * min
+}
+
+// PendingUpload is an upload started on a provider and never completed. Its parts or blocks are
+// stored, and billed, until it is aborted
+type PendingUpload struct {
+	Provider Provider
+	Bucket   string
+	Key      string
+	// UploadID is the S3 multipart upload ID, empty for Azure where blocks belong to the blob
+	UploadID string
+	Started  time.Time
+	Parts    int
+	Size     int64
+}
+
+// PendingLister is implemented by uploaders that can find and abort uploads never completed
+type PendingLister interface {
+	// ListPending returns the uploads of bucket started before the given time
+	ListPending(ctx context.Context, bucket string, before time.Time) ([]PendingUpload, error)
+	AbortPending(ctx context.Context, p PendingUpload) error
+}
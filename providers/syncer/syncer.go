@@ -0,0 +1,366 @@
+package syncer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/stevequadros/uploader/files"
+	"github.com/stevequadros/uploader/providers"
+	"github.com/stevequadros/uploader/providers/coordinator"
+	"github.com/stevequadros/uploader/providers/ratelimit"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Compare is how a local file and the object already stored for it are judged the same
+type Compare string
+
+const (
+	// CompareChecksum compares sizes, then the sha256 uploader stores in metadata or whichever
+	// checksum the provider reports
+	CompareChecksum Compare = "checksum"
+	// CompareMTime compares sizes and the modification time uploader stores in metadata, which
+	// avoids reading every local file
+	CompareMTime Compare = "mtime"
+)
+
+// DefaultWorkers is how many uploads and deletes run at once when no worker count is given
+const DefaultWorkers = 8
+
+type Options struct {
+	// Files selects the local files and the key prefix they are synced under
+	Files files.Options
+	// Compare defaults to CompareChecksum
+	Compare Compare
+	// Delete removes objects under the prefix with no local file, objects excluded by the
+	// include and exclude patterns are left alone
+	Delete bool
+	// DryRun plans the changes without making them
+	DryRun  bool
+	Workers int
+	// Bandwidth limits every upload together, on top of each provider's limits, nil for none
+	Bandwidth *ratelimit.Bucket
+}
+
+type Action string
+
+const (
+	ActionUpload    Action = "upload"
+	ActionDelete    Action = "delete"
+	ActionUnchanged Action = "unchanged"
+)
+
+// Change is what sync does, or would do on a dry run, for one key on one provider
+type Change struct {
+	Key string
+	// Path is the local file, empty for deletes
+	Path   string
+	Action Action
+	// Reason says why a key is uploaded or deleted, ex: new, size, checksum, mtime
+	Reason string
+	Err    error
+}
+
+type ProviderResult struct {
+	Provider providers.Provider
+	Changes  []Change
+	// Err is set when the provider could not be listed, nothing was changed on it then
+	Err error
+}
+
+// Count returns how many changes of a kind succeeded
+func (r ProviderResult) Count(a Action) int {
+	var n int
+	for _, c := range r.Changes {
+		if c.Action == a && c.Err == nil {
+			n++
+		}
+	}
+	return n
+}
+
+// Failed counts the changes that failed, a listing failure counts once
+func (r ProviderResult) Failed() int {
+	n := 0
+	if r.Err != nil {
+		n++
+	}
+	for _, c := range r.Changes {
+		if c.Err != nil {
+			n++
+		}
+	}
+	return n
+}
+
+type Result struct {
+	Providers []ProviderResult
+	Skipped   []files.Skipped
+}
+
+// Run brings the objects under opts.Files.Prefix on every uploader in line with the files in
+// dir, a prefix without a trailing slash gets one. Each provider is listed and compared on its own, so a provider that missed a previous
+// run catches up without touching the others. Uploads store the file's sha256 and mtime as
+// metadata so later runs can compare against providers that don't report a usable checksum
+func Run(ctx context.Context, uploaders []providers.Uploader, bucket, dir string, opts Options) (Result, error) {
+	if opts.Compare == "" {
+		opts.Compare = CompareChecksum
+	}
+	if opts.Compare != CompareChecksum && opts.Compare != CompareMTime {
+		return Result{}, fmt.Errorf("unknown compare mode %q", opts.Compare)
+	}
+	if opts.Workers <= 0 {
+		opts.Workers = DefaultWorkers
+	}
+	// the directory is synced under the prefix as a directory, so that -delete on releases/v1
+	// doesn't list and delete releases/v10/
+	if opts.Files.Prefix != "" && !strings.HasSuffix(opts.Files.Prefix, "/") {
+		opts.Files.Prefix += "/"
+	}
+
+	local, skipped, err := files.Expand([]string{dir}, opts.Files)
+	if err != nil {
+		return Result{}, err
+	}
+	sources := make([]*source, len(local))
+	for i, f := range local {
+		sources[i] = &source{File: f}
+	}
+
+	result := Result{Providers: make([]ProviderResult, len(uploaders)), Skipped: skipped}
+	wg := sync.WaitGroup{}
+	for i, u := range uploaders {
+		wg.Add(1)
+		go func(i int, u providers.Uploader) {
+			defer wg.Done()
+			result.Providers[i] = plan(ctx, u, bucket, sources, opts)
+		}(i, u)
+	}
+	wg.Wait()
+
+	if !opts.DryRun {
+		apply(ctx, uploaders, opts.Bandwidth, bucket, sources, result.Providers, opts.Workers)
+	}
+	return result, nil
+}
+
+// source is a local file whose checksums are computed at most once, shared by every provider
+type source struct {
+	files.File
+	once sync.Once
+	sums providers.Checksums
+	err  error
+}
+
+func (s *source) checksums() (providers.Checksums, error) {
+	s.once.Do(func() {
+		f, err := os.Open(s.Path)
+		if err != nil {
+			s.err = err
+			return
+		}
+		defer f.Close()
+		s.sums, _, s.err = providers.ComputeChecksums(f)
+	})
+	return s.sums, s.err
+}
+
+func plan(ctx context.Context, u providers.Uploader, bucket string, sources []*source, opts Options) ProviderResult {
+	res := ProviderResult{Provider: u.GetName()}
+	l, ok := u.(providers.Lister)
+	if !ok {
+		res.Err = errors.New("listing not supported")
+		return res
+	}
+	objects, err := providers.ListAll(ctx, l, bucket, providers.ListOptions{Prefix: opts.Files.Prefix})
+	// a missing bucket holds nothing yet, the first upload creates it
+	if err != nil && !errors.Is(err, providers.ErrNotFound) {
+		res.Err = err
+		return res
+	}
+	remote := map[string]providers.ObjectInfo{}
+	for _, o := range objects {
+		remote[o.Key] = o
+	}
+
+	for _, s := range sources {
+		obj, ok := remote[s.Key]
+		delete(remote, s.Key)
+		if !ok {
+			res.Changes = append(res.Changes, Change{Key: s.Key, Path: s.Path, Action: ActionUpload, Reason: "new"})
+			continue
+		}
+		reason, err := changed(ctx, u, bucket, s, obj, opts.Compare)
+		switch {
+		case err != nil:
+			res.Changes = append(res.Changes, Change{Key: s.Key, Path: s.Path, Action: ActionUpload, Reason: "checksum", Err: err})
+		case reason == "":
+			res.Changes = append(res.Changes, Change{Key: s.Key, Path: s.Path, Action: ActionUnchanged})
+		default:
+			res.Changes = append(res.Changes, Change{Key: s.Key, Path: s.Path, Action: ActionUpload, Reason: reason})
+		}
+	}
+
+	if opts.Delete {
+		var stale []string
+		for key := range remote {
+			// directory placeholders some consoles create aren't files that went away
+			if strings.HasSuffix(key, "/") || !opts.Files.Selected(strings.TrimPrefix(key, opts.Files.Prefix)) {
+				continue
+			}
+			stale = append(stale, key)
+		}
+		sort.Strings(stale)
+		for _, key := range stale {
+			res.Changes = append(res.Changes, Change{Key: key, Action: ActionDelete, Reason: "not found locally"})
+		}
+	}
+	return res
+}
+
+// changed returns why obj no longer matches s, or an empty reason when it still does
+func changed(ctx context.Context, u providers.Uploader, bucket string, s *source, obj providers.ObjectInfo, compare Compare) (string, error) {
+	if obj.Size != s.Size {
+		return "size", nil
+	}
+
+	// listings leave out metadata or checksums on some providers, the object is only fetched
+	// on its own when the listing can't settle it
+	if compare == CompareMTime {
+		mtime := obj.MetadataValue(providers.MetadataMTime)
+		if mtime == "" {
+			mtime = stat(ctx, u, bucket, obj).MetadataValue(providers.MetadataMTime)
+		}
+		if mtime != FormatMTime(s.ModTime) {
+			return "mtime", nil
+		}
+		return "", nil
+	}
+
+	sums, err := s.checksums()
+	if err != nil {
+		return "", err
+	}
+	same, known := sameContent(obj, sums)
+	if !known {
+		same, known = sameContent(stat(ctx, u, bucket, obj), sums)
+	}
+	switch {
+	case !known:
+		return "checksum unknown", nil
+	case !same:
+		return "checksum", nil
+	}
+	return "", nil
+}
+
+func stat(ctx context.Context, u providers.Uploader, bucket string, obj providers.ObjectInfo) providers.ObjectInfo {
+	s, ok := u.(providers.Stater)
+	if !ok {
+		return obj
+	}
+	info, err := s.Stat(ctx, bucket, obj.Key)
+	if err != nil {
+		return obj
+	}
+	return info
+}
+
+// sameContent compares the strongest checksum obj has, known is false when it has none
+func sameContent(obj providers.ObjectInfo, sums providers.Checksums) (same, known bool) {
+	if v := obj.MetadataValue(providers.MetadataSHA256); v != "" {
+		return strings.EqualFold(v, sums.SHA256), true
+	}
+	switch {
+	case obj.Checksums.SHA256 != "":
+		return strings.EqualFold(obj.Checksums.SHA256, sums.SHA256), true
+	case obj.Checksums.MD5 != "":
+		return strings.EqualFold(obj.Checksums.MD5, sums.MD5), true
+	case obj.Checksums.CRC32C != "":
+		return strings.EqualFold(obj.Checksums.CRC32C, sums.CRC32C), true
+	}
+	return false, false
+}
+
+// FormatMTime is how a file's modification time is stored under providers.MetadataMTime
+func FormatMTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+// apply carries out the planned uploads and deletes on a pool of workers shared by every
+// provider, recording each outcome on its Change
+func apply(ctx context.Context, uploaders []providers.Uploader, bandwidth *ratelimit.Bucket, bucket string, sources []*source, results []ProviderResult, workers int) {
+	byKey := map[string]*source{}
+	for _, s := range sources {
+		byKey[s.Key] = s
+	}
+
+	type job struct {
+		provider int
+		change   int
+	}
+	jobs := make(chan job)
+	wg := sync.WaitGroup{}
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				c := &results[j.provider].Changes[j.change]
+				u := uploaders[j.provider]
+				switch c.Action {
+				case ActionUpload:
+					c.Err = upload(ctx, u, bandwidth, bucket, byKey[c.Key])
+				case ActionDelete:
+					c.Err = remove(ctx, u, bucket, c.Key)
+				}
+			}
+		}()
+	}
+
+	for i, r := range results {
+		if r.Err != nil {
+			continue
+		}
+		for j, c := range r.Changes {
+			if c.Action == ActionUnchanged || c.Err != nil {
+				continue
+			}
+			jobs <- job{i, j}
+		}
+	}
+	close(jobs)
+	wg.Wait()
+}
+
+func upload(ctx context.Context, u providers.Uploader, bandwidth *ratelimit.Bucket, bucket string, s *source) error {
+	sums, err := s.checksums()
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(s.Path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return coordinator.Upload(ctx, u, bandwidth, bucket, s.Key, f, providers.UploadOptions{
+		Metadata: map[string]string{
+			providers.MetadataSHA256: sums.SHA256,
+			providers.MetadataMTime:  FormatMTime(s.ModTime),
+		},
+	})
+}
+
+func remove(ctx context.Context, u providers.Uploader, bucket, key string) error {
+	d, ok := u.(providers.Deleter)
+	if !ok {
+		return errors.New("delete not supported")
+	}
+	if err := d.Delete(ctx, bucket, key); err != nil && !errors.Is(err, providers.ErrNotFound) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,239 @@
+package syncer
+
+import (
+	"context"
+	"errors"
+	"github.com/stevequadros/uploader/config"
+	"github.com/stevequadros/uploader/files"
+	"github.com/stevequadros/uploader/providers"
+	"github.com/stevequadros/uploader/providers/coordinator"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memStore is an in-memory provider, listMetadata false mimics S3 listings without metadata
+type memStore struct {
+	name         providers.Provider
+	objects      map[string]memObject
+	listMetadata bool
+	uploads      []string
+	listErr      error
+	mu           sync.Mutex
+}
+
+type memObject struct {
+	content  string
+	metadata map[string]string
+}
+
+var _ providers.OptionsUploader = (*memStore)(nil)
+var _ providers.Lister = (*memStore)(nil)
+var _ providers.Stater = (*memStore)(nil)
+var _ providers.Deleter = (*memStore)(nil)
+
+func newMemStore(name providers.Provider, listMetadata bool) *memStore {
+	return &memStore{name: name, objects: map[string]memObject{}, listMetadata: listMetadata}
+}
+
+func (m *memStore) GetName() providers.Provider { return m.name }
+func (m *memStore) Upload(ctx context.Context, bucket, key string, r io.ReadSeekCloser) error {
+	return m.UploadWithOptions(ctx, bucket, key, r, providers.UploadOptions{})
+}
+func (m *memStore) UploadWithOptions(ctx context.Context, bucket, key string, r io.ReadSeekCloser, opts providers.UploadOptions) error {
+	b, err := io.ReadAll(r)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.objects[key] = memObject{string(b), opts.Metadata}
+	m.uploads = append(m.uploads, key)
+	return err
+}
+func (m *memStore) info(key string) providers.ObjectInfo {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	o := m.objects[key]
+	return providers.ObjectInfo{Key: key, Size: int64(len(o.content)), Metadata: o.metadata}
+}
+func (m *memStore) Stat(ctx context.Context, bucket, key string) (providers.ObjectInfo, error) {
+	info := m.info(key)
+	if info.Metadata == nil && info.Size == 0 {
+		return providers.ObjectInfo{}, providers.NewError(m.GetName(), "stat", http.StatusNotFound, "", "", errors.New("missing"))
+	}
+	return info, nil
+}
+func (m *memStore) List(ctx context.Context, bucket string, opts providers.ListOptions) (providers.ListPage, error) {
+	if m.listErr != nil {
+		return providers.ListPage{}, m.listErr
+	}
+	m.mu.Lock()
+	var keys []string
+	for key := range m.objects {
+		keys = append(keys, key)
+	}
+	m.mu.Unlock()
+	var page providers.ListPage
+	for _, key := range keys {
+		if strings.HasPrefix(key, opts.Prefix) {
+			info := m.info(key)
+			if !m.listMetadata {
+				info.Metadata = nil
+			}
+			page.Objects = append(page.Objects, info)
+		}
+	}
+	return page, nil
+}
+func (m *memStore) Delete(ctx context.Context, bucket, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.objects, key)
+	return nil
+}
+
+func writeTree(t *testing.T, files map[string]string) string {
+	dir := t.TempDir()
+	for name, content := range files {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		require.NoError(t, os.MkdirAll(filepath.Dir(p), 0755))
+		require.NoError(t, os.WriteFile(p, []byte(content), 0644))
+	}
+	return dir
+}
+
+func changes(r ProviderResult) map[string]string {
+	got := map[string]string{}
+	for _, c := range r.Changes {
+		got[c.Key] = string(c.Action) + " " + c.Reason
+	}
+	return got
+}
+
+func TestRun(t *testing.T) {
+	dir := writeTree(t, map[string]string{"a.txt": "aaa", "sub/b.txt": "bbb", "skip.tmp": "tmp"})
+	opts := Options{Files: files.Options{Prefix: "p/", Exclude: []string{"*.tmp"}}, Delete: true}
+
+	withMeta, withoutMeta := newMemStore("meta", true), newMemStore("nometa", false)
+	uploaders := []providers.Uploader{withMeta, withoutMeta}
+
+	res, err := Run(context.Background(), uploaders, "bucket", dir, opts)
+	require.NoError(t, err)
+	for _, r := range res.Providers {
+		require.Equal(t, map[string]string{"p/a.txt": "upload new", "p/sub/b.txt": "upload new"}, changes(r))
+		require.Equal(t, 2, r.Count(ActionUpload))
+	}
+	require.Equal(t, "aaa", withMeta.objects["p/a.txt"].content)
+	require.NotEmpty(t, withMeta.objects["p/a.txt"].metadata[providers.MetadataSHA256])
+
+	// second run changes nothing, the store without listing metadata is settled with Stat
+	res, err = Run(context.Background(), uploaders, "bucket", dir, opts)
+	require.NoError(t, err)
+	for _, r := range res.Providers {
+		require.Equal(t, 2, r.Count(ActionUnchanged), r.Provider)
+	}
+
+	// one provider drifts: changed content, a stale key and an excluded key that must survive
+	withoutMeta.objects["p/a.txt"] = memObject{"xyz", nil}
+	withoutMeta.objects["p/gone.txt"] = memObject{"old", nil}
+	withoutMeta.objects["p/keep.tmp"] = memObject{"tmp", nil}
+	withMeta.uploads, withoutMeta.uploads = nil, nil
+
+	res, err = Run(context.Background(), uploaders, "bucket", dir, opts)
+	require.NoError(t, err)
+	require.Empty(t, withMeta.uploads, "the provider in sync is untouched")
+	require.Equal(t, map[string]string{
+		"p/a.txt":     "upload checksum unknown",
+		"p/sub/b.txt": "unchanged ",
+		"p/gone.txt":  "delete not found locally",
+	}, changes(res.Providers[1]))
+	require.Equal(t, []string{"p/a.txt"}, withoutMeta.uploads)
+	require.NotContains(t, withoutMeta.objects, "p/gone.txt")
+	require.Contains(t, withoutMeta.objects, "p/keep.tmp")
+}
+
+func TestRunCompare(t *testing.T) {
+	dir := writeTree(t, map[string]string{"a.txt": "aaa"})
+	store := newMemStore("mem", true)
+	uploaders := []providers.Uploader{store}
+
+	_, err := Run(context.Background(), uploaders, "bucket", dir, Options{Compare: CompareMTime})
+	require.NoError(t, err)
+
+	// same size and mtime counts as unchanged in mtime mode even when the content differs
+	path := filepath.Join(dir, "a.txt")
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(path, []byte("ccc"), 0644))
+	require.NoError(t, os.Chtimes(path, info.ModTime(), info.ModTime()))
+	res, err := Run(context.Background(), uploaders, "bucket", dir, Options{Compare: CompareMTime})
+	require.NoError(t, err)
+	require.Equal(t, 1, res.Providers[0].Count(ActionUnchanged))
+
+	res, err = Run(context.Background(), uploaders, "bucket", dir, Options{Compare: CompareChecksum, DryRun: true})
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"a.txt": "upload checksum"}, changes(res.Providers[0]))
+	require.Equal(t, "aaa", store.objects["a.txt"].content, "dry run changes nothing")
+
+	later := time.Now().Add(time.Hour)
+	require.NoError(t, os.Chtimes(path, later, later))
+	res, err = Run(context.Background(), uploaders, "bucket", dir, Options{Compare: CompareMTime})
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"a.txt": "upload mtime"}, changes(res.Providers[0]))
+
+	_, err = Run(context.Background(), uploaders, "bucket", dir, Options{Compare: "size"})
+	require.Error(t, err)
+}
+
+func TestRunPrefixIsADirectory(t *testing.T) {
+	dir := writeTree(t, map[string]string{"a.txt": "aaa"})
+	store := newMemStore("mem", true)
+	store.objects["releases/v10/b.txt"] = memObject{"bbb", nil}
+	store.objects["releases/v1/gone.txt"] = memObject{"old", nil}
+
+	res, err := Run(context.Background(), []providers.Uploader{store}, "bucket", dir, Options{Files: files.Options{Prefix: "releases/v1"}, Delete: true})
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{
+		"releases/v1/a.txt":    "upload new",
+		"releases/v1/gone.txt": "delete not found locally",
+	}, changes(res.Providers[0]))
+	require.Contains(t, store.objects, "releases/v10/b.txt", "a sibling sharing the prefix's characters is kept")
+}
+
+func TestRunListFailureIsPerProvider(t *testing.T) {
+	dir := writeTree(t, map[string]string{"a.txt": "aaa"})
+	broken, ok := newMemStore("broken", true), newMemStore("ok", true)
+	broken.listErr = errors.New("AccessDenied")
+
+	res, err := Run(context.Background(), []providers.Uploader{broken, ok}, "bucket", dir, Options{})
+	require.NoError(t, err)
+	require.Error(t, res.Providers[0].Err)
+	require.Equal(t, 1, res.Providers[0].Failed())
+	require.Empty(t, broken.uploads)
+	require.Equal(t, []string{"a.txt"}, ok.uploads)
+}
+
+// stalledStore never finishes an upload, its idle timeout has to end it
+type stalledStore struct {
+	*memStore
+}
+
+func (s stalledStore) Timeouts() config.Timeouts {
+	return config.Timeouts{Idle: config.Duration(20 * time.Millisecond)}
+}
+func (s stalledStore) UploadWithOptions(ctx context.Context, bucket, key string, r io.ReadSeekCloser, opts providers.UploadOptions) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestRunTimeouts(t *testing.T) {
+	dir := writeTree(t, map[string]string{"a.txt": "aaa"})
+	res, err := Run(context.Background(), []providers.Uploader{stalledStore{newMemStore("stalled", true)}}, "bucket", dir, Options{})
+	require.NoError(t, err)
+	require.Equal(t, 1, res.Providers[0].Failed())
+	require.ErrorIs(t, res.Providers[0].Changes[0].Err, coordinator.ErrTimeout)
+}
@@ -0,0 +1,20 @@
+package providers
+
+import (
+	"github.com/stevequadros/uploader/config"
+)
+
+// TimeoutsConfigured is implemented by uploaders built with timeouts in their config. The connect
+// timeout is applied by the uploader's HTTP client, the coordinator applies the others, to the
+// uploads of sync, repair and audit too
+type TimeoutsConfigured interface {
+	Timeouts() config.Timeouts
+}
+
+// TimeoutsOf returns u's timeouts, none when it has no config for them
+func TimeoutsOf(u Uploader) config.Timeouts {
+	if t, ok := u.(TimeoutsConfigured); ok {
+		return t.Timeouts()
+	}
+	return config.Timeouts{}
+}
@@ -0,0 +1,71 @@
+package tracing
+
+import (
+	"context"
+	"github.com/stevequadros/uploader/config"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"net/url"
+)
+
+// Name is the instrumentation name of the uploader's spans
+const Name = "github.com/stevequadros/uploader"
+
+// DefaultServiceName names the uploader in traces when the config doesn't
+const DefaultServiceName = "uploader"
+
+// NewProvider builds a tracer provider exporting spans in batches to the collector of cfg over
+// OTLP/HTTP. Shutdown must be called before exiting to send the last batch
+func NewProvider(ctx context.Context, cfg config.Tracing) (*sdktrace.TracerProvider, error) {
+	u, err := url.Parse(cfg.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	opts := []otlptracehttp.Option{otlptracehttp.WithEndpoint(u.Host)}
+	if u.Scheme == "http" {
+		opts = append(opts, otlptracehttp.WithInsecure())
+	}
+	if u.Path != "" && u.Path != "/" {
+		opts = append(opts, otlptracehttp.WithURLPath(u.Path))
+	}
+	if len(cfg.Headers) > 0 {
+		opts = append(opts, otlptracehttp.WithHeaders(cfg.Headers))
+	}
+	exporter, err := otlptracehttp.New(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+	name := cfg.ServiceName
+	if name == "" {
+		name = DefaultServiceName
+	}
+	sampler := sdktrace.AlwaysSample()
+	if cfg.SampleRatio != nil {
+		sampler = sdktrace.TraceIDRatioBased(*cfg.SampleRatio)
+	}
+	return sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", name))),
+		sdktrace.WithSampler(sdktrace.ParentBased(sampler)),
+	), nil
+}
+
+// Start starts a span as a child of the one in ctx, traced by the same provider. Nothing is
+// recorded when ctx has no span, ex: uploads run without a tracer
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	tracer := trace.SpanFromContext(ctx).TracerProvider().Tracer(Name)
+	return tracer.Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// End ends span, marking it failed with err when there is one
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
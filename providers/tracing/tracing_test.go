@@ -0,0 +1,73 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func newTracer() (*tracetest.InMemoryExporter, *sdktrace.TracerProvider) {
+	exporter := tracetest.NewInMemoryExporter()
+	return exporter, sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+}
+
+func TestStart(t *testing.T) {
+	_, span := Start(context.Background(), "untraced")
+	require.False(t, span.IsRecording(), "without a span in ctx nothing is recorded")
+
+	exporter, tp := newTracer()
+	ctx, parent := tp.Tracer("test").Start(context.Background(), "upload")
+	_, child := Start(ctx, "ensure bucket", attribute.String("bucket", "b"))
+	End(child, errors.New("denied"))
+	End(parent, nil)
+
+	spans := exporter.GetSpans()
+	require.Len(t, spans, 2)
+	require.Equal(t, "ensure bucket", spans[0].Name)
+	require.Equal(t, spans[1].SpanContext.SpanID(), spans[0].Parent.SpanID())
+	require.Equal(t, codes.Error, spans[0].Status.Code)
+	require.Equal(t, "denied", spans[0].Status.Description)
+	require.Equal(t, codes.Unset, spans[1].Status.Code)
+}
+
+func TestTransport(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Amz-Request-Id", "req-1")
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer server.Close()
+	client := &http.Client{Transport: &Transport{}}
+
+	req, err := http.NewRequest(http.MethodPut, server.URL+"/key?partNumber=2&X-Amz-Signature=secret", nil)
+	require.NoError(t, err)
+	resp, err := client.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	exporter, tp := newTracer()
+	ctx, parent := tp.Tracer("test").Start(context.Background(), "upload")
+	resp, err = client.Do(req.WithContext(ctx))
+	require.NoError(t, err)
+	resp.Body.Close()
+	parent.End()
+
+	spans := exporter.GetSpans()
+	require.Len(t, spans, 2, "only the request within a traced upload has a span")
+	span := spans[0]
+	require.Equal(t, "HTTP PUT", span.Name)
+	require.Equal(t, codes.Error, span.Status.Code)
+	attrs := map[attribute.Key]attribute.Value{}
+	for _, a := range span.Attributes {
+		attrs[a.Key] = a.Value
+	}
+	require.Equal(t, server.URL+"/key?partNumber=2&X-Amz-Signature=REDACTED", attrs["http.url"].AsString())
+	require.Equal(t, int64(http.StatusForbidden), attrs["http.status_code"].AsInt64())
+	require.Equal(t, "req-1", attrs["request_id"].AsString())
+}
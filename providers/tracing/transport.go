@@ -0,0 +1,46 @@
+package tracing
+
+import (
+	"fmt"
+	"github.com/stevequadros/uploader/providers/logging"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"net/http"
+)
+
+// Transport records a client span for every request sent through Base within a traced upload,
+// ex: each part of a multipart upload. URLs are redacted like logged ones
+type Transport struct {
+	Base http.RoundTripper
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	if !trace.SpanFromContext(req.Context()).IsRecording() {
+		return base.RoundTrip(req)
+	}
+	ctx, span := Start(req.Context(), "HTTP "+req.Method,
+		attribute.String("http.method", req.Method),
+		attribute.String("http.url", (*logging.Redactor)(nil).Redact(req.URL.String())),
+		attribute.Int64("http.request_content_length", req.ContentLength),
+	)
+	defer span.End()
+	resp, err := base.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return resp, err
+	}
+	span.SetAttributes(attribute.Int("http.status_code", resp.StatusCode))
+	if id := logging.RequestID(resp.Header); id != "" {
+		span.SetAttributes(attribute.String("request_id", id))
+	}
+	if resp.StatusCode >= 400 {
+		span.SetStatus(codes.Error, fmt.Sprintf("%d %s", resp.StatusCode, http.StatusText(resp.StatusCode)))
+	}
+	return resp, nil
+}
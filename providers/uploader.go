@@ -2,8 +2,10 @@ package providers
 
 import (
 	"context"
-	"fmt"
+	"errors"
 	"io"
+	"os"
+	"time"
 )
 
 type Provider string
@@ -25,17 +27,103 @@ type Uploader interface {
 	GetName() Provider
 }
 
-type UploadError struct {
-	provider Provider
-	err      error
+// UploadOptions are object properties set along with the upload, empty fields are left to
+// the provider's defaults
+type UploadOptions struct {
+	ContentType string
+	// Metadata keys should be lowercase letters and digits, the only form every provider accepts
+	Metadata map[string]string
+	// Checksums of the content when known before uploading, providers send the ones their API
+	// takes so the service rejects bytes corrupted on the way
+	Checksums Checksums
 }
 
-var _ error = (*UploadError)(nil)
+// OptionsUploader is implemented by uploaders that can set UploadOptions
+type OptionsUploader interface {
+	UploadWithOptions(ctx context.Context, bucket, key string, reader io.ReadSeekCloser, opts UploadOptions) error
+}
+
+// StreamUploader is implemented by uploaders that can upload from a reader that can't seek,
+// without knowing its size in advance. A read error from reader must abort the upload rather
+// than store a truncated object
+type StreamUploader interface {
+	UploadStream(ctx context.Context, bucket, key string, reader io.Reader, opts UploadOptions) error
+}
+
+// UploadWithOptions uploads with opts when u supports them, and with a plain Upload otherwise
+func UploadWithOptions(ctx context.Context, u Uploader, bucket, key string, reader io.ReadSeekCloser, opts UploadOptions) error {
+	if ou, ok := u.(OptionsUploader); ok {
+		return ou.UploadWithOptions(ctx, bucket, key, reader, opts)
+	}
+	return u.Upload(ctx, bucket, key, reader)
+}
+
+// BucketEnsurer is implemented by uploaders that can check for and create a bucket
+// without uploading anything
+type BucketEnsurer interface {
+	EnsureBucket(ctx context.Context, bucket string) error
+}
+
+// Downloader is implemented by uploaders that can read an object back,
+// the caller must close the returned reader
+type Downloader interface {
+	Download(ctx context.Context, bucket, key string) (io.ReadCloser, error)
+}
+
+// Stage downloads key from u into a temp file positioned at its start, checksumming it on the
+// way, so it can be uploaded to other providers. The caller closes and removes the file
+func Stage(ctx context.Context, u Uploader, bucket, key string) (*os.File, Checksums, error) {
+	d, ok := u.(Downloader)
+	if !ok {
+		return nil, Checksums{}, errors.New("download not supported")
+	}
+	r, err := d.Download(ctx, bucket, key)
+	if err != nil {
+		return nil, Checksums{}, err
+	}
+	defer r.Close()
+
+	tmp, err := os.CreateTemp("", "uploader-stage-*")
+	if err != nil {
+		return nil, Checksums{}, err
+	}
+	h := NewHasher()
+	if _, err = io.Copy(io.MultiWriter(tmp, h), r); err == nil {
+		_, err = tmp.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return nil, Checksums{}, err
+	}
+	return tmp, h.Sum(), nil
+}
+
+// Deleter is implemented by uploaders that can remove an object
+type Deleter interface {
+	Delete(ctx context.Context, bucket, key string) error
+}
+
+// AbortTimeout bounds the cleanup of an upload that failed or was cancelled
+const AbortTimeout = 30 * time.Second
+
+// detached carries the values of a context without its cancellation
+type detached struct {
+	context.Context
+	values context.Context
+}
+
+func (d detached) Value(key interface{}) interface{} {
+	return d.values.Value(key)
+}
 
-func (e UploadError) Error() string {
-	return fmt.Sprintf("%q upload failed, error: %q", string(e.provider), e.err.Error())
+// Detach returns a context with ctx's values that is never cancelled, for work that has to run
+// once ctx is, like aborting the upload it cancelled
+func Detach(ctx context.Context) context.Context {
+	return detached{Context: context.Background(), values: ctx}
 }
 
-func NewUploadError(provider Provider, err error) UploadError {
-	return UploadError{provider: provider, err: err}
+// AbortContext is the context to clean up an upload cancelled with ctx
+func AbortContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(Detach(ctx), AbortTimeout)
 }